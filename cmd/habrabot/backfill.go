@@ -0,0 +1,177 @@
+package habrabot
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/kapitanov/habrabot/internal/carboncopy"
+	"github.com/kapitanov/habrabot/internal/data"
+	"github.com/kapitanov/habrabot/internal/db"
+)
+
+// backfillProgressBucket is a BoltDB bucket that progress of "carboncopy backfill" command is kept in.
+const backfillProgressBucket = "backfill"
+
+// dateLayout is a layout of dates in command arguments.
+const dateLayout = "2006-01-02"
+
+// backfillConfiguration is a configuration of "carboncopy backfill" command.
+type backfillConfiguration struct {
+	BoltDBPath string `env:"BOLTDB_PATH,required"`
+	CarbonCopy *carbonCopyConfiguration
+}
+
+// backfillCarbonCopies archives processed articles that are missing from the archive of carbon copies.
+// Progress is kept in the database, so an interrupted backfill is resumed by the next run.
+func backfillCarbonCopies(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("carboncopy backfill", flag.ContinueOnError)
+	from := flags.String("from", "", "archive articles published on or after a date, e.g. 2022-10-01")
+	to := flags.String("to", "", "archive articles published on or before a date, e.g. 2022-10-31")
+	concurrency := flags.Int("concurrency", 4, "number of articles that are archived at once")
+	force := flags.Bool("force", false, "archive articles again even if they have carbon copies already")
+	restart := flags.Bool("restart", false, "discard progress of an interrupted backfill and start over")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if *concurrency < 1 {
+		return fmt.Errorf("invalid concurrency %d, expected a positive number", *concurrency)
+	}
+
+	cfg := backfillConfiguration{CarbonCopy: newCarbonCopyConfiguration()}
+	err = parseEnv(&cfg)
+	if err != nil {
+		return err
+	}
+
+	if !cfg.CarbonCopy.IsEnabled() {
+		return errors.New("carbon copies are not configured, set CC_PATH or CC_STORAGE")
+	}
+
+	// Carbon copy directory is locked, so the backfill isn't run while the bot is running.
+	lock, err := cfg.CarbonCopy.Lock()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = lock.Close()
+	}()
+
+	articles, err := loadBackfillArticles(db.NewArchive(cfg.BoltDBPath), *from, *to)
+	if err != nil {
+		return err
+	}
+
+	options, err := cfg.CarbonCopy.Options()
+	if err != nil {
+		return err
+	}
+
+	if index := cfg.CarbonCopy.SearchIndex(); index != nil {
+		options = append(options, carboncopy.WithIndexer(index))
+		defer func() {
+			err := index.Close()
+			if err != nil {
+				log.Error().Err(err).Msg("unable to close search index")
+			}
+		}()
+	}
+
+	progress := db.NewStore(cfg.BoltDBPath, backfillProgressBucket)
+	if *restart {
+		err = progress.Clear()
+		if err != nil {
+			return err
+		}
+	}
+
+	// Interrupted backfill keeps its progress, so it's resumed by the next run.
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Info().Int("articles", len(articles)).Msg("backfilling carbon copies")
+
+	backfill := carboncopy.Backfill{Concurrency: *concurrency, Force: *force, Progress: progress}
+	report, err := backfill.Run(ctx, cfg.CarbonCopy.DirPath, articles, options...)
+
+	log.Info().
+		Int("archived", report.Archived).
+		Int("skipped", report.Skipped).
+		Int("failed", len(report.Failed)).
+		Msg("carbon copies have been backfilled")
+
+	if errors.Is(err, context.Canceled) {
+		return errors.New("backfill has been interrupted, run the command again to resume it")
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for _, id := range report.Failed {
+		fmt.Printf("failed: %s\n", id)
+	}
+
+	if len(report.Failed) > 0 {
+		return fmt.Errorf("%d articles haven't been archived, run the command again to retry them", len(report.Failed))
+	}
+
+	// Progress is discarded once the backfill is complete, so the next backfill checks all articles again.
+	return progress.Clear()
+}
+
+// loadBackfillArticles loads processed articles published within a range of dates, inclusive. Empty dates leave the range open.
+func loadBackfillArticles(archive *db.Archive, from, to string) ([]data.Article, error) {
+	if from == "" && to == "" {
+		return archive.All()
+	}
+
+	start, end, err := parseDateRange(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return archive.Range(start, end)
+}
+
+// parseDateRange parses an inclusive range of dates in UTC and returns it as [start, end) range of times.
+func parseDateRange(from, to string) (time.Time, time.Time, error) {
+	var start, end time.Time
+
+	if from != "" {
+		var err error
+		start, err = time.Parse(dateLayout, from)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid date \"%s\", expected e.g. \"2022-10-01\"", from)
+		}
+	}
+
+	if to == "" {
+		// Articles are never published that far in the future.
+		end = time.Date(9999, time.December, 31, 0, 0, 0, 0, time.UTC)
+	} else {
+		day, err := time.Parse(dateLayout, to)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid date \"%s\", expected e.g. \"2022-10-31\"", to)
+		}
+
+		end = day.AddDate(0, 0, 1)
+	}
+
+	if !start.Before(end) {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid range of dates from %s to %s", from, to)
+	}
+
+	return start, end, nil
+}
@@ -0,0 +1,66 @@
+package habrabot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDateRange(t *testing.T) {
+	start, end, err := parseDateRange("2022-10-01", "2022-10-31")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC), start)
+	assert.Equal(t, time.Date(2022, 11, 1, 0, 0, 0, 0, time.UTC), end, "the last day is included")
+
+	start, end, err = parseDateRange("", "2022-10-31")
+	require.NoError(t, err)
+	assert.True(t, start.IsZero())
+	assert.Equal(t, time.Date(2022, 11, 1, 0, 0, 0, 0, time.UTC), end)
+
+	_, end, err = parseDateRange("2022-10-01", "")
+	require.NoError(t, err)
+	assert.True(t, end.After(time.Now()))
+
+	_, _, err = parseDateRange("2022-10-31", "2022-10-01")
+	assert.Error(t, err)
+
+	_, _, err = parseDateRange("yesterday", "")
+	assert.Error(t, err)
+}
+
+func TestBackfillConfiguration(t *testing.T) {
+	t.Setenv("BOLTDB_PATH", "boltdb.dat")
+	t.Setenv("CC_PATH", "cc")
+	t.Setenv("CC_STORAGE", "s3")
+	t.Setenv("CC_S3_BUCKET", "archive")
+	t.Setenv("SEARCH_INDEX_PATH", "search")
+
+	cfg := backfillConfiguration{CarbonCopy: newCarbonCopyConfiguration()}
+	require.NoError(t, parseEnv(&cfg))
+	assert.True(t, cfg.CarbonCopy.IsEnabled())
+	assert.Equal(t, "archive", cfg.CarbonCopy.Storage.S3Bucket, "nested configurations are parsed")
+	assert.NotNil(t, cfg.CarbonCopy.SearchIndex())
+
+	_, err := cfg.CarbonCopy.Options()
+	require.NoError(t, err)
+}
+
+func TestCarbonCopyConfiguration_RemoteStorage(t *testing.T) {
+	t.Setenv("BOLTDB_PATH", "boltdb.dat")
+	t.Setenv("CC_STORAGE", "s3")
+	t.Setenv("CC_S3_BUCKET", "archive")
+
+	cfg := backfillConfiguration{CarbonCopy: newCarbonCopyConfiguration()}
+	require.NoError(t, parseEnv(&cfg))
+	assert.True(t, cfg.CarbonCopy.IsEnabled(), "carbon copies don't need a local directory")
+
+	_, err := cfg.CarbonCopy.Options()
+	require.NoError(t, err)
+
+	// WARC files are recorded locally before they are moved into the storage.
+	cfg.CarbonCopy.WARC = true
+	_, err = cfg.CarbonCopy.Options()
+	assert.Error(t, err)
+}
@@ -0,0 +1,153 @@
+package habrabot
+
+import (
+	"errors"
+	"io"
+
+	"github.com/kapitanov/habrabot/internal/carboncopy"
+	"github.com/kapitanov/habrabot/internal/search"
+)
+
+// carbonCopyConfiguration is a configuration of carbon copies, which is shared by the bot and "carboncopy backfill" command.
+// Configurations refer to it by a pointer, so environment variables are parsed into it.
+type carbonCopyConfiguration struct {
+	DirPath       string `env:"CC_PATH"`                           // Required by "local" storage and by WARC recording.
+	PathTemplate  string `env:"CC_PATH_TEMPLATE"`                  // Layout of carbon copies, see carboncopy.ParseLayout.
+	PathMaxLength int    `env:"CC_PATH_MAX_LENGTH"`                // Maximum length of carbon copy paths in bytes.
+	ArchiveMode   string `env:"CC_ARCHIVE_MODE" envDefault:"page"` // "page", "assets" or "single_file".
+	WARC          bool   `env:"CC_WARC"`
+	WARCMaxSize   int64  `env:"CC_WARC_MAX_SIZE" envDefault:"1073741824"` // Size in bytes that WARC files are rotated at.
+	WARCAssets    bool   `env:"CC_WARC_ASSETS"`
+	Markdown      bool   `env:"CC_MARKDOWN"`
+	EPUB          bool   `env:"CC_EPUB"`
+	Storage       *storageConfiguration
+
+	SearchIndexPath string `env:"SEARCH_INDEX_PATH"` // Carbon copies are indexed for full-text search if it's set.
+
+	resolver *carboncopy.Resolver
+}
+
+func newCarbonCopyConfiguration() *carbonCopyConfiguration {
+	return &carbonCopyConfiguration{Storage: &storageConfiguration{}}
+}
+
+// IsEnabled returns true if carbon copies are stored, either into CC_PATH directory or into a remote storage.
+func (c *carbonCopyConfiguration) IsEnabled() bool {
+	return c != nil && (c.DirPath != "" || c.Storage.IsRemote())
+}
+
+// Layout returns a layout of carbon copies, the default one if carbon copies aren't configured.
+func (c *carbonCopyConfiguration) Layout() (carboncopy.Layout, error) {
+	if c == nil {
+		return carboncopy.Layout{}, nil
+	}
+
+	return carboncopy.ParseLayout(c.PathTemplate, c.PathMaxLength)
+}
+
+// Lock locks carbon copy directory, so the bot and "carboncopy backfill" command don't store carbon copies at the same time.
+// Nothing is locked if there's no directory, i.e. carbon copies are stored into a remote storage and WARC is disabled.
+func (c *carbonCopyConfiguration) Lock() (io.Closer, error) {
+	if c.DirPath == "" {
+		return noLock{}, nil
+	}
+
+	return carboncopy.Lock(c.DirPath)
+}
+
+// noLock is a lock of carbon copies that have no directory to lock.
+type noLock struct{}
+
+func (noLock) Close() error {
+	return nil
+}
+
+// SearchIndex returns a full-text index of carbon copies, or nil if full-text search is disabled.
+func (c *carbonCopyConfiguration) SearchIndex() *search.Index {
+	if c == nil || c.SearchIndexPath == "" {
+		return nil
+	}
+
+	return search.New(c.SearchIndexPath)
+}
+
+// Resolver returns a resolver of carbon copy paths. It's created once and shared by carbon copy consumer and post buttons,
+// so buttons link to paths that carbon copies are stored at.
+func (c *carbonCopyConfiguration) Resolver() (*carboncopy.Resolver, error) {
+	if c.resolver != nil {
+		return c.resolver, nil
+	}
+
+	layout, err := c.Layout()
+	if err != nil {
+		return nil, err
+	}
+
+	storage, err := c.Storage.Storage(c.DirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	c.resolver = carboncopy.NewResolver(layout, storage)
+	return c.resolver, nil
+}
+
+// PathFunc returns a template function that returns a path of article's carbon copy.
+// Paths are resolved the same way carbon copies are stored, if they are enabled, and paths of the layout are used otherwise.
+func (c *carbonCopyConfiguration) PathFunc() (interface{}, error) {
+	if !c.IsEnabled() {
+		layout, err := c.Layout()
+		if err != nil {
+			return nil, err
+		}
+
+		return layout.Path, nil
+	}
+
+	resolver, err := c.Resolver()
+	if err != nil {
+		return nil, err
+	}
+
+	return resolver.Path, nil
+}
+
+// Options returns options of carbon copy consumer, except for the search index.
+func (c *carbonCopyConfiguration) Options() ([]carboncopy.Option, error) {
+	mode, err := carboncopy.ParseArchiveMode(c.ArchiveMode)
+	if err != nil {
+		return nil, err
+	}
+
+	// WARC files are recorded locally, even if they are moved into a remote storage once complete.
+	if c.WARC && c.DirPath == "" {
+		return nil, errors.New("WARC files are recorded into carbon copy directory, set CC_PATH")
+	}
+
+	resolver, err := c.Resolver()
+	if err != nil {
+		return nil, err
+	}
+
+	options := []carboncopy.Option{
+		carboncopy.WithArchiveMode(mode),
+		carboncopy.WithResolver(resolver),
+	}
+
+	if c.Markdown {
+		options = append(options, carboncopy.WithMarkdown())
+	}
+
+	if c.EPUB {
+		options = append(options, carboncopy.WithEPUB())
+	}
+
+	if c.WARC {
+		options = append(options, carboncopy.WithWARC(carboncopy.WARC{
+			MaxSize: c.WARCMaxSize,
+			Assets:  c.WARCAssets,
+		}))
+	}
+
+	return options, nil
+}
@@ -0,0 +1,118 @@
+package habrabot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/kapitanov/habrabot/internal/carboncopy"
+	"github.com/kapitanov/habrabot/internal/s3"
+)
+
+// command is a maintenance command that runs instead of the bot.
+type command func(ctx context.Context, args []string) error
+
+// commands are maintenance commands by their names, e.g. "habrabot export epub".
+var commands = map[string]command{
+	"export epub":         exportEPUB,
+	"carboncopy verify":   verifyCarbonCopies,
+	"carboncopy backfill": backfillCarbonCopies,
+	"search":              searchArticles,
+}
+
+// archiveConfiguration is a configuration of commands that work with the archive of processed articles.
+type archiveConfiguration struct {
+	BoltDBPath              string `env:"BOLTDB_PATH,required"`
+	CarbonCopyDirPath       string `env:"CC_PATH"` // Required by "local" storage only.
+	CarbonCopyPathTemplate  string `env:"CC_PATH_TEMPLATE"`
+	CarbonCopyPathMaxLength int    `env:"CC_PATH_MAX_LENGTH"`
+	CarbonCopyStorage       *storageConfiguration
+}
+
+// readArchiveConfig reads a configuration of commands that work with the archive.
+func readArchiveConfig() (archiveConfiguration, error) {
+	cfg := archiveConfiguration{CarbonCopyStorage: &storageConfiguration{}}
+	err := parseEnv(&cfg)
+	if err != nil {
+		return archiveConfiguration{}, err
+	}
+
+	return cfg, nil
+}
+
+func (c archiveConfiguration) Layout() (carboncopy.Layout, error) {
+	return carboncopy.ParseLayout(c.CarbonCopyPathTemplate, c.CarbonCopyPathMaxLength)
+}
+
+func (c archiveConfiguration) Storage() (carboncopy.Storage, error) {
+	return c.CarbonCopyStorage.Storage(c.CarbonCopyDirPath)
+}
+
+// storageConfiguration selects a storage of carbon copies.
+// Configurations refer to it by a pointer, so environment variables are parsed into it.
+type storageConfiguration struct {
+	Kind              string `env:"CC_STORAGE" envDefault:"local"` // "local" directory or "s3" bucket.
+	S3Endpoint        string `env:"CC_S3_ENDPOINT"`                // AWS endpoint of the region by default.
+	S3Region          string `env:"CC_S3_REGION" envDefault:"us-east-1"`
+	S3Bucket          string `env:"CC_S3_BUCKET"`
+	S3Prefix          string `env:"CC_S3_PREFIX"`
+	S3AccessKeyID     string `env:"CC_S3_ACCESS_KEY_ID"`
+	S3SecretAccessKey string `env:"CC_S3_SECRET_ACCESS_KEY"`
+	S3PathStyle       bool   `env:"CC_S3_PATH_STYLE"`     // Required by MinIO, unless it's configured with a domain.
+	S3StorageClass    string `env:"CC_S3_STORAGE_CLASS"`  // e.g. "STANDARD_IA", the default class of the bucket if it's empty.
+	S3SSE             string `env:"CC_S3_SSE"`            // "AES256" or "aws:kms", no server-side encryption if it's empty.
+	S3SSEKMSKeyID     string `env:"CC_S3_SSE_KMS_KEY_ID"` // KMS key for "aws:kms" encryption.
+}
+
+// IsRemote returns true if carbon copies are stored into a storage other than "local" directory.
+func (c *storageConfiguration) IsRemote() bool {
+	return c != nil && c.Kind != "" && c.Kind != "local"
+}
+
+// Storage creates a storage of carbon copies, dirPath is a directory of "local" storage.
+func (c *storageConfiguration) Storage(dirPath string) (carboncopy.Storage, error) {
+	if !c.IsRemote() {
+		if dirPath == "" {
+			return nil, errors.New("carbon copy directory is not specified, set CC_PATH")
+		}
+
+		return carboncopy.Dir(dirPath), nil
+	}
+
+	if c.Kind != "s3" {
+		return nil, fmt.Errorf("unknown carbon copy storage \"%s\", expected \"local\" or \"s3\"", c.Kind)
+	}
+
+	return s3.New(s3.Config{
+		Endpoint:        c.S3Endpoint,
+		Region:          c.S3Region,
+		Bucket:          c.S3Bucket,
+		Prefix:          c.S3Prefix,
+		AccessKeyID:     c.S3AccessKeyID,
+		SecretAccessKey: c.S3SecretAccessKey,
+		PathStyle:       c.S3PathStyle,
+		StorageClass:    c.S3StorageClass,
+		SSE:             c.S3SSE,
+		SSEKMSKeyID:     c.S3SSEKMSKeyID,
+	})
+}
+
+// runCommand runs a command that args start with, passing the rest of args to it.
+func runCommand(ctx context.Context, args []string) error {
+	for n := len(args); n > 0; n-- {
+		if cmd, ok := commands[strings.Join(args[:n], " ")]; ok {
+			return cmd(ctx, args[n:])
+		}
+	}
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return fmt.Errorf("unknown command \"%s\", available commands are: %s", strings.Join(args, " "), strings.Join(names, ", "))
+}
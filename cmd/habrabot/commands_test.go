@@ -0,0 +1,29 @@
+package habrabot
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorageConfiguration(t *testing.T) {
+	var cfg *storageConfiguration
+	_, err := cfg.Storage("")
+	assert.Error(t, err, "local storage requires a directory")
+
+	storage, err := cfg.Storage(t.TempDir())
+	require.NoError(t, err)
+	assert.NotNil(t, storage)
+
+	_, err = (&storageConfiguration{Kind: "ftp"}).Storage("")
+	assert.Error(t, err)
+
+	_, err = (&storageConfiguration{Kind: "s3"}).Storage("")
+	assert.Error(t, err, "s3 storage requires a bucket")
+
+	storage, err = (&storageConfiguration{Kind: "s3", S3Bucket: "archive", S3Prefix: "cc"}).Storage("")
+	require.NoError(t, err)
+	assert.Equal(t, "s3://archive/cc/", fmt.Sprint(storage))
+}
@@ -0,0 +1,191 @@
+package habrabot
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/kapitanov/habrabot/internal/carboncopy"
+	"github.com/kapitanov/habrabot/internal/data"
+	"github.com/kapitanov/habrabot/internal/db"
+	"github.com/kapitanov/habrabot/internal/epub"
+)
+
+// exportEPUB builds an EPUB book from carbon copies of a single article, or of a week of articles with a tag.
+func exportEPUB(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("export epub", flag.ContinueOnError)
+	id := flags.String("id", "", "ID of a single article to export")
+	tag := flags.String("tag", "", "export articles with a tag, all articles by default")
+	week := flags.String("week", "", "ISO week to export articles of, e.g. 2022-W42, the current week by default")
+	title := flags.String("title", "", "title of the book, derived from the articles by default")
+	out := flags.String("out", "", "path of the EPUB file, derived from the book by default")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := readArchiveConfig()
+	if err != nil {
+		return err
+	}
+
+	layout, err := cfg.Layout()
+	if err != nil {
+		return err
+	}
+
+	storage, err := cfg.Storage()
+	if err != nil {
+		return err
+	}
+
+	archive := db.NewArchive(cfg.BoltDBPath)
+
+	var (
+		book     *epub.Book
+		articles []data.Article
+		path     string
+	)
+
+	if *id != "" {
+		article, found, err := archive.Get(*id)
+		if err != nil {
+			return err
+		}
+
+		if !found {
+			return fmt.Errorf("article \"%s\" not found", *id)
+		}
+
+		book = carboncopy.NewArticleBook(article)
+		articles = []data.Article{article}
+		path = strings.TrimSuffix(filepath.Base(layout.Path(article)), ".html") + ".epub"
+	} else {
+		from, err := parseISOWeek(*week, time.Now())
+		if err != nil {
+			return err
+		}
+
+		articles, err = archive.Range(from, from.AddDate(0, 0, 7))
+		if err != nil {
+			return err
+		}
+
+		articles = filterByTag(articles, *tag)
+		book = newCompilation(articles, *tag, from)
+		path = strings.NewReplacer(", ", "-", " ", "-", "/", "-").Replace(book.Title) + ".epub"
+	}
+
+	if *title != "" {
+		book.Title = *title
+	}
+
+	if *out != "" {
+		path = *out
+	}
+
+	err = carboncopy.BuildEPUB(ctx, storage, layout, book, articles)
+	if err != nil {
+		return err
+	}
+
+	if book.Len() == 0 {
+		return errors.New("no archived articles to export")
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	err = book.Write(f)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	log.Info().Str("file", path).Int("articles", book.Len()).Msg("epub has been exported")
+	return nil
+}
+
+// newCompilation creates an EPUB book with metadata of a weekly compilation of articles.
+func newCompilation(articles []data.Article, tag string, week time.Time) *epub.Book {
+	year, number := week.ISOWeek()
+	book := &epub.Book{Title: fmt.Sprintf("%d-W%02d", year, number)}
+
+	if tag != "" {
+		book.Title = tag + ", " + book.Title
+		book.Subjects = []string{tag}
+	}
+
+	authors := make(map[string]bool)
+	for _, article := range articles {
+		if article.Author != "" && !authors[article.Author] {
+			authors[article.Author] = true
+			book.Authors = append(book.Authors, article.Author)
+		}
+
+		if article.Time.After(book.Date) {
+			book.Date = article.Time
+		}
+	}
+
+	return book
+}
+
+// parseISOWeek returns the beginning of an ISO week, e.g. "2022-W42", or of the week of now if str is empty.
+func parseISOWeek(str string, now time.Time) (time.Time, error) {
+	if str == "" {
+		now = now.UTC()
+		day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7), nil
+	}
+
+	var year, week int
+	_, err := fmt.Sscanf(str, "%d-W%d", &year, &week)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid week \"%s\", expected e.g. \"2022-W42\"", str)
+	}
+
+	// The first ISO week of a year is the one with January 4th.
+	jan4 := time.Date(year, time.January, 4, 0, 0, 0, 0, time.UTC)
+	from := jan4.AddDate(0, 0, -(int(jan4.Weekday())+6)%7+(week-1)*7)
+
+	if y, w := from.ISOWeek(); y != year || w != week {
+		return time.Time{}, fmt.Errorf("invalid week \"%s\", year %d has no week %d", str, year, week)
+	}
+
+	return from, nil
+}
+
+// filterByTag returns articles that have a tag, ignoring case. All articles are returned if tag is empty.
+func filterByTag(articles []data.Article, tag string) []data.Article {
+	if tag == "" {
+		return articles
+	}
+
+	var filtered []data.Article
+	for _, article := range articles {
+		for _, t := range article.Tags {
+			if strings.EqualFold(t, tag) {
+				filtered = append(filtered, article)
+				break
+			}
+		}
+	}
+
+	return filtered
+}
@@ -0,0 +1,47 @@
+package habrabot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kapitanov/habrabot/internal/data"
+)
+
+func TestParseISOWeek(t *testing.T) {
+	from, err := parseISOWeek("2022-W42", time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2022, 10, 17, 0, 0, 0, 0, time.UTC), from)
+
+	// The first week of 2021 starts in 2021, since January 1st is a Friday.
+	from, err = parseISOWeek("2021-W01", time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2021, 1, 4, 0, 0, 0, 0, time.UTC), from)
+
+	from, err = parseISOWeek("", time.Date(2022, 10, 23, 18, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2022, 10, 17, 0, 0, 0, 0, time.UTC), from)
+
+	_, err = parseISOWeek("2022-W53", time.Time{})
+	assert.Error(t, err)
+
+	_, err = parseISOWeek("last week", time.Time{})
+	assert.Error(t, err)
+}
+
+func TestNewCompilation(t *testing.T) {
+	articles := filterByTag([]data.Article{
+		{ID: "1", Author: "alice", Tags: []string{"Go"}, Time: time.Date(2022, 10, 18, 0, 0, 0, 0, time.UTC)},
+		{ID: "2", Author: "bob", Tags: []string{"Python"}},
+		{ID: "3", Author: "alice", Tags: []string{"python", "go"}, Time: time.Date(2022, 10, 19, 0, 0, 0, 0, time.UTC)},
+	}, "go")
+	require.Len(t, articles, 2)
+
+	book := newCompilation(articles, "go", time.Date(2022, 10, 17, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, "go, 2022-W42", book.Title)
+	assert.Equal(t, []string{"alice"}, book.Authors)
+	assert.Equal(t, []string{"go"}, book.Subjects)
+	assert.Equal(t, articles[1].Time, book.Date)
+}
@@ -3,23 +3,33 @@ package habrabot
 import (
 	"errors"
 	"flag"
+	"io"
 	"os"
 	"os/signal"
 	"sync"
 	"syscall"
+	"text/template"
 	"time"
+	_ "time/tzdata" // Time zone database for minimal container images.
 
 	"golang.org/x/net/context"
 
+	"github.com/kapitanov/habrabot/internal/admin"
 	"github.com/kapitanov/habrabot/internal/carboncopy"
 
 	"github.com/rs/zerolog"
 
 	"github.com/kapitanov/habrabot/internal/data"
 	"github.com/kapitanov/habrabot/internal/db"
+	"github.com/kapitanov/habrabot/internal/moderation"
 	"github.com/kapitanov/habrabot/internal/opengraph"
+	"github.com/kapitanov/habrabot/internal/ratelimit"
 	"github.com/kapitanov/habrabot/internal/rss"
+	"github.com/kapitanov/habrabot/internal/schedule"
+	"github.com/kapitanov/habrabot/internal/search"
+	"github.com/kapitanov/habrabot/internal/subscriptions"
 	"github.com/kapitanov/habrabot/internal/telegram"
+	"github.com/kapitanov/habrabot/internal/updates"
 
 	"github.com/caarlos0/env"
 	"github.com/joho/godotenv"
@@ -35,29 +45,76 @@ func init() {
 }
 
 type configuration struct {
-	TelegramToken     string        `env:"TELEGRAM_TOKEN,required"`
-	TelegramChannel   string        `env:"TELEGRAM_CHANNEL,required"`
-	RSSFeedURL        string        `env:"RSS_FEED,required"`
-	RSSFeedPeriod     time.Duration `env:"RSS_FEED_PERIOD" envDefault:"5m"`
-	BoltDBPath        string        `env:"BOLTDB_PATH,required"`
-	CarbonCopyDirPath string        `env:"CC_PATH"`
+	TelegramToken   string        `env:"TELEGRAM_TOKEN,required"`
+	TelegramChannel string        `env:"TELEGRAM_CHANNEL,required"`
+	RSSFeedURL      string        `env:"RSS_FEED,required"`
+	RSSFeedPeriod   time.Duration `env:"RSS_FEED_PERIOD" envDefault:"5m"`
+	BoltDBPath      string        `env:"BOLTDB_PATH,required"`
+	CarbonCopy      *carbonCopyConfiguration
+
+	TelegramChatRateLimit   int `env:"TELEGRAM_CHAT_RATE_LIMIT" envDefault:"20"`   // Messages per minute per chat.
+	TelegramGlobalRateLimit int `env:"TELEGRAM_GLOBAL_RATE_LIMIT" envDefault:"30"` // Messages per second across all chats.
+
+	TrackUpdates      bool `env:"TRACK_UPDATES"`
+	TelegramEditMedia bool `env:"TELEGRAM_EDIT_MEDIA"`
+
+	TelegramAdmins []int64 `env:"TELEGRAM_ADMINS" envSeparator:","` // User IDs that are allowed to send commands to the bot.
+
+	TelegramWebhookURL    string `env:"TELEGRAM_WEBHOOK_URL"` // Updates are received via long polling if webhook isn't set.
+	TelegramWebhookListen string `env:"TELEGRAM_WEBHOOK_LISTEN" envDefault:":8080"`
+	TelegramWebhookPath   string `env:"TELEGRAM_WEBHOOK_PATH"`
+	TelegramWebhookSecret string `env:"TELEGRAM_WEBHOOK_SECRET"`
+
+	ModerationChatID int64 `env:"MODERATION_CHAT_ID"` // Articles are published without moderation if it isn't set.
+
+	SubscriptionsEnabled   bool `env:"SUBSCRIPTIONS_ENABLED"`
+	SubscriptionsRateLimit int  `env:"SUBSCRIPTIONS_RATE_LIMIT" envDefault:"20"` // Notifications per hour per user.
+
+	TelegramButtons string `env:"TELEGRAM_BUTTONS"` // Inline keyboard layout, see telegram.ParseKeyboard.
+
+	TelegramTopics       string `env:"TELEGRAM_TOPICS"`        // Routing of articles to forum topics, see telegram.ParseTopics.
+	TelegramDefaultTopic int    `env:"TELEGRAM_DEFAULT_TOPIC"` // Topic of articles that match no route.
+
+	TelegramPostingMode     string `env:"TELEGRAM_POSTING_MODE" envDefault:"upload"` // "upload" images or show "link_preview"s.
+	TelegramPreviewPosition string `env:"TELEGRAM_PREVIEW_POSITION" envDefault:"below"`
+	TelegramPreviewSize     string `env:"TELEGRAM_PREVIEW_SIZE" envDefault:"auto"`
+
+	ScheduleMinInterval time.Duration `env:"SCHEDULE_MIN_INTERVAL"`
+	ScheduleQuietHours  string        `env:"SCHEDULE_QUIET_HOURS"`
+	ScheduleQuietMode   string        `env:"SCHEDULE_QUIET_MODE" envDefault:"defer"`
+	ScheduleTimeZone    string        `env:"SCHEDULE_TIMEZONE" envDefault:"UTC"`
+
+	ReconcilePeriod time.Duration `env:"RECONCILE_PERIOD"`
+	ReconcileWindow time.Duration `env:"RECONCILE_WINDOW" envDefault:"72h"`
+	ReconcileAction string        `env:"RECONCILE_ACTION" envDefault:"mark"`
+	ReconcileMarker string        `env:"RECONCILE_MARKER" envDefault:"❌ Removed"`
+}
+
+// service is a background routine that runs until the context is canceled.
+type service interface {
+	Run(ctx context.Context)
 }
 
 func readConfig() (configuration, error) {
+	cfg := configuration{CarbonCopy: newCarbonCopyConfiguration()}
+	err := parseEnv(&cfg)
+	if err != nil {
+		return configuration{}, err
+	}
+
+	return cfg, nil
+}
+
+// parseEnv fills a configuration struct from environment variables and .env file.
+func parseEnv(cfg interface{}) error {
 	if *envFilePath != "" {
 		err := godotenv.Load(*envFilePath)
 		if err != nil {
-			return configuration{}, err
+			return err
 		}
 	}
 
-	cfg := configuration{}
-	err := env.Parse(&cfg)
-	if err != nil {
-		return configuration{}, err
-	}
-
-	return cfg, nil
+	return env.Parse(cfg)
 }
 
 func (c configuration) CreateFeed() (data.Feed, error) {
@@ -71,22 +128,286 @@ func (c configuration) CreateFeed() (data.Feed, error) {
 	feed = opengraph.Enrich(feed)
 
 	// Then it should be filtered by BoltDB database.
-	feed = db.Use(feed, c.BoltDBPath)
+	var dbOptions []db.Option
+	if c.TrackUpdates {
+		dbOptions = append(dbOptions, db.TrackUpdates())
+	}
+
+	feed = db.Use(feed, c.BoltDBPath, dbOptions...)
 
 	return feed, nil
 }
 
-func (c configuration) CreateConsumer() data.Consumer {
-	consumer := telegram.New(c.TelegramToken, c.TelegramChannel)
+// pipeline is a set of components that deliver articles.
+type pipeline struct {
+	consumer    data.Consumer // Consumer of feed articles.
+	publisher   data.Consumer // Consumer that publishes articles into Telegram channel, bypassing moderation.
+	transmitter *telegram.Transmitter
+	scheduler   *schedule.Scheduler     // Scheduler is nil if scheduling is disabled.
+	moderator   *moderation.Moderator   // Moderator is nil if moderation is disabled.
+	notifier    *subscriptions.Notifier // Notifier is nil if subscriptions are disabled.
+	searchIndex *search.Index           // Search index is nil if full-text search is disabled.
+	services    []service
+	closers     []io.Closer // Closers release resources of consumers once the pipeline is stopped.
+}
+
+func (c configuration) CreatePipeline() (*pipeline, error) {
+	p := &pipeline{}
+
+	limiter := ratelimit.New(
+		ratelimit.Rate{Count: c.TelegramGlobalRateLimit, Period: time.Second},
+		ratelimit.Rate{Count: c.TelegramChatRateLimit, Period: time.Minute},
+	)
+
+	telegramOptions, err := c.TelegramOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	// Edits and deletions of posted messages share the rate limiter with new posts.
+	telegramOptions = append(telegramOptions, telegram.WithRateLimiter(limiter))
+
+	// Subscribers are notified about published articles in private chats, sharing the rate limiter with the channel.
+	// They are notified once articles have been posted, rather than when they are queued or scheduled.
+	// Notifications are sent in background, so they don't delay posting into the channel.
+	if c.SubscriptionsEnabled {
+		p.notifier = subscriptions.New(
+			c.TelegramToken,
+			db.NewStore(c.BoltDBPath, "subscriptions"),
+			limiter,
+			ratelimit.Rate{Count: c.SubscriptionsRateLimit, Period: time.Hour},
+		)
+		telegramOptions = append(telegramOptions, telegram.WithListener(p.notifier))
+		p.services = append(p.services, p.notifier)
+	}
+
+	p.transmitter = telegram.New(c.TelegramToken, c.TelegramChannel, telegramOptions...)
+
+	// Posted articles are re-checked in background, if enabled.
+	if c.ReconcilePeriod > 0 {
+		p.services = append(p.services, p.transmitter)
+	}
+
+	// Outgoing messages are queued in BoltDB database while they are waiting for the rate limiter.
+	// Messages that can't be sent are moved out of the queue eventually.
+	p.consumer = ratelimit.Use(
+		p.transmitter,
+		limiter,
+		c.TelegramChannel,
+		db.NewQueue(c.BoltDBPath, "outgoing"),
+		db.NewStore(c.BoltDBPath, "undelivered"),
+	)
+
+	// Scheduler is optional, it delivers articles from its own outbox in background.
+	if c.IsSchedulingEnabled() {
+		p.scheduler, err = c.CreateScheduler(p.consumer)
+		if err != nil {
+			return nil, err
+		}
+
+		p.consumer = p.scheduler
+		p.services = append(p.services, p.scheduler)
+	}
+
+	p.publisher = p.consumer
+
+	// Moderation is optional, articles are published only once moderators approve them.
+	if c.IsModerationEnabled() {
+		p.moderator = moderation.New(c.TelegramToken, c.ModerationChatID, p.consumer, db.NewStore(c.BoltDBPath, "moderation"))
+		p.consumer = p.moderator
+	}
+
+	if c.CarbonCopy.IsEnabled() {
+		err = c.addCarbonCopy(p)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// addCarbonCopy makes the pipeline store carbon copies of articles along with delivering them.
+func (c configuration) addCarbonCopy(p *pipeline) error {
+	// Carbon copy directory is locked while the bot is running, so a backfill isn't started meanwhile.
+	lock, err := c.CarbonCopy.Lock()
+	if err != nil {
+		return err
+	}
+
+	carbonCopyOptions, err := c.CarbonCopy.Options()
+	if err != nil {
+		_ = lock.Close()
+		return err
+	}
+
+	// Articles are added to the search index once their carbon copies are stored.
+	p.searchIndex = c.CarbonCopy.SearchIndex()
+	if p.searchIndex != nil {
+		carbonCopyOptions = append(carbonCopyOptions, carboncopy.WithIndexer(p.searchIndex))
+	}
+
+	carbonCopy := carboncopy.Use(c.CarbonCopy.DirPath, carbonCopyOptions...)
+	if closer, ok := carbonCopy.(io.Closer); ok {
+		p.closers = append(p.closers, closer)
+	}
 
-	if c.CarbonCopyDirPath != "" {
-		consumer = data.Tee(consumer, carboncopy.Use(c.CarbonCopyDirPath))
+	// The search index is kept open while the bot is running, and it's closed once carbon copies are.
+	if p.searchIndex != nil {
+		p.closers = append(p.closers, p.searchIndex)
 	}
 
-	return consumer
+	// The lock is released last, once carbon copies and the search index are closed.
+	p.closers = append(p.closers, lock)
+
+	p.consumer = data.Tee(p.consumer, carbonCopy)
+	return nil
 }
 
-func runOnce(ctx context.Context, feed data.Feed, consumer data.Consumer) error {
+func (c configuration) TelegramOptions() ([]telegram.Option, error) {
+	// Posted messages are tracked in BoltDB database, so they can be edited later.
+	options := []telegram.Option{
+		telegram.WithMessageStore(db.NewStore(c.BoltDBPath, "messages")),
+	}
+
+	if c.TelegramEditMedia {
+		options = append(options, telegram.WithMediaEdits())
+	}
+
+	if c.TelegramButtons != "" {
+		ccpath, err := c.CarbonCopy.PathFunc()
+		if err != nil {
+			return nil, err
+		}
+
+		keyboard, err := telegram.ParseKeyboard(c.TelegramButtons, template.FuncMap{"ccpath": ccpath})
+		if err != nil {
+			return nil, err
+		}
+
+		options = append(options, telegram.WithKeyboard(keyboard))
+	}
+
+	if c.TelegramTopics != "" || c.TelegramDefaultTopic != 0 {
+		topics, err := telegram.ParseTopics(c.TelegramTopics, c.TelegramDefaultTopic)
+		if err != nil {
+			return nil, err
+		}
+
+		options = append(options, telegram.WithTopics(topics))
+	}
+
+	postingMode, err := telegram.ParsePostingMode(c.TelegramPostingMode)
+	if err != nil {
+		return nil, err
+	}
+
+	previewPosition, err := telegram.ParsePreviewPosition(c.TelegramPreviewPosition)
+	if err != nil {
+		return nil, err
+	}
+
+	previewSize, err := telegram.ParsePreviewSize(c.TelegramPreviewSize)
+	if err != nil {
+		return nil, err
+	}
+
+	options = append(options,
+		telegram.WithPostingMode(postingMode),
+		telegram.WithLinkPreview(telegram.LinkPreview{Position: previewPosition, Size: previewSize}),
+	)
+
+	if c.ReconcilePeriod > 0 {
+		action, err := telegram.ParseReconcileAction(c.ReconcileAction)
+		if err != nil {
+			return nil, err
+		}
+
+		options = append(options, telegram.WithReconciliation(telegram.Reconciliation{
+			Period: c.ReconcilePeriod,
+			Window: c.ReconcileWindow,
+			Action: action,
+			Marker: c.ReconcileMarker,
+		}))
+	}
+
+	return options, nil
+}
+
+func (c configuration) IsSchedulingEnabled() bool {
+	return c.ScheduleMinInterval > 0 || c.ScheduleQuietHours != ""
+}
+
+func (c configuration) CreateScheduler(consumer data.Consumer) (*schedule.Scheduler, error) {
+	location, err := time.LoadLocation(c.ScheduleTimeZone)
+	if err != nil {
+		return nil, err
+	}
+
+	quietHours, err := schedule.ParseQuietHours(c.ScheduleQuietHours, location)
+	if err != nil {
+		return nil, err
+	}
+
+	quietMode, err := schedule.ParseQuietMode(c.ScheduleQuietMode)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := schedule.Policy{
+		MinInterval: c.ScheduleMinInterval,
+		QuietHours:  quietHours,
+		QuietMode:   quietMode,
+	}
+
+	return schedule.New(consumer, policy, db.NewQueue(c.BoltDBPath, "outbox"), db.NewStore(c.BoltDBPath, "schedule")), nil
+}
+
+func (c configuration) IsModerationEnabled() bool {
+	return c.ModerationChatID != 0
+}
+
+func (c configuration) IsAdminBotEnabled() bool {
+	return len(c.TelegramAdmins) > 0
+}
+
+func (c configuration) CreateAdminBot(p *pipeline, status *admin.Status, triggerSync func()) *admin.Bot {
+	options := []admin.Option{
+		admin.WithStatus(status),
+		admin.WithForgetter(p.transmitter),
+		admin.WithQueue("outgoing", db.NewQueue(c.BoltDBPath, "outgoing")),
+		admin.WithSyncTrigger(triggerSync),
+	}
+
+	if p.scheduler != nil {
+		options = append(options,
+			admin.WithScheduler(p.scheduler),
+			admin.WithQueue("outbox", db.NewQueue(c.BoltDBPath, "outbox")),
+		)
+	}
+
+	if p.searchIndex != nil {
+		options = append(options, admin.WithSearcher(p.searchIndex))
+	}
+
+	return admin.New(c.TelegramAdmins, db.NewArchive(c.BoltDBPath), p.publisher, options...)
+}
+
+func (c configuration) CreateUpdateReceiver(handlers ...updates.Handler) *updates.Receiver {
+	var options []updates.Option
+	if c.TelegramWebhookURL != "" {
+		options = append(options, updates.WithWebhook(updates.Webhook{
+			URL:         c.TelegramWebhookURL,
+			ListenAddr:  c.TelegramWebhookListen,
+			Path:        c.TelegramWebhookPath,
+			SecretToken: c.TelegramWebhookSecret,
+		}))
+	}
+
+	return updates.New(c.TelegramToken, handlers, options...)
+}
+
+func runOnce(ctx context.Context, feed data.Feed, consumer data.Consumer) (int, error) {
 	newArticleCount := 0
 	feed = data.Transform(feed, data.TransformationFunc(func(_ context.Context, article *data.Article) error {
 		log.Printf("new article from feed: %s", article.ID)
@@ -97,14 +418,20 @@ func runOnce(ctx context.Context, feed data.Feed, consumer data.Consumer) error
 
 	err := feed.Read(ctx, consumer)
 	if err != nil {
-		return err
+		return newArticleCount, err
+	}
+
+	// Deliver articles that have been left in queues since previous runs.
+	err = data.Flush(ctx, consumer)
+	if err != nil {
+		return newArticleCount, err
 	}
 
 	if newArticleCount > 0 {
 		log.Info().Int("new", newArticleCount).Msg("sync completed")
 	}
 
-	return nil
+	return newArticleCount, nil
 }
 
 // Main is an entrypoint for application.
@@ -120,6 +447,16 @@ func Main() {
 	})
 	log.Logger = log.Logger.With().Timestamp().Logger()
 
+	// Maintenance commands run instead of the bot.
+	if flag.NArg() > 0 {
+		err := runCommand(context.Background(), flag.Args())
+		if err != nil {
+			log.Fatal().Err(err).Msg("command has failed")
+		}
+
+		return
+	}
+
 	config, err := readConfig()
 	if err != nil {
 		log.Fatal().Err(err).Msg("unable to load configuration")
@@ -130,32 +467,82 @@ func Main() {
 		log.Fatal().Err(err).Msg("unable to create feed")
 	}
 
-	consumer := config.CreateConsumer()
+	p, err := config.CreatePipeline()
+	if err != nil {
+		log.Fatal().Err(err).Msg("unable to create consumer")
+	}
 
-	run(feed, consumer, config)
+	run(feed, p, config)
 }
 
-func run(feed data.Feed, consumer data.Consumer, config configuration) {
+func run(feed data.Feed, p *pipeline, config configuration) {
 	syncTrigger := make(chan struct{}, 1)
-	syncTrigger <- struct{}{}
+	triggerSync := func() {
+		// Sync that is pending already covers this request too.
+		select {
+		case syncTrigger <- struct{}{}:
+		default:
+		}
+	}
+
+	triggerSync()
 
 	ctx, cancel := context.WithCancel(context.Background())
 
+	services := p.services
+	status := admin.NewStatus()
+	// Interactive features share a single receiver of Telegram updates.
+	var handlers []updates.Handler
+	if config.IsAdminBotEnabled() {
+		// Admin bot resends articles in background, apart from the receiver of updates.
+		adminBot := config.CreateAdminBot(p, status, triggerSync)
+		handlers = append(handlers, adminBot)
+		services = append(services, adminBot)
+	}
+
+	if p.moderator != nil {
+		handlers = append(handlers, p.moderator)
+	}
+
+	if p.notifier != nil {
+		handlers = append(handlers, p.notifier)
+	}
+
+	if len(handlers) > 0 {
+		services = append(services, config.CreateUpdateReceiver(handlers...))
+	}
+
 	timer := time.NewTicker(config.RSSFeedPeriod)
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
 
 	go func() {
 		for range timer.C {
-			syncTrigger <- struct{}{}
+			triggerSync()
 		}
 	}()
 
+	for _, s := range services {
+		s := s
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			s.Run(ctx)
+		}()
+	}
+
 	go func() {
 		defer wg.Done()
 
-		for range syncTrigger {
-			err := runOnce(ctx, feed, consumer)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-syncTrigger:
+			}
+
+			newArticleCount, err := runOnce(ctx, feed, p.consumer)
 			if err != nil {
 				if errors.Is(err, context.Canceled) {
 					return
@@ -163,6 +550,8 @@ func run(feed data.Feed, consumer data.Consumer, config configuration) {
 
 				log.Fatal().Err(err).Msg("unable to run sync routine")
 			}
+
+			status.Synced(newArticleCount)
 		}
 	}()
 
@@ -172,10 +561,16 @@ func run(feed data.Feed, consumer data.Consumer, config configuration) {
 	<-signals
 
 	log.Info().Msg("shutting down")
-	close(syncTrigger)
 	cancel()
 	timer.Stop()
 	wg.Wait()
 
+	for _, closer := range p.closers {
+		err := closer.Close()
+		if err != nil {
+			log.Error().Err(err).Msg("unable to close pipeline")
+		}
+	}
+
 	log.Info().Msg("goodbye")
 }
@@ -0,0 +1,275 @@
+package habrabot
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kapitanov/habrabot/internal/carboncopy"
+	"github.com/kapitanov/habrabot/internal/data"
+	"github.com/kapitanov/habrabot/internal/db"
+	"github.com/kapitanov/habrabot/internal/telegram/telegramtest"
+)
+
+const (
+	testToken  = "123:token"
+	testChatID = int64(-1001234567890)
+)
+
+const rssTemplate = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0">
+  <channel>
+    <title>Habr</title>
+    <link>{{SITE}}/</link>
+    <item>
+      <title><![CDATA[{{TITLE}}]]></title>
+      <guid isPermaLink="true">{{SITE}}/post/2/</guid>
+      <link>{{SITE}}/post/2/?utm_source=rss</link>
+      <description><![CDATA[<img src="{{SITE}}/image.png"><p>Second <b>article</b> text.</p>]]></description>
+      <pubDate>Tue, 18 Oct 2022 12:00:00 GMT</pubDate>
+      <dc:creator xmlns:dc="http://purl.org/dc/elements/1.1/">author</dc:creator>
+      <category>Go</category>
+    </item>
+    <item>
+      <title><![CDATA[First article]]></title>
+      <guid isPermaLink="true">{{SITE}}/post/1/</guid>
+      <link>{{SITE}}/post/1/</link>
+      <description><![CDATA[<p>First article text.</p>]]></description>
+      <pubDate>Mon, 17 Oct 2022 12:00:00 GMT</pubDate>
+      <category>Python</category>
+    </item>
+  </channel>
+</rss>`
+
+// site is a fake web site with an RSS feed and articles' pages.
+type site struct {
+	*httptest.Server
+
+	mutex sync.Mutex
+	title string // Title of the second article.
+}
+
+func newSite(t *testing.T) *site {
+	var img bytes.Buffer
+	require.NoError(t, png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 64, 32))))
+
+	s := &site{title: "Second article"}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/rss/":
+			s.mutex.Lock()
+			rss := strings.NewReplacer("{{SITE}}", s.URL, "{{TITLE}}", s.title).Replace(rssTemplate)
+			s.mutex.Unlock()
+
+			w.Header().Set("Content-Type", "application/rss+xml")
+			_, _ = w.Write([]byte(rss))
+		case "/image.png":
+			w.Header().Set("Content-Type", "image/png")
+			_, _ = w.Write(img.Bytes())
+		default:
+			_, _ = w.Write([]byte("<html><head><title>Article</title></head></html>"))
+		}
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func (s *site) SetTitle(title string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.title = title
+}
+
+func newTestConfig(t *testing.T, s *site, server *telegramtest.Server) configuration {
+	t.Setenv("TELEGRAM_API_ENDPOINT", server.URL)
+
+	return configuration{
+		TelegramToken:           testToken,
+		TelegramChannel:         "@channel",
+		RSSFeedURL:              s.URL + "/rss/",
+		BoltDBPath:              filepath.Join(t.TempDir(), "boltdb.dat"),
+		TelegramChatRateLimit:   20,
+		TelegramGlobalRateLimit: 30,
+		TrackUpdates:            true,
+		TelegramButtons:         "Read={{.LinkURL}}",
+		TelegramTopics:          "go=12",
+	}
+}
+
+func newTestServer(t *testing.T) *telegramtest.Server {
+	server := telegramtest.NewServer(testToken)
+	t.Cleanup(server.Close)
+
+	server.AddChat(tgbotapi.Chat{ID: testChatID, Type: "supergroup", UserName: "channel", Title: "Channel"})
+
+	return server
+}
+
+func TestPipeline_E2E(t *testing.T) {
+	s := newSite(t)
+	server := newTestServer(t)
+	config := newTestConfig(t, s, server)
+
+	feed, err := config.CreateFeed()
+	require.NoError(t, err)
+
+	p, err := config.CreatePipeline()
+	require.NoError(t, err)
+
+	n, err := runOnce(context.Background(), feed, p.consumer)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	messages := server.Messages(testChatID)
+	require.Len(t, messages, 2)
+
+	// Articles are posted in order of their publication.
+	assert.Equal(t, "text", messages[0].Kind)
+	assert.Equal(t, "First article\n\nFirst article text.", messages[0].Text)
+	assert.Zero(t, messages[0].ThreadID)
+
+	assert.Equal(t, "photo", messages[1].Kind)
+	assert.Equal(t, "Second article\n\nSecond article text.", messages[1].Text)
+	assert.Equal(t, 12, messages[1].ThreadID)
+	assert.Contains(t, messages[1].ReplyMarkup, s.URL+"/post/2/")
+	assert.NotEmpty(t, messages[1].File)
+
+	// Articles that have been posted already are skipped.
+	n, err = runOnce(context.Background(), feed, p.consumer)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+	assert.Len(t, server.Messages(testChatID), 2)
+
+	// Updated articles are edited.
+	s.SetTitle("Updated article")
+
+	_, err = runOnce(context.Background(), feed, p.consumer)
+	require.NoError(t, err)
+
+	messages = server.Messages(testChatID)
+	require.Len(t, messages, 2)
+	assert.True(t, messages[1].Edited)
+	assert.Equal(t, "Updated article\n\nSecond article text.", messages[1].Text)
+}
+
+func TestPipeline_E2E_RateLimited(t *testing.T) {
+	s := newSite(t)
+	server := newTestServer(t)
+	server.Flood(1, 1)
+
+	config := newTestConfig(t, s, server)
+
+	feed, err := config.CreateFeed()
+	require.NoError(t, err)
+
+	p, err := config.CreatePipeline()
+	require.NoError(t, err)
+
+	_, err = runOnce(context.Background(), feed, p.consumer)
+	require.NoError(t, err)
+
+	assert.Len(t, server.Messages(testChatID), 2)
+	assert.Equal(t, 2, server.Calls("sendMessage"))
+}
+
+func TestPipeline_E2E_Undelivered(t *testing.T) {
+	s := newSite(t)
+	server := newTestServer(t)
+	server.Reject("sendMessage", "MESSAGE_REJECTED")
+
+	config := newTestConfig(t, s, server)
+	config.ScheduleMinInterval = time.Nanosecond
+
+	feed, err := config.CreateFeed()
+	require.NoError(t, err)
+
+	p, err := config.CreatePipeline()
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.scheduler.Run(ctx)
+
+	_, err = runOnce(ctx, feed, p.consumer)
+	require.NoError(t, err)
+
+	// Scheduler hands articles over to the rate-limited consumer, which keeps the rejected one for a retry.
+	outbox := db.NewQueue(config.BoltDBPath, "outbox")
+	outgoing := db.NewQueue(config.BoltDBPath, "outgoing")
+	require.Eventually(t, func() bool {
+		count, err := outbox.Len()
+		return err == nil && count == 0 && len(server.Messages(testChatID)) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	items, err := outgoing.Items()
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	assert.Equal(t, "First article", items[0].Title)
+
+	// Once the article runs out of attempts, it's moved out of the queue.
+	var state struct {
+		Attempts int       `json:"attempts"`
+		RetryAt  time.Time `json:"retry_at"`
+	}
+	found, err := outgoing.State(items[0].ID, &state)
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, 1, state.Attempts)
+
+	require.NoError(t, outgoing.SetState(items[0].ID, map[string]interface{}{"attempts": 9}))
+	require.NoError(t, data.Flush(ctx, p.consumer))
+
+	count, err := outgoing.Len()
+	require.NoError(t, err)
+	assert.Zero(t, count)
+
+	var record struct {
+		Error    string `json:"error"`
+		Attempts int    `json:"attempts"`
+	}
+	found, err = db.NewStore(config.BoltDBPath, "undelivered").Get(strings.ToLower(items[0].ID), &record)
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, 10, record.Attempts)
+	assert.Contains(t, record.Error, "MESSAGE_REJECTED")
+}
+
+func TestPipeline_CarbonCopyLocked(t *testing.T) {
+	s := newSite(t)
+	server := newTestServer(t)
+	config := newTestConfig(t, s, server)
+	config.CarbonCopy = newCarbonCopyConfiguration()
+	config.CarbonCopy.DirPath = filepath.Join(t.TempDir(), "cc")
+
+	p, err := config.CreatePipeline()
+	require.NoError(t, err)
+
+	// Carbon copy directory is locked while the bot is running, e.g. for a backfill.
+	_, err = config.CreatePipeline()
+	assert.ErrorIs(t, err, carboncopy.ErrLocked)
+
+	for _, closer := range p.closers {
+		require.NoError(t, closer.Close())
+	}
+
+	p, err = config.CreatePipeline()
+	require.NoError(t, err)
+
+	for _, closer := range p.closers {
+		require.NoError(t, closer.Close())
+	}
+}
@@ -0,0 +1,51 @@
+package habrabot
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/kapitanov/habrabot/internal/search"
+)
+
+// searchConfiguration is a configuration of "search" command.
+type searchConfiguration struct {
+	SearchIndexPath string `env:"SEARCH_INDEX_PATH,required"`
+}
+
+// searchArticles looks for archived articles in the full-text index and prints links to them.
+func searchArticles(_ context.Context, args []string) error {
+	flags := flag.NewFlagSet("search", flag.ContinueOnError)
+	limit := flags.Int("limit", 20, fmt.Sprintf("maximum number of articles to print, up to %d", search.MaxLimit))
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	var cfg searchConfiguration
+	err = parseEnv(&cfg)
+	if err != nil {
+		return err
+	}
+
+	index := search.NewReadOnly(cfg.SearchIndexPath)
+	defer func() {
+		_ = index.Close()
+	}()
+
+	results, err := index.Search(strings.Join(flags.Args(), " "), *limit)
+	if err != nil {
+		return err
+	}
+
+	for _, hit := range results.Hits {
+		fmt.Printf("%s  %s\n            %s\n", hit.Time.Format("2006-01-02"), hit.Title, hit.URL)
+	}
+
+	log.Info().Uint64("total", results.Total).Int("shown", len(results.Hits)).Msg("search has been completed")
+	return nil
+}
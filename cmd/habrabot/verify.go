@@ -0,0 +1,71 @@
+package habrabot
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/kapitanov/habrabot/internal/carboncopy"
+)
+
+// verifyConfiguration is a configuration of "carboncopy verify" command.
+type verifyConfiguration struct {
+	CarbonCopyDirPath string `env:"CC_PATH"` // Required by "local" storage only.
+	CarbonCopyStorage *storageConfiguration
+}
+
+// verifyCarbonCopies re-hashes the archive of carbon copies and reports corrupted or missing files.
+func verifyCarbonCopies(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("carboncopy verify", flag.ContinueOnError)
+	unverified := flags.Bool("unverified", false, "also list pages that have no checksums to verify them with")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	cfg := verifyConfiguration{CarbonCopyStorage: &storageConfiguration{}}
+	err = parseEnv(&cfg)
+	if err != nil {
+		return err
+	}
+
+	storage, err := cfg.CarbonCopyStorage.Storage(cfg.CarbonCopyDirPath)
+	if err != nil {
+		return err
+	}
+
+	report, err := carboncopy.Verify(ctx, storage)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range report.Missing {
+		fmt.Printf("missing: %s\n", file)
+	}
+
+	for _, file := range report.Corrupted {
+		fmt.Printf("corrupted: %s\n", file)
+	}
+
+	if *unverified {
+		for _, file := range report.Unverified {
+			fmt.Printf("unverified: %s\n", file)
+		}
+	}
+
+	log.Info().
+		Int("checked", report.Checked).
+		Int("missing", len(report.Missing)).
+		Int("corrupted", len(report.Corrupted)).
+		Int("unverified", len(report.Unverified)).
+		Msg("carbon copies have been verified")
+
+	if !report.OK() {
+		return fmt.Errorf("%d missing and %d corrupted files found", len(report.Missing), len(report.Corrupted))
+	}
+
+	return nil
+}
@@ -0,0 +1,196 @@
+package admin
+
+import (
+	"context"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/rs/zerolog/log"
+
+	"github.com/kapitanov/habrabot/internal/data"
+	"github.com/kapitanov/habrabot/internal/search"
+)
+
+// Archive provides access to processed articles.
+type Archive interface {
+	// Get loads a processed article by its ID.
+	Get(id string) (data.Article, bool, error)
+
+	// Latest returns up to n most recent processed articles.
+	Latest(n int) ([]data.Article, error)
+
+	// Count returns a number of processed articles.
+	Count() (int, error)
+
+	// Forget removes an article from processed ones.
+	Forget(id string) error
+}
+
+// Forgetter is a destination that keeps track of delivered articles.
+type Forgetter interface {
+	// Forget removes the record of article's delivery.
+	Forget(id string) error
+}
+
+// Pausable is a component that delivery may be paused for.
+type Pausable interface {
+	Pause()
+	Resume()
+	IsPaused() bool
+}
+
+// Queue is a queue of articles waiting for delivery.
+type Queue interface {
+	// Len returns a number of queued articles.
+	Len() (int, error)
+}
+
+// Searcher is a full-text index of archived articles.
+type Searcher interface {
+	// Search returns up to limit articles that match a query, best matches first.
+	Search(query string, limit int) (search.Results, error)
+}
+
+// Bot executes commands sent by administrators in private chats.
+type Bot struct {
+	admins      map[int64]bool
+	archive     Archive
+	consumer    data.Consumer
+	status      *Status
+	forgetters  []Forgetter
+	scheduler   Pausable
+	queues      []namedQueue
+	triggerSync func()
+	searcher    Searcher
+	resends     chan resendRequest
+}
+
+type namedQueue struct {
+	Name  string
+	Queue Queue
+}
+
+// resendRequest is an article queued by /resend command, the outcome is reported with a follow-up reply.
+type resendRequest struct {
+	Article  data.Article
+	FollowUp func(text string)
+}
+
+// Option configures admin bot.
+type Option func(b *Bot)
+
+// WithStatus makes /status command report results of feed synchronization.
+func WithStatus(status *Status) Option {
+	return func(b *Bot) {
+		b.status = status
+	}
+}
+
+// WithForgetter makes /resend and /forget commands reset article's delivery to the destination.
+func WithForgetter(forgetter Forgetter) Option {
+	return func(b *Bot) {
+		b.forgetters = append(b.forgetters, forgetter)
+	}
+}
+
+// WithScheduler enables /pause and /resume commands.
+func WithScheduler(scheduler Pausable) Option {
+	return func(b *Bot) {
+		b.scheduler = scheduler
+	}
+}
+
+// WithQueue makes /status command report length of the queue.
+func WithQueue(name string, queue Queue) Option {
+	return func(b *Bot) {
+		b.queues = append(b.queues, namedQueue{Name: name, Queue: queue})
+	}
+}
+
+// WithSyncTrigger enables /sync command, which invokes the trigger function.
+func WithSyncTrigger(trigger func()) Option {
+	return func(b *Bot) {
+		b.triggerSync = trigger
+	}
+}
+
+// WithSearcher enables /search command, which looks for archived articles in a full-text index.
+func WithSearcher(searcher Searcher) Option {
+	return func(b *Bot) {
+		b.searcher = searcher
+	}
+}
+
+// New creates new admin bot.
+// Commands are accepted only from administrators with the specified user IDs.
+// Articles are redelivered into the consumer.
+func New(admins []int64, archive Archive, consumer data.Consumer, options ...Option) *Bot {
+	b := &Bot{
+		admins:   make(map[int64]bool),
+		archive:  archive,
+		consumer: consumer,
+		resends:  make(chan resendRequest, maxPendingResends),
+	}
+
+	for _, id := range admins {
+		b.admins[id] = true
+	}
+
+	for _, option := range options {
+		option(b)
+	}
+
+	return b
+}
+
+// HandleUpdate executes a command received from Telegram.
+func (b *Bot) HandleUpdate(ctx context.Context, bot *tgbotapi.BotAPI, update tgbotapi.Update) {
+	msg := update.Message
+	if msg == nil || !msg.IsCommand() || !commands[msg.Command()] {
+		return
+	}
+
+	if !msg.Chat.IsPrivate() || msg.From == nil || !b.admins[int64(msg.From.ID)] {
+		// Commands from strangers are ignored silently.
+		log.Warn().
+			Int64("chat", msg.Chat.ID).
+			Str("command", msg.Command()).
+			Msg("ignored telegram command from unauthorized user")
+		return
+	}
+
+	log.Info().
+		Int("user", msg.From.ID).
+		Str("command", msg.Command()).
+		Str("args", msg.CommandArguments()).
+		Msg("received admin command")
+
+	followUp := func(text string) {
+		reply(bot, msg, text)
+	}
+
+	reply(bot, msg, b.execute(ctx, msg.Command(), msg.CommandArguments(), followUp))
+}
+
+// Run redelivers articles queued by /resend command one at a time, until the context is canceled.
+// Articles are redelivered apart from handling of updates, so other commands are replied to meanwhile.
+func (b *Bot) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case r := <-b.resends:
+			r.FollowUp(b.resend(ctx, r.Article))
+		}
+	}
+}
+
+func reply(bot *tgbotapi.BotAPI, msg *tgbotapi.Message, text string) {
+	message := tgbotapi.NewMessage(msg.Chat.ID, text)
+	message.DisableWebPagePreview = true
+	message.ReplyToMessageID = msg.MessageID
+
+	_, err := bot.Send(message)
+	if err != nil {
+		log.Error().Err(err).Int64("chat", msg.Chat.ID).Msg("unable to reply to admin command")
+	}
+}
@@ -0,0 +1,260 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/kapitanov/habrabot/internal/data"
+)
+
+const (
+	defaultLatestCount = 5
+	maxLatestCount     = 50
+	searchResultCount  = 10
+	maxPendingResends  = 10
+)
+
+const helpText = `Available commands:
+/status - show sync status and queue lengths
+/latest N - list N latest articles
+/resend ID - deliver an article again
+/forget ID - forget an article, so it's delivered again on next sync
+/pause - pause scheduled delivery
+/resume - resume scheduled delivery
+/sync - sync the feed now
+/search QUERY - find archived articles`
+
+// commands is a set of commands handled by admin bot, other commands are left for other handlers.
+var commands = map[string]bool{
+	"help":   true,
+	"status": true,
+	"latest": true,
+	"resend": true,
+	"forget": true,
+	"pause":  true,
+	"resume": true,
+	"sync":   true,
+	"search": true,
+}
+
+// execute runs a command and returns a reply text.
+// Commands that complete in background report their outcome with the follow-up function.
+func (b *Bot) execute(ctx context.Context, command, args string, followUp func(text string)) string {
+	args = strings.TrimSpace(args)
+
+	switch command {
+	case "help":
+		return helpText
+	case "status":
+		return b.statusCommand()
+	case "latest":
+		return b.latestCommand(args)
+	case "resend":
+		return b.resendCommand(args, followUp)
+	case "forget":
+		return b.forgetCommand(args)
+	case "pause":
+		return b.pauseCommand(true)
+	case "resume":
+		return b.pauseCommand(false)
+	case "sync":
+		return b.syncCommand()
+	case "search":
+		return b.searchCommand(args)
+	default:
+		return fmt.Sprintf("Unknown command /%s.\n\n%s", command, helpText)
+	}
+}
+
+func (b *Bot) statusCommand() string {
+	var sb strings.Builder
+
+	if b.status != nil {
+		fmt.Fprintf(&sb, "Last sync: %s\n", b.status)
+	}
+
+	count, err := b.archive.Count()
+	if err != nil {
+		return replyError("unable to count articles", err)
+	}
+
+	fmt.Fprintf(&sb, "Processed articles: %d\n", count)
+
+	for _, q := range b.queues {
+		length, err := q.Queue.Len()
+		if err != nil {
+			return replyError("unable to read queue", err)
+		}
+
+		fmt.Fprintf(&sb, "Queued in %s: %d\n", q.Name, length)
+	}
+
+	switch {
+	case b.scheduler == nil:
+		sb.WriteString("Scheduler: disabled")
+	case b.scheduler.IsPaused():
+		sb.WriteString("Scheduler: paused")
+	default:
+		sb.WriteString("Scheduler: running")
+	}
+
+	return sb.String()
+}
+
+func (b *Bot) latestCommand(args string) string {
+	n := defaultLatestCount
+	if args != "" {
+		var err error
+		n, err = strconv.Atoi(args)
+		if err != nil || n <= 0 {
+			return "Usage: /latest N"
+		}
+
+		if n > maxLatestCount {
+			n = maxLatestCount
+		}
+	}
+
+	articles, err := b.archive.Latest(n)
+	if err != nil {
+		return replyError("unable to load articles", err)
+	}
+
+	if len(articles) == 0 {
+		return "No articles yet."
+	}
+
+	var sb strings.Builder
+	for i, article := range articles {
+		if i > 0 {
+			sb.WriteString("\n\n")
+		}
+
+		fmt.Fprintf(&sb, "%s\n%s\nID: %s", article.Title, article.LinkURL, article.ID)
+	}
+
+	return sb.String()
+}
+
+func (b *Bot) resendCommand(id string, followUp func(text string)) string {
+	if id == "" {
+		return "Usage: /resend ID"
+	}
+
+	article, found, err := b.archive.Get(id)
+	if err != nil {
+		return replyError("unable to load article", err)
+	}
+
+	if !found {
+		return fmt.Sprintf("Article %s not found.", id)
+	}
+
+	select {
+	case b.resends <- resendRequest{Article: article, FollowUp: followUp}:
+		return fmt.Sprintf("Article %s will be resent.", article.ID)
+	default:
+		return "Too many articles are being resent, try again later."
+	}
+}
+
+// resend redelivers an article and returns a follow-up reply text.
+func (b *Bot) resend(ctx context.Context, article data.Article) string {
+	// Destinations are made to forget the article, so it's delivered as a new one rather than as an update.
+	for _, forgetter := range b.forgetters {
+		err := forgetter.Forget(article.ID)
+		if err != nil {
+			return replyError("unable to forget article", err)
+		}
+	}
+
+	err := b.consumer.On(ctx, article)
+	if err != nil {
+		return replyError("unable to resend article", err)
+	}
+
+	return fmt.Sprintf("Article %s has been resent.", article.ID)
+}
+
+func (b *Bot) forgetCommand(id string) string {
+	if id == "" {
+		return "Usage: /forget ID"
+	}
+
+	err := b.archive.Forget(id)
+	if err != nil {
+		return replyError("unable to forget article", err)
+	}
+
+	for _, forgetter := range b.forgetters {
+		err = forgetter.Forget(id)
+		if err != nil {
+			return replyError("unable to forget article", err)
+		}
+	}
+
+	return fmt.Sprintf("Article %s has been forgotten, it will be delivered on next sync if it's still in the feed.", id)
+}
+
+func (b *Bot) pauseCommand(pause bool) string {
+	if b.scheduler == nil {
+		return "Scheduler is disabled."
+	}
+
+	if pause {
+		b.scheduler.Pause()
+		return "Scheduler has been paused."
+	}
+
+	b.scheduler.Resume()
+	return "Scheduler has been resumed."
+}
+
+func (b *Bot) syncCommand() string {
+	if b.triggerSync == nil {
+		return "Sync is not available."
+	}
+
+	b.triggerSync()
+	return "Sync has been started."
+}
+
+func (b *Bot) searchCommand(query string) string {
+	if b.searcher == nil {
+		return "Search is not available."
+	}
+
+	if query == "" {
+		return "Usage: /search QUERY"
+	}
+
+	results, err := b.searcher.Search(query, searchResultCount)
+	if err != nil {
+		return replyError("unable to search articles", err)
+	}
+
+	if len(results.Hits) == 0 {
+		return "No articles found."
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Found %d articles", results.Total)
+	if results.Total > uint64(len(results.Hits)) {
+		fmt.Fprintf(&sb, ", showing %d best matches", len(results.Hits))
+	}
+
+	for _, hit := range results.Hits {
+		fmt.Fprintf(&sb, "\n\n%s\n%s", hit.Title, hit.URL)
+	}
+
+	return sb.String()
+}
+
+func replyError(msg string, err error) string {
+	log.Error().Err(err).Msg(msg)
+	return fmt.Sprintf("Error: %s: %v", msg, err)
+}
@@ -0,0 +1,217 @@
+package admin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kapitanov/habrabot/internal/data"
+	"github.com/kapitanov/habrabot/internal/search"
+)
+
+type inMemoryArchive []data.Article
+
+func (a *inMemoryArchive) Get(id string) (data.Article, bool, error) {
+	for _, article := range *a {
+		if article.ID == id {
+			return article, true, nil
+		}
+	}
+
+	return data.Article{}, false, nil
+}
+
+func (a *inMemoryArchive) Latest(n int) ([]data.Article, error) {
+	if len(*a) < n {
+		n = len(*a)
+	}
+
+	return (*a)[:n], nil
+}
+
+func (a *inMemoryArchive) Count() (int, error) {
+	return len(*a), nil
+}
+
+func (a *inMemoryArchive) Forget(id string) error {
+	for i, article := range *a {
+		if article.ID == id {
+			*a = append((*a)[:i], (*a)[i+1:]...)
+			return nil
+		}
+	}
+
+	return nil
+}
+
+type recordingForgetter []string
+
+func (f *recordingForgetter) Forget(id string) error {
+	*f = append(*f, id)
+	return nil
+}
+
+type pausable bool
+
+func (p *pausable) Pause()         { *p = true }
+func (p *pausable) Resume()        { *p = false }
+func (p *pausable) IsPaused() bool { return bool(*p) }
+
+type fixedQueue int
+
+func (q fixedQueue) Len() (int, error) {
+	return int(q), nil
+}
+
+func newTestBot(options ...Option) (*Bot, *inMemoryArchive, *[]data.Article) {
+	archive := &inMemoryArchive{
+		{ID: "2", Title: "Second", LinkURL: "https://habr.com/post/2/"},
+		{ID: "1", Title: "First", LinkURL: "https://habr.com/post/1/"},
+	}
+
+	var delivered []data.Article
+	consumer := data.ConsumerFunc(func(_ context.Context, article data.Article) error {
+		delivered = append(delivered, article)
+		return nil
+	})
+
+	return New([]int64{1}, archive, consumer, options...), archive, &delivered
+}
+
+func TestExecute_Status(t *testing.T) {
+	status := NewStatus()
+	status.Synced(3)
+	scheduler := pausable(true)
+
+	b, _, _ := newTestBot(WithStatus(status), WithQueue("outgoing", fixedQueue(4)), WithScheduler(&scheduler))
+
+	reply := b.execute(context.Background(), "status", "", nil)
+
+	assert.Contains(t, reply, "3 new articles")
+	assert.Contains(t, reply, "Processed articles: 2")
+	assert.Contains(t, reply, "Queued in outgoing: 4")
+	assert.Contains(t, reply, "Scheduler: paused")
+}
+
+func TestExecute_Latest(t *testing.T) {
+	b, _, _ := newTestBot()
+
+	assert.Equal(t, "Second\nhttps://habr.com/post/2/\nID: 2", b.execute(context.Background(), "latest", "1", nil))
+	assert.Equal(t, "Usage: /latest N", b.execute(context.Background(), "latest", "many", nil))
+}
+
+func TestExecute_Resend(t *testing.T) {
+	forgetter := &recordingForgetter{}
+	b, _, delivered := newTestBot(WithForgetter(forgetter))
+
+	followUps := make(chan string, 1)
+	reply := b.execute(context.Background(), "resend", "1", func(text string) {
+		followUps <- text
+	})
+
+	assert.Equal(t, "Article 1 will be resent.", reply)
+	assert.Empty(t, *delivered)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go b.Run(ctx)
+
+	select {
+	case text := <-followUps:
+		assert.Equal(t, "Article 1 has been resent.", text)
+	case <-time.After(time.Second):
+		require.Fail(t, "article hasn't been resent")
+	}
+
+	assert.Equal(t, []string{"1"}, []string(*forgetter))
+	if assert.Len(t, *delivered, 1) {
+		assert.Equal(t, "First", (*delivered)[0].Title)
+	}
+
+	assert.Equal(t, "Article 3 not found.", b.execute(context.Background(), "resend", "3", nil))
+}
+
+func TestExecute_ResendQueueFull(t *testing.T) {
+	b, _, _ := newTestBot()
+
+	for i := 0; i < maxPendingResends; i++ {
+		assert.Equal(t, "Article 1 will be resent.", b.execute(context.Background(), "resend", "1", nil))
+	}
+
+	assert.Equal(t, "Too many articles are being resent, try again later.", b.execute(context.Background(), "resend", "1", nil))
+}
+
+func TestExecute_Forget(t *testing.T) {
+	forgetter := &recordingForgetter{}
+	b, archive, _ := newTestBot(WithForgetter(forgetter))
+
+	b.execute(context.Background(), "forget", "2", nil)
+
+	assert.Len(t, *archive, 1)
+	assert.Equal(t, []string{"2"}, []string(*forgetter))
+}
+
+func TestExecute_PauseResume(t *testing.T) {
+	b, _, _ := newTestBot()
+	assert.Equal(t, "Scheduler is disabled.", b.execute(context.Background(), "pause", "", nil))
+
+	scheduler := pausable(false)
+	b, _, _ = newTestBot(WithScheduler(&scheduler))
+
+	b.execute(context.Background(), "pause", "", nil)
+	assert.True(t, scheduler.IsPaused())
+
+	b.execute(context.Background(), "resume", "", nil)
+	assert.False(t, scheduler.IsPaused())
+}
+
+func TestExecute_Sync(t *testing.T) {
+	triggered := false
+	b, _, _ := newTestBot(WithSyncTrigger(func() { triggered = true }))
+
+	assert.Equal(t, "Sync has been started.", b.execute(context.Background(), "sync", "", nil))
+	assert.True(t, triggered)
+}
+
+type fixedSearcher search.Results
+
+func (s fixedSearcher) Search(_ string, limit int) (search.Results, error) {
+	results := search.Results(s)
+	if len(results.Hits) > limit {
+		results.Hits = results.Hits[:limit]
+	}
+
+	return results, nil
+}
+
+func TestExecute_Search(t *testing.T) {
+	b, _, _ := newTestBot()
+	assert.Equal(t, "Search is not available.", b.execute(context.Background(), "search", "go", nil))
+
+	b, _, _ = newTestBot(WithSearcher(fixedSearcher{}))
+	assert.Equal(t, "Usage: /search QUERY", b.execute(context.Background(), "search", " ", nil))
+	assert.Equal(t, "No articles found.", b.execute(context.Background(), "search", "go", nil))
+
+	b, _, _ = newTestBot(WithSearcher(fixedSearcher{
+		Total: 12,
+		Hits: []search.Result{
+			{ID: "1", Title: "First", URL: "https://habr.com/post/1/"},
+			{ID: "2", Title: "Second", URL: "https://habr.com/post/2/"},
+		},
+	}))
+	assert.Equal(t,
+		"Found 12 articles, showing 2 best matches\n\nFirst\nhttps://habr.com/post/1/\n\nSecond\nhttps://habr.com/post/2/",
+		b.execute(context.Background(), "search", "go", nil),
+	)
+}
+
+func TestStatus_String(t *testing.T) {
+	status := NewStatus()
+	assert.Equal(t, "never", status.String())
+
+	status.Synced(0)
+	assert.Contains(t, status.String(), "0 new articles")
+}
@@ -0,0 +1,40 @@
+package admin
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Status keeps results of the latest feed synchronization.
+type Status struct {
+	mutex       sync.Mutex
+	lastSync    time.Time
+	newArticles int
+}
+
+// NewStatus creates new synchronization status.
+func NewStatus() *Status {
+	return &Status{}
+}
+
+// Synced records results of a feed synchronization.
+func (s *Status) Synced(newArticles int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.lastSync = time.Now()
+	s.newArticles = newArticles
+}
+
+// String returns a human-readable description of the latest synchronization.
+func (s *Status) String() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.lastSync.IsZero() {
+		return "never"
+	}
+
+	return fmt.Sprintf("%s, %d new articles", s.lastSync.UTC().Format(time.RFC3339), s.newArticles)
+}
@@ -0,0 +1,424 @@
+package carboncopy
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// ArchiveMode defines how much of a web page is stored.
+type ArchiveMode string
+
+const (
+	ArchiveModePage       ArchiveMode = "page"        // Only HTML of the page is stored.
+	ArchiveModeAssets     ArchiveMode = "assets"      // Images, stylesheets and fonts are stored into a directory shared by all pages.
+	ArchiveModeSingleFile ArchiveMode = "single_file" // Images, stylesheets and fonts are inlined into a self-contained HTML file.
+)
+
+// AssetsDirName is a name of the directory that assets are stored into, relative to carbon copy directory.
+const AssetsDirName = "assets"
+
+const (
+	maxAssetSize       = 16 * 1024 * 1024
+	maxAssetsPerPage   = 300
+	maxStylesheetDepth = 3     // Maximum depth of nested stylesheet imports.
+	maxKnownAssets     = 10000 // Assets cache is reset when it grows larger.
+)
+
+// ParseArchiveMode parses an archive mode.
+func ParseArchiveMode(str string) (ArchiveMode, error) {
+	switch m := ArchiveMode(strings.ToLower(strings.TrimSpace(str))); m {
+	case "", ArchiveModePage:
+		return ArchiveModePage, nil
+	case ArchiveModeAssets, ArchiveModeSingleFile:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown archive mode \"%s\", expected \"page\", \"assets\" or \"single_file\"", str)
+	}
+}
+
+var errTooManyAssets = errors.New("too many assets")
+
+// pageArchiver downloads assets of a single page and rewrites references to them.
+type pageArchiver struct {
+	consumer *consumer
+	ctx      context.Context
+	pageDir  string            // Directory the page is stored into.
+	inlined  map[string]string // Data URIs of assets that have been inlined into the page.
+	count    int
+	assets   []download // Assets that have been downloaded for the page.
+}
+
+// archivePage downloads assets of a web page and returns page's HTML that refers to local copies of them,
+// along with the assets that have been downloaded.
+func (c *consumer) archivePage(ctx context.Context, page download, pageDir string) ([]byte, []download, error) {
+	doc, err := html.Parse(bytes.NewReader(page.Body))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	base, err := url.Parse(page.FinalURL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	a := &pageArchiver{
+		consumer: c,
+		ctx:      ctx,
+		pageDir:  pageDir,
+		inlined:  make(map[string]string),
+	}
+
+	a.rewriteNode(doc, base)
+
+	if ctx.Err() != nil {
+		return nil, nil, ctx.Err()
+	}
+
+	var buf bytes.Buffer
+	err = html.Render(&buf, doc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	log.Debug().Str("url", page.FinalURL).Int("assets", a.count).Msg("archived page assets")
+	return buf.Bytes(), a.assets, nil
+}
+
+func (a *pageArchiver) rewriteNode(n *html.Node, base *url.URL) {
+	if n.Type == html.ElementNode {
+		switch n.DataAtom {
+		case atom.Base:
+			// Links are resolved by the archiver, so the base URL would only break local references.
+			if href := getAttr(n, "href"); href != "" {
+				if u, err := base.Parse(href); err == nil {
+					*base = *u
+				}
+			}
+
+			defer n.Parent.RemoveChild(n)
+
+		case atom.Img:
+			// Lazy-loaded images keep their actual URL in data attributes, which are loaded by scripts.
+			if src := getAttr(n, "data-src"); src != "" {
+				setAttr(n, "src", src)
+				removeAttr(n, "data-src")
+			}
+
+			if srcset := getAttr(n, "data-srcset"); srcset != "" {
+				setAttr(n, "srcset", srcset)
+				removeAttr(n, "data-srcset")
+			}
+
+			a.rewriteAttr(n, "src", base)
+			a.rewriteSrcset(n, base)
+
+		case atom.Source:
+			a.rewriteAttr(n, "src", base)
+			a.rewriteSrcset(n, base)
+
+		case atom.Video:
+			a.rewriteAttr(n, "poster", base)
+
+		case atom.Link:
+			if isAssetLink(n) {
+				a.rewriteLink(n, base)
+			}
+
+		case atom.Style:
+			if n.FirstChild != nil && n.FirstChild.Type == html.TextNode {
+				n.FirstChild.Data = a.rewriteCSS(n.FirstChild.Data, base, 0)
+			}
+		}
+
+		if style := getAttr(n, "style"); style != "" {
+			setAttr(n, "style", a.rewriteCSS(style, base, 0))
+		}
+	}
+
+	for child := n.FirstChild; child != nil; {
+		// Children may be removed while they are rewritten.
+		next := child.NextSibling
+		a.rewriteNode(child, base)
+		child = next
+	}
+}
+
+func (a *pageArchiver) rewriteAttr(n *html.Node, key string, base *url.URL) {
+	value := getAttr(n, key)
+	if value == "" {
+		return
+	}
+
+	if ref, ok := a.asset(value, base, 0); ok {
+		setAttr(n, key, ref)
+	}
+}
+
+func (a *pageArchiver) rewriteSrcset(n *html.Node, base *url.URL) {
+	srcset := getAttr(n, "srcset")
+	if srcset == "" {
+		return
+	}
+
+	candidates := strings.Split(srcset, ",")
+	for i, candidate := range candidates {
+		fields := strings.Fields(candidate)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if ref, ok := a.asset(fields[0], base, 0); ok {
+			fields[0] = ref
+		}
+
+		candidates[i] = strings.Join(fields, " ")
+	}
+
+	setAttr(n, "srcset", strings.Join(candidates, ", "))
+}
+
+func (a *pageArchiver) rewriteLink(n *html.Node, base *url.URL) {
+	if ref, ok := a.asset(getAttr(n, "href"), base, 0); ok {
+		setAttr(n, "href", ref)
+
+		// Stylesheets are altered by rewriting, so their original hashes would make browsers reject them.
+		removeAttr(n, "integrity")
+		removeAttr(n, "crossorigin")
+	}
+}
+
+// asset downloads an asset and returns a reference to its local copy that replaces the original URL.
+// Stylesheets are rewritten to refer to local copies of their own assets.
+func (a *pageArchiver) asset(rawURL string, base *url.URL, depth int) (string, bool) {
+	rawURL = strings.TrimSpace(rawURL)
+	if rawURL == "" || strings.HasPrefix(rawURL, "#") || strings.HasPrefix(rawURL, "data:") {
+		return "", false
+	}
+
+	u, err := base.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", false
+	}
+
+	u.Fragment = ""
+	assetURL := u.String()
+
+	if a.consumer.mode == ArchiveModeSingleFile {
+		if ref, ok := a.inlined[assetURL]; ok {
+			return ref, true
+		}
+	} else if name, ok := a.consumer.knownAsset(assetURL); ok {
+		return a.reference(name, depth), true
+	}
+
+	if a.ctx.Err() != nil {
+		return "", false
+	}
+
+	if a.count >= maxAssetsPerPage {
+		log.Warn().Err(errTooManyAssets).Str("url", assetURL).Msg("unable to archive asset")
+		return "", false
+	}
+
+	a.count++
+
+	asset, err := a.consumer.download(a.ctx, assetURL, maxAssetSize)
+	if err != nil {
+		log.Warn().Err(err).Str("url", assetURL).Msg("unable to archive asset")
+		return "", false
+	}
+
+	a.assets = append(a.assets, asset)
+
+	contentType := assetContentType(asset)
+	if contentType == "text/css" && depth < maxStylesheetDepth {
+		assetBase, err := url.Parse(asset.FinalURL)
+		if err != nil {
+			assetBase = u
+		}
+
+		asset.Body = []byte(a.rewriteCSS(string(asset.Body), assetBase, depth+1))
+	}
+
+	if a.consumer.mode == ArchiveModeSingleFile {
+		ref := fmt.Sprintf("data:%s;base64,%s", contentType, base64.StdEncoding.EncodeToString(asset.Body))
+		a.inlined[assetURL] = ref
+		return ref, true
+	}
+
+	name, err := a.consumer.storeAsset(a.ctx, assetURL, contentType, asset.Body)
+	if err != nil {
+		log.Warn().Err(err).Str("url", assetURL).Msg("unable to store asset")
+		return "", false
+	}
+
+	return a.reference(name, depth), true
+}
+
+// reference returns a relative URL of an asset that is stored into assets directory.
+func (a *pageArchiver) reference(name string, depth int) string {
+	if depth > 0 {
+		// Assets of stylesheets are referred to from the assets directory itself.
+		return name
+	}
+
+	return assetReference(a.pageDir, name)
+}
+
+// assetReference returns a URL of an asset relative to a directory of a page,
+// which is a slash-separated path relative to the root of the storage.
+func assetReference(pageDir, name string) string {
+	var up string
+	if pageDir = path.Clean(pageDir); pageDir != "." {
+		up = strings.Repeat("../", strings.Count(pageDir, "/")+1)
+	}
+
+	return up + AssetsDirName + "/" + name
+}
+
+func (c *consumer) knownAsset(assetURL string) (string, bool) {
+	c.assetsMutex.Lock()
+	defer c.assetsMutex.Unlock()
+
+	name, ok := c.assets[assetURL]
+	return name, ok
+}
+
+// storeAsset writes an asset into assets directory and returns its file name.
+// Files are named by hashes of their content, so an asset shared by several pages is stored once.
+func (c *consumer) storeAsset(ctx context.Context, assetURL, contentType string, body []byte) (string, error) {
+	name := checksum(body) + assetExtension(assetURL, contentType)
+
+	exists, err := c.storage.Exists(ctx, AssetsDirName+"/"+name)
+	if err != nil {
+		return "", err
+	}
+
+	if !exists {
+		err = c.storage.Write(ctx, AssetsDirName+"/"+name, bytes.NewReader(body))
+		if err != nil {
+			return "", err
+		}
+	}
+
+	c.assetsMutex.Lock()
+	defer c.assetsMutex.Unlock()
+
+	if len(c.assets) >= maxKnownAssets {
+		c.assets = make(map[string]string)
+	}
+
+	c.assets[assetURL] = name
+	return name, nil
+}
+
+func assetContentType(asset download) string {
+	contentType, _, err := mime.ParseMediaType(asset.ContentType)
+	if err == nil && contentType != "" && contentType != "application/octet-stream" {
+		return contentType
+	}
+
+	u, err := url.Parse(asset.FinalURL)
+	if err == nil {
+		if byExt := mime.TypeByExtension(path.Ext(u.Path)); byExt != "" {
+			contentType, _, _ = mime.ParseMediaType(byExt)
+			return contentType
+		}
+	}
+
+	return http.DetectContentType(asset.Body)
+}
+
+// assetExtension returns a file name extension of an asset, so local copies are opened with proper content types.
+func assetExtension(assetURL, contentType string) string {
+	if u, err := url.Parse(assetURL); err == nil {
+		ext := strings.ToLower(path.Ext(u.Path))
+		if len(ext) > 1 && len(ext) <= 6 && mime.TypeByExtension(ext) != "" {
+			return ext
+		}
+	}
+
+	switch contentType {
+	case "text/css":
+		return ".css"
+	case "image/jpeg":
+		return ".jpg"
+	}
+
+	exts, err := mime.ExtensionsByType(contentType)
+	if err != nil || len(exts) == 0 {
+		return ""
+	}
+
+	return exts[0]
+}
+
+func isAssetLink(n *html.Node) bool {
+	for _, rel := range strings.Fields(strings.ToLower(getAttr(n, "rel"))) {
+		switch rel {
+		case "stylesheet", "icon", "apple-touch-icon":
+			return true
+		case "preload":
+			switch getAttr(n, "as") {
+			case "style", "font", "image":
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func getAttr(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Namespace == "" && attr.Key == key {
+			return attr.Val
+		}
+	}
+
+	return ""
+}
+
+func hasAttr(n *html.Node, key string) bool {
+	for _, attr := range n.Attr {
+		if attr.Namespace == "" && attr.Key == key {
+			return true
+		}
+	}
+
+	return false
+}
+
+func setAttr(n *html.Node, key, value string) {
+	for i, attr := range n.Attr {
+		if attr.Namespace == "" && attr.Key == key {
+			n.Attr[i].Val = value
+			return
+		}
+	}
+
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: value})
+}
+
+func removeAttr(n *html.Node, key string) {
+	attrs := n.Attr[:0]
+	for _, attr := range n.Attr {
+		if attr.Namespace != "" || attr.Key != key {
+			attrs = append(attrs, attr)
+		}
+	}
+
+	n.Attr = attrs
+}
@@ -0,0 +1,160 @@
+package carboncopy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kapitanov/habrabot/internal/data"
+)
+
+const testPage = `<!DOCTYPE html>
+<html>
+<head>
+<link rel="stylesheet" href="/static/style.css" integrity="sha256-abc" crossorigin="anonymous">
+<link rel="canonical" href="/post/1/">
+<style>body { background: url('/static/bg.png') }</style>
+</head>
+<body>
+<img src="/static/placeholder.png" data-src="/static/image.png">
+<img srcset="/static/image.png 1x, /static/missing.png 2x">
+<div style="background-image: url(/static/bg.png)"></div>
+<a href="/post/2/">Next</a>
+</body>
+</html>`
+
+// testSite serves article pages and their assets, counting requests to each asset.
+type testSite struct {
+	*httptest.Server
+
+	mutex    sync.Mutex
+	requests map[string]int
+}
+
+func newTestSite(t *testing.T) *testSite {
+	s := &testSite{requests: make(map[string]int)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mutex.Lock()
+		s.requests[r.URL.Path]++
+		s.mutex.Unlock()
+
+		switch r.URL.Path {
+		case "/static/style.css":
+			w.Header().Set("Content-Type", "text/css; charset=utf-8")
+			_, _ = w.Write([]byte(`@import "fonts.css"; h1 { color: red }`))
+		case "/static/fonts.css":
+			w.Header().Set("Content-Type", "text/css")
+			_, _ = w.Write([]byte(`@font-face { src: url("font.woff2") format("woff2") }`))
+		case "/static/font.woff2":
+			_, _ = w.Write([]byte("FONT"))
+		case "/static/image.png", "/static/bg.png":
+			w.Header().Set("Content-Type", "image/png")
+			_, _ = w.Write([]byte("PNG " + r.URL.Path))
+		case "/post/1/", "/post/2/":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_, _ = w.Write([]byte(testPage))
+		case "/post/old/":
+			http.Redirect(w, r, "/post/1/", http.StatusMovedPermanently)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func (s *testSite) Requests(path string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.requests[path]
+}
+
+func TestConsumer_ArchiveAssets(t *testing.T) {
+	site := newTestSite(t)
+	dir := t.TempDir()
+	c := Use(dir, WithArchiveMode(ArchiveModeAssets))
+
+	// Both articles are served with the same page, so they share all assets.
+	first := data.Article{ID: "1", Title: "First", LinkURL: site.URL + "/post/1/"}
+	second := data.Article{ID: "2", Title: "Second", LinkURL: site.URL + "/post/2/"}
+
+	require.NoError(t, c.On(context.Background(), first))
+	require.NoError(t, c.On(context.Background(), second))
+
+	page, err := os.ReadFile(filepath.Join(dir, extractFileName(first)))
+	require.NoError(t, err)
+
+	html := string(page)
+	assert.NotContains(t, html, "/static/style.css")
+	assert.NotContains(t, html, "/static/image.png")
+	assert.NotContains(t, html, "/static/bg.png")
+	assert.NotContains(t, html, "integrity")
+	assert.Contains(t, html, "/static/missing.png", "assets that can't be downloaded keep their original URLs")
+	assert.Contains(t, html, `href="/post/1/"`, "links that aren't assets are kept as is")
+	assert.Contains(t, html, `src="assets/`)
+
+	assets, err := os.ReadDir(filepath.Join(dir, AssetsDirName))
+	require.NoError(t, err)
+	assert.Len(t, assets, 5) // style.css, fonts.css, font.woff2, image.png and bg.png.
+
+	for _, path := range []string{"/static/style.css", "/static/fonts.css", "/static/font.woff2", "/static/image.png", "/static/bg.png"} {
+		assert.Equal(t, 1, site.Requests(path), path)
+	}
+
+	var fonts string
+	for _, asset := range assets {
+		body, err := os.ReadFile(filepath.Join(dir, AssetsDirName, asset.Name()))
+		require.NoError(t, err)
+
+		if strings.HasPrefix(string(body), "@font-face") {
+			fonts = string(body)
+		}
+	}
+
+	// Stylesheets refer to their assets from the assets directory.
+	assert.Regexp(t, `url\("[0-9a-f]{64}\.woff2"\)`, fonts)
+}
+
+func TestConsumer_ArchiveSingleFile(t *testing.T) {
+	site := newTestSite(t)
+	dir := t.TempDir()
+	c := Use(dir, WithArchiveMode(ArchiveModeSingleFile))
+
+	article := data.Article{ID: "1", Title: "First", LinkURL: site.URL + "/post/1/"}
+	require.NoError(t, c.On(context.Background(), article))
+
+	page, err := os.ReadFile(filepath.Join(dir, extractFileName(article)))
+	require.NoError(t, err)
+
+	html := string(page)
+	assert.Contains(t, html, `href="data:text/css;base64,`)
+	assert.Contains(t, html, `src="data:image/png;base64,`)
+	assert.NotContains(t, html, "/static/bg.png")
+	assert.Equal(t, 1, site.Requests("/static/bg.png"))
+
+	_, err = os.Stat(filepath.Join(dir, AssetsDirName))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestParseArchiveMode(t *testing.T) {
+	mode, err := ParseArchiveMode("")
+	require.NoError(t, err)
+	assert.Equal(t, ArchiveModePage, mode)
+
+	mode, err = ParseArchiveMode("Single_File")
+	require.NoError(t, err)
+	assert.Equal(t, ArchiveModeSingleFile, mode)
+
+	_, err = ParseArchiveMode("warc")
+	assert.Error(t, err)
+}
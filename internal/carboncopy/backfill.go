@@ -0,0 +1,179 @@
+package carboncopy
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/kapitanov/habrabot/internal/data"
+)
+
+// ProgressStore keeps track of articles that have been backfilled, so an interrupted backfill is resumed where it has stopped.
+type ProgressStore interface {
+	// Get loads a value by its key. It returns false if the key doesn't exist.
+	Get(key string, value interface{}) (bool, error)
+
+	// Put stores a value by its key.
+	Put(key string, value interface{}) error
+}
+
+// Backfill archives articles that have been processed before carbon copies were enabled, or that have failed to be archived.
+type Backfill struct {
+	Concurrency int           // Number of articles that are archived at once, one if it's not set.
+	Force       bool          // Archive articles again even if they have carbon copies already.
+	Progress    ProgressStore // Articles that have been recorded into the store by previous runs are skipped, if it's set.
+}
+
+// forcedProgressPrefix is a prefix of progress keys of forced backfills.
+// They are kept apart, so a forced backfill archives again articles that have been completed by a regular one.
+const forcedProgressPrefix = "force/"
+
+// BackfillReport is a result of a backfill.
+type BackfillReport struct {
+	Archived int      // Number of articles that have been archived.
+	Skipped  int      // Number of articles that have carbon copies already, or that have been completed by previous runs.
+	Failed   []string // IDs of articles that haven't been archived, they are retried by the next run.
+}
+
+// backfillProgress is a record of an article that has been completed by a backfill.
+type backfillProgress struct {
+	CompletedAt time.Time `json:"completed_at"`
+}
+
+// Run archives articles that are missing from the archive of a carbon copy consumer configured by options.
+// Articles that can't be archived are reported and skipped, so a single broken page doesn't stop the backfill.
+func (b Backfill) Run(ctx context.Context, dirPath string, articles []data.Article, options ...Option) (BackfillReport, error) {
+	c := newConsumer(dirPath, options...)
+
+	concurrency := b.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	// Articles that share a path are archived by the same worker one by one,
+	// so they don't take the same path while the collision is being resolved.
+	var groups [][]data.Article
+	groupIndex := make(map[string]int)
+	for _, article := range articles {
+		p := c.layout.Path(article)
+		i, ok := groupIndex[p]
+		if !ok {
+			i = len(groups)
+			groupIndex[p] = i
+			groups = append(groups, nil)
+		}
+
+		groups[i] = append(groups[i], article)
+	}
+
+	var (
+		report BackfillReport
+		mutex  sync.Mutex
+		wg     sync.WaitGroup
+	)
+
+	jobs := make(chan []data.Article)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for group := range jobs {
+				for _, article := range group {
+					archived, err := b.archive(ctx, c, article)
+					if ctx.Err() != nil {
+						// Articles that have been interrupted are archived by the next run.
+						return
+					}
+
+					mutex.Lock()
+					switch {
+					case err != nil:
+						log.Error().Err(err).Str("id", article.ID).Msg("unable to backfill cc file")
+						report.Failed = append(report.Failed, article.ID)
+					case archived:
+						report.Archived++
+					default:
+						report.Skipped++
+					}
+					mutex.Unlock()
+				}
+			}
+		}()
+	}
+
+loop:
+	for _, group := range groups {
+		select {
+		case jobs <- group:
+		case <-ctx.Done():
+			break loop
+		}
+	}
+
+	close(jobs)
+	wg.Wait()
+
+	sort.Strings(report.Failed)
+
+	// Index pages are rewritten once, rather than after each article.
+	// They are rewritten even if the backfill has been interrupted, so they list articles that have been archived.
+	err := data.Flush(context.Background(), c)
+	if err != nil {
+		return report, err
+	}
+
+	// The WARC file that has been recorded is completed, so it isn't left open until the next run.
+	err = c.Close()
+	if err != nil {
+		return report, err
+	}
+
+	return report, ctx.Err()
+}
+
+// archive stores a carbon copy of an article, unless it's been stored already.
+// It returns true if the article has been archived.
+func (b Backfill) archive(ctx context.Context, c *consumer, article data.Article) (bool, error) {
+	key := strings.ToLower(article.ID)
+	if b.Force {
+		key = forcedProgressPrefix + key
+	}
+
+	if b.Progress != nil {
+		var progress backfillProgress
+		completed, err := b.Progress.Get(key, &progress)
+		if err != nil || completed {
+			return false, err
+		}
+	}
+
+	exists := false
+	if !b.Force {
+		var err error
+		_, exists, err = c.resolver.lookup(ctx, article)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if !exists {
+		err := c.On(ctx, article)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if b.Progress != nil {
+		err := b.Progress.Put(key, backfillProgress{CompletedAt: time.Now().UTC()})
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return !exists, nil
+}
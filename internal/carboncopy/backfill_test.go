@@ -0,0 +1,124 @@
+package carboncopy
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kapitanov/habrabot/internal/data"
+)
+
+// inMemoryProgress is a progress store that keeps JSON-encoded values in memory.
+type inMemoryProgress struct {
+	mutex  sync.Mutex
+	values map[string][]byte
+}
+
+func (p *inMemoryProgress) Get(key string, value interface{}) (bool, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	raw, ok := p.values[key]
+	if !ok {
+		return false, nil
+	}
+
+	return true, json.Unmarshal(raw, value)
+}
+
+func (p *inMemoryProgress) Put(key string, value interface{}) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	p.values[key] = raw
+	return nil
+}
+
+func TestBackfill(t *testing.T) {
+	site := newTestSite(t)
+	dir := t.TempDir()
+
+	// Path template makes paths unique, since all test articles are served by the same host.
+	layout, err := ParseLayout("{{.ID}}.html", 0)
+	require.NoError(t, err)
+
+	first := data.Article{ID: "1", Title: "First", LinkURL: site.URL + "/post/1/"}
+	second := data.Article{ID: "2", Title: "Second", LinkURL: site.URL + "/post/2/"}
+	missing := data.Article{ID: "3", Title: "Missing", LinkURL: site.URL + "/post/missing/"}
+	articles := []data.Article{first, second, missing}
+
+	require.NoError(t, Use(dir, WithLayout(layout)).On(context.Background(), first))
+
+	progress := &inMemoryProgress{values: make(map[string][]byte)}
+	backfill := Backfill{Concurrency: 2, Progress: progress}
+
+	report, err := backfill.Run(context.Background(), dir, articles, WithLayout(layout))
+	require.NoError(t, err)
+	assert.Equal(t, BackfillReport{Archived: 1, Skipped: 1, Failed: []string{"3"}}, report)
+	assert.Equal(t, 1, site.Requests("/post/1/"), "archived articles are not downloaded again")
+
+	for _, name := range []string{"2.html", "2.json", indexFileName} {
+		_, err = os.Stat(filepath.Join(dir, name))
+		assert.NoError(t, err, name)
+	}
+
+	// Completed articles are skipped by the next run, failed ones are retried.
+	report, err = backfill.Run(context.Background(), dir, articles, WithLayout(layout))
+	require.NoError(t, err)
+	assert.Equal(t, BackfillReport{Skipped: 2, Failed: []string{"3"}}, report)
+	assert.Equal(t, 2, site.Requests("/post/missing/"))
+
+	// Forced backfill archives completed articles again, and skips ones that it has completed itself.
+	backfill.Force = true
+	report, err = backfill.Run(context.Background(), dir, []data.Article{first, second}, WithLayout(layout))
+	require.NoError(t, err)
+	assert.Equal(t, BackfillReport{Archived: 2}, report)
+	assert.Equal(t, 2, site.Requests("/post/1/"))
+
+	report, err = backfill.Run(context.Background(), dir, []data.Article{first, second}, WithLayout(layout))
+	require.NoError(t, err)
+	assert.Equal(t, BackfillReport{Skipped: 2}, report)
+	assert.Equal(t, 2, site.Requests("/post/1/"))
+}
+
+func TestBackfill_WARC(t *testing.T) {
+	site := newTestSite(t)
+	dir := t.TempDir()
+
+	articles := []data.Article{{ID: "1", Title: "First", LinkURL: site.URL + "/post/1/"}}
+	report, err := Backfill{}.Run(context.Background(), dir, articles, WithWARC(WARC{}))
+	require.NoError(t, err)
+	assert.Equal(t, BackfillReport{Archived: 1}, report)
+
+	// WARC file is complete once the backfill is over.
+	warcs, err := filepath.Glob(filepath.Join(dir, WARCDirName, "*"+warcFileSuffix))
+	require.NoError(t, err)
+	assert.Len(t, warcs, 1)
+
+	open, err := filepath.Glob(filepath.Join(dir, WARCDirName, "*"+openFileSuffix))
+	require.NoError(t, err)
+	assert.Empty(t, open)
+}
+
+func TestBackfill_Canceled(t *testing.T) {
+	site := newTestSite(t)
+	dir := t.TempDir()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	report, err := Backfill{}.Run(ctx, dir, []data.Article{{ID: "1", Title: "First", LinkURL: site.URL + "/post/1/"}})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, BackfillReport{}, report)
+}
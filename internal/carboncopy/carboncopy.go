@@ -1,12 +1,16 @@
 package carboncopy
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
-	"path/filepath"
+	"net/http/httputil"
+	"path"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/hashicorp/go-retryablehttp"
 	"github.com/rs/zerolog/log"
@@ -15,69 +19,203 @@ import (
 	"github.com/kapitanov/habrabot/internal/httpclient"
 )
 
-func Use(dirPath string) data.Consumer {
-	log.Info().Str("dir", dirPath).Msg("will store local copy of feed items")
-	return &consumer{
+// maxPageSize limits size of a downloaded web page.
+const maxPageSize = 32 * 1024 * 1024
+
+// Use creates a consumer that stores a copy of each article's web page into a local directory,
+// or into another storage, see WithStorage.
+func Use(dirPath string, options ...Option) data.Consumer {
+	c := newConsumer(dirPath, options...)
+	log.Info().Str("storage", fmt.Sprint(c.storage)).Msg("will store local copy of feed items")
+	return c
+}
+
+func newConsumer(dirPath string, options ...Option) *consumer {
+	c := &consumer{
 		dirPath: dirPath,
+		storage: Dir(dirPath),
+		mode:    ArchiveModePage,
+		assets:  make(map[string]string),
+	}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	if c.resolver == nil {
+		c.resolver = NewResolver(c.layout, c.storage)
+	}
+
+	if c.warc != nil && c.storage != Dir(dirPath) {
+		c.warc.storage = c.storage
+	}
+
+	return c
+}
+
+// Option configures carbon copy consumer.
+type Option func(c *consumer)
+
+// WithLayout arranges carbon copies within the directory by a layout.
+func WithLayout(layout Layout) Option {
+	return func(c *consumer) {
+		c.layout = layout
+	}
+}
+
+// WithArchiveMode selects how much of a web page is stored.
+func WithArchiveMode(mode ArchiveMode) Option {
+	return func(c *consumer) {
+		c.mode = mode
 	}
 }
 
 type consumer struct {
-	dirPath    string
+	dirPath    string  // Local directory, which holds WARC files that are being recorded.
+	storage    Storage // Storage that carbon copies are stored into.
+	mode       ArchiveMode
+	layout     Layout
+	resolver   *Resolver   // Resolver assigns paths of the layout to articles.
+	warc       *warcWriter // WARC writer is nil if WARC recording is disabled.
+	markdown   bool
+	epub       bool
+	indexer    Indexer // Indexer is nil if full-text indexing is disabled.
 	httpClient *retryablehttp.Client
+	httpMutex  sync.Mutex // HTTP client is created on first use, articles may be stored concurrently.
+
+	// assets maps URLs of downloaded assets to their file names in assets directory,
+	// so assets shared between articles aren't downloaded again.
+	assets      map[string]string
+	assetsMutex sync.Mutex
+
+	// indexSidecars are articles listed on index pages by their file names, they are nil until index pages are rebuilt.
+	// indexPending are articles that have been stored since index pages have been written.
+	indexSidecars map[string]Sidecar
+	indexPending  []Sidecar
+	indexMutex    sync.Mutex
+}
+
+// download is a web page or an asset that has been downloaded.
+type download struct {
+	URL         string // URL that has been requested.
+	FinalURL    string // URL of the response, after redirects.
+	Status      int
+	ContentType string
+	Body        []byte
+	FetchedAt   time.Time
+
+	RequestHead []byte      // Request line and headers of the final request, as they have been sent.
+	StatusLine  string      // Status line of the response, e.g. "HTTP/1.1 200 OK".
+	Header      http.Header // Headers of the response, matching the decoded body.
 }
 
 // On method is invoked when an article is received from the feed.
 func (c *consumer) On(ctx context.Context, article data.Article) error {
-	f, err := c.open(article)
+	page, err := c.download(ctx, article.LinkURL, maxPageSize)
 	if err != nil {
-		log.Error().Err(err).Str("id", article.ID).Msg("unable to open cc file")
+		log.Error().Err(err).Str("id", article.ID).Msg("unable to download cc file")
 		return err
 	}
-	defer func() {
-		_ = f.Close()
-	}()
 
-	resp, err := c.download(ctx, article)
+	name, _, err := c.resolver.Resolve(ctx, article)
 	if err != nil {
-		log.Error().Err(err).Str("id", article.ID).Msg("unable to download cc file")
+		log.Error().Err(err).Str("id", article.ID).Msg("unable to locate cc file")
 		return err
 	}
 
-	defer func() {
-		_ = resp.Body.Close()
-	}()
+	defer c.resolver.release(name)
+
+	body := page.Body
+	var assets []download
+	if c.mode != ArchiveModePage {
+		body, assets, err = c.archivePage(ctx, page, path.Dir(name))
+		if err != nil {
+			log.Error().Err(err).Str("id", article.ID).Msg("unable to archive cc file")
+			return err
+		}
+	}
+
+	// Checksums of other files of the carbon copy are recorded into its sidecar.
+	files := make(map[string]string)
+
+	if c.markdown {
+		md, err := c.renderMarkdown(article, page, name)
+		if err == nil {
+			err = c.store(ctx, markdownFileName(name), md, files)
+		}
+
+		if err != nil {
+			log.Error().Err(err).Str("id", article.ID).Msg("unable to write markdown file")
+			return err
+		}
+	}
+
+	if c.epub {
+		book, err := c.renderEPUB(ctx, article, page)
+		if err == nil {
+			err = c.store(ctx, epubFileName(name), book, files)
+		}
+
+		if err != nil {
+			log.Error().Err(err).Str("id", article.ID).Msg("unable to write epub file")
+			return err
+		}
+	}
 
-	_, err = io.Copy(f, resp.Body)
+	if c.warc != nil {
+		err = c.warc.Write(ctx, page, assets)
+		if err != nil {
+			log.Error().Err(err).Str("id", article.ID).Msg("unable to write warc records")
+			return err
+		}
+	}
+
+	// The page is written right before its sidecar, so failures of other files don't leave a page without a sidecar behind,
+	// which would make its path taken for good, see Layout.Locate.
+	err = c.storage.Write(ctx, name, bytes.NewReader(body))
 	if err != nil {
-		log.Error().Err(err).Str("id", article.ID).Msg("unable to download cc file")
+		log.Error().Err(err).Str("id", article.ID).Msg("unable to write cc file")
 		return err
 	}
 
-	log.Info().Str("id", article.ID).Str("file", f.Name()).Msg("feed item has been stored locally")
-	return nil
-}
+	sidecar, err := c.writeSidecar(ctx, article, page, name, body, files)
+	if err != nil {
+		log.Error().Err(err).Str("id", article.ID).Msg("unable to write sidecar file")
+		return err
+	}
 
-func (c *consumer) open(article data.Article) (*os.File, error) {
-	fullpath := filepath.Join(c.dirPath, extractFileName(article))
+	c.addToIndex(sidecar)
 
-	dirName := filepath.Dir(fullpath)
-	err := os.MkdirAll(dirName, 0755)
-	if err != nil {
-		log.Error().Err(err).Str("path", fullpath).Msg("mkdir error")
-		return nil, err
+	if c.indexer != nil {
+		text, err := extractText(article, page)
+		if err == nil {
+			err = c.indexer.Index(article, text)
+		}
+
+		if err != nil {
+			log.Error().Err(err).Str("id", article.ID).Msg("unable to index cc file")
+		}
 	}
 
-	f, err := os.OpenFile(fullpath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0755)
+	log.Info().Str("id", article.ID).Str("file", name).Msg("feed item has been stored locally")
+	return nil
+}
+
+// store writes a file of a carbon copy and records its checksum.
+func (c *consumer) store(ctx context.Context, name string, content []byte, files map[string]string) error {
+	err := c.storage.Write(ctx, name, bytes.NewReader(content))
 	if err != nil {
-		log.Error().Err(err).Str("path", fullpath).Msg("fopen error")
-		return nil, err
+		return err
 	}
 
-	return f, nil
+	files[name] = checksum(content)
+	return nil
 }
 
-func (c *consumer) download(ctx context.Context, article data.Article) (*http.Response, error) {
+func (c *consumer) client() (*retryablehttp.Client, error) {
+	c.httpMutex.Lock()
+	defer c.httpMutex.Unlock()
+
 	if c.httpClient == nil {
 		httpClient, err := httpclient.New(httpclient.CCPolicy)
 		if err != nil {
@@ -87,22 +225,79 @@ func (c *consumer) download(ctx context.Context, article data.Article) (*http.Re
 		c.httpClient = httpClient
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, article.LinkURL, nil)
+	return c.httpClient, nil
+}
+
+func (c *consumer) download(ctx context.Context, rawURL string, maxSize int64) (download, error) {
+	httpClient, err := c.client()
 	if err != nil {
-		return nil, err
+		return download{}, err
 	}
 
-	resp, err := c.httpClient.StandardClient().Do(req)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
 	if err != nil {
-		return nil, err
+		return download{}, err
 	}
+
+	resp, err := httpClient.StandardClient().Do(req)
+	if err != nil {
+		return download{}, err
+	}
+
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
 	if resp.StatusCode >= http.StatusMultipleChoices {
-		log.Warn().Err(err).
-			Str("url", article.LinkURL).
+		log.Warn().
+			Str("url", rawURL).
 			Int("status", resp.StatusCode).
 			Msg("unable to download web page")
-		return nil, fmt.Errorf("unable to download \"%s\": %v", article.LinkURL, resp.Status)
+		return download{}, fmt.Errorf("unable to download \"%s\": %v", rawURL, resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return download{}, err
+	}
+
+	if int64(len(body)) > maxSize {
+		return download{}, fmt.Errorf("unable to download \"%s\": response is larger than %d bytes", rawURL, maxSize)
+	}
+
+	finalReq := req
+	if resp.Request != nil && resp.Request.URL != nil {
+		// Response refers to the final request if the request has been redirected.
+		finalReq = resp.Request
+	}
+
+	requestHead, err := httputil.DumpRequestOut(finalReq, false)
+	if err != nil {
+		return download{}, err
+	}
+
+	return download{
+		URL:         rawURL,
+		FinalURL:    finalReq.URL.String(),
+		Status:      resp.StatusCode,
+		ContentType: resp.Header.Get("Content-Type"),
+		Body:        body,
+		FetchedAt:   time.Now().UTC(),
+		RequestHead: requestHead,
+		StatusLine:  fmt.Sprintf("%s %s", resp.Proto, resp.Status),
+		Header:      decodedHeader(resp, len(body)),
+	}, nil
+}
+
+// decodedHeader returns response headers that describe the body as it has been read,
+// since HTTP client removes transfer and content encodings.
+func decodedHeader(resp *http.Response, size int) http.Header {
+	header := resp.Header.Clone()
+	header.Del("Transfer-Encoding")
+	if resp.Uncompressed {
+		header.Del("Content-Encoding")
 	}
 
-	return resp, nil
+	header.Set("Content-Length", strconv.Itoa(size))
+	return header
 }
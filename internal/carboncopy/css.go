@@ -0,0 +1,51 @@
+package carboncopy
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var (
+	cssURLRegex    = regexp.MustCompile(`url\(\s*(?:"([^"]*)"|'([^']*)'|([^'")\s]*))\s*\)`)
+	cssImportRegex = regexp.MustCompile(`@import\s+(?:"([^"]*)"|'([^']*)')`)
+)
+
+// rewriteCSS replaces URLs of images, fonts and imported stylesheets in a stylesheet with references to their local copies.
+func (a *pageArchiver) rewriteCSS(css string, base *url.URL, depth int) string {
+	css = cssURLRegex.ReplaceAllStringFunc(css, func(match string) string {
+		rawURL := firstGroup(cssURLRegex.FindStringSubmatch(match))
+
+		ref, ok := a.asset(rawURL, base, depth)
+		if !ok {
+			return match
+		}
+
+		return "url(\"" + escapeCSSString(ref) + "\")"
+	})
+
+	return cssImportRegex.ReplaceAllStringFunc(css, func(match string) string {
+		rawURL := firstGroup(cssImportRegex.FindStringSubmatch(match))
+
+		ref, ok := a.asset(rawURL, base, depth)
+		if !ok {
+			return match
+		}
+
+		return "@import \"" + escapeCSSString(ref) + "\""
+	})
+}
+
+func firstGroup(groups []string) string {
+	for _, group := range groups[1:] {
+		if group != "" {
+			return group
+		}
+	}
+
+	return ""
+}
+
+func escapeCSSString(str string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\a `).Replace(str)
+}
@@ -0,0 +1,366 @@
+package carboncopy
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"mime"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+
+	"github.com/kapitanov/habrabot/internal/data"
+	"github.com/kapitanov/habrabot/internal/epub"
+)
+
+// maxImagesPerChapter limits a number of images that are embedded into a single chapter of an EPUB book.
+const maxImagesPerChapter = 100
+
+// WithEPUB extracts article body from each web page and stores it as an EPUB book next to the page,
+// with article's images embedded into the book.
+func WithEPUB() Option {
+	return func(c *consumer) {
+		c.epub = true
+	}
+}
+
+// NewArticleBook creates an EPUB book with metadata of a single article.
+func NewArticleBook(article data.Article) *epub.Book {
+	book := &epub.Book{
+		Title:    strings.TrimSpace(article.Title),
+		Subjects: article.Tags,
+		Source:   article.LinkURL,
+		Date:     article.Time,
+	}
+
+	if article.LinkURL != "" {
+		book.Identifier = article.LinkURL
+	}
+
+	if article.Author != "" {
+		book.Authors = []string{article.Author}
+	}
+
+	return book
+}
+
+// BuildEPUB adds a chapter per article into an EPUB book, reading articles from their carbon copies
+// that are arranged in a storage by a layout.
+// Articles that have no carbon copies are skipped. Images are taken from the archive if they have been archived,
+// and downloaded otherwise.
+func BuildEPUB(ctx context.Context, storage Storage, layout Layout, book *epub.Book, articles []data.Article) error {
+	c := &consumer{
+		storage: storage,
+		mode:    ArchiveModePage,
+		assets:  make(map[string]string),
+	}
+
+	for _, article := range articles {
+		name, ok, err := layout.Locate(ctx, storage, article)
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			log.Warn().Str("id", article.ID).Str("file", name).Msg("article has no carbon copy")
+			continue
+		}
+
+		body, err := storage.Read(ctx, name)
+		if err != nil {
+			return err
+		}
+
+		chapter, err := c.epubChapter(ctx, book, article, body, article.LinkURL, path.Dir(name))
+		if err != nil {
+			return err
+		}
+
+		book.AddChapter(chapter)
+		if book.Language == "" {
+			book.Language = chapter.Language
+		}
+	}
+
+	return ctx.Err()
+}
+
+// renderEPUB renders article body of a web page as an EPUB book that is stored next to the page.
+func (c *consumer) renderEPUB(ctx context.Context, article data.Article, page download) ([]byte, error) {
+	book := NewArticleBook(article)
+
+	chapter, err := c.epubChapter(ctx, book, article, page.Body, page.FinalURL, "")
+	if err != nil {
+		return nil, err
+	}
+
+	book.AddChapter(chapter)
+	if book.Language == "" {
+		book.Language = chapter.Language
+	}
+
+	var buf bytes.Buffer
+	err = book.Write(&buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// epubFileName returns a name of the EPUB file of a page.
+func epubFileName(pagePath string) string {
+	return strings.TrimSuffix(pagePath, ".html") + ".epub"
+}
+
+// epubChapter extracts article body from a web page and renders it as a chapter of a book, embedding its images.
+// Images are looked up relative to pageDir first, if it's set, since archived pages refer to local copies of them.
+func (c *consumer) epubChapter(
+	ctx context.Context,
+	book *epub.Book,
+	article data.Article,
+	body []byte,
+	pageURL string,
+	pageDir string,
+) (epub.Chapter, error) {
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return epub.Chapter{}, err
+	}
+
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return epub.Chapter{}, err
+	}
+
+	r := &xhtmlRenderer{consumer: c, ctx: ctx, book: book, base: base, pageDir: pageDir}
+
+	title := strings.TrimSpace(article.Title)
+	r.buf.WriteString("<h1>" + epub.Escape(title) + "</h1>\n")
+
+	var byline []string
+	if article.Author != "" {
+		byline = append(byline, epub.Escape(article.Author))
+	}
+
+	if !article.Time.IsZero() {
+		byline = append(byline, article.Time.UTC().Format("2006-01-02 15:04"))
+	}
+
+	if link := r.url(article.LinkURL); link != "" {
+		byline = append(byline, `<a href="`+epub.Escape(link)+`">`+epub.Escape(base.Hostname())+`</a>`)
+	}
+
+	if len(byline) > 0 {
+		r.buf.WriteString(`<p class="byline">` + strings.Join(byline, " · ") + "</p>\n")
+	}
+
+	if content := extractContent(doc, base.Hostname()); content != nil {
+		r.renderChildren(content)
+	}
+
+	var lang string
+	if root := findFirst(doc, "html"); root != nil {
+		lang = strings.TrimSpace(getAttr(root, "lang"))
+	}
+
+	return epub.Chapter{Title: title, Language: lang, Body: r.buf.String()}, nil
+}
+
+// xhtmlElements are elements that are kept in chapters of EPUB books, other elements are replaced by their content.
+var xhtmlElements = map[atom.Atom]bool{
+	atom.P: true, atom.Div: true, atom.Blockquote: true, atom.Pre: true, atom.Hr: true, atom.Br: true,
+	atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+	atom.Em: true, atom.I: true, atom.Strong: true, atom.B: true, atom.U: true, atom.S: true, atom.Del: true,
+	atom.Sub: true, atom.Sup: true, atom.Code: true, atom.Kbd: true, atom.Mark: true,
+	atom.Ul: true, atom.Ol: true, atom.Li: true, atom.Dl: true, atom.Dt: true, atom.Dd: true,
+	atom.Table: true, atom.Caption: true, atom.Thead: true, atom.Tbody: true, atom.Tfoot: true,
+	atom.Tr: true, atom.Td: true, atom.Th: true, atom.Figure: true, atom.Figcaption: true,
+}
+
+// xhtmlRenderer renders HTML as XHTML markup that EPUB readers accept:
+// only well-known elements and attributes are kept, and images are embedded into the book.
+type xhtmlRenderer struct {
+	consumer *consumer
+	ctx      context.Context
+	book     *epub.Book
+	base     *url.URL
+	pageDir  string
+	images   int
+	buf      strings.Builder
+}
+
+func (r *xhtmlRenderer) renderChildren(n *html.Node) {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		r.render(child)
+	}
+}
+
+func (r *xhtmlRenderer) render(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		r.buf.WriteString(epub.Escape(n.Data))
+		return
+	case html.ElementNode:
+	default:
+		return
+	}
+
+	switch {
+	case n.DataAtom == atom.Script || n.DataAtom == atom.Style || n.DataAtom == atom.Template:
+
+	case n.DataAtom == atom.A:
+		href := r.url(getAttr(n, "href"))
+		if href == "" {
+			r.renderChildren(n)
+			return
+		}
+
+		r.buf.WriteString(`<a href="` + epub.Escape(href) + `">`)
+		r.renderChildren(n)
+		r.buf.WriteString("</a>")
+
+	case n.DataAtom == atom.Img:
+		r.renderImage(n)
+
+	case n.DataAtom == atom.Br || n.DataAtom == atom.Hr:
+		r.buf.WriteString("<" + n.Data + "/>")
+
+	case xhtmlElements[n.DataAtom]:
+		r.buf.WriteString("<" + n.Data)
+		if n.DataAtom == atom.Td || n.DataAtom == atom.Th {
+			for _, key := range []string{"colspan", "rowspan"} {
+				if value := strings.TrimSpace(getAttr(n, key)); value != "" && strings.Trim(value, "0123456789") == "" {
+					r.buf.WriteString(" " + key + `="` + value + `"`)
+				}
+			}
+		}
+
+		r.buf.WriteString(">")
+		r.renderChildren(n)
+		r.buf.WriteString("</" + n.Data + ">")
+
+	default:
+		r.renderChildren(n)
+	}
+}
+
+// renderImage embeds an image into the book, or replaces it with its alternative text if the image is unavailable.
+func (r *xhtmlRenderer) renderImage(n *html.Node) {
+	alt := strings.TrimSpace(getAttr(n, "alt"))
+
+	src := getAttr(n, "data-src")
+	if src == "" {
+		src = getAttr(n, "src")
+	}
+
+	if r.images >= maxImagesPerChapter || r.ctx.Err() != nil {
+		r.buf.WriteString(epub.Escape(alt))
+		return
+	}
+
+	body, contentType, ok := r.consumer.epubImage(r.ctx, src, r.base, r.pageDir)
+	if ok {
+		var name string
+		name, ok = r.book.AddImage(body, contentType)
+		if ok {
+			r.images++
+			r.buf.WriteString(`<img src="` + epub.Escape(name) + `" alt="` + epub.Escape(alt) + `"/>`)
+			return
+		}
+	}
+
+	r.buf.WriteString(epub.Escape(alt))
+}
+
+// url resolves a reference of a link, only web links are kept.
+func (r *xhtmlRenderer) url(ref string) string {
+	u, err := r.base.Parse(strings.TrimSpace(ref))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+
+	return u.String()
+}
+
+// epubImage returns content and media type of an image of a page.
+// It's read from an archived copy, if there is one, and downloaded otherwise.
+func (c *consumer) epubImage(ctx context.Context, ref string, base *url.URL, pageDir string) ([]byte, string, bool) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return nil, "", false
+	}
+
+	if strings.HasPrefix(ref, "data:") {
+		return decodeDataURI(ref)
+	}
+
+	u, err := url.Parse(ref)
+	if err != nil {
+		return nil, "", false
+	}
+
+	// Pages that have been archived with assets refer to them by relative paths.
+	if pageDir != "" && u.Scheme == "" && u.Host == "" && u.Path != "" && !strings.HasPrefix(u.Path, "/") {
+		name := path.Join(pageDir, u.Path)
+		if name != ".." && !strings.HasPrefix(name, "../") {
+			if body, err := c.storage.Read(ctx, name); err == nil {
+				return body, assetContentType(download{FinalURL: u.Path, Body: body}), true
+			}
+		}
+	}
+
+	u = base.ResolveReference(u)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, "", false
+	}
+
+	u.Fragment = ""
+	if name, ok := c.knownAsset(u.String()); ok {
+		body, err := c.storage.Read(ctx, AssetsDirName+"/"+name)
+		if err == nil {
+			return body, assetContentType(download{FinalURL: name, Body: body}), true
+		}
+	}
+
+	image, err := c.download(ctx, u.String(), maxAssetSize)
+	if err != nil {
+		log.Warn().Err(err).Str("url", u.String()).Msg("unable to download image")
+		return nil, "", false
+	}
+
+	return image.Body, assetContentType(image), true
+}
+
+// decodeDataURI returns content and media type of a "data:" URI.
+func decodeDataURI(ref string) ([]byte, string, bool) {
+	header, payload, ok := strings.Cut(strings.TrimPrefix(ref, "data:"), ",")
+	if !ok {
+		return nil, "", false
+	}
+
+	isBase64 := strings.HasSuffix(header, ";base64")
+	mediaType, _, err := mime.ParseMediaType(strings.TrimSuffix(header, ";base64"))
+	if err != nil {
+		return nil, "", false
+	}
+
+	var body []byte
+	if isBase64 {
+		body, err = base64.StdEncoding.DecodeString(payload)
+	} else {
+		var text string
+		text, err = url.PathUnescape(payload)
+		body = []byte(text)
+	}
+
+	if err != nil {
+		return nil, "", false
+	}
+
+	return body, mediaType, true
+}
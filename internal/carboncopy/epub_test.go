@@ -0,0 +1,132 @@
+package carboncopy
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kapitanov/habrabot/internal/data"
+)
+
+// readEPUB returns files of an EPUB book by their names.
+func readEPUB(t *testing.T, r *zip.Reader) map[string]string {
+	files := make(map[string]string)
+	for _, f := range r.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+
+		body, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		files[f.Name] = string(body)
+	}
+
+	return files
+}
+
+func TestConsumer_EPUB(t *testing.T) {
+	site := newTestSite(t)
+	dir := t.TempDir()
+	c := Use(dir, WithEPUB())
+
+	article := data.Article{
+		ID:      "1",
+		Title:   "First & best",
+		Author:  "author",
+		Tags:    []string{"go"},
+		Time:    time.Date(2022, 10, 18, 12, 0, 0, 0, time.UTC),
+		LinkURL: site.URL + "/post/1/",
+	}
+	require.NoError(t, c.On(context.Background(), article))
+
+	r, err := zip.OpenReader(filepath.Join(dir, strings.TrimSuffix(extractFileName(article), ".html")+".epub"))
+	require.NoError(t, err)
+	defer func() {
+		_ = r.Close()
+	}()
+
+	files := readEPUB(t, &r.Reader)
+
+	opf := files["OEBPS/content.opf"]
+	assert.Contains(t, opf, "<dc:title>First &amp; best</dc:title>")
+	assert.Contains(t, opf, "<dc:creator>author</dc:creator>")
+	assert.Contains(t, opf, "<dc:subject>go</dc:subject>")
+	assert.Contains(t, opf, "<dc:source>"+article.LinkURL+"</dc:source>")
+
+	chapter := files["OEBPS/chapter-001.xhtml"]
+	assert.Contains(t, chapter, "<h1>First &amp; best</h1>")
+	assert.Contains(t, chapter, `<a href="`+site.URL+`/post/2/">Next</a>`, "links refer to the web site")
+	assert.Regexp(t, `<img src="images/[0-9a-f]{16}\.png" alt=""/>`, chapter)
+	assert.NotContains(t, chapter, "style=")
+
+	var images []string
+	for name, body := range files {
+		if strings.HasPrefix(name, "OEBPS/images/") {
+			images = append(images, body)
+		}
+	}
+
+	// The first image of the page is the one from "data-src", the second one is not downloadable.
+	assert.Equal(t, []string{"PNG /static/image.png"}, images)
+}
+
+func TestBuildEPUB(t *testing.T) {
+	site := newTestSite(t)
+	dir := t.TempDir()
+	c := Use(dir, WithArchiveMode(ArchiveModeAssets))
+
+	first := data.Article{ID: "1", Title: "First", Author: "alice", LinkURL: site.URL + "/post/1/"}
+	second := data.Article{ID: "2", Title: "Second", Author: "bob", LinkURL: site.URL + "/post/2/"}
+	missing := data.Article{ID: "3", Title: "Missing", LinkURL: site.URL + "/post/3/"}
+
+	require.NoError(t, c.On(context.Background(), first))
+	require.NoError(t, c.On(context.Background(), second))
+	requests := site.Requests("/static/image.png")
+
+	book := NewArticleBook(data.Article{Title: "Weekly"})
+	require.NoError(t, BuildEPUB(context.Background(), Dir(dir), Layout{}, book, []data.Article{first, missing, second}))
+	assert.Equal(t, 2, book.Len(), "articles without carbon copies are skipped")
+	assert.Equal(t, requests, site.Requests("/static/image.png"), "archived images are read from the archive")
+
+	var buf bytes.Buffer
+	require.NoError(t, book.Write(&buf))
+
+	r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+
+	files := readEPUB(t, r)
+	assert.Contains(t, files["OEBPS/nav.xhtml"], `<a href="chapter-001.xhtml">First</a>`)
+	assert.Contains(t, files["OEBPS/nav.xhtml"], `<a href="chapter-002.xhtml">Second</a>`)
+	assert.Contains(t, files["OEBPS/chapter-002.xhtml"], "bob")
+
+	var images int
+	for name := range files {
+		if strings.HasPrefix(name, "OEBPS/images/") {
+			images++
+		}
+	}
+
+	assert.Equal(t, 1, images, "images shared by chapters are embedded once")
+}
+
+func TestDecodeDataURI(t *testing.T) {
+	body, mediaType, ok := decodeDataURI("data:image/png;base64,UE5H")
+	require.True(t, ok)
+	assert.Equal(t, "image/png", mediaType)
+	assert.Equal(t, "PNG", string(body))
+
+	body, mediaType, ok = decodeDataURI("data:image/svg+xml,%3Csvg%3E")
+	require.True(t, ok)
+	assert.Equal(t, "image/svg+xml", mediaType)
+	assert.Equal(t, "<svg>", string(body))
+
+	_, _, ok = decodeDataURI("data:image/png;base64")
+	assert.False(t, ok)
+}
@@ -0,0 +1,291 @@
+package carboncopy
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// extractionProfile selects article body of pages of specific sites.
+type extractionProfile struct {
+	Hosts   []string // Hosts that the profile applies to, including their subdomains.
+	Content []string // Selectors of article body, in order of preference.
+	Remove  []string // Selectors of elements that are removed from article body.
+}
+
+// profiles are extraction profiles of known sites.
+// Pages of other sites are extracted by generic heuristics.
+var profiles = []extractionProfile{
+	{
+		Hosts:   []string{"habr.com"},
+		Content: []string{"#post-content-body", ".article-formatted-body", ".tm-article-body", ".post__text"},
+		Remove:  []string{".tm-article-poll", ".tm-article-presenter__footer", ".post__footer"},
+	},
+}
+
+// Elements that never belong to article body.
+var boilerplateAtoms = map[atom.Atom]bool{
+	atom.Script:   true,
+	atom.Style:    true,
+	atom.Noscript: true,
+	atom.Nav:      true,
+	atom.Header:   true,
+	atom.Footer:   true,
+	atom.Aside:    true,
+	atom.Form:     true,
+	atom.Iframe:   true,
+	atom.Svg:      true,
+	atom.Button:   true,
+	atom.Template: true,
+}
+
+var (
+	positiveClassRegex = regexp.MustCompile(`(?i)article|body|content|entry|main|page|post|text|blog|story`)
+	negativeClassRegex = regexp.MustCompile(
+		`(?i)comment|sidebar|footer|masthead|menu|nav|share|social|related|banner|sponsor|promo|popup|widget|meta|tags|author`,
+	)
+)
+
+const minParagraphLength = 25
+
+// extractContent returns a node with article body of a page, or nil if the page has no recognizable body.
+func extractContent(doc *html.Node, host string) *html.Node {
+	host = strings.ToLower(host)
+
+	for _, profile := range profiles {
+		if !matchesHost(profile.Hosts, host) {
+			continue
+		}
+
+		for _, selector := range profile.Content {
+			if n := findFirst(doc, selector); n != nil {
+				for _, remove := range profile.Remove {
+					removeAll(n, remove)
+				}
+
+				removeBoilerplate(n, false)
+				return n
+			}
+		}
+	}
+
+	return extractByScore(doc)
+}
+
+// extractByScore selects article body by readability-style heuristics:
+// paragraphs with plenty of text score their ancestors, and the best scored element wins.
+func extractByScore(doc *html.Node) *html.Node {
+	body := findFirst(doc, "body")
+	if body == nil {
+		return nil
+	}
+
+	removeBoilerplate(body, true)
+
+	scores := make(map[*html.Node]float64)
+	var candidates []*html.Node
+
+	walk(body, func(n *html.Node) {
+		if n.Type != html.ElementNode || (n.DataAtom != atom.P && n.DataAtom != atom.Pre && n.DataAtom != atom.Td) {
+			return
+		}
+
+		text := strings.TrimSpace(textContent(n))
+		if len([]rune(text)) < minParagraphLength {
+			return
+		}
+
+		score := 1 + float64(strings.Count(text, ",")) + minFloat(float64(len([]rune(text)))/100, 3)
+
+		for i, ancestor := 0, n.Parent; i < 2 && ancestor != nil && ancestor.Type == html.ElementNode; i, ancestor = i+1, ancestor.Parent {
+			if _, ok := scores[ancestor]; !ok {
+				scores[ancestor] = classWeight(ancestor)
+				candidates = append(candidates, ancestor)
+			}
+
+			scores[ancestor] += score / float64(i+1)
+		}
+	})
+
+	var (
+		best      *html.Node
+		bestScore float64
+	)
+
+	for _, n := range candidates {
+		// Elements that consist mostly of links are navigation rather than content.
+		score := scores[n] * (1 - linkDensity(n))
+		if best == nil || score > bestScore {
+			best, bestScore = n, score
+		}
+	}
+
+	if best == nil {
+		return body
+	}
+
+	return best
+}
+
+func classWeight(n *html.Node) float64 {
+	var weight float64
+	for _, value := range []string{getAttr(n, "class"), getAttr(n, "id")} {
+		if value == "" {
+			continue
+		}
+
+		if negativeClassRegex.MatchString(value) {
+			weight -= 25
+		}
+
+		if positiveClassRegex.MatchString(value) {
+			weight += 25
+		}
+	}
+
+	if n.DataAtom == atom.Article {
+		weight += 25
+	}
+
+	return weight
+}
+
+func linkDensity(n *html.Node) float64 {
+	textLength := len([]rune(textContent(n)))
+	if textLength == 0 {
+		return 0
+	}
+
+	var linkLength int
+	walk(n, func(child *html.Node) {
+		if child.Type == html.ElementNode && child.DataAtom == atom.A {
+			linkLength += len([]rune(textContent(child)))
+		}
+	})
+
+	return minFloat(float64(linkLength)/float64(textLength), 1)
+}
+
+// removeBoilerplate removes elements that never belong to article body,
+// and, if aggressive is set, elements that look like navigation or comments by their classes.
+func removeBoilerplate(root *html.Node, aggressive bool) {
+	var remove []*html.Node
+
+	walk(root, func(n *html.Node) {
+		switch {
+		case n == root:
+		case n.Type == html.CommentNode:
+			remove = append(remove, n)
+		case n.Type != html.ElementNode:
+		case boilerplateAtoms[n.DataAtom] || hasAttr(n, "hidden") || getAttr(n, "aria-hidden") == "true":
+			remove = append(remove, n)
+		case aggressive && n.DataAtom != atom.Article && isNegative(n):
+			remove = append(remove, n)
+		}
+	})
+
+	for _, n := range remove {
+		if n.Parent != nil {
+			n.Parent.RemoveChild(n)
+		}
+	}
+}
+
+func isNegative(n *html.Node) bool {
+	value := getAttr(n, "class") + " " + getAttr(n, "id")
+	return negativeClassRegex.MatchString(value) && !positiveClassRegex.MatchString(value)
+}
+
+func matchesHost(hosts []string, host string) bool {
+	for _, h := range hosts {
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// findFirst returns the first element that matches a simple selector, e.g. "div", "#id", ".class" or "div.class".
+func findFirst(root *html.Node, selector string) *html.Node {
+	var found *html.Node
+	walk(root, func(n *html.Node) {
+		if found == nil && matches(n, selector) {
+			found = n
+		}
+	})
+
+	return found
+}
+
+func removeAll(root *html.Node, selector string) {
+	var remove []*html.Node
+	walk(root, func(n *html.Node) {
+		if n != root && matches(n, selector) {
+			remove = append(remove, n)
+		}
+	})
+
+	for _, n := range remove {
+		if n.Parent != nil {
+			n.Parent.RemoveChild(n)
+		}
+	}
+}
+
+func matches(n *html.Node, selector string) bool {
+	if n.Type != html.ElementNode {
+		return false
+	}
+
+	if strings.HasPrefix(selector, "#") {
+		return getAttr(n, "id") == selector[1:]
+	}
+
+	tag, class, _ := strings.Cut(selector, ".")
+	if tag != "" && n.Data != tag {
+		return false
+	}
+
+	if class == "" {
+		return true
+	}
+
+	for _, c := range strings.Fields(getAttr(n, "class")) {
+		if c == class {
+			return true
+		}
+	}
+
+	return false
+}
+
+// walk invokes a function for a node and all its descendants, parents first.
+// Nodes must not be removed by the function, since the walk would stop at them.
+func walk(n *html.Node, fn func(n *html.Node)) {
+	fn(n)
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		walk(child, fn)
+	}
+}
+
+func textContent(n *html.Node) string {
+	var sb strings.Builder
+	walk(n, func(child *html.Node) {
+		if child.Type == html.TextNode {
+			sb.WriteString(child.Data)
+		}
+	})
+
+	return sb.String()
+}
+
+func minFloat(a, b float64) float64 {
+	if a < b {
+		return a
+	}
+
+	return b
+}
@@ -9,6 +9,12 @@ import (
 
 var articleIDRegex = regexp.MustCompile("([0-9]{3,})")
 
+// FileName returns a name of the file that article's carbon copy is stored into by default layout,
+// relative to carbon copy directory.
+func FileName(article data.Article) string {
+	return Layout{}.Path(article)
+}
+
 func extractFileName(article data.Article) string {
 	var filename []rune
 
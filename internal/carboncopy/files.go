@@ -0,0 +1,78 @@
+package carboncopy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// File and directory permissions of the archive.
+const (
+	fileMode = 0644
+	dirMode  = 0755
+)
+
+// tempFileInfix marks temporary files of incomplete writes.
+const tempFileInfix = ".tmp-"
+
+// writeFile writes a file atomically, see writeFileFrom.
+func writeFile(fullpath string, content []byte) error {
+	return writeFileFrom(fullpath, bytes.NewReader(content))
+}
+
+// writeFileFrom writes a file atomically: content is written into a temporary file next to it,
+// which replaces the file only once it's complete, so a failed write never damages a good copy.
+func writeFileFrom(fullpath string, r io.Reader) error {
+	dirPath := filepath.Dir(fullpath)
+	err := os.MkdirAll(dirPath, dirMode)
+	if err != nil {
+		return err
+	}
+
+	// Temporary files are hidden, so they are never mistaken for carbon copies.
+	f, err := os.CreateTemp(dirPath, "."+filepath.Base(fullpath)+tempFileInfix+"*")
+	if err != nil {
+		return err
+	}
+
+	tempPath := f.Name()
+	defer func() {
+		// Temporary file is already gone if it has been renamed.
+		_ = os.Remove(tempPath)
+	}()
+
+	_, err = io.Copy(f, r)
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	err = os.Chmod(tempPath, fileMode)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tempPath, fullpath)
+}
+
+// isTempFile returns true if a file is a temporary file of an incomplete write.
+func isTempFile(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.Contains(name, tempFileInfix)
+}
+
+// checksum returns a hex-encoded SHA-256 hash of content.
+func checksum(content []byte) string {
+	hash := sha256.Sum256(content)
+	return hex.EncodeToString(hash[:])
+}
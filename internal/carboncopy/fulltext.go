@@ -0,0 +1,55 @@
+package carboncopy
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+
+	"github.com/kapitanov/habrabot/internal/data"
+)
+
+// Indexer is a full-text index of articles.
+type Indexer interface {
+	// Index adds an article along with the text of its web page to the index, replacing its previous version.
+	Index(article data.Article, text string) error
+}
+
+// WithIndexer adds text of each stored article to a full-text index.
+// Articles are stored even if they can't be indexed, since the index is an auxiliary one.
+func WithIndexer(indexer Indexer) Option {
+	return func(c *consumer) {
+		c.indexer = indexer
+	}
+}
+
+// extractText returns plain text of article body of a web page,
+// or article's description if the page has no recognizable body.
+func extractText(article data.Article, page download) (string, error) {
+	doc, err := html.Parse(bytes.NewReader(page.Body))
+	if err != nil {
+		return "", err
+	}
+
+	base, err := url.Parse(page.FinalURL)
+	if err != nil {
+		return "", err
+	}
+
+	// Text nodes are separated, so words of adjacent blocks aren't glued together.
+	var words []string
+	if content := extractContent(doc, base.Hostname()); content != nil {
+		walk(content, func(n *html.Node) {
+			if n.Type == html.TextNode {
+				words = append(words, strings.Fields(n.Data)...)
+			}
+		})
+	}
+
+	if len(words) == 0 {
+		return article.Description, nil
+	}
+
+	return strings.Join(words, " "), nil
+}
@@ -0,0 +1,58 @@
+package carboncopy
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kapitanov/habrabot/internal/data"
+)
+
+// testIndexer records texts of indexed articles by their IDs.
+type testIndexer struct {
+	texts map[string]string
+	err   error
+}
+
+func (i *testIndexer) Index(article data.Article, text string) error {
+	if i.err != nil {
+		return i.err
+	}
+
+	i.texts[article.ID] = text
+	return nil
+}
+
+func TestExtractText(t *testing.T) {
+	page := download{FinalURL: "https://habr.com/ru/post/1/", Body: []byte(habrPage)}
+
+	text, err := extractText(data.Article{Description: "Description"}, page)
+	require.NoError(t, err)
+	assert.Contains(t, text, "Intro Some bold , italic and linked text with a * star.")
+	assert.Contains(t, text, "first second x")
+	assert.NotContains(t, text, "Flows", "navigation isn't indexed")
+	assert.NotContains(t, text, "comment", "comments aren't indexed")
+
+	page.Body = []byte("<html><body></body></html>")
+	text, err = extractText(data.Article{Description: "Description"}, page)
+	require.NoError(t, err)
+	assert.Equal(t, "Description", text)
+}
+
+func TestConsumer_Indexer(t *testing.T) {
+	site := newTestSite(t)
+	indexer := &testIndexer{texts: make(map[string]string)}
+	c := Use(t.TempDir(), WithIndexer(indexer))
+
+	article := data.Article{ID: "1", Title: "First", LinkURL: site.URL + "/post/1/"}
+	require.NoError(t, c.On(context.Background(), article))
+	assert.Contains(t, indexer.texts, "1")
+
+	// Articles are stored even if they can't be indexed.
+	indexer.err = errors.New("index is locked")
+	require.NoError(t, c.On(context.Background(), data.Article{ID: "2", Title: "Second", LinkURL: site.URL + "/post/2/"}))
+	assert.NotContains(t, indexer.texts, "2")
+}
@@ -0,0 +1,422 @@
+package carboncopy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"html/template"
+	"io/fs"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/rs/zerolog/log"
+)
+
+// IndexDirName is a name of the directory that per-tag and per-month index pages are stored into,
+// relative to carbon copy directory. The main index page is stored into carbon copy directory itself.
+const IndexDirName = "index"
+
+const (
+	indexFileName = "index.html"
+	tagsDirName   = "tags"
+	monthsDirName = "months"
+	monthFormat   = "2006-01"
+)
+
+// Flush writes index pages of the archive, if articles have been stored since the last time.
+// Index pages are rebuilt from all sidecar files once, and then they are updated with articles stored since.
+// Failures are logged only, since articles are stored already and index pages are written again by the next flush.
+func (c *consumer) Flush(ctx context.Context) error {
+	c.indexMutex.Lock()
+	defer c.indexMutex.Unlock()
+
+	exists, err := c.storage.Exists(ctx, indexFileName)
+	switch {
+	case err != nil:
+	case !exists || (c.indexSidecars == nil && len(c.indexPending) > 0):
+		err = c.rebuildIndex(ctx)
+	case len(c.indexPending) > 0:
+		err = c.updateIndex(ctx)
+	}
+
+	if err != nil {
+		log.Error().Err(err).Msg("unable to write cc index")
+	}
+
+	return nil
+}
+
+// addToIndex makes an article listed on index pages by the next flush.
+func (c *consumer) addToIndex(sidecar Sidecar) {
+	c.indexMutex.Lock()
+	defer c.indexMutex.Unlock()
+
+	c.indexPending = append(c.indexPending, sidecar)
+}
+
+// rebuildIndex writes all index pages from all sidecar files of the storage.
+func (c *consumer) rebuildIndex(ctx context.Context) error {
+	sidecars, err := loadSidecars(ctx, c.storage)
+	if err != nil {
+		return err
+	}
+
+	err = writeIndex(ctx, c.storage, sidecars)
+	if err != nil {
+		return err
+	}
+
+	c.indexSidecars = make(map[string]Sidecar, len(sidecars))
+	for _, sidecar := range sidecars {
+		c.indexSidecars[sidecar.File] = sidecar
+	}
+
+	c.indexPending = nil
+	return nil
+}
+
+// updateIndex rewrites the main index page, and index pages of tags and months of articles stored since the last flush.
+func (c *consumer) updateIndex(ctx context.Context) error {
+	affected := map[string]bool{indexFileName: true}
+	for _, sidecar := range c.indexPending {
+		// Pages of the previous version of an article are rewritten too, in case its tags have changed.
+		if previous, ok := c.indexSidecars[sidecar.File]; ok {
+			for _, file := range indexGroupFiles(previous) {
+				affected[file] = true
+			}
+		}
+
+		for _, file := range indexGroupFiles(sidecar) {
+			affected[file] = true
+		}
+
+		c.indexSidecars[sidecar.File] = sidecar
+	}
+
+	sidecars := make([]Sidecar, 0, len(c.indexSidecars))
+	for _, sidecar := range c.indexSidecars {
+		sidecars = append(sidecars, sidecar)
+	}
+
+	_, err := buildIndex(sidecars).write(ctx, c.storage, affected)
+	if err != nil {
+		return err
+	}
+
+	c.indexPending = nil
+	return nil
+}
+
+// indexEntry is an article as it's listed on index pages.
+type indexEntry struct {
+	Sidecar
+	Date string
+	Tags []indexGroup
+}
+
+// indexGroup is a tag or a month that has an index page of its own.
+type indexGroup struct {
+	Name  string
+	File  string // Path of the index page relative to carbon copy directory.
+	Count int
+}
+
+// indexPage is a data of an index page template.
+type indexPage struct {
+	Title   string
+	Root    string // Relative URL of carbon copy directory, e.g. "../../".
+	Months  []indexGroup
+	Tags    []indexGroup
+	Entries []indexEntry
+}
+
+// archiveIndex is a content of all index pages of an archive.
+type archiveIndex struct {
+	entries []indexEntry
+	tags    []indexGroup
+	months  []indexGroup
+	groups  map[string][]indexEntry // Entries of tags and months by file names of their index pages.
+}
+
+// WriteIndex regenerates static index pages of an archive from metadata of its carbon copies:
+// the main index page with all articles, and index pages of each tag and each month.
+func WriteIndex(ctx context.Context, storage Storage) error {
+	sidecars, err := loadSidecars(ctx, storage)
+	if err != nil {
+		return err
+	}
+
+	return writeIndex(ctx, storage, sidecars)
+}
+
+func writeIndex(ctx context.Context, storage Storage, sidecars []Sidecar) error {
+	written, err := buildIndex(sidecars).write(ctx, storage, nil)
+	if err != nil {
+		return err
+	}
+
+	return removeStaleIndexPages(ctx, storage, written)
+}
+
+// buildIndex arranges articles into index pages.
+func buildIndex(sidecars []Sidecar) archiveIndex {
+	// Newest articles come first.
+	sidecars = append([]Sidecar(nil), sidecars...)
+	sort.SliceStable(sidecars, func(i, j int) bool {
+		return sidecars[i].Article.Time.After(sidecars[j].Article.Time)
+	})
+
+	var (
+		tags   = make(map[string]*indexGroup)
+		months = make(map[string]*indexGroup)
+		index  = archiveIndex{
+			entries: make([]indexEntry, 0, len(sidecars)),
+			groups:  make(map[string][]indexEntry),
+		}
+	)
+
+	for _, sidecar := range sidecars {
+		entry := indexEntry{Sidecar: sidecar, Date: sidecar.Article.Time.UTC().Format("2006-01-02")}
+
+		for _, tag := range indexTags(sidecar) {
+			group, ok := tags[tag.File]
+			if !ok {
+				group = &indexGroup{Name: tag.Name, File: tag.File}
+				tags[tag.File] = group
+			}
+
+			group.Count++
+			entry.Tags = append(entry.Tags, *group)
+		}
+
+		for _, tag := range entry.Tags {
+			index.groups[tag.File] = append(index.groups[tag.File], entry)
+		}
+
+		month := indexMonth(sidecar)
+		if _, ok := months[month.File]; !ok {
+			months[month.File] = &month
+			index.months = append(index.months, month)
+		}
+
+		months[month.File].Count++
+		index.groups[month.File] = append(index.groups[month.File], entry)
+		index.entries = append(index.entries, entry)
+	}
+
+	for i := range index.months {
+		index.months[i].Count = months[index.months[i].File].Count
+	}
+
+	for _, group := range tags {
+		index.tags = append(index.tags, *group)
+	}
+
+	sort.Slice(index.tags, func(i, j int) bool {
+		if index.tags[i].Count != index.tags[j].Count {
+			return index.tags[i].Count > index.tags[j].Count
+		}
+
+		return strings.ToLower(index.tags[i].Name) < strings.ToLower(index.tags[j].Name)
+	})
+
+	return index
+}
+
+// write writes index pages into the storage, and returns names of the written pages.
+// If affected pages are set, other pages are left intact, and affected pages that have no articles are removed.
+func (index archiveIndex) write(ctx context.Context, storage Storage, affected map[string]bool) (map[string]bool, error) {
+	written := make(map[string]bool)
+	write := func(file string, page indexPage) error {
+		if affected != nil && !affected[file] {
+			return nil
+		}
+
+		page.Root = strings.Repeat("../", strings.Count(file, "/"))
+		written[file] = true
+		return writeIndexPage(ctx, storage, file, page)
+	}
+
+	err := write(indexFileName, indexPage{Title: "Archive", Months: index.months, Tags: index.tags, Entries: index.entries})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, group := range index.tags {
+		err = write(group.File, indexPage{Title: "Tag: " + group.Name, Entries: index.groups[group.File]})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, group := range index.months {
+		err = write(group.File, indexPage{Title: group.Name, Entries: index.groups[group.File]})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for file := range affected {
+		if !written[file] {
+			err = storage.Remove(ctx, file)
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return nil, err
+			}
+		}
+	}
+
+	log.Info().
+		Int("articles", len(index.entries)).
+		Int("tags", len(index.tags)).
+		Int("months", len(index.months)).
+		Int("pages", len(written)).
+		Msg("cc index has been written")
+	return written, nil
+}
+
+// indexTags returns index pages of article's tags.
+func indexTags(sidecar Sidecar) []indexGroup {
+	var groups []indexGroup
+
+	seen := make(map[string]bool)
+	for _, tag := range sidecar.Article.Tags {
+		tag = strings.TrimSpace(tag)
+
+		// Tags that differ in case or in special characters share an index page.
+		file := path.Join(IndexDirName, tagsDirName, indexGroupFileName(tag))
+		if tag == "" || seen[file] {
+			continue
+		}
+
+		seen[file] = true
+		groups = append(groups, indexGroup{Name: tag, File: file})
+	}
+
+	return groups
+}
+
+// indexMonth returns an index page of the month of article's publication.
+func indexMonth(sidecar Sidecar) indexGroup {
+	month := sidecar.Article.Time.UTC().Format(monthFormat)
+	return indexGroup{Name: month, File: path.Join(IndexDirName, monthsDirName, month+".html")}
+}
+
+// indexGroupFiles returns file names of index pages that list an article, other than the main index page.
+func indexGroupFiles(sidecar Sidecar) []string {
+	files := []string{indexMonth(sidecar).File}
+	for _, tag := range indexTags(sidecar) {
+		files = append(files, tag.File)
+	}
+
+	return files
+}
+
+func writeIndexPage(ctx context.Context, storage Storage, name string, page indexPage) error {
+	var buf bytes.Buffer
+	err := indexTemplate.Execute(&buf, page)
+	if err != nil {
+		return err
+	}
+
+	return storage.Write(ctx, name, &buf)
+}
+
+// removeStaleIndexPages removes index pages of tags and months that no longer have articles.
+func removeStaleIndexPages(ctx context.Context, storage Storage, written map[string]bool) error {
+	names, err := storage.List(ctx, IndexDirName)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if !written[name] {
+			err = storage.Remove(ctx, name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// indexGroupFileName returns a file name of an index page of a tag.
+func indexGroupFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("+#_.", r) {
+			return unicode.ToLower(r)
+		}
+
+		return '-'
+	}, name)
+
+	name = strings.Trim(name, "-.")
+	if name == "" {
+		name = "-"
+	}
+
+	return name + ".html"
+}
+
+var indexTemplate = template.Must(template.New("index").Funcs(template.FuncMap{
+	"href": func(root, file string) string {
+		segments := strings.Split(file, "/")
+		for i, segment := range segments {
+			segments[i] = url.PathEscape(segment)
+		}
+
+		return root + strings.Join(segments, "/")
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em auto; max-width: 60em; padding: 0 1em; }
+table { border-collapse: collapse; width: 100%; }
+td { padding: 0.3em 0.5em; vertical-align: top; border-bottom: 1px solid #eee; }
+td.date { white-space: nowrap; color: #666; }
+.tags a, .groups a { margin-right: 0.5em; }
+ul.groups { list-style: none; padding: 0; }
+ul.groups li { display: inline-block; margin: 0 1em 0.3em 0; }
+</style>
+</head>
+<body>
+{{- if .Root}}
+<nav><a href="{{.Root}}index.html">All articles</a></nav>
+{{- end}}
+<h1>{{.Title}}</h1>
+{{- if .Months}}
+<h2>Months</h2>
+<ul class="groups">
+{{- range .Months}}
+<li><a href="{{href $.Root .File}}">{{.Name}}</a> ({{.Count}})</li>
+{{- end}}
+</ul>
+{{- end}}
+{{- if .Tags}}
+<h2>Tags</h2>
+<ul class="groups">
+{{- range .Tags}}
+<li><a href="{{href $.Root .File}}">{{.Name}}</a> ({{.Count}})</li>
+{{- end}}
+</ul>
+{{- end}}
+<h2>Articles</h2>
+<table>
+{{- range .Entries}}
+<tr>
+<td class="date">{{.Date}}</td>
+<td><a href="{{href $.Root .File}}">{{.Article.Title}}</a>{{if .Article.LinkURL}} <a href="{{.Article.LinkURL}}">&#8599;</a>{{end}}</td>
+<td>{{.Article.Author}}</td>
+<td class="tags">{{range .Tags}}<a href="{{href $.Root .File}}">{{.Name}}</a>{{end}}</td>
+</tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
@@ -0,0 +1,141 @@
+package carboncopy
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kapitanov/habrabot/internal/data"
+)
+
+func TestConsumer_Index(t *testing.T) {
+	site := newTestSite(t)
+	dir := t.TempDir()
+	c := Use(dir)
+
+	first := data.Article{
+		ID:      "1",
+		Title:   "First <article>",
+		Author:  "alice",
+		Tags:    []string{"Go", "C++"},
+		Time:    time.Date(2022, 9, 30, 12, 0, 0, 0, time.UTC),
+		LinkURL: site.URL + "/post/1/",
+	}
+	second := data.Article{
+		ID:      "2",
+		Title:   "Second",
+		Author:  "bob",
+		Tags:    []string{"go", "Go"},
+		Time:    time.Date(2022, 10, 18, 12, 0, 0, 0, time.UTC),
+		LinkURL: site.URL + "/post/old/",
+	}
+
+	require.NoError(t, c.On(context.Background(), first))
+	require.NoError(t, c.On(context.Background(), second))
+
+	content, err := os.ReadFile(filepath.Join(dir, strings.TrimSuffix(extractFileName(second), ".html")+".json"))
+	require.NoError(t, err)
+
+	var sidecar Sidecar
+	require.NoError(t, json.Unmarshal(content, &sidecar))
+	assert.Equal(t, second, sidecar.Article)
+	assert.Equal(t, extractFileName(second), sidecar.File)
+	assert.Equal(t, site.URL+"/post/old/", sidecar.URL)
+	assert.Equal(t, site.URL+"/post/1/", sidecar.FinalURL)
+	assert.Equal(t, 200, sidecar.Status)
+	assert.Equal(t, len(testPage), sidecar.Size)
+	assert.Len(t, sidecar.SHA256, 64)
+	assert.False(t, sidecar.FetchedAt.IsZero())
+
+	_, err = os.Stat(filepath.Join(dir, "index.html"))
+	assert.ErrorIs(t, err, os.ErrNotExist, "index is written on flush")
+
+	require.NoError(t, data.Flush(context.Background(), c))
+
+	index := readFile(t, filepath.Join(dir, "index.html"))
+	assert.Less(t, strings.Index(index, "Second"), strings.Index(index, "First &lt;article&gt;"), "newest articles come first")
+	assert.Contains(t, index, `<a href="index/months/2022-10.html">2022-10</a> (1)`)
+	assert.Contains(t, index, `<a href="index/tags/go.html">go</a> (2)`, "tags that differ in case share a page")
+	assert.Contains(t, index, `<a href="index/tags/c&#43;&#43;.html">C&#43;&#43;</a> (1)`)
+	assert.Contains(t, index, `href="`+url.PathEscape(extractFileName(first))+`"`)
+
+	tag := readFile(t, filepath.Join(dir, IndexDirName, "tags", "go.html"))
+	assert.Contains(t, tag, `<a href="../../index.html">All articles</a>`)
+	assert.Contains(t, tag, `<a href="../../`+url.PathEscape(extractFileName(first))+`">`)
+	assert.Equal(t, 1, strings.Count(tag, ">Second</a>"), "an article is listed once per tag page")
+
+	month := readFile(t, filepath.Join(dir, IndexDirName, "months", "2022-09.html"))
+	assert.Contains(t, month, "First &lt;article&gt;")
+	assert.NotContains(t, month, "Second")
+
+	// Index pages of tags that no longer have articles are removed.
+	require.NoError(t, os.Remove(filepath.Join(dir, strings.TrimSuffix(extractFileName(first), ".html")+".json")))
+	require.NoError(t, WriteIndex(context.Background(), Dir(dir)))
+
+	_, err = os.Stat(filepath.Join(dir, IndexDirName, "tags", "c++.html"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = os.Stat(filepath.Join(dir, IndexDirName, "months", "2022-09.html"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestConsumer_IndexUpdate(t *testing.T) {
+	site := newTestSite(t)
+	dir := t.TempDir()
+	c := Use(dir)
+
+	first := data.Article{
+		ID:      "1",
+		Title:   "First",
+		Tags:    []string{"go"},
+		Time:    time.Date(2022, 9, 30, 12, 0, 0, 0, time.UTC),
+		LinkURL: site.URL + "/post/1/",
+	}
+	second := data.Article{
+		ID:      "2",
+		Title:   "Second",
+		Tags:    []string{"rust"},
+		Time:    time.Date(2022, 10, 18, 12, 0, 0, 0, time.UTC),
+		LinkURL: site.URL + "/post/2/",
+	}
+
+	require.NoError(t, c.On(context.Background(), first))
+	require.NoError(t, data.Flush(context.Background(), c))
+
+	// Sidecars aren't read again once index pages have been built, so the page of the first article is kept intact.
+	require.NoError(t, os.Remove(filepath.Join(dir, strings.TrimSuffix(extractFileName(first), ".html")+".json")))
+	goPage := filepath.Join(dir, IndexDirName, "tags", "go.html")
+	require.NoError(t, os.WriteFile(goPage, []byte("unchanged"), fileMode))
+
+	require.NoError(t, c.On(context.Background(), second))
+	require.NoError(t, data.Flush(context.Background(), c))
+
+	index := readFile(t, filepath.Join(dir, "index.html"))
+	assert.Contains(t, index, ">First</a>")
+	assert.Contains(t, index, ">Second</a>")
+	assert.Contains(t, readFile(t, filepath.Join(dir, IndexDirName, "tags", "rust.html")), ">Second</a>")
+	assert.Equal(t, "unchanged", readFile(t, goPage))
+
+	// Pages of tags that an updated article no longer has are removed.
+	second.Tags = []string{"go"}
+	require.NoError(t, c.On(context.Background(), second))
+	require.NoError(t, data.Flush(context.Background(), c))
+
+	_, err := os.Stat(filepath.Join(dir, IndexDirName, "tags", "rust.html"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.Contains(t, readFile(t, goPage), ">Second</a>")
+}
+
+func readFile(t *testing.T, path string) string {
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	return string(content)
+}
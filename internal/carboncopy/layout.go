@@ -0,0 +1,327 @@
+package carboncopy
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/kapitanov/habrabot/internal/data"
+)
+
+const (
+	// maxNameLength is a maximum length of a file name in bytes that common file systems support.
+	maxNameLength = 255
+	// maxSlugLength is a maximum length of a slug in bytes.
+	maxSlugLength = 80
+	// minStemLength is a length in bytes that file names aren't shortened beyond to fit into maximum path length.
+	minStemLength = 16
+	// pageExt is an extension of carbon copies, other files of a carbon copy replace it with their own extensions.
+	pageExt = ".html"
+)
+
+// errPathTaken is returned if both the path of an article and its alternate path are taken by other articles.
+var errPathTaken = errors.New("cc paths are taken by other articles")
+
+// Layout arranges carbon copies within carbon copy directory.
+// The zero value stores them flat, named by IDs and titles of articles, see FileName.
+type Layout struct {
+	template  *template.Template
+	maxLength int
+}
+
+// pathFields are fields of an article that path templates are executed with.
+type pathFields struct {
+	data.Article
+	ID   string // Numeric ID of the article from its URL, or a slug of its ID if there's none.
+	GUID string // Original ID of the article.
+}
+
+var pathFuncs = template.FuncMap{
+	"slug":     slug,
+	"translit": transliterate,
+	"lower":    strings.ToLower,
+}
+
+// ParseLayout creates a layout from a path template, e.g. "{{.Time.Year}}/{{.ID}}-{{slug .Title}}.html".
+// Templates are executed with article's fields, where .ID is a numeric ID of the article and .GUID is its original ID,
+// and with "slug", "translit" and "lower" functions. Path is shortened to maxLength bytes, unless it's zero.
+func ParseLayout(pathTemplate string, maxLength int) (Layout, error) {
+	layout := Layout{maxLength: maxLength}
+	if pathTemplate == "" {
+		return layout, nil
+	}
+
+	var err error
+	layout.template, err = template.New("path").Funcs(pathFuncs).Parse(pathTemplate)
+	if err != nil {
+		return Layout{}, err
+	}
+
+	// Templates that refer to unknown fields are rejected early, rather than on each article.
+	err = layout.template.Execute(&bytes.Buffer{}, newPathFields(data.Article{Time: time.Now()}))
+	if err != nil {
+		return Layout{}, err
+	}
+
+	return layout, nil
+}
+
+// Path returns a path of article's carbon copy relative to carbon copy directory, with forward slashes.
+// Carbon copies of different articles may share the same path, Locate resolves such collisions.
+func (l Layout) Path(article data.Article) string {
+	return l.shorten(l.render(article), 0)
+}
+
+// Locate returns a path of article's carbon copy in a storage and whether the carbon copy exists.
+// Paths of articles that are being stored are resolved by Resolver, which takes unfinished carbon copies into account.
+func (l Layout) Locate(ctx context.Context, storage Storage, article data.Article) (string, bool, error) {
+	return l.locate(ctx, storage, article, nil)
+}
+
+// locate implements Locate, paths that are reported as taken are skipped as if they had carbon copies of other articles.
+func (l Layout) locate(ctx context.Context, storage Storage, article data.Article, taken func(name string) bool) (string, bool, error) {
+	for _, name := range []string{l.Path(article), l.alternatePath(article)} {
+		if taken != nil && taken(name) {
+			continue
+		}
+
+		sidecar, err := readSidecar(ctx, storage, sidecarFileName(name))
+		if err == nil {
+			if sidecar.Article.ID == article.ID {
+				return name, true, nil
+			}
+
+			// The path is taken by another article, the alternate one is tried next.
+			continue
+		}
+
+		if !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, errMalformedSidecar) {
+			return "", false, err
+		}
+
+		exists, err := storage.Exists(ctx, name)
+		if err != nil {
+			return "", false, err
+		}
+
+		if !exists {
+			return name, false, nil
+		}
+
+		if l.hasLegacyPath(article) {
+			// Carbon copies that have been stored before sidecars were introduced have no sidecars.
+			return name, true, nil
+		}
+
+		// A file without a sidecar can't be told apart from a carbon copy of another article, so it's left as is.
+	}
+
+	return "", false, fmt.Errorf("%w: %s", errPathTaken, article.ID)
+}
+
+// hasLegacyPath returns true if a file without a sidecar at article's path may only be a carbon copy of the article.
+// Sidecars have been introduced before path templates, so such files are found in the default layout only,
+// where paths start with numeric IDs of articles.
+func (l Layout) hasLegacyPath(article data.Article) bool {
+	return l.template == nil && articleIDRegex.FindString(article.LinkURL) != ""
+}
+
+// alternatePath returns a path of article's carbon copy that is used if its own path is taken by another article.
+// Path is suffixed with a hash of article's ID, so it's stable for the article.
+func (l Layout) alternatePath(article data.Article) string {
+	hash := sha256.Sum256([]byte(article.ID))
+	suffix := "-" + hex.EncodeToString(hash[:4])
+
+	rel := l.shorten(l.render(article), len(suffix))
+	return strings.TrimSuffix(rel, pageExt) + suffix + pageExt
+}
+
+func (l Layout) render(article data.Article) string {
+	if l.template == nil {
+		return extractFileName(article)
+	}
+
+	var buf bytes.Buffer
+	err := l.template.Execute(&buf, newPathFields(article))
+	if err != nil {
+		log.Warn().Err(err).Str("id", article.ID).Msg("unable to render cc path, falling back to default one")
+		return extractFileName(article)
+	}
+
+	return buf.String()
+}
+
+// shorten makes a safe relative path, removing characters that file systems don't allow in names,
+// and fits it into limits of file systems and into maximum path length, leaving reserve bytes for a suffix.
+func (l Layout) shorten(rawPath string, reserve int) string {
+	var parts []string
+	for _, part := range strings.FieldsFunc(rawPath, func(r rune) bool { return r == '/' || r == '\\' }) {
+		part = strings.Map(func(r rune) rune {
+			if r < 0x20 || strings.ContainsRune(`<>:"|?*`, r) {
+				return '_'
+			}
+
+			return r
+		}, part)
+
+		part = strings.TrimRight(strings.TrimSpace(part), ". ")
+		if part != "" && part != "." && part != ".." {
+			parts = append(parts, part)
+		}
+	}
+
+	if len(parts) == 0 {
+		parts = []string{"untitled" + pageExt}
+	}
+
+	for i := range parts[:len(parts)-1] {
+		parts[i] = truncate(parts[i], maxNameLength)
+	}
+
+	last := len(parts) - 1
+	stem := parts[last]
+	if strings.EqualFold(path.Ext(stem), pageExt) {
+		stem = stem[:len(stem)-len(pageExt)]
+	}
+
+	stemLength := maxNameLength - len(pageExt) - reserve
+	if l.maxLength > 0 {
+		dirLength := len(strings.Join(parts[:last], "/")) + 1
+		if dirLength == 1 {
+			dirLength = 0
+		}
+
+		if limit := l.maxLength - dirLength - len(pageExt) - reserve; limit < stemLength {
+			stemLength = limit
+		}
+
+		if stemLength < minStemLength {
+			stemLength = minStemLength
+		}
+	}
+
+	stem = truncate(stem, stemLength)
+	if stem == "" {
+		stem = "untitled"
+	}
+
+	parts[last] = stem + pageExt
+	return strings.Join(parts, "/")
+}
+
+// truncate shortens a string to n bytes without splitting UTF-8 sequences.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+
+	s = s[:n]
+	for len(s) > 0 {
+		r, size := utf8.DecodeLastRuneInString(s)
+		if r != utf8.RuneError || size > 1 {
+			break
+		}
+
+		s = s[:len(s)-1]
+	}
+
+	return strings.TrimRight(s, " .-")
+}
+
+var digitsRegex = regexp.MustCompile(`[0-9]+`)
+
+func newPathFields(article data.Article) pathFields {
+	fields := pathFields{Article: article, GUID: article.ID}
+	fields.Time = article.Time.UTC()
+
+	// Article IDs are usually URLs, their numeric parts are taken from paths, so host ports aren't mistaken for them.
+	for _, ref := range []string{article.ID, article.LinkURL} {
+		value := ref
+		if u, err := url.Parse(ref); err == nil && u.Host != "" {
+			value = u.Path
+		}
+
+		if numbers := digitsRegex.FindAllString(value, -1); len(numbers) > 0 {
+			fields.ID = numbers[len(numbers)-1]
+			return fields
+		}
+	}
+
+	fields.ID = slug(article.ID)
+	return fields
+}
+
+// slug returns a lowercase form of a text with Cyrillic letters transliterated and other characters replaced by hyphens,
+// e.g. "privet-mir" for "Привет, мир!".
+func slug(text string) string {
+	var sb strings.Builder
+	hyphen := false
+
+	for _, r := range transliterate(strings.ToLower(text)) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if hyphen && sb.Len() > 0 {
+				sb.WriteRune('-')
+			}
+
+			sb.WriteRune(r)
+			hyphen = false
+			continue
+		}
+
+		hyphen = true
+	}
+
+	s := sb.String()
+	if len(s) > maxSlugLength {
+		// Slugs are cut at word boundaries, if possible.
+		s = truncate(s, maxSlugLength)
+		if i := strings.LastIndexByte(s, '-'); i > maxSlugLength/2 {
+			s = s[:i]
+		}
+	}
+
+	return s
+}
+
+// translitTable maps Cyrillic letters to Latin ones.
+var translitTable = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "yo", 'ж': "zh", 'з': "z", 'и': "i", 'й': "y",
+	'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f",
+	'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu", 'я': "ya",
+	'і': "i", 'ї': "yi", 'є': "ye", 'ґ': "g", 'ў': "u",
+}
+
+// transliterate replaces Cyrillic letters of a text with Latin ones, preserving their case.
+func transliterate(text string) string {
+	var sb strings.Builder
+	for _, r := range text {
+		lower := unicode.ToLower(r)
+		latin, ok := translitTable[lower]
+		if !ok {
+			sb.WriteRune(r)
+			continue
+		}
+
+		if lower != r && latin != "" {
+			latin = strings.ToUpper(latin[:1]) + latin[1:]
+		}
+
+		sb.WriteString(latin)
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,178 @@
+package carboncopy
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kapitanov/habrabot/internal/data"
+)
+
+func TestLayout_Path(t *testing.T) {
+	article := data.Article{
+		ID:      "https://habr.com/ru/post/704622/",
+		Title:   "Программный рендер в стиле игры Doom",
+		Time:    time.Date(2022, 12, 18, 1, 0, 0, 0, time.FixedZone("MSK", 3*60*60)),
+		LinkURL: "https://habr.com/ru/post/704622/?utm_source=rss",
+	}
+
+	testCases := []struct {
+		Name      string
+		Template  string
+		MaxLength int
+		Expected  string
+	}{
+		{
+			Name:     "default",
+			Expected: "704622 - Программный рендер в стиле игры Doom.html",
+		},
+		{
+			Name:     "directories",
+			Template: `{{.Time.Year}}/{{printf "%02d" .Time.Month}}/{{.ID}}-{{slug .Title}}.html`,
+			Expected: "2022/12/704622-programmnyy-render-v-stile-igry-doom.html",
+		},
+		{
+			Name:     "extension is added",
+			Template: `{{.ID}} {{translit .Title}}`,
+			Expected: "704622 Programmnyy render v stile igry Doom.html",
+		},
+		{
+			Name:     "unsafe names are replaced",
+			Template: `../{{.Author}}/./a:b?/{{.ID}}.`,
+			Expected: "a_b_/704622.html",
+		},
+		{
+			Name:      "maximum length",
+			Template:  `{{.Time.Year}}/{{.ID}}-{{slug .Title}}.html`,
+			MaxLength: 30,
+			Expected:  "2022/704622-programmnyy-r.html",
+		},
+		{
+			Name:      "minimum length of names",
+			Template:  `{{.Time.Year}}/{{.ID}}-{{slug .Title}}.html`,
+			MaxLength: 10,
+			Expected:  "2022/704622-programmn.html",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.Name, func(t *testing.T) {
+			layout, err := ParseLayout(tc.Template, tc.MaxLength)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.Expected, layout.Path(article))
+		})
+	}
+}
+
+func TestLayout_PathLongTitle(t *testing.T) {
+	article := data.Article{
+		ID:      "https://habr.com/ru/post/704622/",
+		Title:   strings.Repeat("Очень длинный заголовок ", 20),
+		LinkURL: "https://habr.com/ru/post/704622/",
+	}
+
+	path := Layout{}.Path(article)
+	assert.LessOrEqual(t, len(path), maxNameLength)
+	assert.True(t, strings.HasPrefix(path, "704622 - Очень длинный заголовок"))
+	assert.True(t, strings.HasSuffix(path, ".html"))
+	assert.True(t, utf8.ValidString(path), "names are not cut in the middle of a letter")
+
+	layout, err := ParseLayout("{{.ID}}-{{slug .Title}}", 0)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, len(layout.Path(article)), len("704622-")+maxSlugLength+len(".html"))
+}
+
+func TestParseLayout(t *testing.T) {
+	_, err := ParseLayout("{{.Unknown}}.html", 0)
+	assert.Error(t, err)
+
+	_, err = ParseLayout("{{.ID", 0)
+	assert.Error(t, err)
+}
+
+func TestSlug(t *testing.T) {
+	assert.Equal(t, "privet-mir", slug("Привет, мир!"))
+	assert.Equal(t, "shchuka-i-yozh-c", slug("  Щука и ёж: C++ "))
+	assert.Equal(t, "go-1-19", slug("Go 1.19"))
+	assert.Equal(t, "Shchi i Yozh", transliterate("Щи и Ёж"))
+}
+
+func TestConsumer_Layout(t *testing.T) {
+	site := newTestSite(t)
+	dir := t.TempDir()
+
+	layout, err := ParseLayout(`{{.Time.Year}}/{{slug .Title}}.html`, 0)
+	require.NoError(t, err)
+
+	c := Use(dir, WithLayout(layout), WithArchiveMode(ArchiveModeAssets))
+
+	// Both articles have the same title, so the second one gets an alternate path.
+	first := data.Article{ID: "1", Title: "Same", Time: time.Date(2022, 10, 18, 0, 0, 0, 0, time.UTC), LinkURL: site.URL + "/post/1/"}
+	second := data.Article{ID: "2", Title: "Same", Time: time.Date(2022, 10, 19, 0, 0, 0, 0, time.UTC), LinkURL: site.URL + "/post/2/"}
+
+	require.NoError(t, c.On(context.Background(), first))
+	require.NoError(t, c.On(context.Background(), second))
+
+	firstName, ok, err := layout.Locate(context.Background(), Dir(dir), first)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "2022/same.html", firstName)
+
+	secondName, ok, err := layout.Locate(context.Background(), Dir(dir), second)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Regexp(t, `^2022/same-[0-9a-f]{8}\.html$`, secondName)
+
+	// Storing an article again overwrites its own copy.
+	require.NoError(t, c.On(context.Background(), second))
+	files, err := os.ReadDir(filepath.Join(dir, "2022"))
+	require.NoError(t, err)
+	assert.Len(t, files, 4) // Pages and sidecars of both articles.
+
+	// Pages in subdirectories refer to shared assets by relative paths.
+	assert.Contains(t, readFile(t, filepath.Join(dir, "2022", "same.html")), `src="../assets/`)
+
+	_, ok, err = layout.Locate(context.Background(), Dir(dir), data.Article{ID: "3", Title: "Missing"})
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestLayout_LocateLegacy(t *testing.T) {
+	dir := t.TempDir()
+	storage := Dir(dir)
+	article := data.Article{ID: "1", Title: "Same", Time: time.Date(2022, 10, 18, 0, 0, 0, 0, time.UTC), LinkURL: "https://habr.com/post/127/"}
+
+	// Carbon copies stored before sidecars have been introduced are found in the default layout.
+	require.NoError(t, storage.Write(context.Background(), FileName(article), strings.NewReader("legacy")))
+
+	name, ok, err := Layout{}.Locate(context.Background(), storage, article)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, FileName(article), name)
+
+	// Other layouts have never had files without sidecars, so such a file may belong to another article.
+	layout, err := ParseLayout(`{{.Time.Year}}/{{slug .Title}}.html`, 0)
+	require.NoError(t, err)
+	require.NoError(t, storage.Write(context.Background(), "2022/same.html", strings.NewReader("unknown")))
+
+	name, ok, err = layout.Locate(context.Background(), storage, article)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, layout.alternatePath(article), name)
+
+	// Paths that are both taken aren't reported as free.
+	require.NoError(t, storage.Write(context.Background(), layout.alternatePath(article), strings.NewReader("unknown")))
+
+	_, _, err = layout.Locate(context.Background(), storage, article)
+	assert.ErrorIs(t, err, errPathTaken)
+}
@@ -0,0 +1,27 @@
+package carboncopy
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// LockFileName is a name of the file in carbon copy directory that is locked while carbon copies are stored into it.
+const LockFileName = ".habrabot.lock"
+
+// ErrLocked is returned if carbon copy directory is locked by another process,
+// e.g. when a backfill is started while the bot is running.
+var ErrLocked = errors.New("carbon copy directory is locked by another process")
+
+// Lock locks carbon copy directory, so that the bot and a backfill don't record WARC files
+// and rewrite index pages of the archive at the same time. It fails at once if the directory is locked already.
+// The lock is released once the returned closer is closed.
+func Lock(dirPath string) (io.Closer, error) {
+	err := os.MkdirAll(dirPath, dirMode)
+	if err != nil {
+		return nil, err
+	}
+
+	return lockFile(filepath.Join(dirPath, LockFileName))
+}
@@ -0,0 +1,40 @@
+//go:build !unix
+
+package carboncopy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+)
+
+// exclusiveFile is a lock file that is removed once the lock is released.
+type exclusiveFile struct {
+	file *os.File
+}
+
+// lockFile creates a lock file exclusively, since advisory locks aren't available.
+// The file is left behind if the process crashes, so it has to be removed manually then.
+func lockFile(name string) (io.Closer, error) {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, fileMode)
+	if errors.Is(err, fs.ErrExist) {
+		return nil, fmt.Errorf("%w: %s, remove it unless another process is running", ErrLocked, name)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return exclusiveFile{file: f}, nil
+}
+
+func (l exclusiveFile) Close() error {
+	err := l.file.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(l.file.Name())
+}
@@ -0,0 +1,25 @@
+package carboncopy
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLock(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "cc")
+
+	lock, err := Lock(dir)
+	require.NoError(t, err)
+
+	_, err = Lock(dir)
+	assert.ErrorIs(t, err, ErrLocked)
+
+	require.NoError(t, lock.Close())
+
+	lock, err = Lock(dir)
+	require.NoError(t, err)
+	require.NoError(t, lock.Close())
+}
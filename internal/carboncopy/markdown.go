@@ -0,0 +1,407 @@
+package carboncopy
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"gopkg.in/yaml.v3"
+
+	"github.com/kapitanov/habrabot/internal/data"
+)
+
+// WithMarkdown extracts article body from each web page and stores it as Markdown next to the page,
+// with YAML front matter built from the article.
+func WithMarkdown() Option {
+	return func(c *consumer) {
+		c.markdown = true
+	}
+}
+
+// frontMatter is a YAML front matter of a Markdown file.
+type frontMatter struct {
+	Title  string    `yaml:"title"`
+	Author string    `yaml:"author,omitempty"`
+	Tags   []string  `yaml:"tags,omitempty"`
+	Time   time.Time `yaml:"time"`
+	URL    string    `yaml:"url"`
+}
+
+// renderMarkdown renders article body of a web page as a Markdown file that is stored next to the page.
+func (c *consumer) renderMarkdown(article data.Article, page download, pageName string) ([]byte, error) {
+	var resolve func(u *url.URL) (string, bool)
+	if c.mode == ArchiveModeAssets {
+		// Images refer to their local copies, if they have been archived along with the page.
+		resolve = func(u *url.URL) (string, bool) {
+			name, ok := c.knownAsset(u.String())
+			if !ok {
+				return "", false
+			}
+
+			return assetReference(path.Dir(pageName), name), true
+		}
+	}
+
+	return renderArticleMarkdown(article, page, resolve)
+}
+
+// markdownFileName returns a name of the Markdown file of a page.
+func markdownFileName(pagePath string) string {
+	return strings.TrimSuffix(pagePath, ".html") + ".md"
+}
+
+// renderArticleMarkdown extracts article body from a web page and renders it as a Markdown document.
+// Resources are referred to by their URLs, unless resolve function provides local references.
+func renderArticleMarkdown(article data.Article, page download, resolve func(u *url.URL) (string, bool)) ([]byte, error) {
+	doc, err := html.Parse(bytes.NewReader(page.Body))
+	if err != nil {
+		return nil, err
+	}
+
+	base, err := url.Parse(page.FinalURL)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := yaml.Marshal(frontMatter{
+		Title:  article.Title,
+		Author: article.Author,
+		Tags:   article.Tags,
+		Time:   article.Time.UTC(),
+		URL:    article.LinkURL,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("---\n")
+	buf.Write(meta)
+	buf.WriteString("---\n\n")
+	buf.WriteString("# " + escapeMarkdown(strings.TrimSpace(article.Title)) + "\n\n")
+
+	if content := extractContent(doc, base.Hostname()); content != nil {
+		r := &markdownRenderer{base: base, resolve: resolve}
+		r.renderChildren(content)
+		buf.WriteString(r.String())
+	}
+
+	return buf.Bytes(), nil
+}
+
+// markdownRenderer converts HTML into Markdown.
+// Blocks are rendered into a buffer separated by blank lines, inline content is accumulated into the current line.
+type markdownRenderer struct {
+	base    *url.URL
+	resolve func(u *url.URL) (string, bool)
+	buf     strings.Builder
+}
+
+var (
+	blankLinesRegex = regexp.MustCompile(`\n{3,}`)
+	spaceRegex      = regexp.MustCompile(`[ \t\r\n\f\x{00a0}]+`)
+)
+
+func (r *markdownRenderer) String() string {
+	text := blankLinesRegex.ReplaceAllString(r.buf.String(), "\n\n")
+	return strings.TrimSpace(text) + "\n"
+}
+
+func (r *markdownRenderer) renderChildren(n *html.Node) {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		r.render(child)
+	}
+}
+
+//nolint:cyclop // A flat switch over HTML elements is easier to follow than a table of handlers.
+func (r *markdownRenderer) render(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		text := spaceRegex.ReplaceAllString(n.Data, " ")
+		if r.atLineStart() {
+			// Indentation of HTML source must not turn into indentation of Markdown.
+			text = strings.TrimLeft(text, " ")
+		}
+
+		r.buf.WriteString(escapeMarkdown(text))
+		return
+	case html.ElementNode:
+	default:
+		return
+	}
+
+	switch n.DataAtom {
+	case atom.Script, atom.Style, atom.Noscript, atom.Template:
+
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		level := int(n.Data[1] - '0')
+		r.block(strings.Repeat("#", level) + " " + r.inline(n))
+
+	case atom.P, atom.Div, atom.Section, atom.Article, atom.Main, atom.Figure:
+		r.buf.WriteString("\n\n")
+		r.renderChildren(n)
+		r.buf.WriteString("\n\n")
+
+	case atom.Figcaption:
+		if caption := r.inline(n); caption != "" {
+			r.block("_" + caption + "_")
+		}
+
+	case atom.Br:
+		r.buf.WriteString("  \n")
+
+	case atom.Hr:
+		r.block("---")
+
+	case atom.Strong, atom.B:
+		r.wrap(n, "**")
+
+	case atom.Em, atom.I:
+		r.wrap(n, "_")
+
+	case atom.S, atom.Del, atom.Strike:
+		r.wrap(n, "~~")
+
+	case atom.Code:
+		code := textContent(n)
+		fence := "`"
+		if strings.Contains(code, "`") {
+			fence = "``"
+		}
+
+		r.buf.WriteString(fence + strings.TrimSpace(code) + fence)
+
+	case atom.Pre:
+		r.renderCodeBlock(n)
+
+	case atom.A:
+		text := r.inline(n)
+		href := r.url(getAttr(n, "href"), false)
+		if href == "" || text == "" {
+			r.buf.WriteString(text)
+			return
+		}
+
+		r.buf.WriteString("[" + text + "](" + href + ")")
+
+	case atom.Img:
+		r.renderImage(n)
+
+	case atom.Blockquote:
+		inner := &markdownRenderer{base: r.base, resolve: r.resolve}
+		inner.renderChildren(n)
+
+		lines := strings.Split(strings.TrimSpace(inner.String()), "\n")
+		for i, line := range lines {
+			if line == "" {
+				lines[i] = ">"
+			} else {
+				lines[i] = "> " + line
+			}
+		}
+
+		r.block(strings.Join(lines, "\n"))
+
+	case atom.Ul, atom.Ol:
+		r.renderList(n)
+
+	case atom.Table:
+		r.renderTable(n)
+
+	default:
+		r.renderChildren(n)
+	}
+}
+
+func (r *markdownRenderer) atLineStart() bool {
+	text := r.buf.String()
+	return text == "" || strings.HasSuffix(text, "\n")
+}
+
+// block writes a block of text, separated from the surrounding content by blank lines.
+func (r *markdownRenderer) block(text string) {
+	r.buf.WriteString("\n\n" + text + "\n\n")
+}
+
+func (r *markdownRenderer) wrap(n *html.Node, marker string) {
+	text := r.inline(n)
+	if text == "" {
+		return
+	}
+
+	r.buf.WriteString(marker + text + marker)
+}
+
+// inline renders children of a node into a single line of text.
+func (r *markdownRenderer) inline(n *html.Node) string {
+	inner := &markdownRenderer{base: r.base, resolve: r.resolve}
+	inner.renderChildren(n)
+
+	return strings.TrimSpace(spaceRegex.ReplaceAllString(inner.buf.String(), " "))
+}
+
+func (r *markdownRenderer) renderImage(n *html.Node) {
+	src := getAttr(n, "data-src")
+	if src == "" {
+		src = getAttr(n, "src")
+	}
+
+	src = r.url(src, true)
+	if src == "" {
+		return
+	}
+
+	alt := escapeMarkdown(strings.TrimSpace(getAttr(n, "alt")))
+	r.buf.WriteString("![" + alt + "](" + src + ")")
+}
+
+func (r *markdownRenderer) renderCodeBlock(n *html.Node) {
+	lang := codeLanguage(n)
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode && child.DataAtom == atom.Code && lang == "" {
+			lang = codeLanguage(child)
+		}
+	}
+
+	code := strings.Trim(textContent(n), "\n")
+
+	fence := "```"
+	for strings.Contains(code, fence) {
+		fence += "`"
+	}
+
+	r.block(fence + lang + "\n" + code + "\n" + fence)
+}
+
+func (r *markdownRenderer) renderList(n *html.Node) {
+	var items []string
+	index := 1
+
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type != html.ElementNode || child.DataAtom != atom.Li {
+			continue
+		}
+
+		marker := "- "
+		if n.DataAtom == atom.Ol {
+			marker = fmt.Sprintf("%d. ", index)
+			index++
+		}
+
+		inner := &markdownRenderer{base: r.base, resolve: r.resolve}
+		inner.renderChildren(child)
+
+		// Nested blocks are indented, so they belong to the list item.
+		lines := strings.Split(strings.TrimSpace(inner.String()), "\n")
+		for i := range lines {
+			if i > 0 && lines[i] != "" {
+				lines[i] = strings.Repeat(" ", len(marker)) + lines[i]
+			}
+		}
+
+		items = append(items, marker+strings.Join(lines, "\n"))
+	}
+
+	if len(items) > 0 {
+		r.block(strings.Join(items, "\n"))
+	}
+}
+
+func (r *markdownRenderer) renderTable(n *html.Node) {
+	var rows [][]string
+	walk(n, func(child *html.Node) {
+		if child.Type != html.ElementNode || child.DataAtom != atom.Tr {
+			return
+		}
+
+		var row []string
+		for cell := child.FirstChild; cell != nil; cell = cell.NextSibling {
+			if cell.Type == html.ElementNode && (cell.DataAtom == atom.Td || cell.DataAtom == atom.Th) {
+				row = append(row, strings.ReplaceAll(r.inline(cell), "|", `\|`))
+			}
+		}
+
+		if len(row) > 0 {
+			rows = append(rows, row)
+		}
+	})
+
+	if len(rows) == 0 {
+		return
+	}
+
+	width := 0
+	for _, row := range rows {
+		if len(row) > width {
+			width = len(row)
+		}
+	}
+
+	lines := make([]string, 0, len(rows)+1)
+	for i, row := range rows {
+		for len(row) < width {
+			row = append(row, "")
+		}
+
+		lines = append(lines, "| "+strings.Join(row, " | ")+" |")
+		if i == 0 {
+			lines = append(lines, "|"+strings.Repeat(" --- |", width))
+		}
+	}
+
+	r.block(strings.Join(lines, "\n"))
+}
+
+// url resolves a reference of a link or an image.
+func (r *markdownRenderer) url(ref string, isResource bool) string {
+	ref = strings.TrimSpace(ref)
+	if ref == "" || strings.HasPrefix(ref, "javascript:") || strings.HasPrefix(ref, "data:") {
+		return ""
+	}
+
+	u, err := r.base.Parse(ref)
+	if err != nil {
+		return ""
+	}
+
+	if isResource && r.resolve != nil {
+		if local, ok := r.resolve(u); ok {
+			return local
+		}
+	}
+
+	return strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29").Replace(u.String())
+}
+
+var codeLanguageRegex = regexp.MustCompile(`^(?:lang(?:uage)?-)?([A-Za-z0-9_+#-]+)$`)
+
+// codeLanguage returns a language of a code block by its class, e.g. "go" or "language-go".
+func codeLanguage(n *html.Node) string {
+	for _, class := range strings.Fields(getAttr(n, "class")) {
+		if m := codeLanguageRegex.FindStringSubmatch(class); m != nil && !strings.HasPrefix(class, "hljs") {
+			return strings.ToLower(m[1])
+		}
+	}
+
+	return ""
+}
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"`", "\\`",
+	`*`, `\*`,
+	`_`, `\_`,
+	`[`, `\[`,
+	`]`, `\]`,
+	`<`, `\<`,
+)
+
+func escapeMarkdown(text string) string {
+	return markdownEscaper.Replace(text)
+}
@@ -0,0 +1,131 @@
+package carboncopy
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kapitanov/habrabot/internal/data"
+)
+
+const habrPage = `<!DOCTYPE html>
+<html>
+<head><title>Article</title><script>var x = 1;</script></head>
+<body>
+<header><nav><a href="/">Habr</a> <a href="/flows/">Flows</a></nav></header>
+<div class="tm-article-presenter">
+  <h1>Article</h1>
+  <div id="post-content-body">
+    <div class="article-formatted-body">
+      <h2>Intro</h2>
+      <p>Some <b>bold</b>, <i>italic</i> and <a href="/ru/post/1/">linked</a> text with a * star.</p>
+      <figure><img src="/placeholder.png" data-src="https://habrastorage.org/image.png" alt="Scheme"><figcaption>Figure 1</figcaption></figure>
+      <pre><code class="go">func main() {
+	fmt.Println("hello")
+}</code></pre>
+      <ul><li>first</li><li>second <code>x</code></li></ul>
+      <blockquote>Quoted<br>text</blockquote>
+      <table><tr><th>A</th><th>B</th></tr><tr><td>1</td><td>2</td></tr></table>
+    </div>
+  </div>
+  <div class="tm-article-presenter__footer">Share</div>
+</div>
+<div class="comments">A comment that is long enough to be a paragraph, with commas, and more.</div>
+</body>
+</html>`
+
+const expectedHabrMarkdown = `---
+title: Article
+author: author
+tags:
+    - go
+    - habr
+time: 2022-10-18T12:00:00Z
+url: https://habr.com/ru/post/704622/
+---
+
+# Article
+
+## Intro
+
+Some **bold**, _italic_ and [linked](https://habr.com/ru/post/1/) text with a \* star.
+
+![Scheme](https://habrastorage.org/image.png)
+
+_Figure 1_
+
+` + "```go" + `
+func main() {
+	fmt.Println("hello")
+}
+` + "```" + `
+
+- first
+- second ` + "`x`" + `
+
+> Quoted  
+> text
+
+| A | B |
+| --- | --- |
+| 1 | 2 |
+`
+
+func TestRenderArticleMarkdown_Habr(t *testing.T) {
+	article := data.Article{
+		Title:   "Article",
+		Author:  "author",
+		Tags:    []string{"go", "habr"},
+		Time:    time.Date(2022, 10, 18, 12, 0, 0, 0, time.UTC),
+		LinkURL: "https://habr.com/ru/post/704622/",
+	}
+	page := download{FinalURL: article.LinkURL, Body: []byte(habrPage)}
+
+	md, err := renderArticleMarkdown(article, page, nil)
+	require.NoError(t, err)
+	assert.Equal(t, expectedHabrMarkdown, string(md))
+}
+
+func TestRenderArticleMarkdown_Generic(t *testing.T) {
+	page := download{FinalURL: "https://example.com/blog/1", Body: []byte(`<html><body>
+<div class="menu"><a href="/">Home</a> <a href="/about">About, contacts, and everything else</a></div>
+<div class="main">
+  <div class="entry">
+    <p>First paragraph of the article, which is long enough to be scored.</p>
+    <p>Second paragraph of the article, with commas, and other punctuation.</p>
+  </div>
+  <div class="sidebar"><p>Popular posts, recent posts, archives and other links.</p></div>
+</div>
+<footer><p>Copyright, all rights reserved, and so on and so forth.</p></footer>
+</body></html>`)}
+
+	md, err := renderArticleMarkdown(data.Article{Title: "Post"}, page, nil)
+	require.NoError(t, err)
+
+	assert.Contains(t, string(md), "# Post\n\nFirst paragraph of the article, which is long enough to be scored.\n\n"+
+		"Second paragraph of the article, with commas, and other punctuation.\n")
+	assert.NotContains(t, string(md), "Popular posts")
+	assert.NotContains(t, string(md), "Copyright")
+	assert.NotContains(t, string(md), "About")
+}
+
+func TestConsumer_Markdown(t *testing.T) {
+	site := newTestSite(t)
+	dir := t.TempDir()
+	c := Use(dir, WithArchiveMode(ArchiveModeAssets), WithMarkdown())
+
+	article := data.Article{ID: "1", Title: "First", LinkURL: site.URL + "/post/1/"}
+	require.NoError(t, c.On(context.Background(), article))
+
+	md, err := os.ReadFile(filepath.Join(dir, markdownFileName(extractFileName(article))))
+	require.NoError(t, err)
+
+	// Images refer to their archived copies.
+	assert.Regexp(t, `!\[\]\(assets/[0-9a-f]{64}\.png\)`, string(md))
+	assert.Contains(t, string(md), "title: First\n")
+}
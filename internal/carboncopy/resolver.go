@@ -0,0 +1,89 @@
+package carboncopy
+
+import (
+	"context"
+	"sync"
+
+	"github.com/kapitanov/habrabot/internal/data"
+)
+
+// Resolver assigns paths of carbon copies in a storage to articles, resolving collisions between articles
+// that share a path of the layout, see Layout.Locate.
+// Paths are claimed by articles while they are being stored, so articles that are stored concurrently don't take the same path.
+type Resolver struct {
+	layout  Layout
+	storage Storage
+
+	mutex  sync.Mutex
+	claims map[string]string // IDs of articles by paths that have been resolved for them, but haven't been stored yet.
+}
+
+// NewResolver creates a resolver of paths of carbon copies arranged in a storage by a layout.
+func NewResolver(layout Layout, storage Storage) *Resolver {
+	return &Resolver{
+		layout:  layout,
+		storage: storage,
+		claims:  make(map[string]string),
+	}
+}
+
+// WithResolver makes the consumer store carbon copies into the storage of a resolver, at paths that it resolves.
+// It's used instead of WithLayout and WithStorage when paths are resolved elsewhere too, e.g. for post buttons.
+func WithResolver(resolver *Resolver) Option {
+	return func(c *consumer) {
+		c.layout = resolver.layout
+		c.storage = resolver.storage
+		c.resolver = resolver
+	}
+}
+
+// Resolve returns a path of article's carbon copy and whether the carbon copy exists.
+// The path is claimed by the article until it's released, which is done once its carbon copy is stored or has failed.
+func (r *Resolver) Resolve(ctx context.Context, article data.Article) (string, bool, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	name, exists, err := r.locate(ctx, article)
+	if err != nil {
+		return "", false, err
+	}
+
+	if !exists {
+		r.claims[name] = article.ID
+	}
+
+	return name, exists, nil
+}
+
+// Path returns a path of article's carbon copy, it's meant to be used by templates.
+// The path isn't claimed, it's the one that the article is stored at unless another article sharing it is stored first.
+func (r *Resolver) Path(article data.Article) (string, error) {
+	name, _, err := r.lookup(context.Background(), article)
+	return name, err
+}
+
+// lookup returns a path of article's carbon copy and whether the carbon copy exists, without claiming the path.
+func (r *Resolver) lookup(ctx context.Context, article data.Article) (string, bool, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.locate(ctx, article)
+}
+
+// locate returns a path of article's carbon copy and whether the carbon copy exists, skipping paths claimed by other articles.
+// It's invoked with the mutex held.
+func (r *Resolver) locate(ctx context.Context, article data.Article) (string, bool, error) {
+	return r.layout.locate(ctx, r.storage, article, func(name string) bool {
+		id, ok := r.claims[name]
+		return ok && id != article.ID
+	})
+}
+
+// release drops a claim of a path once the carbon copy is stored, since its sidecar marks the path as taken,
+// or once storing it has failed.
+func (r *Resolver) release(name string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.claims, name)
+}
@@ -0,0 +1,88 @@
+package carboncopy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kapitanov/habrabot/internal/data"
+)
+
+func TestResolver(t *testing.T) {
+	site := newTestSite(t)
+	dir := t.TempDir()
+
+	layout, err := ParseLayout(`{{.Time.Year}}/{{slug .Title}}.html`, 0)
+	require.NoError(t, err)
+
+	resolver := NewResolver(layout, Dir(dir))
+	c := Use(dir, WithResolver(resolver), WithArchiveMode(ArchiveModePage))
+
+	first := data.Article{ID: "1", Title: "Same", Time: time.Date(2022, 10, 18, 0, 0, 0, 0, time.UTC), LinkURL: site.URL + "/post/1/"}
+	second := data.Article{ID: "2", Title: "Same", Time: time.Date(2022, 10, 19, 0, 0, 0, 0, time.UTC), LinkURL: site.URL + "/post/2/"}
+
+	// Paths that are resolved for post buttons aren't claimed.
+	firstPath, err := resolver.Path(first)
+	require.NoError(t, err)
+	assert.Equal(t, "2022/same.html", firstPath)
+	assert.Empty(t, resolver.claims)
+
+	// A path that is being stored into is claimed, so the other article sharing it gets an alternate path.
+	claimed, exists, err := resolver.Resolve(context.Background(), first)
+	require.NoError(t, err)
+	assert.False(t, exists)
+	assert.Equal(t, firstPath, claimed)
+
+	secondPath, err := resolver.Path(second)
+	require.NoError(t, err)
+	assert.Regexp(t, `^2022/same-[0-9a-f]{8}\.html$`, secondPath)
+
+	resolver.release(claimed)
+
+	require.NoError(t, c.On(context.Background(), first))
+	require.NoError(t, c.On(context.Background(), second))
+
+	name, ok, err := layout.Locate(context.Background(), Dir(dir), first)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, firstPath, name)
+
+	name, ok, err = layout.Locate(context.Background(), Dir(dir), second)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, secondPath, name)
+
+	// Claims are released once carbon copies are stored.
+	assert.Empty(t, resolver.claims)
+
+	path, err := resolver.Path(second)
+	require.NoError(t, err)
+	assert.Equal(t, secondPath, path)
+}
+
+// failingStorage is a storage that fails to write files.
+type failingStorage struct {
+	Storage
+}
+
+func (failingStorage) Write(context.Context, string, io.Reader) error {
+	return errors.New("write failed")
+}
+
+func TestResolver_ReleasesFailedClaims(t *testing.T) {
+	site := newTestSite(t)
+	dir := t.TempDir()
+
+	resolver := NewResolver(Layout{}, failingStorage{Dir(dir)})
+	c := Use(dir, WithResolver(resolver), WithArchiveMode(ArchiveModePage))
+
+	article := data.Article{ID: "1", Title: "First", LinkURL: site.URL + "/post/1/"}
+	require.Error(t, c.On(context.Background(), article))
+
+	assert.Empty(t, resolver.claims)
+}
@@ -0,0 +1,124 @@
+package carboncopy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/kapitanov/habrabot/internal/data"
+)
+
+// Sidecar is metadata of a carbon copy that is stored as a JSON file next to the page.
+type Sidecar struct {
+	Article     data.Article `json:"article"`
+	File        string       `json:"file"` // Path of the page relative to carbon copy directory, with forward slashes.
+	URL         string       `json:"url"`
+	FinalURL    string       `json:"final_url"`
+	Status      int          `json:"status"`
+	ContentType string       `json:"content_type,omitempty"`
+	SHA256      string       `json:"sha256"` // Hash of the page as it's stored.
+	Size        int          `json:"size"`   // Size of the page as it's stored.
+	FetchedAt   time.Time    `json:"fetched_at"`
+
+	// Files are hashes of other files of the carbon copy, e.g. its Markdown file,
+	// by their paths relative to carbon copy directory, with forward slashes.
+	Files map[string]string `json:"files,omitempty"`
+}
+
+// writeSidecar stores metadata of a carbon copy next to the page.
+func (c *consumer) writeSidecar(
+	ctx context.Context, article data.Article, page download, pageName string, body []byte, files map[string]string,
+) (Sidecar, error) {
+	sidecar := Sidecar{
+		Article:     article,
+		File:        pageName,
+		URL:         page.URL,
+		FinalURL:    page.FinalURL,
+		Status:      page.Status,
+		ContentType: page.ContentType,
+		SHA256:      checksum(body),
+		Size:        len(body),
+		FetchedAt:   page.FetchedAt,
+		Files:       files,
+	}
+
+	content, err := json.MarshalIndent(sidecar, "", "  ")
+	if err != nil {
+		return Sidecar{}, err
+	}
+
+	err = c.storage.Write(ctx, sidecarFileName(pageName), bytes.NewReader(append(content, '\n')))
+	if err != nil {
+		return Sidecar{}, err
+	}
+
+	return sidecar, nil
+}
+
+// sidecarFileName returns a name of the JSON sidecar file of a page.
+func sidecarFileName(pagePath string) string {
+	return strings.TrimSuffix(pagePath, ".html") + ".json"
+}
+
+// loadSidecars reads metadata of all carbon copies that are stored in a storage.
+func loadSidecars(ctx context.Context, storage Storage) ([]Sidecar, error) {
+	names, err := storage.List(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var sidecars []Sidecar
+	for _, name := range names {
+		if path.Ext(name) != ".json" || isServicePath(name) {
+			continue
+		}
+
+		sidecar, err := readSidecar(ctx, storage, name)
+		if errors.Is(err, errMalformedSidecar) {
+			log.Warn().Str("file", name).Msg("skipped malformed sidecar file")
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		sidecars = append(sidecars, sidecar)
+	}
+
+	return sidecars, nil
+}
+
+var errMalformedSidecar = errors.New("malformed sidecar file")
+
+// readSidecar reads metadata of a carbon copy from its sidecar file.
+func readSidecar(ctx context.Context, storage Storage, name string) (Sidecar, error) {
+	content, err := storage.Read(ctx, name)
+	if err != nil {
+		return Sidecar{}, err
+	}
+
+	var sidecar Sidecar
+	err = json.Unmarshal(content, &sidecar)
+	if err != nil || sidecar.File == "" {
+		return Sidecar{}, errMalformedSidecar
+	}
+
+	return sidecar, nil
+}
+
+// isServiceDir returns true if a subdirectory of carbon copy directory holds files other than carbon copies.
+func isServiceDir(name string) bool {
+	switch name {
+	case AssetsDirName, WARCDirName, IndexDirName:
+		return true
+	default:
+		return strings.HasPrefix(name, ".")
+	}
+}
@@ -0,0 +1,122 @@
+package carboncopy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Storage is a destination that carbon copies are stored into, e.g. a local directory or an object storage.
+// Files are named by slash-separated paths relative to the root of the storage, e.g. "assets/<hash>.png".
+type Storage interface {
+	// Read returns content of a file. Error matches fs.ErrNotExist if there's no such file.
+	Read(ctx context.Context, name string) ([]byte, error)
+	// Write replaces content of a file atomically, so a failed write never damages a previous version of it.
+	Write(ctx context.Context, name string, r io.Reader) error
+	// Exists returns true if a file exists.
+	Exists(ctx context.Context, name string) (bool, error)
+	// List returns names of all files within a directory and its subdirectories, in lexical order.
+	// All files are listed if dir is empty.
+	List(ctx context.Context, dir string) ([]string, error)
+	// Remove removes a file. It's not an error if there's no such file.
+	Remove(ctx context.Context, name string) error
+}
+
+// WithStorage stores carbon copies into a storage rather than into the local directory.
+// The local directory still holds WARC files that are being recorded, they are moved into the storage once complete.
+func WithStorage(storage Storage) Option {
+	return func(c *consumer) {
+		c.storage = storage
+	}
+}
+
+// Dir returns a storage that keeps files in a local directory.
+func Dir(dirPath string) Storage {
+	return dirStorage(dirPath)
+}
+
+type dirStorage string
+
+func (d dirStorage) String() string {
+	return string(d)
+}
+
+func (d dirStorage) path(name string) string {
+	return filepath.Join(string(d), filepath.FromSlash(path.Clean("/"+name)))
+}
+
+func (d dirStorage) Read(_ context.Context, name string) ([]byte, error) {
+	return os.ReadFile(d.path(name))
+}
+
+func (d dirStorage) Write(_ context.Context, name string, r io.Reader) error {
+	return writeFileFrom(d.path(name), r)
+}
+
+func (d dirStorage) Exists(_ context.Context, name string) (bool, error) {
+	_, err := os.Stat(d.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func (d dirStorage) List(_ context.Context, dir string) ([]string, error) {
+	var names []string
+	err := filepath.WalkDir(d.path(dir), func(fullpath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Temporary files of incomplete writes are hidden.
+		if entry.IsDir() || isTempFile(entry.Name()) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(string(d), fullpath)
+		if err != nil {
+			return err
+		}
+
+		names = append(names, filepath.ToSlash(rel))
+		return nil
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
+
+func (d dirStorage) Remove(_ context.Context, name string) error {
+	err := os.Remove(d.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// isServicePath returns true if a file of the storage is not a part of a carbon copy, e.g. an asset or an index page.
+func isServicePath(name string) bool {
+	dirs := strings.Split(name, "/")
+	for _, dir := range dirs[:len(dirs)-1] {
+		if isServiceDir(dir) {
+			return true
+		}
+	}
+
+	return false
+}
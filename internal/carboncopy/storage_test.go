@@ -0,0 +1,125 @@
+package carboncopy
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kapitanov/habrabot/internal/data"
+	"github.com/kapitanov/habrabot/internal/epub"
+	"github.com/kapitanov/habrabot/internal/s3"
+	"github.com/kapitanov/habrabot/internal/s3/s3test"
+)
+
+func TestDirStorage(t *testing.T) {
+	dir := t.TempDir()
+	storage := Dir(dir)
+	ctx := context.Background()
+
+	require.NoError(t, storage.Write(ctx, "b/c.html", strings.NewReader("page")))
+	require.NoError(t, storage.Write(ctx, "a.json", strings.NewReader("{}")))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "b", ".c.html.tmp-1"), []byte("partial"), 0644))
+
+	content, err := storage.Read(ctx, "b/c.html")
+	require.NoError(t, err)
+	assert.Equal(t, "page", string(content))
+
+	_, err = storage.Read(ctx, "missing.html")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	names, err := storage.List(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a.json", "b/c.html"}, names, "temporary files are not listed")
+
+	names, err = storage.List(ctx, "missing")
+	require.NoError(t, err)
+	assert.Empty(t, names)
+
+	exists, err := storage.Exists(ctx, "../"+filepath.Base(dir)+"/a.json")
+	require.NoError(t, err)
+	assert.False(t, exists, "files outside of the directory are not accessible")
+
+	require.NoError(t, storage.Remove(ctx, "a.json"))
+	require.NoError(t, storage.Remove(ctx, "a.json"))
+
+	exists, err = storage.Exists(ctx, "a.json")
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestConsumer_S3(t *testing.T) {
+	site := newTestSite(t)
+	dir := t.TempDir()
+
+	server := s3test.NewServer("archive")
+	defer server.Close()
+
+	cfg := server.Config()
+	cfg.Prefix = "cc"
+	cfg.StorageClass = "STANDARD_IA"
+	cfg.SSE = s3.SSES3
+
+	client, err := s3.New(cfg)
+	require.NoError(t, err)
+
+	options := []Option{WithStorage(client), WithArchiveMode(ArchiveModeAssets), WithMarkdown(), WithWARC(WARC{})}
+	first := data.Article{ID: "1", Title: "First", Tags: []string{"go"}, LinkURL: site.URL + "/post/1/"}
+	second := data.Article{ID: "2", Title: "Second", LinkURL: site.URL + "/post/2/"}
+
+	c := Use(dir, options...)
+	require.NoError(t, c.On(context.Background(), first))
+	require.NoError(t, data.Flush(context.Background(), c))
+
+	name := extractFileName(first)
+	object, ok := server.Object("cc/" + name)
+	require.True(t, ok)
+	assert.Contains(t, string(object.Content), `src="assets/`)
+	assert.Equal(t, "STANDARD_IA", object.Header.Get("X-Amz-Storage-Class"))
+	assert.Equal(t, "AES256", object.Header.Get("X-Amz-Server-Side-Encryption"))
+
+	for _, key := range []string{"cc/index.html", "cc/index/tags/go.html", "cc/" + strings.TrimSuffix(name, ".html") + ".md"} {
+		_, ok = server.Object(key)
+		assert.True(t, ok, key)
+	}
+
+	// Only the WARC file that is being recorded stays in the local directory.
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	assert.Equal(t, WARCDirName, files[0].Name())
+
+	// WARC files are moved into the storage once they are complete, e.g. when the consumer is stopped.
+	require.NoError(t, c.(io.Closer).Close())
+
+	c = Use(dir, options...)
+	require.NoError(t, c.On(context.Background(), second))
+
+	var warcKeys []string
+	for _, key := range server.Keys() {
+		if strings.HasPrefix(key, "cc/"+WARCDirName+"/") {
+			warcKeys = append(warcKeys, key)
+		}
+	}
+
+	assert.Len(t, warcKeys, 2) // The WARC file and its CDX index.
+
+	files, err = os.ReadDir(filepath.Join(dir, WARCDirName))
+	require.NoError(t, err)
+	assert.Len(t, files, 2, "the current WARC file and its CDX index are kept locally")
+
+	report, err := Verify(context.Background(), client)
+	require.NoError(t, err)
+	assert.True(t, report.OK())
+	assert.Greater(t, report.Checked, 0)
+
+	book := &epub.Book{Title: "Book"}
+	require.NoError(t, BuildEPUB(context.Background(), client, Layout{}, book, []data.Article{first}))
+	assert.Equal(t, 1, book.Len())
+}
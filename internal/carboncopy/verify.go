@@ -0,0 +1,122 @@
+package carboncopy
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// VerifyReport is a result of archive verification.
+// Files are listed by their paths relative to carbon copy directory, with forward slashes.
+type VerifyReport struct {
+	Checked    int      // Number of files that have been re-hashed.
+	Missing    []string // Files that sidecars refer to, but that don't exist.
+	Corrupted  []string // Files that don't match their checksums, and malformed sidecars.
+	Unverified []string // Pages that have no sidecars, so there are no checksums to verify them with.
+}
+
+// OK returns true if no files are missing or corrupted.
+func (r VerifyReport) OK() bool {
+	return len(r.Missing) == 0 && len(r.Corrupted) == 0
+}
+
+// assetNameRegex matches names of assets, which are hashes of their content.
+var assetNameRegex = regexp.MustCompile(`^([0-9a-f]{64})(\.[^.]*)?$`)
+
+// Verify re-hashes files of the archive in a storage and reports ones that are missing or corrupted.
+// Carbon copies are checked against checksums from their sidecars, assets are checked against their names.
+func Verify(ctx context.Context, storage Storage) (VerifyReport, error) {
+	names, err := storage.List(ctx, "")
+	if err != nil {
+		return VerifyReport{}, err
+	}
+
+	var report VerifyReport
+	pages := make(map[string]bool)
+	verified := make(map[string]bool)
+
+	for _, name := range names {
+		if dir, file := path.Split(name); dir == AssetsDirName+"/" {
+			if m := assetNameRegex.FindStringSubmatch(file); m != nil {
+				err = report.check(ctx, storage, name, m[1])
+				if err != nil {
+					return VerifyReport{}, err
+				}
+			}
+
+			continue
+		}
+
+		if isServicePath(name) || strings.HasPrefix(path.Base(name), ".") {
+			continue
+		}
+
+		switch path.Ext(name) {
+		case pageExt:
+			// The main index page is the only page of the archive that isn't a carbon copy.
+			if name != indexFileName {
+				pages[name] = true
+			}
+
+		case ".json":
+			sidecar, err := readSidecar(ctx, storage, name)
+			if errors.Is(err, errMalformedSidecar) {
+				report.Corrupted = append(report.Corrupted, name)
+				continue
+			}
+
+			if err != nil {
+				return VerifyReport{}, err
+			}
+
+			verified[sidecar.File] = true
+
+			err = report.check(ctx, storage, sidecar.File, sidecar.SHA256)
+			if err != nil {
+				return VerifyReport{}, err
+			}
+
+			for file, hash := range sidecar.Files {
+				err = report.check(ctx, storage, file, hash)
+				if err != nil {
+					return VerifyReport{}, err
+				}
+			}
+		}
+	}
+
+	for page := range pages {
+		if !verified[page] {
+			report.Unverified = append(report.Unverified, page)
+		}
+	}
+
+	sort.Strings(report.Missing)
+	sort.Strings(report.Corrupted)
+	sort.Strings(report.Unverified)
+	return report, nil
+}
+
+// check re-hashes a file of the archive and compares it with its expected checksum.
+func (r *VerifyReport) check(ctx context.Context, storage Storage, name, hash string) error {
+	content, err := storage.Read(ctx, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		r.Missing = append(r.Missing, name)
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	r.Checked++
+	if checksum(content) != hash {
+		r.Corrupted = append(r.Corrupted, name)
+	}
+
+	return nil
+}
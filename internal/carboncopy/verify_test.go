@@ -0,0 +1,86 @@
+package carboncopy
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kapitanov/habrabot/internal/data"
+)
+
+func TestConsumer_FailedDownloadKeepsCopy(t *testing.T) {
+	site := newTestSite(t)
+	dir := t.TempDir()
+	c := Use(dir)
+
+	article := data.Article{ID: "1", Title: "First", LinkURL: site.URL + "/post/1/"}
+	require.NoError(t, c.On(context.Background(), article))
+
+	article.LinkURL = site.URL + "/post/missing/"
+	assert.Error(t, c.On(context.Background(), article))
+
+	assert.Equal(t, testPage, readFile(t, filepath.Join(dir, extractFileName(article))))
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 2, "no temporary files are left") // The page and its sidecar.
+}
+
+func TestWriteFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a", "b.html")
+
+	require.NoError(t, writeFile(path, []byte("first")))
+	require.NoError(t, writeFile(path, []byte("second")))
+	assert.Equal(t, "second", readFile(t, path))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(fileMode), info.Mode().Perm())
+
+	info, err = os.Stat(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(dirMode), info.Mode().Perm()&dirMode)
+}
+
+func TestVerify(t *testing.T) {
+	site := newTestSite(t)
+	dir := t.TempDir()
+	c := Use(dir, WithArchiveMode(ArchiveModeAssets), WithMarkdown())
+
+	first := data.Article{ID: "1", Title: "First", LinkURL: site.URL + "/post/1/"}
+	second := data.Article{ID: "2", Title: "Second", LinkURL: site.URL + "/post/2/"}
+
+	require.NoError(t, c.On(context.Background(), first))
+	require.NoError(t, c.On(context.Background(), second))
+	require.NoError(t, data.Flush(context.Background(), c))
+
+	report, err := Verify(context.Background(), Dir(dir))
+	require.NoError(t, err)
+	assert.True(t, report.OK())
+	assert.Equal(t, 2*2+5, report.Checked) // Pages, Markdown files and assets.
+	assert.Empty(t, report.Unverified)
+
+	// A page is damaged, a Markdown file is lost, an asset is damaged and a page without a sidecar is added.
+	firstName := extractFileName(first)
+	secondMarkdown := strings.TrimSuffix(extractFileName(second), ".html") + ".md"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, firstName), []byte("damaged"), 0644))
+	require.NoError(t, os.Remove(filepath.Join(dir, secondMarkdown)))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "legacy.html"), []byte("legacy"), 0644))
+
+	assets, err := os.ReadDir(filepath.Join(dir, AssetsDirName))
+	require.NoError(t, err)
+	asset := AssetsDirName + "/" + assets[0].Name()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, filepath.FromSlash(asset)), []byte("damaged"), 0644))
+
+	report, err = Verify(context.Background(), Dir(dir))
+	require.NoError(t, err)
+	assert.False(t, report.OK())
+	assert.Equal(t, []string{secondMarkdown}, report.Missing)
+	assert.ElementsMatch(t, []string{firstName, asset}, report.Corrupted)
+	assert.Equal(t, []string{"legacy.html"}, report.Unverified)
+}
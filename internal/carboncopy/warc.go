@@ -0,0 +1,501 @@
+package carboncopy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"mime"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// WARCDirName is a name of the directory that WARC files are stored into, relative to carbon copy directory.
+const WARCDirName = "warc"
+
+// DefaultWARCMaxSize is a size that a WARC file is rotated at by default.
+const DefaultWARCMaxSize = 1024 * 1024 * 1024
+
+// abandonedWARCAge is an age of the last modification that an open WARC file is considered abandoned at,
+// e.g. when the process that has been writing it has crashed. Such files are completed by other writers.
+const abandonedWARCAge = 7 * 24 * time.Hour
+
+const (
+	warcVersion     = "WARC/1.0"
+	warcTimeFormat  = "2006-01-02T15:04:05Z"
+	cdxTimeFormat   = "20060102150405"
+	cdxHeader       = " CDX N b a m s k r M S V g"
+	warcFilePrefix  = "habrabot"
+	warcFileSuffix  = ".warc.gz"
+	openFileSuffix  = ".open" // Suffix of a WARC file that is being written, it's removed once the file is complete.
+	cdxFileSuffix   = ".cdx"
+	warcFileFormat  = "WARC File Format 1.0"
+	warcContentType = "application/warc-fields"
+)
+
+// WARC configures recording of downloaded web pages into WARC files.
+type WARC struct {
+	MaxSize int64 // WARC file is rotated once it grows larger, DefaultWARCMaxSize is used if it's zero.
+	Assets  bool  // If true, assets downloaded for pages are recorded too.
+}
+
+// WithWARC records HTTP requests and responses of downloaded web pages into gzip-compressed WARC files,
+// along with CDX indexes, so archived pages may be replayed by standard tools.
+func WithWARC(options WARC) Option {
+	return func(c *consumer) {
+		if options.MaxSize <= 0 {
+			options.MaxSize = DefaultWARCMaxSize
+		}
+
+		c.warc = &warcWriter{
+			dirPath: filepath.Join(c.dirPath, WARCDirName),
+			options: options,
+		}
+	}
+}
+
+// Close completes the WARC file that is being recorded, and moves complete WARC files into the storage.
+// It should be called once the consumer is stopped, the next article starts a new WARC file otherwise.
+func (c *consumer) Close() error {
+	if c.warc == nil {
+		return nil
+	}
+
+	return c.warc.Close(context.Background())
+}
+
+// warcWriter appends records into the current WARC file and rotates it when it grows too large.
+// Each record is compressed as a separate gzip member, so it may be read from its offset in the CDX index.
+type warcWriter struct {
+	dirPath string
+	options WARC
+	storage Storage // Storage that complete WARC files are moved into, they stay in the local directory if it's nil.
+
+	mutex    sync.Mutex
+	file     *os.File
+	name     string
+	offset   int64
+	serial   int
+	infoID   string
+	cdxLines []string
+}
+
+// Write records a web page and its assets.
+func (w *warcWriter) Write(ctx context.Context, page download, assets []download) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.file != nil && w.offset >= w.options.MaxSize {
+		err := w.close()
+		if err != nil {
+			return err
+		}
+	}
+
+	if w.file == nil {
+		err := w.moveComplete(ctx)
+		if err != nil {
+			return err
+		}
+
+		err = w.open(page.FetchedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	downloads := []download{page}
+	if w.options.Assets {
+		downloads = append(downloads, assets...)
+	}
+
+	for _, d := range downloads {
+		err := w.writeExchange(d)
+		if err != nil {
+			return err
+		}
+	}
+
+	return w.writeCDX()
+}
+
+// Close completes the current WARC file, so the next write starts a new one,
+// and moves complete WARC files into the storage.
+func (w *warcWriter) Close(ctx context.Context) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	err := w.close()
+	if err != nil {
+		return err
+	}
+
+	return w.moveComplete(ctx)
+}
+
+func (w *warcWriter) open(now time.Time) error {
+	err := os.MkdirAll(w.dirPath, dirMode)
+	if err != nil {
+		return err
+	}
+
+	// File names are unique across restarts, since they start with a timestamp.
+	for {
+		w.serial++
+		w.name = fmt.Sprintf("%s-%s-%05d%s", warcFilePrefix, now.UTC().Format(cdxTimeFormat), w.serial, warcFileSuffix)
+
+		// The file is open until it's complete, so it's neither moved nor taken by other writers meanwhile.
+		_, err = os.Stat(filepath.Join(w.dirPath, w.name))
+		if err == nil {
+			continue
+		}
+
+		w.file, err = os.OpenFile(filepath.Join(w.dirPath, w.name+openFileSuffix), os.O_CREATE|os.O_EXCL|os.O_WRONLY, fileMode)
+		if err == nil {
+			break
+		}
+
+		if !os.IsExist(err) {
+			return err
+		}
+	}
+
+	w.offset = 0
+	w.cdxLines = nil
+	w.infoID = newRecordID()
+
+	fields := fmt.Sprintf("software: habrabot\r\nformat: %s\r\n", warcFileFormat)
+	err = w.writeRecord(warcRecord{
+		Type:        "warcinfo",
+		ID:          w.infoID,
+		Date:        now,
+		ContentType: warcContentType,
+		Headers:     [][2]string{{"WARC-Filename", w.name}},
+		Block:       []byte(fields),
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Info().Str("file", w.name).Msg("started a new warc file")
+	return nil
+}
+
+func (w *warcWriter) close() error {
+	if w.file == nil {
+		return nil
+	}
+
+	err := w.file.Close()
+	w.file = nil
+	if err != nil {
+		return err
+	}
+
+	return complete(filepath.Join(w.dirPath, w.name))
+}
+
+// complete renames an open WARC file to its final name.
+func complete(fullpath string) error {
+	err := os.Rename(fullpath+openFileSuffix, fullpath)
+	if err != nil {
+		return err
+	}
+
+	log.Info().Str("file", filepath.Base(fullpath)).Msg("completed a warc file")
+	return nil
+}
+
+// moveComplete moves WARC files that are no longer written to, along with their CDX indexes, into the storage.
+// Files that are still open, e.g. by another process, are skipped, unless they have been abandoned,
+// so files are moved eventually even if the bot has been stopped abruptly.
+// It must not be called while the writer has an open file.
+func (w *warcWriter) moveComplete(ctx context.Context) error {
+	if w.storage == nil {
+		return nil
+	}
+
+	entries, err := os.ReadDir(w.dirPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		name := entry.Name()
+		if strings.HasSuffix(name, warcFileSuffix+openFileSuffix) {
+			name, err = w.completeAbandoned(entry)
+			if err != nil {
+				return err
+			}
+		}
+
+		if !strings.HasSuffix(name, warcFileSuffix) {
+			continue
+		}
+
+		// CDX index is moved after its WARC file, so the storage never has an index of a missing file.
+		cdxName := strings.TrimSuffix(name, warcFileSuffix) + cdxFileSuffix
+		for _, n := range []string{name, cdxName} {
+			err = w.move(ctx, n)
+			if err != nil {
+				return err
+			}
+		}
+
+		log.Info().Str("file", name).Msg("moved warc file into storage")
+	}
+
+	return nil
+}
+
+// completeAbandoned completes an open WARC file if it hasn't been written to for a long time.
+// It returns the name of the complete file, or the name of the open file if it's still being written.
+func (w *warcWriter) completeAbandoned(entry os.DirEntry) (string, error) {
+	info, err := entry.Info()
+	if errors.Is(err, os.ErrNotExist) {
+		return entry.Name(), nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	if time.Since(info.ModTime()) < abandonedWARCAge {
+		return entry.Name(), nil
+	}
+
+	name := strings.TrimSuffix(entry.Name(), openFileSuffix)
+	log.Warn().Str("file", name).Msg("found an abandoned warc file")
+	return name, complete(filepath.Join(w.dirPath, name))
+}
+
+func (w *warcWriter) move(ctx context.Context, name string) error {
+	fullpath := filepath.Join(w.dirPath, name)
+	f, err := os.Open(fullpath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	err = w.storage.Write(ctx, WARCDirName+"/"+name, f)
+	_ = f.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(fullpath)
+}
+
+// writeExchange writes a response record and a request record that it has been received for.
+func (w *warcWriter) writeExchange(d download) error {
+	var response bytes.Buffer
+	response.WriteString(d.StatusLine + "\r\n")
+	err := d.Header.Write(&response)
+	if err != nil {
+		return err
+	}
+
+	response.WriteString("\r\n")
+	response.Write(d.Body)
+
+	payloadDigest := digest(d.Body)
+	responseID := newRecordID()
+
+	offset := w.offset
+	err = w.writeRecord(warcRecord{
+		Type:        "response",
+		ID:          responseID,
+		Date:        d.FetchedAt,
+		TargetURI:   d.FinalURL,
+		ContentType: "application/http; msgtype=response",
+		Headers:     [][2]string{{"WARC-Payload-Digest", "sha1:" + payloadDigest}},
+		Block:       response.Bytes(),
+	})
+	if err != nil {
+		return err
+	}
+
+	w.cdxLines = append(w.cdxLines, strings.Join([]string{
+		surt(d.FinalURL),
+		d.FetchedAt.UTC().Format(cdxTimeFormat),
+		cdxField(d.FinalURL),
+		cdxField(mediaType(d.ContentType)),
+		strconv.Itoa(d.Status),
+		payloadDigest,
+		"-",
+		"-",
+		strconv.FormatInt(w.offset-offset, 10),
+		strconv.FormatInt(offset, 10),
+		w.name,
+	}, " "))
+
+	return w.writeRecord(warcRecord{
+		Type:         "request",
+		ID:           newRecordID(),
+		Date:         d.FetchedAt,
+		TargetURI:    d.FinalURL,
+		ContentType:  "application/http; msgtype=request",
+		ConcurrentTo: responseID,
+		Block:        d.RequestHead,
+	})
+}
+
+// writeCDX rewrites CDX index of the current WARC file, since replay tools expect it to be sorted.
+func (w *warcWriter) writeCDX() error {
+	lines := append([]string(nil), w.cdxLines...)
+	sort.Strings(lines)
+
+	var buf bytes.Buffer
+	buf.WriteString(cdxHeader + "\n")
+	for _, line := range lines {
+		buf.WriteString(line + "\n")
+	}
+
+	name := strings.TrimSuffix(w.name, warcFileSuffix) + cdxFileSuffix
+	return writeFile(filepath.Join(w.dirPath, name), buf.Bytes())
+}
+
+type warcRecord struct {
+	Type         string
+	ID           string
+	Date         time.Time
+	TargetURI    string
+	ContentType  string
+	ConcurrentTo string
+	Headers      [][2]string
+	Block        []byte
+}
+
+func (w *warcWriter) writeRecord(r warcRecord) error {
+	var header bytes.Buffer
+	header.WriteString(warcVersion + "\r\n")
+	writeWARCHeader(&header, "WARC-Type", r.Type)
+	writeWARCHeader(&header, "WARC-Record-ID", r.ID)
+	writeWARCHeader(&header, "WARC-Date", r.Date.UTC().Format(warcTimeFormat))
+	writeWARCHeader(&header, "WARC-Target-URI", r.TargetURI)
+	writeWARCHeader(&header, "WARC-Concurrent-To", r.ConcurrentTo)
+	if r.Type != "warcinfo" {
+		writeWARCHeader(&header, "WARC-Warcinfo-ID", w.infoID)
+	}
+
+	for _, h := range r.Headers {
+		writeWARCHeader(&header, h[0], h[1])
+	}
+
+	writeWARCHeader(&header, "WARC-Block-Digest", "sha1:"+digest(r.Block))
+	writeWARCHeader(&header, "Content-Type", r.ContentType)
+	writeWARCHeader(&header, "Content-Length", strconv.Itoa(len(r.Block)))
+	header.WriteString("\r\n")
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	for _, part := range [][]byte{header.Bytes(), r.Block, []byte("\r\n\r\n")} {
+		_, err := gz.Write(part)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := gz.Close()
+	if err != nil {
+		return err
+	}
+
+	n, err := w.file.Write(buf.Bytes())
+	w.offset += int64(n)
+	return err
+}
+
+func writeWARCHeader(buf *bytes.Buffer, key, value string) {
+	if value != "" {
+		buf.WriteString(key + ": " + value + "\r\n")
+	}
+}
+
+func newRecordID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func digest(b []byte) string {
+	hash := sha1.Sum(b)
+	return base32.StdEncoding.EncodeToString(hash[:])
+}
+
+func mediaType(contentType string) string {
+	t, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+
+	return t
+}
+
+func cdxField(value string) string {
+	if value == "" {
+		return "-"
+	}
+
+	return strings.ReplaceAll(value, " ", "%20")
+}
+
+// surt returns a canonical form of a URL that CDX indexes are sorted by,
+// e.g. "com,habr)/ru/post/704622" for "https://www.habr.com/ru/post/704622".
+func surt(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return cdxField(strings.ToLower(rawURL))
+	}
+
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	parts := strings.Split(host, ".")
+	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+
+	key := strings.Join(parts, ",")
+	if port := u.Port(); port != "" && port != "80" && port != "443" {
+		key += ":" + port
+	}
+
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	key += ")" + strings.ToLower(path)
+	if u.RawQuery != "" {
+		query := strings.Split(u.RawQuery, "&")
+		sort.Strings(query)
+		key += "?" + strings.ToLower(strings.Join(query, "&"))
+	}
+
+	return cdxField(key)
+}
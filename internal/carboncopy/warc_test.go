@@ -0,0 +1,170 @@
+package carboncopy
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kapitanov/habrabot/internal/data"
+)
+
+func TestConsumer_WARC(t *testing.T) {
+	site := newTestSite(t)
+	dir := t.TempDir()
+	c := Use(dir, WithArchiveMode(ArchiveModeAssets), WithWARC(WARC{Assets: true}))
+
+	article := data.Article{ID: "1", Title: "First", LinkURL: site.URL + "/post/old/"}
+	require.NoError(t, c.On(context.Background(), article))
+	require.NoError(t, c.(io.Closer).Close())
+
+	warcs, err := filepath.Glob(filepath.Join(dir, WARCDirName, "*.warc.gz"))
+	require.NoError(t, err)
+	require.Len(t, warcs, 1)
+
+	records := readWARCRecords(t, warcs[0])
+	// Warcinfo, then a response and a request for the page and each of 5 assets.
+	require.Len(t, records, 1+2*6)
+	assert.Contains(t, records[0], "WARC-Type: warcinfo")
+	assert.Contains(t, records[1], "WARC-Type: response")
+	assert.Contains(t, records[1], "WARC-Target-URI: "+site.URL+"/post/1/")
+	assert.Contains(t, records[1], "HTTP/1.1 200 OK\r\n")
+	assert.Contains(t, records[1], testPage, "page is recorded as it has been downloaded")
+	assert.Contains(t, records[2], "WARC-Type: request")
+	assert.Contains(t, records[2], "GET /post/1/ HTTP/1.1\r\n")
+
+	cdx, err := os.ReadFile(strings.TrimSuffix(warcs[0], ".warc.gz") + ".cdx")
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimRight(string(cdx), "\n"), "\n")
+	require.Len(t, lines, 1+6)
+	assert.Equal(t, " CDX N b a m s k r M S V g", lines[0])
+
+	var pageLine []string
+	for _, line := range lines[1:] {
+		if fields := strings.Fields(line); fields[2] == site.URL+"/post/1/" {
+			pageLine = fields
+		}
+	}
+
+	require.Len(t, pageLine, 11)
+	assert.Equal(t, "text/html", pageLine[3])
+	assert.Equal(t, "200", pageLine[4])
+	assert.Equal(t, filepath.Base(warcs[0]), pageLine[10])
+
+	// CDX refers to a single gzip member of the page's response record.
+	length, err := strconv.ParseInt(pageLine[8], 10, 64)
+	require.NoError(t, err)
+	offset, err := strconv.ParseInt(pageLine[9], 10, 64)
+	require.NoError(t, err)
+
+	raw, err := os.ReadFile(warcs[0])
+	require.NoError(t, err)
+
+	gz, err := gzip.NewReader(bytes.NewReader(raw[offset : offset+length]))
+	require.NoError(t, err)
+	record, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	assert.Contains(t, string(record), "WARC-Type: response")
+	assert.Contains(t, string(record), testPage)
+}
+
+func TestConsumer_WARCRotation(t *testing.T) {
+	site := newTestSite(t)
+	dir := t.TempDir()
+	c := Use(dir, WithWARC(WARC{MaxSize: 1}))
+
+	require.NoError(t, c.On(context.Background(), data.Article{ID: "1", Title: "First", LinkURL: site.URL + "/post/1/"}))
+	require.NoError(t, c.On(context.Background(), data.Article{ID: "2", Title: "Second", LinkURL: site.URL + "/post/2/"}))
+	require.NoError(t, c.(io.Closer).Close())
+
+	warcs, err := filepath.Glob(filepath.Join(dir, WARCDirName, "*.warc.gz"))
+	require.NoError(t, err)
+	require.Len(t, warcs, 2)
+
+	for _, name := range warcs {
+		// Assets aren't recorded unless they are enabled.
+		assert.Len(t, readWARCRecords(t, name), 3)
+	}
+}
+
+func TestConsumer_WARCOpenFiles(t *testing.T) {
+	site := newTestSite(t)
+	dir := t.TempDir()
+	storageDir := t.TempDir()
+	c := Use(dir, WithWARC(WARC{}), WithStorage(Dir(storageDir)))
+
+	require.NoError(t, c.On(context.Background(), data.Article{ID: "1", Title: "First", LinkURL: site.URL + "/post/1/"}))
+
+	// A file that is being written by another process.
+	other := filepath.Join(dir, WARCDirName, "habrabot-20230101000000-00001.warc.gz.open")
+	require.NoError(t, os.WriteFile(other, []byte("other"), fileMode))
+
+	// An open file that has been abandoned by a crashed process.
+	abandoned := filepath.Join(dir, WARCDirName, "habrabot-20220101000000-00001.warc.gz.open")
+	require.NoError(t, os.WriteFile(abandoned, []byte("abandoned"), fileMode))
+	abandonedAt := time.Now().Add(-abandonedWARCAge - time.Hour)
+	require.NoError(t, os.Chtimes(abandoned, abandonedAt, abandonedAt))
+
+	open, err := filepath.Glob(filepath.Join(dir, WARCDirName, "*.warc.gz.open"))
+	require.NoError(t, err)
+	assert.Len(t, open, 3, "the current file is open until the consumer is closed")
+
+	require.NoError(t, c.(io.Closer).Close())
+
+	local, err := os.ReadDir(filepath.Join(dir, WARCDirName))
+	require.NoError(t, err)
+	if assert.Len(t, local, 1) {
+		assert.Equal(t, filepath.Base(other), local[0].Name())
+	}
+
+	moved, err := filepath.Glob(filepath.Join(storageDir, WARCDirName, "*.warc.gz"))
+	require.NoError(t, err)
+	assert.Len(t, moved, 2)
+}
+
+func TestSURT(t *testing.T) {
+	assert.Equal(t, "com,habr)/ru/post/704622/", surt("https://www.Habr.com/ru/post/704622/"))
+	assert.Equal(t, "com,habr)/?a=1&b=2", surt("https://habr.com?b=2&a=1"))
+	assert.Equal(t, "1,0,0,127:8080)/post/1/", surt("http://127.0.0.1:8080/post/1/"))
+}
+
+// readWARCRecords reads all records of a WARC file, checking that each record is a separate gzip member.
+func readWARCRecords(t *testing.T, name string) []string {
+	f, err := os.Open(name)
+	require.NoError(t, err)
+	defer func() {
+		_ = f.Close()
+	}()
+
+	r := bufio.NewReader(f)
+	gz, err := gzip.NewReader(r)
+	require.NoError(t, err)
+
+	var records []string
+	for {
+		gz.Multistream(false)
+
+		record, err := io.ReadAll(gz)
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(string(record), "WARC/1.0\r\n"))
+		records = append(records, string(record))
+
+		err = gz.Reset(r)
+		if err == io.EOF {
+			return records
+		}
+
+		require.NoError(t, err)
+	}
+}
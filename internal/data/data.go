@@ -2,9 +2,15 @@ package data
 
 import (
 	"context"
+	"errors"
 	"time"
 )
 
+// ErrUnavailable is returned by consumers whose destination doesn't accept articles anymore,
+// e.g. when the bot has been removed from a chat. Articles that haven't been delivered to such consumers
+// should be kept for later, rather than failing the whole pipeline.
+var ErrUnavailable = errors.New("destination is unavailable")
+
 // Article is a single item of a feed.
 type Article struct {
 	ID          string    // ID of article.
@@ -15,6 +21,7 @@ type Article struct {
 	ImageURL    *string   // A hyperlink to article's title image if available.
 	Author      string    // Article's author name.
 	Tags        []string  // List of article's tags.
+	Updated     bool      // Article has been processed before, and its content has changed since then.
 }
 
 // Feed reads article list from remote source.
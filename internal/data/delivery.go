@@ -0,0 +1,17 @@
+package data
+
+import "context"
+
+type silentDeliveryKey struct{}
+
+// WithSilentDelivery returns a context that asks consumers to deliver articles with or without notifying recipients.
+// Consumers that deliver articles queued earlier should scope it to each article, rather than pass it on as is.
+func WithSilentDelivery(ctx context.Context, silent bool) context.Context {
+	return context.WithValue(ctx, silentDeliveryKey{}, silent)
+}
+
+// IsSilentDelivery returns true if articles should be delivered without notifying recipients.
+func IsSilentDelivery(ctx context.Context) bool {
+	silent, _ := ctx.Value(silentDeliveryKey{}).(bool)
+	return silent
+}
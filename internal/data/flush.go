@@ -0,0 +1,19 @@
+package data
+
+import "context"
+
+// Flusher is implemented by consumers that buffer articles and deliver them later.
+type Flusher interface {
+	// Flush delivers buffered articles.
+	Flush(ctx context.Context) error
+}
+
+// Flush delivers articles buffered by the consumer, if it supports buffering.
+func Flush(ctx context.Context, consumer Consumer) error {
+	flusher, ok := consumer.(Flusher)
+	if !ok {
+		return nil
+	}
+
+	return flusher.Flush(ctx)
+}
@@ -0,0 +1,51 @@
+package data
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+)
+
+// ContentHash returns a hash of article's content, which changes whenever the article is edited.
+// Image URL isn't a part of the hash, since feeds lose and regain it from time to time, see IsUpdated.
+func ContentHash(article Article) string {
+	tags := append([]string(nil), article.Tags...)
+	sort.Strings(tags)
+
+	content := struct {
+		Title       string
+		Description string
+		LinkURL     string
+		Author      string
+		Tags        []string
+	}{
+		Title:       article.Title,
+		Description: article.Description,
+		LinkURL:     article.LinkURL,
+		Author:      article.Author,
+		Tags:        tags,
+	}
+
+	bytes, _ := json.Marshal(content)
+	hash := sha256.Sum256(bytes)
+	return hex.EncodeToString(hash[:])
+}
+
+// IsUpdated returns true if the content of an article has changed since its previous version.
+// An image counts as changed only if it's been replaced by another one, rather than lost or found.
+func IsUpdated(previous, current Article) bool {
+	if ContentHash(previous) != ContentHash(current) {
+		return true
+	}
+
+	return imageURL(previous) != "" && imageURL(current) != "" && imageURL(previous) != imageURL(current)
+}
+
+func imageURL(article Article) string {
+	if article.ImageURL == nil {
+		return ""
+	}
+
+	return *article.ImageURL
+}
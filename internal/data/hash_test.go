@@ -0,0 +1,47 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContentHash(t *testing.T) {
+	article := Article{
+		ID:    "1",
+		Title: "Title",
+		Tags:  []string{"go", "habr"},
+	}
+
+	reordered := article
+	reordered.Tags = []string{"habr", "go"}
+
+	edited := article
+	edited.Title = "Edited title"
+
+	assert.Equal(t, ContentHash(article), ContentHash(reordered))
+	assert.NotEqual(t, ContentHash(article), ContentHash(edited))
+	assert.Equal(t, []string{"go", "habr"}, article.Tags)
+}
+
+func TestIsUpdated(t *testing.T) {
+	image := "https://example.com/1.png"
+	other := "https://example.com/2.png"
+
+	article := Article{ID: "1", Title: "Title", ImageURL: &image}
+
+	withoutImage := article
+	withoutImage.ImageURL = nil
+
+	replacedImage := article
+	replacedImage.ImageURL = &other
+
+	edited := withoutImage
+	edited.Title = "Edited title"
+
+	assert.False(t, IsUpdated(article, article))
+	assert.False(t, IsUpdated(article, withoutImage))
+	assert.False(t, IsUpdated(withoutImage, article))
+	assert.True(t, IsUpdated(article, replacedImage))
+	assert.True(t, IsUpdated(article, edited))
+}
@@ -24,3 +24,15 @@ func (t tee) On(ctx context.Context, article Article) error {
 
 	return nil
 }
+
+// Flush delivers articles buffered by any of the consumers.
+func (t tee) Flush(ctx context.Context) error {
+	for _, consumer := range t.consumers {
+		err := Flush(ctx, consumer)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -1,6 +1,7 @@
 package data
 
 import (
+	"context"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -29,3 +30,25 @@ func TestTee(t *testing.T) {
 		assert.Equal(t, input[i], c3.Items()[i], "c3", i)
 	}
 }
+
+func TestTee_Flush(t *testing.T) {
+	c1 := &flushCounter{}
+	c2 := NewInMemoryConsumer()
+	c3 := &flushCounter{}
+
+	err := Flush(context.Background(), Tee(c1, c2, c3))
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, c1.flushes)
+	assert.Equal(t, 1, c3.flushes)
+}
+
+type flushCounter struct {
+	InMemoryConsumer
+	flushes int
+}
+
+func (c *flushCounter) Flush(_ context.Context) error {
+	c.flushes++
+	return nil
+}
@@ -0,0 +1,157 @@
+package db
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/kapitanov/habrabot/internal/data"
+)
+
+// Archive provides access to articles that have been processed already.
+type Archive struct {
+	dbPath string
+}
+
+// NewArchive creates an accessor to processed articles.
+func NewArchive(dbPath string) *Archive {
+	return &Archive{dbPath: dbPath}
+}
+
+// Get loads a processed article by its ID. It returns false if the article hasn't been processed.
+func (a *Archive) Get(id string) (data.Article, bool, error) {
+	var (
+		article data.Article
+		found   bool
+	)
+
+	err := executeViewTX(a.dbPath, func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketName)
+		if bucket == nil {
+			return nil
+		}
+
+		var err error
+		article, found, err = loadProcessed(bucket, []byte(strings.ToLower(id)))
+		return err
+	})
+	if err != nil {
+		return data.Article{}, false, err
+	}
+
+	return article, found, nil
+}
+
+// Latest returns up to n most recent processed articles, newest first.
+func (a *Archive) Latest(n int) ([]data.Article, error) {
+	articles, err := a.all()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(articles, func(i, j int) bool {
+		return articles[i].Time.After(articles[j].Time)
+	})
+
+	if len(articles) > n {
+		articles = articles[:n]
+	}
+
+	return articles, nil
+}
+
+// Range returns processed articles that have been published within [from, to), oldest first.
+func (a *Archive) Range(from, to time.Time) ([]data.Article, error) {
+	all, err := a.all()
+	if err != nil {
+		return nil, err
+	}
+
+	var articles []data.Article
+	for _, article := range all {
+		if !article.Time.Before(from) && article.Time.Before(to) {
+			articles = append(articles, article)
+		}
+	}
+
+	sort.SliceStable(articles, func(i, j int) bool {
+		return articles[i].Time.Before(articles[j].Time)
+	})
+
+	return articles, nil
+}
+
+// All returns all processed articles, oldest first.
+func (a *Archive) All() ([]data.Article, error) {
+	articles, err := a.all()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(articles, func(i, j int) bool {
+		return articles[i].Time.Before(articles[j].Time)
+	})
+
+	return articles, nil
+}
+
+func (a *Archive) all() ([]data.Article, error) {
+	var articles []data.Article
+
+	err := executeViewTX(a.dbPath, func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketName)
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(_, value []byte) error {
+			var article data.Article
+			err := json.Unmarshal(value, &article)
+			if err != nil {
+				return err
+			}
+
+			articles = append(articles, article)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return articles, nil
+}
+
+// Count returns a number of processed articles.
+func (a *Archive) Count() (int, error) {
+	count := 0
+
+	err := executeViewTX(a.dbPath, func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketName)
+		if bucket != nil {
+			count = bucket.Stats().KeyN
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// Forget removes an article from processed ones, so it passes through the filter again.
+func (a *Archive) Forget(id string) error {
+	return executeTX(a.dbPath, func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketName)
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.Delete([]byte(strings.ToLower(id)))
+	})
+}
@@ -0,0 +1,52 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchive(t *testing.T) {
+	dbPath := createTempDBFile(t)
+
+	input := NewArticles("1", "2", "3")
+	for i := range input {
+		input[i].Time = time.Date(2023, 1, i+1, 0, 0, 0, 0, time.UTC)
+	}
+
+	Execute(t, Use(NewInMemoryFeed(input), dbPath))
+
+	archive := NewArchive(dbPath)
+
+	count, err := archive.Count()
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	article, found, err := archive.Get("2")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, input[1], article)
+
+	latest, err := archive.Latest(2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"3", "2"}, []string{latest[0].ID, latest[1].ID})
+
+	articles, err := archive.Range(input[1].Time, input[2].Time.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"2", "3"}, []string{articles[0].ID, articles[1].ID})
+
+	articles, err = archive.All()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1", "2", "3"}, []string{articles[0].ID, articles[1].ID, articles[2].ID})
+
+	require.NoError(t, archive.Forget("2"))
+
+	_, found, err = archive.Get("2")
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	output := Execute(t, Use(NewInMemoryFeed(input), dbPath))
+	assert.Equal(t, []string{"2"}, []string{output[0].ID})
+}
@@ -15,41 +15,86 @@ import (
 
 var bucketName = []byte("articles")
 
-func Use(feed data.Feed, dbPath string) data.Feed {
+func Use(feed data.Feed, dbPath string, options ...Option) data.Feed {
 	log.Info().Str("path", dbPath).Msg("using boltdb db")
 
 	storage := &boltDBStorage{
 		dbPath: dbPath,
 	}
 
+	for _, option := range options {
+		option(storage)
+	}
+
 	return data.Wrap(feed, storage)
 }
 
+// Option configures BoltDB filter.
+type Option func(s *boltDBStorage)
+
+// TrackUpdates makes processed articles pass through the filter again once their content changes.
+func TrackUpdates() Option {
+	return func(s *boltDBStorage) {
+		s.trackUpdates = true
+	}
+}
+
 type boltDBStorage struct {
-	dbPath string
+	dbPath       string
+	trackUpdates bool
 }
 
 // Do method executes an action over a stream item.
-func (s *boltDBStorage) Do(_m context.Context, article data.Article, next data.NextFunc) error {
+// Transaction isn't held while the article is being processed,
+// so downstream consumers are free to use the database too.
+func (s *boltDBStorage) Do(_ context.Context, article data.Article, next data.NextFunc) error {
+	key := []byte(strings.ToLower(article.ID))
+
+	var (
+		stored    data.Article
+		processed bool
+	)
+	err := executeViewTX(s.dbPath, func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketName)
+		if bucket == nil {
+			return nil
+		}
+
+		var e error
+		stored, processed, e = loadProcessed(bucket, key)
+		return e
+	})
+	if err != nil {
+		return err
+	}
+
+	if processed {
+		if !s.trackUpdates || !data.IsUpdated(stored, article) {
+			return nil
+		}
+
+		log.Info().Str("id", article.ID).Msg("feed item has been updated")
+	}
+
+	updated := article
+	updated.Updated = processed
+
+	err = next(updated)
+	if err != nil {
+		log.Error().Err(err).Str("id", article.ID).Msg("unable to process feed item")
+		return err
+	}
+
 	return executeTX(s.dbPath, func(tx *bolt.Tx) error {
-		bucket, e := ensureBucket(tx)
+		bucket, e := ensureBucket(tx, bucketName)
 		if e != nil {
 			return e
 		}
 
-		key := []byte(strings.ToLower(article.ID))
-		if !hasBeenProcessed(bucket, key) {
-			e = next(article)
-			if e != nil {
-				log.Error().Err(e).Str("id", article.ID).Msg("unable to process feed item")
-				return e
-			}
-
-			e = markAsProcessed(bucket, key, article)
-			if e != nil {
-				log.Error().Err(e).Str("id", article.ID).Msg("unable to mark feed item as processed")
-				return e
-			}
+		e = markAsProcessed(bucket, key, article)
+		if e != nil {
+			log.Error().Err(e).Str("id", article.ID).Msg("unable to mark feed item as processed")
+			return e
 		}
 
 		return nil
@@ -77,6 +122,20 @@ func executeTX(dbPath string, fn func(tx *bolt.Tx) error) error {
 	return nil
 }
 
+func executeViewTX(dbPath string, fn func(tx *bolt.Tx) error) error {
+	db, err := openDB(dbPath)
+	if err != nil {
+		log.Error().Err(err).Str("path", dbPath).Msg("unable to open db file")
+		return err
+	}
+
+	defer func() {
+		_ = db.Close()
+	}()
+
+	return db.View(fn)
+}
+
 func openDB(dbPath string) (*bolt.DB, error) {
 	dbPath, err := filepath.Abs(dbPath)
 	if err != nil {
@@ -96,13 +155,13 @@ func openDB(dbPath string) (*bolt.DB, error) {
 	return db, nil
 }
 
-func ensureBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
-	bucket := tx.Bucket(bucketName)
+func ensureBucket(tx *bolt.Tx, name []byte) (*bolt.Bucket, error) {
+	bucket := tx.Bucket(name)
 	if bucket == nil {
 		var err error
-		bucket, err = tx.CreateBucket(bucketName)
+		bucket, err = tx.CreateBucket(name)
 		if err != nil {
-			log.Error().Err(err).Str("bucket", string(bucketName)).Msg("unable to create bucket")
+			log.Error().Err(err).Str("bucket", string(name)).Msg("unable to create bucket")
 			return nil, err
 		}
 	}
@@ -110,8 +169,19 @@ func ensureBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
 	return bucket, nil
 }
 
-func hasBeenProcessed(bucket *bolt.Bucket, key []byte) bool {
-	return bucket.Get(key) != nil
+func loadProcessed(bucket *bolt.Bucket, key []byte) (data.Article, bool, error) {
+	value := bucket.Get(key)
+	if value == nil {
+		return data.Article{}, false, nil
+	}
+
+	var article data.Article
+	err := json.Unmarshal(value, &article)
+	if err != nil {
+		return data.Article{}, false, err
+	}
+
+	return article, true, nil
 }
 
 func markAsProcessed(bucket *bolt.Bucket, key []byte, article data.Article) error {
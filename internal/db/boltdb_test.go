@@ -56,3 +56,37 @@ func TestUseBoltDB_Block(t *testing.T) {
 		assert.Equal(t, input[i], output[i])
 	}
 }
+
+func TestUseBoltDB_TrackUpdates(t *testing.T) {
+	dbPath := createTempDBFile(t)
+
+	input := NewArticles("1", "2")
+	output := Execute(t, Use(NewInMemoryFeed(input), dbPath, TrackUpdates()))
+	assert.Len(t, output, len(input))
+
+	updated := NewArticles("1", "2")
+	updated[1].Title = "Updated title"
+
+	output = Execute(t, Use(NewInMemoryFeed(updated), dbPath, TrackUpdates()))
+	if assert.Len(t, output, 1) {
+		expected := updated[1]
+		expected.Updated = true
+		assert.Equal(t, expected, output[0])
+	}
+
+	output = Execute(t, Use(NewInMemoryFeed(updated), dbPath, TrackUpdates()))
+	assert.Empty(t, output)
+}
+
+func TestUseBoltDB_IgnoreUpdates(t *testing.T) {
+	dbPath := createTempDBFile(t)
+
+	input := NewArticles("1")
+	Execute(t, Use(NewInMemoryFeed(input), dbPath))
+
+	updated := NewArticles("1")
+	updated[0].Title = "Updated title"
+
+	output := Execute(t, Use(NewInMemoryFeed(updated), dbPath))
+	assert.Empty(t, output)
+}
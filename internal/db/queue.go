@@ -0,0 +1,249 @@
+package db
+
+import (
+	"encoding/binary"
+	"encoding/json"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/kapitanov/habrabot/internal/data"
+)
+
+// Queue is a persistent FIFO queue of articles stored in a BoltDB bucket.
+// Each queued article may have a state of its delivery, e.g. a number of failed attempts.
+type Queue struct {
+	dbPath string
+	bucket []byte
+}
+
+// queueEntry is a queued article along with the state of its delivery.
+type queueEntry struct {
+	Article data.Article    `json:"article"`
+	State   json.RawMessage `json:"state,omitempty"`
+}
+
+// NewQueue creates a persistent queue stored in a bucket with the specified name.
+func NewQueue(dbPath, name string) *Queue {
+	return &Queue{
+		dbPath: dbPath,
+		bucket: []byte(name),
+	}
+}
+
+// Push appends an article to the end of the queue.
+// Articles that are queued already are ignored.
+func (q *Queue) Push(article data.Article) error {
+	return q.push(queueEntry{Article: article})
+}
+
+// PushWithState appends an article to the end of the queue along with a state of its delivery.
+// Articles that are queued already are ignored, they keep their state.
+func (q *Queue) PushWithState(article data.Article, value interface{}) error {
+	state, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return q.push(queueEntry{Article: article, State: state})
+}
+
+func (q *Queue) push(entry queueEntry) error {
+	article := entry.Article
+
+	return executeTX(q.dbPath, func(tx *bolt.Tx) error {
+		bucket, err := ensureBucket(tx, q.bucket)
+		if err != nil {
+			return err
+		}
+
+		key, _, err := findQueuedArticle(bucket, article.ID)
+		if err != nil {
+			return err
+		}
+		if key != nil {
+			return nil
+		}
+
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		value, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put(encodeSequence(seq), value)
+	})
+}
+
+// Peek returns the first article of the queue without removing it.
+// It returns false if the queue is empty.
+func (q *Queue) Peek() (data.Article, bool, error) {
+	var entry queueEntry
+	found := false
+
+	err := executeViewTX(q.dbPath, func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(q.bucket)
+		if bucket == nil {
+			return nil
+		}
+
+		_, value := bucket.Cursor().First()
+		if value == nil {
+			return nil
+		}
+
+		found = true
+		return json.Unmarshal(value, &entry)
+	})
+	if err != nil {
+		return data.Article{}, false, err
+	}
+
+	return entry.Article, found, nil
+}
+
+// Items returns all queued articles in their order.
+func (q *Queue) Items() ([]data.Article, error) {
+	var articles []data.Article
+
+	err := executeViewTX(q.dbPath, func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(q.bucket)
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(_, value []byte) error {
+			var entry queueEntry
+			err := json.Unmarshal(value, &entry)
+			if err != nil {
+				return err
+			}
+
+			articles = append(articles, entry.Article)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return articles, nil
+}
+
+// Len returns a number of queued articles.
+func (q *Queue) Len() (int, error) {
+	count := 0
+
+	err := executeViewTX(q.dbPath, func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(q.bucket)
+		if bucket != nil {
+			count = bucket.Stats().KeyN
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// State loads a state of a queued article's delivery.
+// It returns false if the article isn't queued, or if it has no state.
+func (q *Queue) State(id string, value interface{}) (bool, error) {
+	found := false
+
+	err := executeViewTX(q.dbPath, func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(q.bucket)
+		if bucket == nil {
+			return nil
+		}
+
+		_, entry, err := findQueuedArticle(bucket, id)
+		if err != nil || entry.State == nil {
+			return err
+		}
+
+		found = true
+		return json.Unmarshal(entry.State, value)
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return found, nil
+}
+
+// SetState stores a state of a queued article's delivery, the article keeps its place in the queue.
+// It does nothing if the article isn't queued.
+func (q *Queue) SetState(id string, value interface{}) error {
+	state, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return executeTX(q.dbPath, func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(q.bucket)
+		if bucket == nil {
+			return nil
+		}
+
+		key, entry, err := findQueuedArticle(bucket, id)
+		if err != nil || key == nil {
+			return err
+		}
+
+		entry.State = state
+		raw, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put(key, raw)
+	})
+}
+
+// Remove removes an article from the queue.
+func (q *Queue) Remove(id string) error {
+	return executeTX(q.dbPath, func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(q.bucket)
+		if bucket == nil {
+			return nil
+		}
+
+		key, _, err := findQueuedArticle(bucket, id)
+		if err != nil || key == nil {
+			return err
+		}
+
+		return bucket.Delete(key)
+	})
+}
+
+// findQueuedArticle returns a key and an entry of a queued article, the key is nil if the article isn't queued.
+func findQueuedArticle(bucket *bolt.Bucket, id string) ([]byte, queueEntry, error) {
+	c := bucket.Cursor()
+	for key, value := c.First(); key != nil; key, value = c.Next() {
+		var entry queueEntry
+		err := json.Unmarshal(value, &entry)
+		if err != nil {
+			return nil, queueEntry{}, err
+		}
+
+		if entry.Article.ID == id {
+			return key, entry, nil
+		}
+	}
+
+	return nil, queueEntry{}, nil
+}
+
+func encodeSequence(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
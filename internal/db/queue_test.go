@@ -0,0 +1,87 @@
+package db
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueue(t *testing.T) {
+	dbPath := createTempDBFile(t)
+
+	queue := NewQueue(dbPath, "queue")
+
+	_, found, err := queue.Peek()
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	for _, article := range NewArticles("1", "2", "1", "3") {
+		require.NoError(t, queue.Push(article))
+	}
+
+	items, err := queue.Items()
+	require.NoError(t, err)
+	assert.Equal(t, NewArticles("1", "2", "3"), items)
+
+	count, err := queue.Len()
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	require.NoError(t, queue.Remove("2"))
+
+	article, found, err := queue.Peek()
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "1", article.ID)
+
+	// Delivery state is kept with a queued article, until it's removed.
+	type state struct{ Attempts int }
+	var st state
+	found, err = queue.State("1", &st)
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	require.NoError(t, queue.SetState("1", state{Attempts: 2}))
+	found, err = NewQueue(dbPath, "queue").State("1", &st)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, state{Attempts: 2}, st)
+
+	items, err = queue.Items()
+	require.NoError(t, err)
+	assert.Equal(t, NewArticles("1", "3"), items, "articles keep their places in the queue")
+
+	require.NoError(t, queue.Remove("1"))
+
+	found, err = queue.State("1", &st)
+	require.NoError(t, err)
+	assert.False(t, found)
+	require.NoError(t, queue.SetState("1", state{Attempts: 3}), "state of articles that aren't queued is ignored")
+
+	items, err = NewQueue(dbPath, "queue").Items()
+	require.NoError(t, err)
+	assert.Equal(t, NewArticles("3"), items)
+
+	require.NoError(t, queue.PushWithState(NewArticles("4")[0], state{Attempts: 1}))
+	require.NoError(t, queue.PushWithState(NewArticles("4")[0], state{Attempts: 5}))
+	found, err = queue.State("4", &st)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, state{Attempts: 1}, st, "queued articles keep their state")
+}
+
+func createTempDBFile(t *testing.T) string {
+	f, err := os.CreateTemp(os.TempDir(), "*")
+	require.NoError(t, err)
+	dbPath := f.Name()
+	t.Logf("data file: %v", dbPath)
+	require.NoError(t, f.Close())
+
+	t.Cleanup(func() {
+		_ = os.Remove(dbPath)
+	})
+
+	return dbPath
+}
@@ -0,0 +1,108 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Store is a persistent key-value storage with JSON-encoded values kept in a BoltDB bucket.
+type Store struct {
+	dbPath string
+	bucket []byte
+}
+
+// NewStore creates a key-value storage kept in a bucket with the specified name.
+func NewStore(dbPath, name string) *Store {
+	return &Store{
+		dbPath: dbPath,
+		bucket: []byte(name),
+	}
+}
+
+// Get loads a value by its key. It returns false if the key doesn't exist.
+func (s *Store) Get(key string, value interface{}) (bool, error) {
+	found := false
+
+	err := executeViewTX(s.dbPath, func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(s.bucket)
+		if bucket == nil {
+			return nil
+		}
+
+		raw := bucket.Get([]byte(key))
+		if raw == nil {
+			return nil
+		}
+
+		found = true
+		return json.Unmarshal(raw, value)
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return found, nil
+}
+
+// Put stores a value by its key.
+func (s *Store) Put(key string, value interface{}) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return executeTX(s.dbPath, func(tx *bolt.Tx) error {
+		bucket, err := ensureBucket(tx, s.bucket)
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put([]byte(key), raw)
+	})
+}
+
+// Delete removes a value by its key.
+func (s *Store) Delete(key string) error {
+	return executeTX(s.dbPath, func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(s.bucket)
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.Delete([]byte(key))
+	})
+}
+
+// ForEach iterates over values with keys starting with the specified prefix.
+// Callback must not use the database since it's invoked within a transaction.
+func (s *Store) ForEach(prefix string, fn func(key string, value []byte) error) error {
+	return executeViewTX(s.dbPath, func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(s.bucket)
+		if bucket == nil {
+			return nil
+		}
+
+		c := bucket.Cursor()
+		for key, value := c.Seek([]byte(prefix)); key != nil && bytes.HasPrefix(key, []byte(prefix)); key, value = c.Next() {
+			err := fn(string(key), value)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Clear removes all values.
+func (s *Store) Clear() error {
+	return executeTX(s.dbPath, func(tx *bolt.Tx) error {
+		if tx.Bucket(s.bucket) == nil {
+			return nil
+		}
+
+		return tx.DeleteBucket(s.bucket)
+	})
+}
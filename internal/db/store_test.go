@@ -0,0 +1,60 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	type record struct {
+		Name  string
+		Count int
+	}
+
+	store := NewStore(createTempDBFile(t), "records")
+
+	var value record
+	found, err := store.Get("key", &value)
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	require.NoError(t, store.Put("key", record{Name: "name", Count: 42}))
+
+	found, err = store.Get("key", &value)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, record{Name: "name", Count: 42}, value)
+
+	require.NoError(t, store.Delete("key"))
+
+	found, err = store.Get("key", &value)
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	require.NoError(t, store.Put("key", record{Name: "name"}))
+	require.NoError(t, store.Clear())
+	require.NoError(t, store.Clear(), "missing bucket is cleared silently")
+
+	found, err = store.Get("key", &value)
+	require.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestStore_ForEach(t *testing.T) {
+	store := NewStore(createTempDBFile(t), "records")
+
+	require.NoError(t, store.Put("a/1", 1))
+	require.NoError(t, store.Put("a/2", 2))
+	require.NoError(t, store.Put("b/1", 3))
+
+	values := make(map[string]string)
+	err := store.ForEach("a/", func(key string, value []byte) error {
+		values[key] = string(value)
+		return nil
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"a/1": "1", "a/2": "2"}, values)
+}
@@ -0,0 +1,342 @@
+package epub
+
+import (
+	"archive/zip"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// MediaType is a media type of EPUB files.
+const MediaType = "application/epub+zip"
+
+// Book is an EPUB 3 book that consists of XHTML chapters and images they refer to.
+type Book struct {
+	Identifier string    // Unique identifier of the book, derived from its content if it's empty.
+	Title      string    // Title of the book.
+	Language   string    // Language tag of the book, e.g. "ru", "und" is used if it's empty.
+	Authors    []string  // Authors of the book.
+	Subjects   []string  // Subjects of the book, e.g. tags of articles.
+	Source     string    // URL of the original of the book, if any.
+	Date       time.Time // Publication date of the book.
+
+	chapters []Chapter
+	images   []image
+	names    map[string]string // Maps hashes of images to their file names.
+}
+
+// Chapter is a chapter of a book.
+type Chapter struct {
+	Title    string // Title of the chapter, as shown in the table of contents.
+	Language string // Language tag of the chapter, language of the book is used if it's empty.
+	Body     string // Content of the chapter as XHTML markup of its body.
+}
+
+type image struct {
+	Name      string
+	MediaType string
+	Data      []byte
+}
+
+// Media types of images that EPUB readers are required to support.
+var imageMediaTypes = map[string]string{
+	"image/gif":     ".gif",
+	"image/jpeg":    ".jpg",
+	"image/png":     ".png",
+	"image/svg+xml": ".svg",
+	"image/webp":    ".webp",
+}
+
+// IsImageMediaType returns true if EPUB readers are able to show images of a media type.
+func IsImageMediaType(mediaType string) bool {
+	_, ok := imageMediaTypes[mediaType]
+	return ok
+}
+
+// AddChapter appends a chapter to the book.
+func (b *Book) AddChapter(chapter Chapter) {
+	b.chapters = append(b.chapters, chapter)
+}
+
+// AddImage stores an image in the book and returns a reference to it from chapters.
+// Identical images are stored once. It returns false if the image has unsupported media type.
+func (b *Book) AddImage(data []byte, mediaType string) (string, bool) {
+	ext, ok := imageMediaTypes[mediaType]
+	if !ok {
+		return "", false
+	}
+
+	hash := sha256.Sum256(data)
+	key := hex.EncodeToString(hash[:])
+	if name, ok := b.names[key]; ok {
+		return name, true
+	}
+
+	if b.names == nil {
+		b.names = make(map[string]string)
+	}
+
+	name := "images/" + key[:16] + ext
+	b.names[key] = name
+	b.images = append(b.images, image{Name: name, MediaType: mediaType, Data: data})
+	return name, true
+}
+
+// Len returns a number of chapters of the book.
+func (b *Book) Len() int {
+	return len(b.chapters)
+}
+
+// bookView is a book as it's seen by templates.
+type bookView struct {
+	Book
+	Modified time.Time
+	Chapters []Chapter
+	Images   []image
+}
+
+// Write writes the book as an EPUB file.
+func (b *Book) Write(w io.Writer) error {
+	book := bookView{Book: *b, Modified: b.Date.UTC(), Chapters: b.chapters, Images: b.images}
+	if book.Language == "" {
+		book.Language = "und"
+	}
+
+	if book.Identifier == "" {
+		book.Identifier = b.contentIdentifier()
+	}
+
+	if book.Modified.IsZero() {
+		book.Modified = time.Now().UTC()
+	}
+
+	z := zip.NewWriter(w)
+
+	// Readers recognize EPUB files by an uncompressed "mimetype" file that comes first.
+	err := writeFile(z, "mimetype", zip.Store, book.Modified, func(w io.Writer) error {
+		_, err := io.WriteString(w, MediaType)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	files := []struct {
+		name     string
+		template *template.Template
+		data     interface{}
+	}{
+		{"META-INF/container.xml", containerTemplate, nil},
+		{"OEBPS/content.opf", packageTemplate, book},
+		{"OEBPS/nav.xhtml", navTemplate, book},
+		{"OEBPS/toc.ncx", ncxTemplate, book},
+	}
+
+	for i, chapter := range book.Chapters {
+		if chapter.Language == "" {
+			chapter.Language = book.Language
+		}
+
+		files = append(files, struct {
+			name     string
+			template *template.Template
+			data     interface{}
+		}{"OEBPS/" + chapterName(i), chapterTemplate, chapter})
+	}
+
+	for _, file := range files {
+		file := file
+		err = writeFile(z, file.name, zip.Deflate, book.Modified, func(w io.Writer) error {
+			return file.template.Execute(w, file.data)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	resources := append([]image{{Name: "style.css", Data: []byte(stylesheet)}}, book.Images...)
+	for _, resource := range resources {
+		resource := resource
+		err = writeFile(z, "OEBPS/"+resource.Name, zip.Deflate, book.Modified, func(w io.Writer) error {
+			_, err := w.Write(resource.Data)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return z.Close()
+}
+
+func writeFile(z *zip.Writer, name string, method uint16, modified time.Time, fn func(w io.Writer) error) error {
+	w, err := z.CreateHeader(&zip.FileHeader{Name: name, Method: method, Modified: modified})
+	if err != nil {
+		return err
+	}
+
+	return fn(w)
+}
+
+// contentIdentifier returns a name-based UUID of the book, so the same book gets the same identifier.
+func (b *Book) contentIdentifier() string {
+	h := sha1.New()
+	_, _ = io.WriteString(h, b.Title)
+	for _, chapter := range b.chapters {
+		_, _ = io.WriteString(h, "\x00"+chapter.Title)
+	}
+
+	sum := h.Sum(nil)
+	sum[6] = (sum[6] & 0x0f) | 0x50
+	sum[8] = (sum[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+func chapterName(i int) string {
+	return fmt.Sprintf("chapter-%03d.xhtml", i+1)
+}
+
+// Escape escapes special characters of a text, so it may be included into XHTML markup.
+// Characters that XML doesn't allow are removed.
+func Escape(text string) string {
+	text = strings.Map(func(r rune) rune {
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' || r == 0xfffe || r == 0xffff {
+			return -1
+		}
+
+		return r
+	}, text)
+
+	return html.EscapeString(text)
+}
+
+const stylesheet = `body { font-family: serif; line-height: 1.4; }
+h1 { font-size: 1.6em; }
+pre { white-space: pre-wrap; font-size: 0.85em; }
+code { font-family: monospace; }
+img { max-width: 100%; }
+blockquote { margin-left: 1em; padding-left: 0.5em; border-left: 2px solid #999; }
+table { border-collapse: collapse; }
+td, th { border: 1px solid #999; padding: 0.2em 0.4em; }
+.byline { color: #666; font-size: 0.9em; }
+`
+
+var funcs = template.FuncMap{
+	"xml":     Escape,
+	"chapter": chapterName,
+	"date": func(t time.Time) string {
+		return t.UTC().Format("2006-01-02T15:04:05Z")
+	},
+	"id": func(name string) string {
+		return strings.NewReplacer("/", "-", ".", "-").Replace(name)
+	},
+	"inc": func(i int) int {
+		return i + 1
+	},
+}
+
+var containerTemplate = template.Must(template.New("container").Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`))
+
+var packageTemplate = template.Must(template.New("package").Funcs(funcs).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="book-id" xml:lang="{{xml .Language}}">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="book-id">{{xml .Identifier}}</dc:identifier>
+    <dc:title>{{xml .Title}}</dc:title>
+    <dc:language>{{xml .Language}}</dc:language>
+{{- range .Authors}}
+    <dc:creator>{{xml .}}</dc:creator>
+{{- end}}
+{{- range .Subjects}}
+    <dc:subject>{{xml .}}</dc:subject>
+{{- end}}
+{{- if .Source}}
+    <dc:source>{{xml .Source}}</dc:source>
+{{- end}}
+{{- if not .Date.IsZero}}
+    <dc:date>{{date .Date}}</dc:date>
+{{- end}}
+    <meta property="dcterms:modified">{{date .Modified}}</meta>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/>
+    <item id="style" href="style.css" media-type="text/css"/>
+{{- range $i, $chapter := .Chapters}}
+    <item id="{{id (chapter $i)}}" href="{{chapter $i}}" media-type="application/xhtml+xml"/>
+{{- end}}
+{{- range .Images}}
+    <item id="{{id .Name}}" href="{{xml .Name}}" media-type="{{.MediaType}}"/>
+{{- end}}
+  </manifest>
+  <spine toc="ncx">
+{{- range $i, $chapter := .Chapters}}
+    <itemref idref="{{id (chapter $i)}}"/>
+{{- end}}
+  </spine>
+</package>
+`))
+
+var navTemplate = template.Must(template.New("nav").Funcs(funcs).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" xml:lang="{{xml .Language}}" lang="{{xml .Language}}">
+<head>
+  <meta charset="UTF-8"/>
+  <title>{{xml .Title}}</title>
+</head>
+<body>
+  <nav epub:type="toc" id="toc">
+    <h1>{{xml .Title}}</h1>
+    <ol>
+{{- range $i, $chapter := .Chapters}}
+      <li><a href="{{chapter $i}}">{{xml $chapter.Title}}</a></li>
+{{- end}}
+    </ol>
+  </nav>
+</body>
+</html>
+`))
+
+// NCX table of contents is kept for EPUB 2 readers.
+var ncxTemplate = template.Must(template.New("ncx").Funcs(funcs).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1" xml:lang="{{xml .Language}}">
+  <head>
+    <meta name="dtb:uid" content="{{xml .Identifier}}"/>
+  </head>
+  <docTitle><text>{{xml .Title}}</text></docTitle>
+  <navMap>
+{{- range $i, $chapter := .Chapters}}
+    <navPoint id="nav-{{inc $i}}" playOrder="{{inc $i}}">
+      <navLabel><text>{{xml $chapter.Title}}</text></navLabel>
+      <content src="{{chapter $i}}"/>
+    </navPoint>
+{{- end}}
+  </navMap>
+</ncx>
+`))
+
+var chapterTemplate = template.Must(template.New("chapter").Funcs(funcs).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" xml:lang="{{xml .Language}}" lang="{{xml .Language}}">
+<head>
+  <meta charset="UTF-8"/>
+  <title>{{xml .Title}}</title>
+  <link rel="stylesheet" type="text/css" href="style.css"/>
+</head>
+<body>
+{{.Body}}
+</body>
+</html>
+`))
@@ -0,0 +1,107 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBook_Write(t *testing.T) {
+	book := &Book{
+		Title:    "Go & Python",
+		Language: "ru",
+		Authors:  []string{"alice", "bob"},
+		Subjects: []string{"go"},
+		Date:     time.Date(2022, 10, 18, 12, 0, 0, 0, time.UTC),
+	}
+
+	image, ok := book.AddImage([]byte("PNG"), "image/png")
+	require.True(t, ok)
+
+	same, ok := book.AddImage([]byte("PNG"), "image/png")
+	require.True(t, ok)
+	assert.Equal(t, image, same, "identical images are stored once")
+
+	_, ok = book.AddImage([]byte("BMP"), "image/bmp")
+	assert.False(t, ok)
+
+	book.AddChapter(Chapter{Title: "First <article>", Body: `<p>Text</p><img src="` + image + `" alt=""/>`})
+	book.AddChapter(Chapter{Title: "Second", Language: "en", Body: "<p>Text</p>"})
+
+	var buf bytes.Buffer
+	require.NoError(t, book.Write(&buf))
+
+	r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+
+	names := make([]string, 0, len(r.File))
+	files := make(map[string]string)
+	for _, f := range r.File {
+		names = append(names, f.Name)
+
+		rc, err := f.Open()
+		require.NoError(t, err)
+		body, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		files[f.Name] = string(body)
+	}
+
+	assert.Equal(t, "mimetype", r.File[0].Name)
+	assert.Equal(t, zip.Store, r.File[0].Method)
+	assert.Equal(t, MediaType, files["mimetype"])
+	assert.ElementsMatch(t, []string{
+		"mimetype",
+		"META-INF/container.xml",
+		"OEBPS/content.opf",
+		"OEBPS/nav.xhtml",
+		"OEBPS/toc.ncx",
+		"OEBPS/chapter-001.xhtml",
+		"OEBPS/chapter-002.xhtml",
+		"OEBPS/style.css",
+		"OEBPS/" + image,
+	}, names)
+
+	// All XML documents must be well-formed, or readers refuse to open the book.
+	for name, body := range files {
+		if name == "mimetype" || name == "OEBPS/style.css" || name == "OEBPS/"+image {
+			continue
+		}
+
+		d := xml.NewDecoder(bytes.NewReader([]byte(body)))
+		d.Strict = true
+		d.Entity = xml.HTMLEntity
+		for {
+			_, err := d.Token()
+			if err == io.EOF {
+				break
+			}
+
+			require.NoError(t, err, name)
+		}
+	}
+
+	opf := files["OEBPS/content.opf"]
+	assert.Contains(t, opf, "<dc:title>Go &amp; Python</dc:title>")
+	assert.Contains(t, opf, "<dc:creator>alice</dc:creator>")
+	assert.Contains(t, opf, "<dc:subject>go</dc:subject>")
+	assert.Contains(t, opf, "<dc:date>2022-10-18T12:00:00Z</dc:date>")
+	assert.Contains(t, opf, `<dc:identifier id="book-id">urn:uuid:`)
+	assert.Contains(t, opf, `href="`+image+`" media-type="image/png"`)
+	assert.Contains(t, opf, `<itemref idref="chapter-002-xhtml"/>`)
+
+	assert.Contains(t, files["OEBPS/nav.xhtml"], `<a href="chapter-001.xhtml">First &lt;article&gt;</a>`)
+	assert.Contains(t, files["OEBPS/toc.ncx"], `<content src="chapter-002.xhtml"/>`)
+	assert.Contains(t, files["OEBPS/chapter-001.xhtml"], `xml:lang="ru"`)
+	assert.Contains(t, files["OEBPS/chapter-002.xhtml"], `xml:lang="en"`)
+
+	// The same book gets the same identifier.
+	var again bytes.Buffer
+	require.NoError(t, book.Write(&again))
+	assert.Equal(t, buf.Bytes(), again.Bytes())
+}
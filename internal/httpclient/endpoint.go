@@ -0,0 +1,53 @@
+package httpclient
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// telegramAPIHost is the host that Bot API library sends all requests to, including file uploads and downloads.
+const telegramAPIHost = "api.telegram.org"
+
+// NewEndpointTransport creates a transport that redirects Bot API requests to another Bot API server,
+// e.g. a self-hosted "telegram-bot-api" server or a fake server in tests.
+// Path of the endpoint is prepended to paths of requests, so "/bot<token>/sendPhoto" is sent to "<endpoint>/bot<token>/sendPhoto"
+// and "/file/bot<token>/<path>" is sent to "<endpoint>/file/bot<token>/<path>".
+// Requests to other hosts are passed to the next transport as is.
+func NewEndpointTransport(endpoint string, next http.RoundTripper) (http.RoundTripper, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("malformed bot api endpoint \"%s\", expected an absolute http(s) url", endpoint)
+	}
+
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	return &endpointTransport{endpoint: u, next: next}, nil
+}
+
+type endpointTransport struct {
+	endpoint *url.URL
+	next     http.RoundTripper
+}
+
+func (t *endpointTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != telegramAPIHost {
+		return t.next.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	req.URL.Scheme = t.endpoint.Scheme
+	req.URL.Host = t.endpoint.Host
+	req.URL.Path = strings.TrimSuffix(t.endpoint.Path, "/") + req.URL.Path
+	req.URL.RawPath = ""
+	req.Host = ""
+
+	return t.next.RoundTrip(req)
+}
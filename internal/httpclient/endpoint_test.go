@@ -0,0 +1,64 @@
+package httpclient
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEndpointTransport(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+
+		if r.URL.Path == "/tg/bottoken/sendPhoto" {
+			assert.NoError(t, r.ParseMultipartForm(1024))
+			assert.Equal(t, "-100", r.FormValue("chat_id"))
+
+			_, _, err := r.FormFile("photo")
+			assert.NoError(t, err)
+		}
+
+		_, _ = w.Write([]byte(`{"ok":true,"result":{"id":1,"is_bot":true,"username":"bot","message_id":1}}`))
+	}))
+	defer server.Close()
+
+	transport, err := NewEndpointTransport(server.URL+"/tg/", nil)
+	require.NoError(t, err)
+
+	bot, err := tgbotapi.NewBotAPIWithClient("token", &http.Client{Transport: transport})
+	require.NoError(t, err)
+
+	_, err = bot.Send(tgbotapi.NewPhotoUpload(-100, tgbotapi.FileBytes{Name: "image.jpg", Bytes: []byte("image")}))
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"/tg/bottoken/getMe", "/tg/bottoken/sendPhoto"}, paths)
+}
+
+func TestEndpointTransport_OtherHosts(t *testing.T) {
+	var host string
+	server := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		host = r.Host
+	}))
+	defer server.Close()
+
+	transport, err := NewEndpointTransport("http://localhost:8081", nil)
+	require.NoError(t, err)
+
+	resp, err := (&http.Client{Transport: transport}).Get(server.URL + "/image.jpg")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+
+	assert.Equal(t, server.Listener.Addr().String(), host)
+}
+
+func TestNewEndpointTransport_Malformed(t *testing.T) {
+	for _, endpoint := range []string{"localhost:8081", "ftp://localhost", "http://"} {
+		_, err := NewEndpointTransport(endpoint, nil)
+		assert.Error(t, err, endpoint)
+	}
+}
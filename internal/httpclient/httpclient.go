@@ -18,11 +18,18 @@ type policy interface {
 	CreateLogger() zerolog.Logger
 }
 
+// transportPolicy is a policy that also alters the transport of the inner HTTP client.
+type transportPolicy interface {
+	ConfigureTransport(transport http.RoundTripper, logger zerolog.Logger) (http.RoundTripper, error)
+}
+
 var (
 	TelegramPolicy  policy = telegramPolicy{}
 	RSSPolicy       policy = rssPolicy{}
 	OpengraphPolicy policy = opengraphPolicy{}
 	CCPolicy        policy = ccPolicy{}
+	ReconcilePolicy policy = reconcilePolicy{}
+	S3Policy        policy = s3Policy{}
 )
 
 // New creates new HTTP client with proper resilience policy.
@@ -34,6 +41,13 @@ func New(p policy) (*retryablehttp.Client, error) {
 		return nil, err
 	}
 
+	if tp, ok := p.(transportPolicy); ok {
+		innerHTTPClient.Transport, err = tp.ConfigureTransport(innerHTTPClient.Transport, logger)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	httpClient := retryablehttp.NewClient()
 	p.ConfigureHTTP(httpClient)
 
@@ -91,13 +105,38 @@ func (_ telegramPolicy) ConfigureHTTP(client *retryablehttp.Client) {
 	client.Backoff = retryablehttp.LinearJitterBackoff
 	client.RetryMax = 10
 	client.RetryWaitMin = time.Second
-	client.RetryWaitMin = 30 * time.Second
+	client.RetryWaitMax = 30 * time.Second
+	client.CheckRetry = func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+		// Client errors (including 429) carry a Bot API error description and are handled by the telegram package,
+		// so only network failures and server errors are retried here.
+		if resp != nil && resp.StatusCode >= http.StatusBadRequest && resp.StatusCode < http.StatusInternalServerError {
+			return false, nil
+		}
+
+		return retryablehttp.DefaultRetryPolicy(ctx, resp, err)
+	}
 }
 
 func (_ telegramPolicy) CreateLogger() zerolog.Logger {
 	return log.Logger.With().Str("component", "telegram").Logger()
 }
 
+// ConfigureTransport redirects Bot API requests to a self-hosted Bot API server, if TELEGRAM_API_ENDPOINT is set.
+func (_ telegramPolicy) ConfigureTransport(transport http.RoundTripper, logger zerolog.Logger) (http.RoundTripper, error) {
+	endpoint := os.Getenv("TELEGRAM_API_ENDPOINT")
+	if endpoint == "" {
+		return transport, nil
+	}
+
+	transport, err := NewEndpointTransport(endpoint, transport)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info().Str("endpoint", endpoint).Msg("will use custom bot api endpoint")
+	return transport, nil
+}
+
 type rssPolicy struct{}
 
 func (_ rssPolicy) ConfigureHTTP(client *retryablehttp.Client) {
@@ -147,3 +186,45 @@ func (_ ccPolicy) ConfigureHTTP(client *retryablehttp.Client) {
 func (_ ccPolicy) CreateLogger() zerolog.Logger {
 	return log.Logger.With().Str("component", "carboncopy").Logger()
 }
+
+type reconcilePolicy struct{}
+
+func (_ reconcilePolicy) ConfigureHTTP(client *retryablehttp.Client) {
+	client.Backoff = retryablehttp.LinearJitterBackoff
+	client.RetryMax = 3
+	client.RetryWaitMin = time.Second
+	client.RetryWaitMax = 30 * time.Second
+	client.CheckRetry = func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+		// Missing pages are the expected outcome of a check, so they are never retried.
+		if resp != nil && resp.StatusCode >= http.StatusBadRequest && resp.StatusCode < http.StatusInternalServerError {
+			return false, nil
+		}
+
+		return retryablehttp.DefaultRetryPolicy(ctx, resp, err)
+	}
+}
+
+func (_ reconcilePolicy) CreateLogger() zerolog.Logger {
+	return log.Logger.With().Str("component", "reconcile").Logger()
+}
+
+type s3Policy struct{}
+
+func (_ s3Policy) ConfigureHTTP(client *retryablehttp.Client) {
+	client.Backoff = retryablehttp.LinearJitterBackoff
+	client.RetryMax = 5
+	client.RetryWaitMin = time.Second
+	client.RetryWaitMax = 30 * time.Second
+	client.CheckRetry = func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+		// Client errors, e.g. a missing object or a denied access, won't change on retry.
+		if resp != nil && resp.StatusCode >= http.StatusBadRequest && resp.StatusCode < http.StatusInternalServerError {
+			return false, nil
+		}
+
+		return retryablehttp.DefaultRetryPolicy(ctx, resp, err)
+	}
+}
+
+func (_ s3Policy) CreateLogger() zerolog.Logger {
+	return log.Logger.With().Str("component", "s3").Logger()
+}
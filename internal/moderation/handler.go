@@ -0,0 +1,219 @@
+package moderation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/rs/zerolog/log"
+
+	"github.com/kapitanov/habrabot/internal/data"
+)
+
+// HandleUpdate handles decisions of moderators, which are sent from the moderators' chat.
+func (m *Moderator) HandleUpdate(ctx context.Context, bot *tgbotapi.BotAPI, update tgbotapi.Update) {
+	m.handleUpdate(ctx, bot, update)
+}
+
+func (m *Moderator) handleUpdate(ctx context.Context, bot messenger, update tgbotapi.Update) {
+	switch {
+	case update.CallbackQuery != nil:
+		m.handleCallback(ctx, bot, update.CallbackQuery)
+	case update.Message != nil && update.Message.ReplyToMessage != nil:
+		m.handleTagsReply(bot, update.Message)
+	}
+}
+
+func (m *Moderator) handleCallback(ctx context.Context, bot messenger, query *tgbotapi.CallbackQuery) {
+	action, key, ok := parseCallbackData(query.Data)
+	if !ok || query.Message == nil || query.Message.Chat.ID != m.chatID {
+		return
+	}
+
+	reply := m.decide(ctx, bot, action, key, userName(query.From))
+
+	_, err := bot.AnswerCallbackQuery(tgbotapi.NewCallback(query.ID, reply))
+	if err != nil {
+		log.Error().Err(err).Msg("unable to answer moderation callback")
+	}
+}
+
+// decide applies a moderator's decision and returns a text to be shown to the moderator.
+func (m *Moderator) decide(ctx context.Context, bot messenger, action, key, moderator string) string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var it item
+	found, err := m.store.Get(key, &it)
+	if err != nil {
+		log.Error().Err(err).Str("key", key).Msg("unable to load moderation item")
+		return fmt.Sprintf("Error: %v", err)
+	}
+
+	if !found {
+		return "Article not found."
+	}
+
+	if m.publishing[key] {
+		return "Article is being published."
+	}
+
+	if it.Status != statusPending {
+		return fmt.Sprintf("Article has been %s already.", it.Status)
+	}
+
+	switch action {
+	case actionApprove:
+		err = m.publish(ctx, key, it.Article)
+		if err != nil {
+			log.Error().Err(err).Str("id", it.Article.ID).Msg("unable to publish approved feed item")
+			return fmt.Sprintf("Unable to publish: %v", err)
+		}
+
+		it.Status = statusApproved
+
+	case actionReject:
+		it.Status = statusRejected
+
+	case actionTags:
+		return m.promptTags(bot, key, it)
+
+	default:
+		return "Unknown action."
+	}
+
+	it.DecidedBy = moderator
+
+	err = m.store.Put(key, it)
+	if err != nil {
+		log.Error().Err(err).Str("id", it.Article.ID).Msg("unable to store moderation item")
+		return fmt.Sprintf("Error: %v", err)
+	}
+
+	log.Info().Str("id", it.Article.ID).Str("status", string(it.Status)).Str("by", moderator).Msg("feed item has been moderated")
+
+	m.refreshPreview(bot, it)
+	return fmt.Sprintf("Article has been %s.", it.Status)
+}
+
+// publish passes an approved article to the downstream consumer.
+// The mutex, which must be held by the caller, is released meanwhile, so the feed and other moderators aren't blocked.
+// Updates of the article that arrive while it's being published are passed downstream as if it's been approved.
+func (m *Moderator) publish(ctx context.Context, key string, article data.Article) error {
+	m.publishing[key] = true
+	m.mutex.Unlock()
+
+	err := m.consumer.On(ctx, article)
+
+	m.mutex.Lock()
+	delete(m.publishing, key)
+	return err
+}
+
+func (m *Moderator) promptTags(bot messenger, key string, it item) string {
+	prompt := tgbotapi.NewMessage(m.chatID, fmt.Sprintf(
+		"Reply to this message with comma-separated tags for \"%s\".\nCurrent tags: %s",
+		it.Article.Title,
+		strings.Join(it.Article.Tags, ", "),
+	))
+	prompt.ReplyToMessageID = it.MessageID
+	prompt.ReplyMarkup = tgbotapi.ForceReply{ForceReply: true, Selective: true}
+
+	msg, err := bot.Send(prompt)
+	if err != nil {
+		log.Error().Err(err).Str("id", it.Article.ID).Msg("unable to ask for new tags")
+		return fmt.Sprintf("Error: %v", err)
+	}
+
+	it.TagsPromptID = msg.MessageID
+
+	err = m.store.Put(key, it)
+	if err != nil {
+		log.Error().Err(err).Str("id", it.Article.ID).Msg("unable to store moderation item")
+		return fmt.Sprintf("Error: %v", err)
+	}
+
+	return ""
+}
+
+func (m *Moderator) handleTagsReply(bot messenger, msg *tgbotapi.Message) {
+	if msg.Chat.ID != m.chatID {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	key, it, found, err := m.findByTagsPrompt(msg.ReplyToMessage.MessageID)
+	if err != nil {
+		log.Error().Err(err).Msg("unable to load moderation item")
+		return
+	}
+
+	if !found || it.Status != statusPending || m.publishing[key] {
+		return
+	}
+
+	it.Article.Tags = parseTags(msg.Text)
+	it.TagsPromptID = 0
+
+	err = m.store.Put(key, it)
+	if err != nil {
+		log.Error().Err(err).Str("id", it.Article.ID).Msg("unable to store moderation item")
+		return
+	}
+
+	log.Info().Str("id", it.Article.ID).Strs("tags", it.Article.Tags).Msg("tags of feed item have been edited")
+	m.refreshPreview(bot, it)
+}
+
+func (m *Moderator) findByTagsPrompt(messageID int) (string, item, bool, error) {
+	var (
+		foundKey  string
+		foundItem item
+	)
+
+	err := m.store.ForEach("", func(key string, value []byte) error {
+		if foundKey != "" {
+			return nil
+		}
+
+		var it item
+		err := json.Unmarshal(value, &it)
+		if err != nil {
+			return err
+		}
+
+		if it.TagsPromptID == messageID {
+			foundKey, foundItem = key, it
+		}
+
+		return nil
+	})
+	if err != nil {
+		return "", item{}, false, err
+	}
+
+	return foundKey, foundItem, foundKey != "", nil
+}
+
+func (m *Moderator) refreshPreview(bot messenger, it item) {
+	_, err := bot.Send(createPreviewEdit(m.chatID, it))
+	if err != nil && !isMessageNotModified(err) {
+		log.Error().Err(err).Str("id", it.Article.ID).Msg("unable to update moderation preview")
+	}
+}
+
+func userName(user *tgbotapi.User) string {
+	if user == nil {
+		return "unknown"
+	}
+
+	if user.UserName != "" {
+		return "@" + user.UserName
+	}
+
+	return strings.TrimSpace(user.FirstName + " " + user.LastName)
+}
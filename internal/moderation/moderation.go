@@ -0,0 +1,198 @@
+package moderation
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/rs/zerolog/log"
+
+	"github.com/kapitanov/habrabot/internal/data"
+	"github.com/kapitanov/habrabot/internal/httpclient"
+	"github.com/kapitanov/habrabot/internal/telegram"
+)
+
+// Store is a persistent key-value storage.
+type Store interface {
+	// Get loads a value by its key. It returns false if the key doesn't exist.
+	Get(key string, value interface{}) (bool, error)
+
+	// Put stores a value by its key.
+	Put(key string, value interface{}) error
+
+	// ForEach iterates over values with keys starting with the specified prefix.
+	ForEach(prefix string, fn func(key string, value []byte) error) error
+}
+
+// messenger is a subset of Bot API used by moderation.
+type messenger interface {
+	Send(c tgbotapi.Chattable) (tgbotapi.Message, error)
+	AnswerCallbackQuery(config tgbotapi.CallbackConfig) (tgbotapi.APIResponse, error)
+}
+
+// status is a moderation decision about an article.
+type status string
+
+const (
+	statusPending  status = "pending"
+	statusApproved status = "approved"
+	statusRejected status = "rejected"
+)
+
+// item is an article submitted for moderation.
+type item struct {
+	Article      data.Article `json:"article"`
+	Status       status       `json:"status"`
+	MessageID    int          `json:"message_id"`               // ID of the preview message.
+	TagsPromptID int          `json:"tags_prompt_id,omitempty"` // ID of the message that asks for new tags.
+	SubmittedAt  time.Time    `json:"submitted_at"`
+	DecidedBy    string       `json:"decided_by,omitempty"` // Name of the moderator who has approved or rejected the article.
+}
+
+// Moderator is a consumer that holds articles until moderators approve them.
+// Previews of articles are sent into the moderators' chat,
+// and approved articles are passed to the downstream consumer.
+type Moderator struct {
+	token    string
+	chatID   int64
+	consumer data.Consumer
+	store    Store
+	bot      messenger
+
+	// mutex serializes changes of moderation items between the feed and moderators' decisions.
+	// It isn't held while approved articles are being published, those are tracked by keys instead.
+	mutex      sync.Mutex
+	publishing map[string]bool
+}
+
+// New creates new moderator.
+// Previews are sent into the chat with the specified ID, and moderation items are kept in the store.
+func New(token string, chatID int64, consumer data.Consumer, store Store) *Moderator {
+	log.Info().Int64("chat", chatID).Msg("will send feed items to moderation")
+
+	return &Moderator{
+		token:      token,
+		chatID:     chatID,
+		consumer:   consumer,
+		store:      store,
+		publishing: make(map[string]bool),
+	}
+}
+
+// On method is invoked when an article is received from the feed.
+func (m *Moderator) On(ctx context.Context, article data.Article) error {
+	approved, err := m.submit(article)
+	if err != nil || !approved {
+		return err
+	}
+
+	// Updates of approved articles don't need another approval.
+	return m.consumer.On(ctx, article)
+}
+
+// submit sends an article to moderation, unless moderators have decided on it already.
+// It returns true if the article has been approved.
+func (m *Moderator) submit(article data.Article) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	key := itemKey(article.ID)
+	if m.publishing[key] {
+		return true, nil
+	}
+
+	var it item
+	found, err := m.store.Get(key, &it)
+	if err != nil {
+		log.Error().Err(err).Str("id", article.ID).Msg("unable to load moderation item")
+		return false, err
+	}
+
+	if found {
+		switch it.Status {
+		case statusRejected:
+			log.Info().Str("id", article.ID).Msg("feed item has been rejected by moderators")
+			return false, nil
+		case statusApproved:
+			return true, nil
+		}
+	}
+
+	err = m.connect()
+	if err != nil {
+		return false, err
+	}
+
+	it.Article = article
+	it.Status = statusPending
+	if !found {
+		it.SubmittedAt = time.Now().UTC()
+	}
+
+	var msg tgbotapi.Message
+	if found {
+		// Article has been updated while it's waiting for moderation, so the preview is refreshed.
+		msg, err = m.bot.Send(createPreviewEdit(m.chatID, it))
+		if err != nil && isMessageNotModified(err) {
+			msg, err = tgbotapi.Message{MessageID: it.MessageID}, nil
+		}
+	} else {
+		msg, err = m.bot.Send(createPreview(m.chatID, it))
+	}
+
+	if err != nil {
+		log.Error().Err(err).Str("id", article.ID).Msg("unable to send feed item to moderation")
+		return false, err
+	}
+
+	it.MessageID = msg.MessageID
+
+	err = m.store.Put(key, it)
+	if err != nil {
+		log.Error().Err(err).Str("id", article.ID).Msg("unable to store moderation item")
+		return false, err
+	}
+
+	log.Info().Str("id", article.ID).Int("msg", msg.MessageID).Msg("feed item has been sent to moderation")
+	return false, nil
+}
+
+// Flush delivers articles buffered by the downstream consumer.
+func (m *Moderator) Flush(ctx context.Context) error {
+	return data.Flush(ctx, m.consumer)
+}
+
+func (m *Moderator) connect() error {
+	if m.bot != nil {
+		return nil
+	}
+
+	httpClient, err := httpclient.New(httpclient.TelegramPolicy)
+	if err != nil {
+		return err
+	}
+
+	bot, err := tgbotapi.NewBotAPIWithClient(m.token, httpClient.StandardClient())
+	if err != nil {
+		log.Error().Err(err).Msg("unable to connect to telegram")
+		return err
+	}
+
+	m.bot = bot
+	return nil
+}
+
+// itemKey returns a short key of an article, since it's used in callback data which is limited to 64 bytes.
+func itemKey(articleID string) string {
+	hash := sha256.Sum256([]byte(strings.ToLower(articleID)))
+	return hex.EncodeToString(hash[:8])
+}
+
+func isMessageNotModified(err error) bool {
+	apiErr, ok := telegram.AsAPIError(err)
+	return ok && apiErr.IsMessageNotModified()
+}
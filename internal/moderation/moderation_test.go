@@ -0,0 +1,227 @@
+package moderation
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kapitanov/habrabot/internal/data"
+)
+
+type inMemoryStore map[string][]byte
+
+func (s inMemoryStore) Get(key string, value interface{}) (bool, error) {
+	bytes, exists := s[key]
+	if !exists {
+		return false, nil
+	}
+
+	return true, json.Unmarshal(bytes, value)
+}
+
+func (s inMemoryStore) Put(key string, value interface{}) error {
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	s[key] = bytes
+	return nil
+}
+
+func (s inMemoryStore) ForEach(prefix string, fn func(key string, value []byte) error) error {
+	for key, value := range s {
+		if strings.HasPrefix(key, prefix) {
+			err := fn(key, value)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+type fakeMessenger struct {
+	sent    []tgbotapi.Chattable
+	answers []string
+}
+
+func (m *fakeMessenger) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	m.sent = append(m.sent, c)
+	return tgbotapi.Message{MessageID: 100 + len(m.sent)}, nil
+}
+
+func (m *fakeMessenger) AnswerCallbackQuery(config tgbotapi.CallbackConfig) (tgbotapi.APIResponse, error) {
+	m.answers = append(m.answers, config.Text)
+	return tgbotapi.APIResponse{Ok: true}, nil
+}
+
+const moderatorsChatID = -100
+
+func newTestModerator() (*Moderator, *fakeMessenger, *[]data.Article) {
+	var published []data.Article
+	consumer := data.ConsumerFunc(func(_ context.Context, article data.Article) error {
+		published = append(published, article)
+		return nil
+	})
+
+	bot := &fakeMessenger{}
+	m := New("token", moderatorsChatID, consumer, inMemoryStore{})
+	m.bot = bot
+
+	return m, bot, &published
+}
+
+func callback(action string, article data.Article) tgbotapi.Update {
+	return tgbotapi.Update{
+		CallbackQuery: &tgbotapi.CallbackQuery{
+			ID:      "1",
+			From:    &tgbotapi.User{UserName: "moderator"},
+			Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: moderatorsChatID}},
+			Data:    action + ":" + itemKey(article.ID),
+		},
+	}
+}
+
+func TestModerator_Approve(t *testing.T) {
+	m, bot, published := newTestModerator()
+	article := data.Article{ID: "1", Title: "TITLE", LinkURL: "https://habr.com/post/1/"}
+
+	require.NoError(t, m.On(context.Background(), article))
+	assert.Empty(t, *published)
+	if assert.Len(t, bot.sent, 1) {
+		assert.IsType(t, tgbotapi.MessageConfig{}, bot.sent[0])
+	}
+
+	m.handleUpdate(context.Background(), bot, callback(actionApprove, article))
+
+	assert.Equal(t, []data.Article{article}, *published)
+	assert.Equal(t, []string{"Article has been approved."}, bot.answers)
+	if assert.Len(t, bot.sent, 2) {
+		edit := bot.sent[1].(tgbotapi.EditMessageTextConfig)
+		assert.Equal(t, 101, edit.MessageID)
+		assert.Contains(t, edit.Text, "Approved by @moderator")
+		assert.Nil(t, edit.ReplyMarkup)
+	}
+
+	// Second click doesn't publish the article again.
+	m.handleUpdate(context.Background(), bot, callback(actionApprove, article))
+	assert.Len(t, *published, 1)
+
+	// Updates of approved articles are published right away.
+	article.Title = "NEW TITLE"
+	require.NoError(t, m.On(context.Background(), article))
+	assert.Len(t, *published, 2)
+}
+
+func TestModerator_UpdatedWhilePublishing(t *testing.T) {
+	var (
+		m         *Moderator
+		published []data.Article
+	)
+
+	article := data.Article{ID: "1", Title: "TITLE"}
+	updated := data.Article{ID: "1", Title: "NEW TITLE"}
+
+	m = New("token", moderatorsChatID, data.ConsumerFunc(func(ctx context.Context, a data.Article) error {
+		published = append(published, a)
+		if len(published) == 1 {
+			// Moderator isn't locked while the article is being published.
+			return m.On(ctx, updated)
+		}
+
+		return nil
+	}), inMemoryStore{})
+
+	bot := &fakeMessenger{}
+	m.bot = bot
+
+	require.NoError(t, m.On(context.Background(), article))
+	m.handleUpdate(context.Background(), bot, callback(actionApprove, article))
+
+	assert.Equal(t, []data.Article{article, updated}, published)
+	assert.Equal(t, []string{"Article has been approved."}, bot.answers)
+}
+
+func TestModerator_Reject(t *testing.T) {
+	m, bot, published := newTestModerator()
+	article := data.Article{ID: "1", Title: "TITLE"}
+
+	require.NoError(t, m.On(context.Background(), article))
+	m.handleUpdate(context.Background(), bot, callback(actionReject, article))
+
+	assert.Empty(t, *published)
+	assert.Equal(t, []string{"Article has been rejected."}, bot.answers)
+
+	// Rejected articles never come back.
+	require.NoError(t, m.On(context.Background(), article))
+	assert.Empty(t, *published)
+	assert.Len(t, bot.sent, 2)
+}
+
+func TestModerator_EditTags(t *testing.T) {
+	m, bot, published := newTestModerator()
+	article := data.Article{ID: "1", Title: "TITLE", Tags: []string{"go"}}
+
+	require.NoError(t, m.On(context.Background(), article))
+	m.handleUpdate(context.Background(), bot, callback(actionTags, article))
+
+	require.Len(t, bot.sent, 2)
+	prompt := bot.sent[1].(tgbotapi.MessageConfig)
+	assert.Equal(t, 101, prompt.ReplyToMessageID)
+
+	m.handleUpdate(context.Background(), bot, tgbotapi.Update{
+		Message: &tgbotapi.Message{
+			Chat:           &tgbotapi.Chat{ID: moderatorsChatID},
+			Text:           "go, telegram ,",
+			ReplyToMessage: &tgbotapi.Message{MessageID: 102},
+		},
+	})
+
+	require.Len(t, bot.sent, 3)
+	assert.Contains(t, bot.sent[2].(tgbotapi.EditMessageTextConfig).Text, "Tags: go, telegram")
+
+	m.handleUpdate(context.Background(), bot, callback(actionApprove, article))
+	if assert.Len(t, *published, 1) {
+		assert.Equal(t, []string{"go", "telegram"}, (*published)[0].Tags)
+	}
+}
+
+func TestModerator_IgnoresOtherChats(t *testing.T) {
+	m, bot, published := newTestModerator()
+	article := data.Article{ID: "1", Title: "TITLE"}
+
+	require.NoError(t, m.On(context.Background(), article))
+
+	update := callback(actionApprove, article)
+	update.CallbackQuery.Message.Chat.ID = 42
+	m.handleUpdate(context.Background(), bot, update)
+
+	assert.Empty(t, *published)
+	assert.Empty(t, bot.answers)
+}
+
+func TestFormatPreview(t *testing.T) {
+	it := item{
+		Article: data.Article{
+			Title:       "TITLE",
+			Description: "<p>Some&nbsp;<b>text</b></p>",
+			LinkURL:     "https://habr.com/post/1/",
+			Author:      "author",
+			Tags:        []string{"a", "b"},
+		},
+		Status:    statusRejected,
+		DecidedBy: "@moderator",
+	}
+
+	assert.Equal(t,
+		"TITLE\n\nSome text\n\nhttps://habr.com/post/1/\nAuthor: author\nTags: a, b\n\n❌ Rejected by @moderator",
+		formatPreview(it),
+	)
+}
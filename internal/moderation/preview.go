@@ -0,0 +1,118 @@
+package moderation
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"golang.org/x/exp/utf8string"
+)
+
+const maxPreviewDescriptionLength = 1000
+
+// Callback data is "<action>:<key>".
+const (
+	actionApprove = "approve"
+	actionReject  = "reject"
+	actionTags    = "tags"
+)
+
+func createPreview(chatID int64, it item) tgbotapi.Chattable {
+	msg := tgbotapi.NewMessage(chatID, formatPreview(it))
+	msg.DisableWebPagePreview = false
+
+	keyboard := createKeyboard(it)
+	msg.ReplyMarkup = &keyboard
+
+	return msg
+}
+
+func createPreviewEdit(chatID int64, it item) tgbotapi.Chattable {
+	edit := tgbotapi.NewEditMessageText(chatID, it.MessageID, formatPreview(it))
+
+	if it.Status == statusPending {
+		keyboard := createKeyboard(it)
+		edit.ReplyMarkup = &keyboard
+	}
+
+	return edit
+}
+
+func createKeyboard(it item) tgbotapi.InlineKeyboardMarkup {
+	key := itemKey(it.Article.ID)
+
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✅ Approve", actionApprove+":"+key),
+			tgbotapi.NewInlineKeyboardButtonData("❌ Reject", actionReject+":"+key),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🏷 Edit tags", actionTags+":"+key),
+		),
+	)
+}
+
+var htmlTagRegex = regexp.MustCompile(`<[^>]*>`)
+
+// formatPreview renders a plain text preview of an article, so its markup can't break the message.
+func formatPreview(it item) string {
+	var sb strings.Builder
+
+	sb.WriteString(strings.TrimSpace(it.Article.Title))
+	sb.WriteString("\n\n")
+
+	description := html.UnescapeString(htmlTagRegex.ReplaceAllString(it.Article.Description, " "))
+	description = strings.Join(strings.Fields(description), " ")
+	if description != "" {
+		sb.WriteString(trimLongText(description, maxPreviewDescriptionLength))
+		sb.WriteString("\n\n")
+	}
+
+	sb.WriteString(it.Article.LinkURL)
+
+	if it.Article.Author != "" {
+		fmt.Fprintf(&sb, "\nAuthor: %s", it.Article.Author)
+	}
+
+	if len(it.Article.Tags) > 0 {
+		fmt.Fprintf(&sb, "\nTags: %s", strings.Join(it.Article.Tags, ", "))
+	}
+
+	switch it.Status {
+	case statusApproved:
+		fmt.Fprintf(&sb, "\n\n✅ Approved by %s", it.DecidedBy)
+	case statusRejected:
+		fmt.Fprintf(&sb, "\n\n❌ Rejected by %s", it.DecidedBy)
+	}
+
+	return sb.String()
+}
+
+func trimLongText(text string, max int) string {
+	const ellipsis = "…"
+
+	str := utf8string.NewString(text)
+	if str.RuneCount() <= max {
+		return text
+	}
+
+	return str.Slice(0, max-1) + ellipsis
+}
+
+func parseCallbackData(str string) (action, key string, ok bool) {
+	return strings.Cut(str, ":")
+}
+
+func parseTags(str string) []string {
+	var tags []string
+	for _, tag := range strings.Split(str, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+
+	return tags
+}
@@ -0,0 +1,110 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Rate defines a number of events allowed per time period.
+type Rate struct {
+	Count  int           // Number of events.
+	Period time.Duration // Time period.
+}
+
+// IsZero returns true if the rate is not limited.
+func (r Rate) IsZero() bool {
+	return r.Count <= 0 || r.Period <= 0
+}
+
+// bucket is a token bucket which refills continuously up to its capacity.
+type bucket struct {
+	mutex    sync.Mutex
+	rate     Rate
+	tokens   float64
+	lastFill time.Time
+	now      func() time.Time
+}
+
+func newBucket(rate Rate, now func() time.Time) *bucket {
+	return &bucket{
+		rate:     rate,
+		tokens:   float64(rate.Count),
+		lastFill: now(),
+		now:      now,
+	}
+}
+
+// reserve takes a token from the bucket and returns the delay before it may be used.
+func (b *bucket) reserve() time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.refill()
+
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.tokens * float64(b.interval()))
+}
+
+// take takes a token from the bucket only if it's available right now.
+func (b *bucket) take() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.refill()
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
+
+// refund returns a reserved token that hasn't been used.
+func (b *bucket) refund() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.refill()
+
+	b.tokens++
+	if b.tokens > float64(b.rate.Count) {
+		b.tokens = float64(b.rate.Count)
+	}
+}
+
+func (b *bucket) refill() {
+	now := b.now()
+
+	elapsed := now.Sub(b.lastFill)
+	b.tokens += float64(elapsed) / float64(b.interval())
+	if b.tokens > float64(b.rate.Count) {
+		b.tokens = float64(b.rate.Count)
+	}
+	b.lastFill = now
+}
+
+func (b *bucket) interval() time.Duration {
+	return b.rate.Period / time.Duration(b.rate.Count)
+}
+
+func wait(ctx context.Context, delay time.Duration) error {
+	if delay <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,232 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/kapitanov/habrabot/internal/data"
+)
+
+// Queue is a persistent queue of articles waiting to be sent.
+type Queue interface {
+	// PushWithState appends an article to the end of the queue along with a state of its delivery.
+	PushWithState(article data.Article, value interface{}) error
+
+	// Items returns all queued articles in their order.
+	Items() ([]data.Article, error)
+
+	// State loads a state of a queued article's delivery. It returns false if the article has no state.
+	State(id string, value interface{}) (bool, error)
+
+	// SetState stores a state of a queued article's delivery.
+	SetState(id string, value interface{}) error
+
+	// Remove removes an article from the queue.
+	Remove(id string) error
+}
+
+// Store is a persistent key-value storage of articles that couldn't be delivered.
+type Store interface {
+	// Put stores a value by its key.
+	Put(key string, value interface{}) error
+}
+
+// Articles that fail to be delivered are retried later, so they don't block articles queued after them.
+// Delay before the next attempt doubles after each failure, up to maxRetryDelay.
+// Once an article runs out of attempts, it's moved from the queue to the store.
+const (
+	minRetryDelay       = time.Minute
+	maxRetryDelay       = time.Hour
+	maxDeliveryAttempts = 10
+)
+
+// Use wraps a consumer so it receives articles no faster than the limiter allows.
+// Articles are put into the queue first, so they are not lost if the process stops while they are waiting.
+// Once an article is queued, it's the queue that keeps it until delivery, so its delivery failures
+// aren't returned to the caller. Articles that can't be delivered are moved into the store eventually.
+func Use(consumer data.Consumer, limiter *Limiter, chat string, queue Queue, store Store) data.Consumer {
+	return &limitedConsumer{
+		consumer: consumer,
+		limiter:  limiter,
+		chat:     chat,
+		queue:    queue,
+		store:    store,
+		now:      time.Now,
+	}
+}
+
+type limitedConsumer struct {
+	consumer data.Consumer
+	limiter  *Limiter
+	chat     string
+	queue    Queue
+	store    Store
+	now      func() time.Time
+
+	// mutex prevents concurrent flushes from delivering the same queued article twice.
+	mutex sync.Mutex
+}
+
+// delivery is a state of a queued article's delivery, it's kept in the queue along with the article.
+type delivery struct {
+	Silent   bool      `json:"silent,omitempty"`   // Article is delivered without notification.
+	Attempts int       `json:"attempts,omitempty"` // Number of failed attempts.
+	RetryAt  time.Time `json:"retry_at,omitempty"` // Time of the next attempt.
+}
+
+// undelivered is a record of an article that has run out of delivery attempts.
+type undelivered struct {
+	Article  data.Article `json:"article"`
+	Error    string       `json:"error"`
+	Attempts int          `json:"attempts"`
+	FailedAt time.Time    `json:"failed_at"`
+}
+
+// On method is invoked when an article is received from the feed.
+// Silent delivery requested by the context applies to this article only, not to other queued articles.
+func (c *limitedConsumer) On(ctx context.Context, article data.Article) error {
+	err := c.queue.PushWithState(article, delivery{Silent: data.IsSilentDelivery(ctx)})
+	if err != nil {
+		log.Error().Err(err).Str("id", article.ID).Msg("unable to enqueue feed item")
+		return err
+	}
+
+	return c.Flush(ctx)
+}
+
+// Flush delivers queued articles.
+// Shutdown doesn't fail delivery since queued articles are delivered after restart.
+// Neither does unavailable destination, articles are kept in the queue until the bot regains access to it.
+func (c *limitedConsumer) Flush(ctx context.Context) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for {
+		article, state, found, err := c.next()
+		if err != nil {
+			return err
+		}
+
+		if !found {
+			return nil
+		}
+
+		err = c.limiter.Wait(ctx, c.chat)
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				log.Info().Str("id", article.ID).Msg("feed item will be delivered after restart")
+				return nil
+			}
+
+			return err
+		}
+
+		err = c.consumer.On(data.WithSilentDelivery(ctx, state.Silent), article)
+		if errors.Is(err, data.ErrUnavailable) {
+			log.Error().Err(err).Str("id", article.ID).Str("chat", c.chat).Msg("destination is unavailable, feed items are kept in the queue")
+			return nil
+		}
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+
+			err = c.fail(article, state, err)
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		err = c.queue.Remove(article.ID)
+		if err != nil {
+			log.Error().Err(err).Str("id", article.ID).Msg("unable to dequeue feed item")
+			return err
+		}
+	}
+}
+
+// next returns the first queued article that isn't waiting for a retry, along with the state of its delivery.
+func (c *limitedConsumer) next() (data.Article, delivery, bool, error) {
+	articles, err := c.queue.Items()
+	if err != nil {
+		return data.Article{}, delivery{}, false, err
+	}
+
+	now := c.now()
+	for _, article := range articles {
+		var state delivery
+		_, err = c.queue.State(article.ID, &state)
+		if err != nil {
+			return data.Article{}, delivery{}, false, err
+		}
+
+		if now.Before(state.RetryAt) {
+			continue
+		}
+
+		return article, state, true, nil
+	}
+
+	return data.Article{}, delivery{}, false, nil
+}
+
+// fail schedules a retry of an article that has failed to be delivered,
+// or moves it from the queue to the store once it runs out of attempts.
+func (c *limitedConsumer) fail(article data.Article, state delivery, err error) error {
+	state.Attempts++
+
+	if state.Attempts >= maxDeliveryAttempts {
+		log.Error().
+			Err(err).
+			Str("id", article.ID).
+			Int("attempts", state.Attempts).
+			Msg("unable to deliver feed item, giving up")
+
+		record := undelivered{Article: article, Error: err.Error(), Attempts: state.Attempts, FailedAt: c.now().UTC()}
+		err = c.store.Put(strings.ToLower(article.ID), record)
+		if err != nil {
+			log.Error().Err(err).Str("id", article.ID).Msg("unable to store undelivered feed item")
+			return err
+		}
+
+		err = c.queue.Remove(article.ID)
+		if err != nil {
+			log.Error().Err(err).Str("id", article.ID).Msg("unable to dequeue undelivered feed item")
+		}
+
+		return err
+	}
+
+	delay := minRetryDelay
+	for i := 1; i < state.Attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+
+	state.RetryAt = c.now().Add(delay)
+
+	log.Error().
+		Err(err).
+		Str("id", article.ID).
+		Int("attempts", state.Attempts).
+		Time("retry_at", state.RetryAt).
+		Msg("unable to deliver feed item, it will be retried later")
+
+	err = c.queue.SetState(article.ID, state)
+	if err != nil {
+		log.Error().Err(err).Str("id", article.ID).Msg("unable to store delivery state of feed item")
+	}
+
+	return err
+}
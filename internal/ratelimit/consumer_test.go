@@ -0,0 +1,208 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kapitanov/habrabot/internal/data"
+)
+
+type inMemoryQueue struct {
+	articles []data.Article
+	states   map[string]delivery
+}
+
+func (q *inMemoryQueue) PushWithState(article data.Article, value interface{}) error {
+	for _, a := range q.articles {
+		if a.ID == article.ID {
+			return nil
+		}
+	}
+
+	q.articles = append(q.articles, article)
+	return q.SetState(article.ID, value)
+}
+
+func (q *inMemoryQueue) Items() ([]data.Article, error) {
+	return append([]data.Article(nil), q.articles...), nil
+}
+
+func (q *inMemoryQueue) State(id string, value interface{}) (bool, error) {
+	state, ok := q.states[id]
+	if ok {
+		*value.(*delivery) = state
+	}
+
+	return ok, nil
+}
+
+func (q *inMemoryQueue) SetState(id string, value interface{}) error {
+	if q.states == nil {
+		q.states = make(map[string]delivery)
+	}
+
+	q.states[id] = value.(delivery)
+	return nil
+}
+
+func (q *inMemoryQueue) Remove(id string) error {
+	delete(q.states, id)
+
+	for i, a := range q.articles {
+		if a.ID == id {
+			q.articles = append(q.articles[:i], q.articles[i+1:]...)
+			return nil
+		}
+	}
+
+	return nil
+}
+
+type inMemoryStore map[string]interface{}
+
+func (s inMemoryStore) Put(key string, value interface{}) error {
+	s[key] = value
+	return nil
+}
+
+type inMemoryConsumer struct {
+	articles []data.Article
+	silent   []string
+}
+
+func (c *inMemoryConsumer) Items() []data.Article {
+	return c.articles
+}
+
+func (c *inMemoryConsumer) On(ctx context.Context, article data.Article) error {
+	c.articles = append(c.articles, article)
+	if data.IsSilentDelivery(ctx) {
+		c.silent = append(c.silent, article.ID)
+	}
+
+	return nil
+}
+
+func TestUse_Deliver(t *testing.T) {
+	queue := &inMemoryQueue{}
+	output := &inMemoryConsumer{}
+	consumer := Use(output, New(Rate{}, Rate{}), "chat", queue, inMemoryStore{})
+
+	for _, id := range []string{"1", "2", "3"} {
+		require.NoError(t, consumer.On(context.Background(), data.Article{ID: id}))
+	}
+
+	assert.Len(t, output.Items(), 3)
+	assert.Empty(t, queue.articles)
+}
+
+func TestUse_SilentDelivery(t *testing.T) {
+	queue := &inMemoryQueue{}
+	output := &inMemoryConsumer{}
+	consumer := Use(output, New(Rate{}, Rate{Count: 1, Period: time.Hour}), "chat", queue, inMemoryStore{})
+
+	// The first article takes the only token, so the second one waits in the queue.
+	require.NoError(t, consumer.On(context.Background(), data.Article{ID: "1"}))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.NoError(t, consumer.On(ctx, data.Article{ID: "2"}))
+
+	// Silence requested for the third article doesn't apply to the second one, which is delivered in the same flush.
+	consumer.(*limitedConsumer).limiter = New(Rate{}, Rate{})
+	require.NoError(t, consumer.On(data.WithSilentDelivery(context.Background(), true), data.Article{ID: "3"}))
+
+	assert.Len(t, output.Items(), 3)
+	assert.Equal(t, []string{"3"}, output.silent)
+}
+
+func TestUse_KeepQueuedOnShutdown(t *testing.T) {
+	queue := &inMemoryQueue{}
+	output := &inMemoryConsumer{}
+	consumer := Use(output, New(Rate{}, Rate{Count: 1, Period: time.Hour}), "chat", queue, inMemoryStore{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	require.NoError(t, consumer.On(ctx, data.Article{ID: "1"}))
+	cancel()
+	require.NoError(t, consumer.On(ctx, data.Article{ID: "2"}))
+
+	assert.Len(t, output.Items(), 1)
+	if assert.Len(t, queue.articles, 1) {
+		assert.Equal(t, "2", queue.articles[0].ID)
+	}
+}
+
+func TestUse_ConsumerError(t *testing.T) {
+	queue := &inMemoryQueue{}
+	output := &inMemoryConsumer{}
+	consumer := Use(data.ConsumerFunc(func(ctx context.Context, article data.Article) error {
+		if article.ID == "1" {
+			return errors.New("expected error")
+		}
+
+		return output.On(ctx, article)
+	}), New(Rate{}, Rate{}), "chat", queue, inMemoryStore{}).(*limitedConsumer)
+
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	consumer.now = clock.Now
+
+	// The failing article doesn't block articles queued after it.
+	require.NoError(t, consumer.On(context.Background(), data.Article{ID: "1"}))
+	require.NoError(t, consumer.On(context.Background(), data.Article{ID: "2"}))
+	assert.Equal(t, []data.Article{{ID: "2"}}, output.Items())
+	assert.Equal(t, []data.Article{{ID: "1"}}, queue.articles)
+	assert.Equal(t, delivery{Attempts: 1, RetryAt: clock.now.Add(time.Minute)}, queue.states["1"])
+
+	// The article is retried once its delay has passed, and the delay doubles.
+	require.NoError(t, consumer.Flush(context.Background()))
+	assert.Equal(t, 1, queue.states["1"].Attempts)
+
+	clock.now = clock.now.Add(time.Minute)
+	require.NoError(t, consumer.Flush(context.Background()))
+	assert.Equal(t, delivery{Attempts: 2, RetryAt: clock.now.Add(2 * time.Minute)}, queue.states["1"])
+	assert.Equal(t, []data.Article{{ID: "1"}}, queue.articles)
+}
+
+func TestUse_Undelivered(t *testing.T) {
+	queue := &inMemoryQueue{}
+	store := inMemoryStore{}
+	consumer := Use(data.ConsumerFunc(func(_ context.Context, _ data.Article) error {
+		return errors.New("expected error")
+	}), New(Rate{}, Rate{}), "chat", queue, store).(*limitedConsumer)
+
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	consumer.now = clock.Now
+
+	require.NoError(t, consumer.On(context.Background(), data.Article{ID: "ID"}))
+	for i := 1; i < maxDeliveryAttempts; i++ {
+		clock.now = clock.now.Add(maxRetryDelay)
+		require.NoError(t, consumer.Flush(context.Background()))
+	}
+
+	// The article is moved out of the queue once it runs out of attempts.
+	assert.Empty(t, queue.articles)
+	assert.Empty(t, queue.states)
+	assert.Equal(t, undelivered{
+		Article:  data.Article{ID: "ID"},
+		Error:    "expected error",
+		Attempts: maxDeliveryAttempts,
+		FailedAt: clock.now.UTC(),
+	}, store["id"])
+}
+
+func TestUse_DestinationUnavailable(t *testing.T) {
+	queue := &inMemoryQueue{}
+	consumer := Use(data.ConsumerFunc(func(_ context.Context, _ data.Article) error {
+		return fmt.Errorf("chat: %w", data.ErrUnavailable)
+	}), New(Rate{}, Rate{}), "chat", queue, inMemoryStore{})
+
+	// Sync goes on, while articles wait for the destination to become available again.
+	require.NoError(t, consumer.On(context.Background(), data.Article{ID: "1"}))
+	require.NoError(t, consumer.On(context.Background(), data.Article{ID: "2"}))
+	assert.Len(t, queue.articles, 2)
+}
@@ -0,0 +1,86 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter limits rate of outgoing messages, both globally and per chat.
+type Limiter struct {
+	mutex   sync.Mutex
+	global  *bucket
+	perChat Rate
+	chats   map[string]*bucket
+	now     func() time.Time
+}
+
+// New creates new rate limiter. Zero rates are not limited.
+func New(global, perChat Rate) *Limiter {
+	l := &Limiter{
+		perChat: perChat,
+		chats:   make(map[string]*bucket),
+		now:     time.Now,
+	}
+
+	if !global.IsZero() {
+		l.global = newBucket(global, l.now)
+	}
+
+	return l
+}
+
+// Wait blocks until a message may be sent into the specified chat.
+// Tokens are returned to the limiter if the wait is canceled, since no message is sent then.
+func (l *Limiter) Wait(ctx context.Context, chat string) error {
+	var (
+		delay    time.Duration
+		reserved []*bucket
+	)
+
+	if b := l.chatBucket(chat); b != nil {
+		delay = b.reserve()
+		reserved = append(reserved, b)
+	}
+
+	if l.global != nil {
+		if d := l.global.reserve(); d > delay {
+			delay = d
+		}
+
+		reserved = append(reserved, l.global)
+	}
+
+	err := wait(ctx, delay)
+	if err != nil {
+		for _, b := range reserved {
+			b.refund()
+		}
+	}
+
+	return err
+}
+
+// Allow reports whether a message may be sent into the specified chat right now, without waiting.
+// Global rate isn't checked, since messages that are allowed should still Wait for it.
+func (l *Limiter) Allow(chat string) bool {
+	b := l.chatBucket(chat)
+	return b == nil || b.take()
+}
+
+func (l *Limiter) chatBucket(chat string) *bucket {
+	if l.perChat.IsZero() {
+		return nil
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	b, exists := l.chats[chat]
+	if !exists {
+		b = newBucket(l.perChat, l.now)
+		l.chats[chat] = b
+	}
+
+	return b
+}
@@ -0,0 +1,100 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func TestBucket_Burst(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	b := newBucket(Rate{Count: 3, Period: 3 * time.Second}, clock.Now)
+
+	assert.Equal(t, time.Duration(0), b.reserve())
+	assert.Equal(t, time.Duration(0), b.reserve())
+	assert.Equal(t, time.Duration(0), b.reserve())
+	assert.Equal(t, time.Second, b.reserve())
+	assert.Equal(t, 2*time.Second, b.reserve())
+}
+
+func TestBucket_Refill(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	b := newBucket(Rate{Count: 2, Period: 2 * time.Second}, clock.Now)
+
+	b.reserve()
+	b.reserve()
+	clock.now = clock.now.Add(time.Second)
+
+	assert.Equal(t, time.Duration(0), b.reserve())
+	assert.Equal(t, time.Second, b.reserve())
+
+	clock.now = clock.now.Add(time.Hour)
+
+	assert.Equal(t, time.Duration(0), b.reserve())
+	assert.Equal(t, time.Duration(0), b.reserve())
+	assert.Equal(t, time.Second, b.reserve())
+}
+
+func TestLimiter_PerChat(t *testing.T) {
+	l := New(Rate{}, Rate{Count: 1, Period: time.Hour})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	assert.NoError(t, l.Wait(ctx, "a"))
+	assert.NoError(t, l.Wait(ctx, "b"))
+	assert.ErrorIs(t, l.Wait(ctx, "a"), context.DeadlineExceeded)
+}
+
+func TestLimiter_Global(t *testing.T) {
+	l := New(Rate{Count: 1, Period: time.Hour}, Rate{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	assert.NoError(t, l.Wait(ctx, "a"))
+	assert.ErrorIs(t, l.Wait(ctx, "b"), context.DeadlineExceeded)
+}
+
+func TestLimiter_RefundOnCancel(t *testing.T) {
+	l := New(Rate{}, Rate{Count: 1, Period: time.Hour})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	assert.NoError(t, l.Wait(ctx, "a"))
+	assert.ErrorIs(t, l.Wait(ctx, "a"), context.DeadlineExceeded)
+
+	// Only the token of the delivered message is taken, so the next message waits for one period rather than for two.
+	b := l.chatBucket("a")
+	assert.InDelta(t, time.Hour, b.reserve(), float64(time.Minute))
+}
+
+func TestLimiter_Unlimited(t *testing.T) {
+	l := New(Rate{}, Rate{})
+
+	for i := 0; i < 100; i++ {
+		assert.NoError(t, l.Wait(context.Background(), "a"))
+	}
+}
+
+func TestLimiter_Allow(t *testing.T) {
+	l := New(Rate{Count: 1, Period: time.Hour}, Rate{Count: 2, Period: time.Hour})
+
+	assert.True(t, l.Allow("a"))
+	assert.True(t, l.Allow("a"))
+	assert.False(t, l.Allow("a"))
+	assert.True(t, l.Allow("b"))
+
+	assert.True(t, New(Rate{}, Rate{}).Allow("a"))
+}
@@ -0,0 +1,371 @@
+// Package s3 stores files in a bucket of an S3-compatible object storage, e.g. Amazon S3 or MinIO.
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+
+	"github.com/kapitanov/habrabot/internal/httpclient"
+)
+
+// maxErrorSize limits size of an error response that is read.
+const maxErrorSize = 64 * 1024
+
+// codeNoSuchKey is a code of an error response to a request for a missing object.
+const codeNoSuchKey = "NoSuchKey"
+
+// Server-side encryption modes.
+const (
+	SSES3  = "AES256"  // Objects are encrypted with keys managed by the storage.
+	SSEKMS = "aws:kms" // Objects are encrypted with a KMS key.
+)
+
+// Config configures access to a bucket.
+type Config struct {
+	Endpoint        string // URL of the storage, "https://s3.<region>.amazonaws.com" by default.
+	Region          string // Region of the bucket, "us-east-1" by default.
+	Bucket          string
+	Prefix          string // Prefix of object keys, so a bucket may be shared, e.g. "habrabot/".
+	AccessKeyID     string
+	SecretAccessKey string
+	PathStyle       bool   // If true, bucket is addressed by URL path rather than by host name, as MinIO expects by default.
+	StorageClass    string // Storage class of new objects, e.g. "STANDARD_IA", the default one of the bucket if it's empty.
+	SSE             string // Server-side encryption of new objects, SSES3 or SSEKMS, none if it's empty.
+	SSEKMSKeyID     string // KMS key that objects are encrypted with, the default one of the account if it's empty.
+}
+
+// Client stores files as objects in a bucket.
+// Files are named by slash-separated paths, which are appended to the prefix to make object keys.
+type Client struct {
+	config      Config
+	endpoint    *url.URL
+	credentials credentials
+	httpClient  *retryablehttp.Client
+	now         func() time.Time
+}
+
+// New creates a client of a bucket.
+func New(config Config) (*Client, error) {
+	if config.Bucket == "" {
+		return nil, errors.New("s3 bucket is not specified")
+	}
+
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+
+	if config.Endpoint == "" {
+		config.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", config.Region)
+	}
+
+	switch config.SSE {
+	case "", SSES3, SSEKMS:
+	default:
+		return nil, fmt.Errorf("unknown s3 server-side encryption \"%s\", expected \"%s\" or \"%s\"", config.SSE, SSES3, SSEKMS)
+	}
+
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	if (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("malformed s3 endpoint \"%s\", expected an absolute http(s) url", config.Endpoint)
+	}
+
+	if !config.PathStyle {
+		endpoint.Host = config.Bucket + "." + endpoint.Host
+	}
+
+	config.Prefix = strings.Trim(config.Prefix, "/")
+	if config.Prefix != "" {
+		config.Prefix += "/"
+	}
+
+	httpClient, err := httpclient.New(httpclient.S3Policy)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		config:   config,
+		endpoint: endpoint,
+		credentials: credentials{
+			AccessKeyID:     config.AccessKeyID,
+			SecretAccessKey: config.SecretAccessKey,
+			Region:          config.Region,
+		},
+		httpClient: httpClient,
+		now:        time.Now,
+	}, nil
+}
+
+// String returns a URL of the bucket and the prefix, e.g. "s3://bucket/prefix/".
+func (c *Client) String() string {
+	return "s3://" + c.config.Bucket + "/" + c.config.Prefix
+}
+
+// Read returns content of a file. Error matches fs.ErrNotExist if there's no such file.
+func (c *Client) Read(ctx context.Context, name string) ([]byte, error) {
+	resp, err := c.do(ctx, http.MethodGet, c.key(name), nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	return io.ReadAll(resp.Body)
+}
+
+// Write uploads a file, replacing a previous version of it. Objects are created in a single request,
+// so readers never see a partially written file. Readers that implement io.Seeker are uploaded without buffering.
+func (c *Client) Write(ctx context.Context, name string, r io.Reader) error {
+	body, ok := r.(io.ReadSeeker)
+	if !ok {
+		content, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+
+		body = bytes.NewReader(content)
+	}
+
+	header := make(http.Header)
+	if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+
+	if c.config.StorageClass != "" {
+		header.Set("X-Amz-Storage-Class", c.config.StorageClass)
+	}
+
+	if c.config.SSE != "" {
+		header.Set("X-Amz-Server-Side-Encryption", c.config.SSE)
+	}
+
+	if c.config.SSE == SSEKMS && c.config.SSEKMSKeyID != "" {
+		header.Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", c.config.SSEKMSKeyID)
+	}
+
+	resp, err := c.do(ctx, http.MethodPut, c.key(name), nil, header, body)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+// Exists returns true if a file exists.
+// It requests the first byte of the file rather than its metadata, since responses to HEAD requests have no body,
+// so a missing file can't be told apart from a missing bucket.
+func (c *Client) Exists(ctx context.Context, name string) (bool, error) {
+	header := http.Header{"Range": {"bytes=0-0"}}
+
+	resp, err := c.do(ctx, http.MethodGet, c.key(name), nil, header, nil)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+
+	// Empty files have no bytes to return.
+	var e *Error
+	if errors.As(err, &e) && e.Status == http.StatusRequestedRangeNotSatisfiable {
+		return true, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, resp.Body.Close()
+}
+
+// List returns names of all files within a directory and its subdirectories, in lexical order.
+// All files are listed if dir is empty.
+func (c *Client) List(ctx context.Context, dir string) ([]string, error) {
+	prefix := c.config.Prefix
+	if dir = strings.Trim(dir, "/"); dir != "" {
+		prefix += dir + "/"
+	}
+
+	var (
+		names []string
+		token string
+	)
+
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		var result struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+
+		resp, err := c.do(ctx, http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range result.Contents {
+			names = append(names, strings.TrimPrefix(object.Key, c.config.Prefix))
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+
+		token = result.NextContinuationToken
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
+
+// Remove removes a file. It's not an error if there's no such file.
+func (c *Client) Remove(ctx context.Context, name string) error {
+	resp, err := c.do(ctx, http.MethodDelete, c.key(name), nil, nil, nil)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+// key returns an object key of a file.
+func (c *Client) key(name string) string {
+	return c.config.Prefix + strings.TrimLeft(name, "/")
+}
+
+// do sends a signed request for an object, or for the bucket itself if key is empty.
+// Responses with error statuses are returned as errors.
+func (c *Client) do(
+	ctx context.Context, method, key string, query url.Values, header http.Header, body io.ReadSeeker,
+) (*http.Response, error) {
+	rawPath := "/" + escapePath(key)
+	if c.config.PathStyle {
+		rawPath = "/" + escape(c.config.Bucket, true) + rawPath
+	}
+
+	target, err := url.Parse(c.endpoint.Scheme + "://" + c.endpoint.Host + strings.TrimSuffix(c.endpoint.EscapedPath(), "/") + rawPath)
+	if err != nil {
+		return nil, err
+	}
+
+	target.RawQuery = query.Encode()
+
+	payloadHash := emptyPayloadHash
+	var size int64
+	if body != nil {
+		h := sha256.New()
+		size, err = io.Copy(h, body)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = body.Seek(0, io.SeekStart)
+		if err != nil {
+			return nil, err
+		}
+
+		payloadHash = hex.EncodeToString(h.Sum(nil))
+	}
+
+	// Empty bodies aren't passed, so that the length of the request is known to be zero rather than unknown.
+	var reqBody interface{}
+	if body != nil && size > 0 {
+		reqBody = body
+	}
+
+	req, err := retryablehttp.NewRequestWithContext(ctx, method, target.String(), reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	req.ContentLength = size
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	c.credentials.sign(req.Request, payloadHash, c.now())
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+
+		return nil, readError(resp)
+	}
+
+	return resp, nil
+}
+
+// Error is an error response of the storage.
+type Error struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+// Error returns a description of the error.
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("s3 request failed with status %d", e.Status)
+	}
+
+	return fmt.Sprintf("s3 request failed with status %d: %s: %s", e.Status, e.Code, e.Message)
+}
+
+// Is makes errors of missing objects match fs.ErrNotExist.
+// Other "not found" errors, e.g. of a missing bucket, don't match it.
+func (e *Error) Is(target error) bool {
+	return target == fs.ErrNotExist && e.Code == codeNoSuchKey
+}
+
+func readError(resp *http.Response) error {
+	e := &Error{Status: resp.StatusCode}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxErrorSize))
+	if err == nil && len(body) > 0 {
+		_ = xml.Unmarshal(body, e)
+	}
+
+	return e
+}
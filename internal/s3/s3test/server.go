@@ -0,0 +1,244 @@
+// Package s3test provides a fake S3-compatible object storage server for tests.
+package s3test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/kapitanov/habrabot/internal/s3"
+)
+
+// Credentials that the server accepts.
+const (
+	AccessKeyID     = "test-access-key"
+	SecretAccessKey = "test-secret-key"
+)
+
+// Object is an object that has been uploaded to the fake server.
+type Object struct {
+	Content []byte
+	Header  http.Header // Headers of the upload request that describe the object, e.g. its storage class.
+}
+
+// objectHeaders are headers of upload requests that are kept with objects.
+var objectHeaders = []string{
+	"Content-Type",
+	"X-Amz-Storage-Class",
+	"X-Amz-Server-Side-Encryption",
+	"X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id",
+}
+
+// Server is a fake object storage server with a single bucket, addressed by URL path.
+// It keeps objects in memory and checks that requests are signed by AccessKeyID and carry hashes of their bodies.
+// Signatures themselves are not verified.
+type Server struct {
+	// URL is a base URL of the server, which may be used as an endpoint.
+	URL string
+	// Bucket is a name of the bucket.
+	Bucket string
+	// PageSize is a maximum number of objects in a listing, so clients have to follow continuation tokens.
+	PageSize int
+
+	server  *httptest.Server
+	mutex   sync.Mutex
+	objects map[string]Object
+}
+
+// NewServer starts a fake object storage server with a bucket.
+// Caller should call Close when finished, to shut it down.
+func NewServer(bucket string) *Server {
+	s := &Server{
+		Bucket:   bucket,
+		PageSize: 1000,
+		objects:  make(map[string]Object),
+	}
+
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.server.URL
+
+	return s
+}
+
+// Close shuts down the server.
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// Config returns a configuration of a client of the bucket.
+func (s *Server) Config() s3.Config {
+	return s3.Config{
+		Endpoint:        s.URL,
+		Bucket:          s.Bucket,
+		AccessKeyID:     AccessKeyID,
+		SecretAccessKey: SecretAccessKey,
+		PathStyle:       true,
+	}
+}
+
+// Object returns an object by its key.
+func (s *Server) Object(key string) (Object, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	object, ok := s.objects[key]
+	return object, ok
+}
+
+// Keys returns keys of all objects in lexical order.
+func (s *Server) Keys() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	keys := make([]string, 0, len(s.objects))
+	for key := range s.objects {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential="+AccessKeyID+"/") {
+		writeError(w, http.StatusForbidden, "InvalidAccessKeyId", "The AWS Access Key Id you provided does not exist in our records.")
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != s.Bucket {
+		writeError(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+
+	hash := sha256.Sum256(body)
+	if r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(hash[:]) {
+		writeError(w, http.StatusBadRequest, "XAmzContentSHA256Mismatch", "The provided 'x-amz-content-sha256' header does not match")
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if key == "" {
+		if r.Method != http.MethodGet || r.URL.Query().Get("list-type") != "2" {
+			writeError(w, http.StatusNotImplemented, "NotImplemented", "Only ListObjectsV2 is supported for buckets")
+			return
+		}
+
+		s.list(w, r.URL.Query())
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		if r.ContentLength < 0 {
+			writeError(w, http.StatusLengthRequired, "MissingContentLength", "You must provide the Content-Length HTTP header.")
+			return
+		}
+
+		header := make(http.Header)
+		for _, name := range objectHeaders {
+			if value := r.Header.Get(name); value != "" {
+				header.Set(name, value)
+			}
+		}
+
+		s.objects[key] = Object{Content: body, Header: header}
+
+	case http.MethodGet, http.MethodHead:
+		object, ok := s.objects[key]
+		if !ok {
+			writeError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+			return
+		}
+
+		content := object.Content
+		if r.Method == http.MethodGet && r.Header.Get("Range") == "bytes=0-0" {
+			// Only requests for the first byte of an object are supported.
+			if len(content) == 0 {
+				writeError(w, http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "The requested range is not satisfiable")
+				return
+			}
+
+			content = content[:1]
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-0/%d", len(object.Content)))
+			w.Header().Set("Content-Length", "1")
+			w.WriteHeader(http.StatusPartialContent)
+			_, _ = w.Write(content)
+			return
+		}
+
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(content)
+		}
+
+	case http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed against this resource.")
+	}
+}
+
+type listResult struct {
+	XMLName  xml.Name `xml:"ListBucketResult"`
+	Contents []struct {
+		Key  string
+		Size int
+	}
+	IsTruncated           bool
+	NextContinuationToken string `xml:",omitempty"`
+}
+
+// list lists objects with a prefix, continuation tokens are keys that listings start after.
+func (s *Server) list(w http.ResponseWriter, query url.Values) {
+	var keys []string
+	for key := range s.objects {
+		if strings.HasPrefix(key, query.Get("prefix")) && key > query.Get("continuation-token") {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	var result listResult
+	if len(keys) > s.PageSize {
+		keys = keys[:s.PageSize]
+		result.IsTruncated = true
+		result.NextContinuationToken = keys[len(keys)-1]
+	}
+
+	for _, key := range keys {
+		result.Contents = append(result.Contents, struct {
+			Key  string
+			Size int
+		}{key, len(s.objects[key].Content)})
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(w).Encode(result)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_, _ = fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, message)
+}
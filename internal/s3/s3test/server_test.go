@@ -0,0 +1,131 @@
+package s3test
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kapitanov/habrabot/internal/s3"
+)
+
+func TestClient(t *testing.T) {
+	server := NewServer("archive")
+	defer server.Close()
+	server.PageSize = 2
+
+	cfg := server.Config()
+	cfg.Prefix = "/habrabot/"
+	cfg.StorageClass = "STANDARD_IA"
+	cfg.SSE = s3.SSEKMS
+	cfg.SSEKMSKeyID = "key"
+
+	client, err := s3.New(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, "s3://archive/habrabot/", client.String())
+
+	ctx := context.Background()
+	require.NoError(t, client.Write(ctx, "2022/704622 - Привет+мир.html", strings.NewReader("page")))
+	require.NoError(t, client.Write(ctx, "assets/a.png", strings.NewReader("PNG")))
+	require.NoError(t, client.Write(ctx, "assets/b.png", strings.NewReader("PNG")))
+
+	// Files are uploaded from disk without buffering.
+	path := filepath.Join(t.TempDir(), "index.json")
+	require.NoError(t, os.WriteFile(path, []byte("{}"), 0644))
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer func() {
+		_ = f.Close()
+	}()
+
+	require.NoError(t, client.Write(ctx, "index.json", f))
+
+	object, ok := server.Object("habrabot/2022/704622 - Привет+мир.html")
+	require.True(t, ok)
+	assert.Equal(t, "page", string(object.Content))
+	assert.Equal(t, "text/html; charset=utf-8", object.Header.Get("Content-Type"))
+	assert.Equal(t, "STANDARD_IA", object.Header.Get("X-Amz-Storage-Class"))
+	assert.Equal(t, "aws:kms", object.Header.Get("X-Amz-Server-Side-Encryption"))
+	assert.Equal(t, "key", object.Header.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id"))
+
+	content, err := client.Read(ctx, "2022/704622 - Привет+мир.html")
+	require.NoError(t, err)
+	assert.Equal(t, "page", string(content))
+
+	content, err = client.Read(ctx, "index.json")
+	require.NoError(t, err)
+	assert.Equal(t, "{}", string(content))
+
+	_, err = client.Read(ctx, "missing.html")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	exists, err := client.Exists(ctx, "assets/a.png")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	exists, err = client.Exists(ctx, "missing.html")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	require.NoError(t, client.Write(ctx, "empty.html", strings.NewReader("")))
+	exists, err = client.Exists(ctx, "empty.html")
+	require.NoError(t, err)
+	assert.True(t, exists)
+	require.NoError(t, client.Remove(ctx, "empty.html"))
+
+	names, err := client.List(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"2022/704622 - Привет+мир.html", "assets/a.png", "assets/b.png", "index.json"}, names)
+
+	names, err = client.List(ctx, "assets")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"assets/a.png", "assets/b.png"}, names)
+
+	require.NoError(t, client.Remove(ctx, "assets/a.png"))
+	require.NoError(t, client.Remove(ctx, "assets/a.png"), "missing files are removed silently")
+	assert.Equal(t, []string{"habrabot/2022/704622 - Привет+мир.html", "habrabot/assets/b.png", "habrabot/index.json"}, server.Keys())
+}
+
+func TestClient_Errors(t *testing.T) {
+	server := NewServer("archive")
+	defer server.Close()
+
+	cfg := server.Config()
+	cfg.AccessKeyID = "unknown"
+
+	client, err := s3.New(cfg)
+	require.NoError(t, err)
+
+	err = client.Write(context.Background(), "a.html", strings.NewReader("page"))
+	var e *s3.Error
+	require.ErrorAs(t, err, &e)
+	assert.Equal(t, "InvalidAccessKeyId", e.Code)
+
+	// A missing bucket isn't a missing file.
+	cfg = server.Config()
+	cfg.Bucket = "missing"
+
+	client, err = s3.New(cfg)
+	require.NoError(t, err)
+
+	_, err = client.Read(context.Background(), "a.html")
+	require.ErrorAs(t, err, &e)
+	assert.Equal(t, "NoSuchBucket", e.Code)
+	assert.NotErrorIs(t, err, fs.ErrNotExist)
+
+	_, err = client.Exists(context.Background(), "a.html")
+	assert.Error(t, err)
+
+	assert.Error(t, client.Remove(context.Background(), "a.html"))
+
+	_, err = s3.New(s3.Config{})
+	assert.Error(t, err)
+
+	_, err = s3.New(s3.Config{Bucket: "archive", SSE: "unknown"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,103 @@
+package schedule
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// QuietHours is a daily time window when posts are deferred or sent without notification.
+type QuietHours struct {
+	Start    time.Duration  // Start of the window, as an offset from midnight.
+	End      time.Duration  // End of the window, as an offset from midnight.
+	Location *time.Location // Time zone of the window.
+}
+
+// ParseQuietHours parses a time window in "HH:MM-HH:MM" format.
+// The window may span midnight, e.g. "23:00-08:00".
+func ParseQuietHours(str string, location *time.Location) (QuietHours, error) {
+	if str == "" {
+		return QuietHours{}, nil
+	}
+
+	parts := strings.Split(str, "-")
+	if len(parts) != 2 {
+		return QuietHours{}, fmt.Errorf("malformed quiet hours \"%s\", expected HH:MM-HH:MM", str)
+	}
+
+	start, err := parseTimeOfDay(parts[0])
+	if err != nil {
+		return QuietHours{}, err
+	}
+
+	end, err := parseTimeOfDay(parts[1])
+	if err != nil {
+		return QuietHours{}, err
+	}
+
+	if location == nil {
+		location = time.UTC
+	}
+
+	return QuietHours{
+		Start:    start,
+		End:      end,
+		Location: location,
+	}, nil
+}
+
+func parseTimeOfDay(str string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(str))
+	if err != nil {
+		return 0, fmt.Errorf("malformed time of day \"%s\", expected HH:MM", str)
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// IsZero returns true if quiet hours are not defined.
+func (q QuietHours) IsZero() bool {
+	return q.Start == q.End
+}
+
+// Contains returns true if the time is within quiet hours.
+func (q QuietHours) Contains(t time.Time) bool {
+	if q.IsZero() {
+		return false
+	}
+
+	offset := q.offset(t)
+	if q.Start < q.End {
+		return offset >= q.Start && offset < q.End
+	}
+
+	return offset >= q.Start || offset < q.End
+}
+
+// EndAfter returns the nearest end of quiet hours after the specified time.
+// The end is a time of day in the time zone of quiet hours, so it's correct on days when clocks are changed.
+func (q QuietHours) EndAfter(t time.Time) time.Time {
+	local := t.In(q.Location)
+
+	end := q.on(local, q.End)
+	if !end.After(t) {
+		end = q.on(local.AddDate(0, 0, 1), q.End)
+	}
+
+	return end
+}
+
+// on returns the time of day, as an offset from midnight, on the date of the specified time.
+func (q QuietHours) on(date time.Time, offset time.Duration) time.Time {
+	hour := int(offset / time.Hour)
+	minute := int(offset % time.Hour / time.Minute)
+
+	return time.Date(date.Year(), date.Month(), date.Day(), hour, minute, 0, 0, q.Location)
+}
+
+// offset returns the time of day of the specified time, as it's shown by wall clocks in the time zone of quiet hours.
+func (q QuietHours) offset(t time.Time) time.Duration {
+	hour, minute, second := t.In(q.Location).Clock()
+	return time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute + time.Duration(second)*time.Second +
+		time.Duration(t.Nanosecond())
+}
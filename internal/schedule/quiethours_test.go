@@ -0,0 +1,108 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseQuietHours(t *testing.T) {
+	q, err := ParseQuietHours("23:30-08:00", time.UTC)
+
+	require.NoError(t, err)
+	assert.Equal(t, 23*time.Hour+30*time.Minute, q.Start)
+	assert.Equal(t, 8*time.Hour, q.End)
+}
+
+func TestParseQuietHours_Empty(t *testing.T) {
+	q, err := ParseQuietHours("", time.UTC)
+
+	require.NoError(t, err)
+	assert.True(t, q.IsZero())
+	assert.False(t, q.Contains(time.Now()))
+}
+
+func TestParseQuietHours_Malformed(t *testing.T) {
+	for _, str := range []string{"23:00", "23:00-", "25:00-08:00", "foo-bar"} {
+		_, err := ParseQuietHours(str, time.UTC)
+		assert.Error(t, err, str)
+	}
+}
+
+func TestQuietHours_Contains(t *testing.T) {
+	overnight, err := ParseQuietHours("23:00-08:00", time.UTC)
+	require.NoError(t, err)
+
+	daytime, err := ParseQuietHours("12:00-14:00", time.UTC)
+	require.NoError(t, err)
+
+	at := func(hour, minute int) time.Time {
+		return time.Date(2023, 1, 1, hour, minute, 0, 0, time.UTC)
+	}
+
+	assert.True(t, overnight.Contains(at(23, 0)))
+	assert.True(t, overnight.Contains(at(3, 0)))
+	assert.False(t, overnight.Contains(at(8, 0)))
+	assert.False(t, overnight.Contains(at(15, 0)))
+
+	assert.True(t, daytime.Contains(at(12, 0)))
+	assert.True(t, daytime.Contains(at(13, 59)))
+	assert.False(t, daytime.Contains(at(14, 0)))
+	assert.False(t, daytime.Contains(at(3, 0)))
+}
+
+func TestQuietHours_TimeZone(t *testing.T) {
+	location := time.FixedZone("UTC+3", 3*60*60)
+	q, err := ParseQuietHours("23:00-08:00", location)
+	require.NoError(t, err)
+
+	assert.True(t, q.Contains(time.Date(2023, 1, 1, 21, 0, 0, 0, time.UTC)))
+	assert.False(t, q.Contains(time.Date(2023, 1, 1, 6, 0, 0, 0, time.UTC)))
+}
+
+func TestQuietHours_EndAfter(t *testing.T) {
+	q, err := ParseQuietHours("23:00-08:00", time.UTC)
+	require.NoError(t, err)
+
+	assert.Equal(
+		t,
+		time.Date(2023, 1, 2, 8, 0, 0, 0, time.UTC),
+		q.EndAfter(time.Date(2023, 1, 1, 23, 30, 0, 0, time.UTC)),
+	)
+	assert.Equal(
+		t,
+		time.Date(2023, 1, 2, 8, 0, 0, 0, time.UTC),
+		q.EndAfter(time.Date(2023, 1, 2, 2, 0, 0, 0, time.UTC)),
+	)
+}
+
+func TestQuietHours_DaylightSaving(t *testing.T) {
+	location, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+
+	q, err := ParseQuietHours("01:00-08:00", location)
+	require.NoError(t, err)
+
+	// Clocks are moved forward from 02:00 to 03:00 on March 26, 2023, so the day is 23 hours long.
+	assert.Equal(
+		t,
+		time.Date(2023, 3, 26, 8, 0, 0, 0, location),
+		q.EndAfter(time.Date(2023, 3, 26, 1, 30, 0, 0, location)),
+	)
+	assert.True(t, q.Contains(time.Date(2023, 3, 26, 7, 30, 0, 0, location)))
+	assert.False(t, q.Contains(time.Date(2023, 3, 26, 8, 30, 0, 0, location)))
+
+	// Clocks are moved back from 03:00 to 02:00 on October 29, 2023, so the day is 25 hours long.
+	assert.Equal(
+		t,
+		time.Date(2023, 10, 29, 8, 0, 0, 0, location),
+		q.EndAfter(time.Date(2023, 10, 29, 1, 30, 0, 0, location)),
+	)
+	assert.Equal(
+		t,
+		time.Date(2023, 10, 30, 8, 0, 0, 0, location),
+		q.EndAfter(time.Date(2023, 10, 29, 23, 30, 0, 0, location)),
+	)
+}
@@ -0,0 +1,269 @@
+package schedule
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/kapitanov/habrabot/internal/data"
+)
+
+// QuietMode defines what happens to posts during quiet hours.
+type QuietMode int
+
+const (
+	QuietModeDefer  QuietMode = iota // Posts are deferred until quiet hours end.
+	QuietModeSilent                  // Posts are sent without notification.
+)
+
+// ParseQuietMode parses quiet mode name.
+func ParseQuietMode(str string) (QuietMode, error) {
+	switch str {
+	case "", "defer":
+		return QuietModeDefer, nil
+	case "silent":
+		return QuietModeSilent, nil
+	default:
+		return QuietModeDefer, fmt.Errorf("unknown quiet mode \"%s\", expected \"defer\" or \"silent\"", str)
+	}
+}
+
+// Policy defines when scheduled posts are delivered.
+type Policy struct {
+	MinInterval time.Duration // Minimum interval between two posts.
+	QuietHours  QuietHours    // Daily window when posts are deferred or sent silently.
+	QuietMode   QuietMode     // What happens to posts during quiet hours.
+}
+
+// Outbox is a persistent queue of scheduled articles.
+type Outbox interface {
+	// Push appends an article to the end of the queue.
+	Push(article data.Article) error
+
+	// Peek returns the first article of the queue without removing it.
+	Peek() (data.Article, bool, error)
+
+	// Remove removes an article from the queue.
+	Remove(id string) error
+}
+
+// Store is a persistent key-value storage of scheduler state.
+type Store interface {
+	// Get loads a value by its key. It returns false if the key doesn't exist.
+	Get(key string, value interface{}) (bool, error)
+
+	// Put stores a value by its key.
+	Put(key string, value interface{}) error
+}
+
+const (
+	idleDelay  = time.Hour
+	retryDelay = time.Minute
+
+	lastPostKey = "last_post"
+)
+
+// Scheduler is a consumer that puts articles into an outbox
+// and delivers them to the downstream consumer according to the policy.
+type Scheduler struct {
+	consumer data.Consumer
+	policy   Policy
+	outbox   Outbox
+	store    Store
+	wakeup   chan struct{}
+	now      func() time.Time
+
+	mutex    sync.Mutex
+	lastPost time.Time
+	loaded   bool
+	paused   bool
+}
+
+// New creates new scheduler.
+// Scheduled articles are delivered only while Run method is running.
+// The time of the last post is kept in the store, so the minimum interval is preserved across restarts.
+// Articles are removed from the outbox once the downstream consumer accepts them, so it should keep articles
+// that fail to be delivered, as the rate-limited consumer does, rather than return errors for them.
+func New(consumer data.Consumer, policy Policy, outbox Outbox, store Store) *Scheduler {
+	log.Info().
+		Dur("min_interval", policy.MinInterval).
+		Bool("quiet_hours", !policy.QuietHours.IsZero()).
+		Msg("will schedule posts")
+
+	return &Scheduler{
+		consumer: consumer,
+		policy:   policy,
+		outbox:   outbox,
+		store:    store,
+		wakeup:   make(chan struct{}, 1),
+		now:      time.Now,
+	}
+}
+
+// On method is invoked when an article is received from the feed.
+func (s *Scheduler) On(_ context.Context, article data.Article) error {
+	err := s.outbox.Push(article)
+	if err != nil {
+		log.Error().Err(err).Str("id", article.ID).Msg("unable to schedule feed item")
+		return err
+	}
+
+	s.notify()
+	return nil
+}
+
+// Flush wakes up the scheduler so it delivers articles that are due,
+// and flushes the downstream consumer, so it retries articles that it has accepted before.
+func (s *Scheduler) Flush(ctx context.Context) error {
+	s.notify()
+	return data.Flush(ctx, s.consumer)
+}
+
+// Pause stops delivery of scheduled articles, they are kept in the outbox until Resume is called.
+func (s *Scheduler) Pause() {
+	s.mutex.Lock()
+	s.paused = true
+	s.mutex.Unlock()
+
+	log.Info().Msg("scheduler has been paused")
+}
+
+// Resume restarts delivery of scheduled articles after Pause.
+func (s *Scheduler) Resume() {
+	s.mutex.Lock()
+	s.paused = false
+	s.mutex.Unlock()
+
+	log.Info().Msg("scheduler has been resumed")
+	s.notify()
+}
+
+// IsPaused returns true if delivery of scheduled articles is paused.
+func (s *Scheduler) IsPaused() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.paused
+}
+
+// Run delivers scheduled articles until the context is canceled.
+func (s *Scheduler) Run(ctx context.Context) {
+	for {
+		delay := s.deliver(ctx)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-s.wakeup:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+func (s *Scheduler) notify() {
+	select {
+	case s.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// deliver sends all articles that are due, and returns a delay before the next one.
+func (s *Scheduler) deliver(ctx context.Context) time.Duration {
+	for {
+		if s.IsPaused() {
+			return idleDelay
+		}
+
+		article, found, err := s.outbox.Peek()
+		if err != nil {
+			log.Error().Err(err).Msg("unable to read scheduled feed items")
+			return retryDelay
+		}
+
+		if !found {
+			return idleDelay
+		}
+
+		now := s.now()
+		due, silent := s.nextSlot(now)
+		if due.After(now) {
+			return due.Sub(now)
+		}
+
+		err = s.consumer.On(data.WithSilentDelivery(ctx, silent), article)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Error().Err(err).Str("id", article.ID).Msg("unable to deliver scheduled feed item")
+			}
+
+			return retryDelay
+		}
+
+		s.setLastPost(now)
+
+		err = s.outbox.Remove(article.ID)
+		if err != nil {
+			log.Error().Err(err).Str("id", article.ID).Msg("unable to remove delivered feed item from outbox")
+			return retryDelay
+		}
+	}
+}
+
+// nextSlot returns the earliest time the next post may be delivered at,
+// and whether it should be delivered without notification.
+func (s *Scheduler) nextSlot(now time.Time) (time.Time, bool) {
+	due := s.getLastPost().Add(s.policy.MinInterval)
+
+	if due.Before(now) {
+		due = now
+	}
+
+	if !s.policy.QuietHours.Contains(due) {
+		return due, false
+	}
+
+	if s.policy.QuietMode == QuietModeSilent {
+		return due, true
+	}
+
+	return s.policy.QuietHours.EndAfter(due), false
+}
+
+// getLastPost returns the time of the last post, it's loaded from the store once.
+func (s *Scheduler) getLastPost() time.Time {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.loaded {
+		var lastPost time.Time
+		_, err := s.store.Get(lastPostKey, &lastPost)
+		if err != nil {
+			log.Error().Err(err).Msg("unable to load time of last post")
+			return s.lastPost
+		}
+
+		s.lastPost = lastPost
+		s.loaded = true
+	}
+
+	return s.lastPost
+}
+
+// setLastPost updates the time of the last post, both in memory and in the store.
+func (s *Scheduler) setLastPost(t time.Time) {
+	s.mutex.Lock()
+	s.lastPost = t
+	s.loaded = true
+	s.mutex.Unlock()
+
+	err := s.store.Put(lastPostKey, t.UTC())
+	if err != nil {
+		log.Error().Err(err).Msg("unable to store time of last post")
+	}
+}
@@ -0,0 +1,205 @@
+package schedule
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kapitanov/habrabot/internal/data"
+)
+
+type inMemoryOutbox struct {
+	articles []data.Article
+}
+
+func (q *inMemoryOutbox) Push(article data.Article) error {
+	q.articles = append(q.articles, article)
+	return nil
+}
+
+func (q *inMemoryOutbox) Peek() (data.Article, bool, error) {
+	if len(q.articles) == 0 {
+		return data.Article{}, false, nil
+	}
+
+	return q.articles[0], true, nil
+}
+
+func (q *inMemoryOutbox) Remove(id string) error {
+	for i, a := range q.articles {
+		if a.ID == id {
+			q.articles = append(q.articles[:i], q.articles[i+1:]...)
+			return nil
+		}
+	}
+
+	return nil
+}
+
+type inMemoryStore struct {
+	values map[string][]byte
+}
+
+func newInMemoryStore() *inMemoryStore {
+	return &inMemoryStore{values: make(map[string][]byte)}
+}
+
+func (s *inMemoryStore) Get(key string, value interface{}) (bool, error) {
+	raw, ok := s.values[key]
+	if !ok {
+		return false, nil
+	}
+
+	return true, json.Unmarshal(raw, value)
+}
+
+func (s *inMemoryStore) Put(key string, value interface{}) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	s.values[key] = raw
+	return nil
+}
+
+type delivery struct {
+	ID     string
+	Silent bool
+}
+
+type recordingConsumer struct {
+	deliveries []delivery
+}
+
+func (c *recordingConsumer) On(ctx context.Context, article data.Article) error {
+	c.deliveries = append(c.deliveries, delivery{ID: article.ID, Silent: data.IsSilentDelivery(ctx)})
+	return nil
+}
+
+func newTestScheduler(policy Policy, now time.Time) (*Scheduler, *recordingConsumer, *inMemoryOutbox) {
+	consumer := &recordingConsumer{}
+	outbox := &inMemoryOutbox{}
+
+	s := New(consumer, policy, outbox, newInMemoryStore())
+	s.now = func() time.Time { return now }
+
+	return s, consumer, outbox
+}
+
+func TestScheduler_MinInterval(t *testing.T) {
+	now := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	s, consumer, outbox := newTestScheduler(Policy{MinInterval: 10 * time.Minute}, now)
+
+	for _, id := range []string{"1", "2", "3"} {
+		require.NoError(t, s.On(context.Background(), data.Article{ID: id}))
+	}
+
+	delay := s.deliver(context.Background())
+
+	assert.Equal(t, 10*time.Minute, delay)
+	assert.Equal(t, []delivery{{ID: "1"}}, consumer.deliveries)
+	assert.Len(t, outbox.articles, 2)
+
+	s.now = func() time.Time { return now.Add(10 * time.Minute) }
+	s.deliver(context.Background())
+
+	assert.Equal(t, []delivery{{ID: "1"}, {ID: "2"}}, consumer.deliveries)
+}
+
+func TestScheduler_Pause(t *testing.T) {
+	now := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	s, consumer, outbox := newTestScheduler(Policy{}, now)
+
+	require.NoError(t, s.On(context.Background(), data.Article{ID: "1"}))
+
+	s.Pause()
+	assert.True(t, s.IsPaused())
+	assert.Equal(t, idleDelay, s.deliver(context.Background()))
+	assert.Empty(t, consumer.deliveries)
+	assert.Len(t, outbox.articles, 1)
+
+	s.Resume()
+	assert.False(t, s.IsPaused())
+	s.deliver(context.Background())
+	assert.Equal(t, []delivery{{ID: "1"}}, consumer.deliveries)
+	assert.Empty(t, outbox.articles)
+}
+
+func TestScheduler_QuietHoursDefer(t *testing.T) {
+	quietHours, err := ParseQuietHours("23:00-08:00", time.UTC)
+	require.NoError(t, err)
+
+	now := time.Date(2023, 1, 1, 23, 30, 0, 0, time.UTC)
+	s, consumer, _ := newTestScheduler(Policy{QuietHours: quietHours, QuietMode: QuietModeDefer}, now)
+
+	require.NoError(t, s.On(context.Background(), data.Article{ID: "1"}))
+	delay := s.deliver(context.Background())
+
+	assert.Equal(t, 8*time.Hour+30*time.Minute, delay)
+	assert.Empty(t, consumer.deliveries)
+}
+
+func TestScheduler_QuietHoursSilent(t *testing.T) {
+	quietHours, err := ParseQuietHours("23:00-08:00", time.UTC)
+	require.NoError(t, err)
+
+	now := time.Date(2023, 1, 1, 23, 30, 0, 0, time.UTC)
+	s, consumer, _ := newTestScheduler(Policy{QuietHours: quietHours, QuietMode: QuietModeSilent}, now)
+
+	require.NoError(t, s.On(context.Background(), data.Article{ID: "1"}))
+	s.deliver(context.Background())
+
+	assert.Equal(t, []delivery{{ID: "1", Silent: true}}, consumer.deliveries)
+}
+
+func TestScheduler_ConsumerError(t *testing.T) {
+	outbox := &inMemoryOutbox{}
+	s := New(data.ConsumerFunc(func(_ context.Context, _ data.Article) error {
+		return errors.New("expected error")
+	}), Policy{}, outbox, newInMemoryStore())
+
+	require.NoError(t, s.On(context.Background(), data.Article{ID: "1"}))
+	delay := s.deliver(context.Background())
+
+	assert.Equal(t, retryDelay, delay)
+	assert.Len(t, outbox.articles, 1)
+}
+
+func TestScheduler_LastPostPersisted(t *testing.T) {
+	now := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	store := newInMemoryStore()
+	policy := Policy{MinInterval: 10 * time.Minute}
+
+	s := New(&recordingConsumer{}, policy, &inMemoryOutbox{}, store)
+	s.now = func() time.Time { return now }
+	require.NoError(t, s.On(context.Background(), data.Article{ID: "1"}))
+	s.deliver(context.Background())
+
+	// A restarted scheduler keeps the interval after the post delivered before the restart.
+	consumer := &recordingConsumer{}
+	restarted := New(consumer, policy, &inMemoryOutbox{}, store)
+	restarted.now = func() time.Time { return now.Add(time.Minute) }
+	require.NoError(t, restarted.On(context.Background(), data.Article{ID: "2"}))
+
+	assert.Equal(t, 9*time.Minute, restarted.deliver(context.Background()))
+	assert.Empty(t, consumer.deliveries)
+}
+
+func TestParseQuietMode(t *testing.T) {
+	mode, err := ParseQuietMode("silent")
+	require.NoError(t, err)
+	assert.Equal(t, QuietModeSilent, mode)
+
+	mode, err = ParseQuietMode("")
+	require.NoError(t, err)
+	assert.Equal(t, QuietModeDefer, mode)
+
+	_, err = ParseQuietMode("loud")
+	assert.Error(t, err)
+}
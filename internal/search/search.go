@@ -0,0 +1,271 @@
+// Package search provides a full-text index of archived articles.
+package search
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/custom"
+	"github.com/blevesearch/bleve/v2/analysis/lang/en"
+	"github.com/blevesearch/bleve/v2/analysis/lang/ru"
+	"github.com/blevesearch/bleve/v2/analysis/token/lowercase"
+	"github.com/blevesearch/bleve/v2/analysis/tokenizer/unicode"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/rs/zerolog/log"
+
+	"github.com/kapitanov/habrabot/internal/data"
+)
+
+// analyzerName is a name of the analyzer of article texts, which are written either in Russian or in English.
+// Stemmers of both languages are applied to each word, since each of them leaves words of the other language intact.
+const analyzerName = "ru_en"
+
+// lockTimeout limits how long an index that is being used by another process is waited for,
+// e.g. when it's searched from command line while the bot has it opened.
+const lockTimeout = "10s"
+
+// MaxLimit is a maximum number of results that a search returns.
+const MaxLimit = 100
+
+// Fields of indexed documents.
+const (
+	fieldTitle  = "title"
+	fieldAuthor = "author"
+	fieldTags   = "tags"
+	fieldText   = "text"
+	fieldURL    = "url"
+	fieldTime   = "time"
+)
+
+// ErrEmptyQuery is returned when a search query has no terms.
+var ErrEmptyQuery = errors.New("search query is empty")
+
+// errReadOnly is returned when articles are added to an index that is opened for reading.
+var errReadOnly = errors.New("search index is opened for reading")
+
+// Index is a full-text index of articles stored in a local directory.
+// The index is opened on first use and is kept open until it's closed,
+// and only one process may have it opened for writing at a time.
+type Index struct {
+	path     string
+	readOnly bool
+
+	mutex sync.Mutex
+	index bleve.Index // Index is nil until it's opened.
+}
+
+// New creates an accessor to a full-text index in a directory.
+// The index is created once the first article is added to it.
+func New(path string) *Index {
+	return &Index{path: path}
+}
+
+// NewReadOnly creates an accessor to a full-text index in a directory that is only searched,
+// e.g. by a maintenance command.
+func NewReadOnly(path string) *Index {
+	return &Index{path: path, readOnly: true}
+}
+
+// Result is an article that matches a search query.
+type Result struct {
+	ID    string
+	Title string
+	URL   string
+	Time  time.Time
+	Score float64
+}
+
+// Results are articles that match a search query, best matches first.
+type Results struct {
+	Total uint64 // Number of all matching articles, which may be larger than the number of hits.
+	Hits  []Result
+}
+
+// document is an article as it's indexed.
+type document struct {
+	Title  string    `json:"title"`
+	Author string    `json:"author"`
+	Tags   []string  `json:"tags"`
+	Text   string    `json:"text"`
+	URL    string    `json:"url"`
+	Time   time.Time `json:"time"`
+}
+
+// Index adds an article along with the text of its web page to the index, replacing its previous version.
+func (i *Index) Index(article data.Article, text string) error {
+	if i.readOnly {
+		return errReadOnly
+	}
+
+	index, err := i.get(true)
+	if err != nil {
+		return err
+	}
+
+	return index.Index(article.ID, document{
+		Title:  article.Title,
+		Author: article.Author,
+		Tags:   article.Tags,
+		Text:   text,
+		URL:    article.LinkURL,
+		Time:   article.Time.UTC(),
+	})
+}
+
+// Search returns up to limit articles that match a query, best matches first.
+// Queries follow Bleve query string syntax: words, "phrases", +required and -excluded words, and fields, e.g. tags:go.
+func (i *Index) Search(query string, limit int) (Results, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return Results{}, ErrEmptyQuery
+	}
+
+	if limit <= 0 || limit > MaxLimit {
+		limit = MaxLimit
+	}
+
+	index, err := i.get(false)
+	if errors.Is(err, bleve.ErrorIndexPathDoesNotExist) {
+		// Nothing has been indexed yet.
+		return Results{}, nil
+	}
+
+	if err != nil {
+		return Results{}, err
+	}
+
+	request := bleve.NewSearchRequestOptions(bleve.NewQueryStringQuery(query), limit, 0, false)
+	request.Fields = []string{fieldTitle, fieldURL, fieldTime}
+
+	response, err := index.Search(request)
+	if err != nil {
+		return Results{}, err
+	}
+
+	results := Results{Total: response.Total}
+	for _, hit := range response.Hits {
+		result := Result{ID: hit.ID, Score: hit.Score}
+		result.Title, _ = hit.Fields[fieldTitle].(string)
+		result.URL, _ = hit.Fields[fieldURL].(string)
+		if str, ok := hit.Fields[fieldTime].(string); ok {
+			result.Time, _ = time.Parse(time.RFC3339, str)
+		}
+
+		results.Hits = append(results.Hits, result)
+	}
+
+	return results, nil
+}
+
+// Close closes the index, if it has been opened.
+func (i *Index) Close() error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	if i.index == nil {
+		return nil
+	}
+
+	err := i.index.Close()
+	i.index = nil
+	return err
+}
+
+// get returns the index, opening it on first use. The index is created if it doesn't exist, unless create is false.
+func (i *Index) get(create bool) (bleve.Index, error) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	if i.index != nil {
+		return i.index, nil
+	}
+
+	index, err := i.open(create)
+	if err != nil {
+		return nil, err
+	}
+
+	i.index = index
+	return index, nil
+}
+
+// open opens the index, it's created if it doesn't exist and create is true.
+func (i *Index) open(create bool) (bleve.Index, error) {
+	path, err := filepath.Abs(i.path)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := bleve.OpenUsing(path, map[string]interface{}{
+		"bolt_timeout": lockTimeout,
+		"read_only":    i.readOnly,
+	})
+	if !errors.Is(err, bleve.ErrorIndexPathDoesNotExist) || !create {
+		return index, err
+	}
+
+	indexMapping, err := newMapping()
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info().Str("path", path).Msg("creating search index")
+	return bleve.New(path, indexMapping)
+}
+
+// newMapping returns a mapping of indexed documents.
+func newMapping() (mapping.IndexMapping, error) {
+	m := bleve.NewIndexMapping()
+	err := m.AddCustomAnalyzer(analyzerName, map[string]interface{}{
+		"type":      custom.Name,
+		"tokenizer": unicode.Name,
+		"token_filters": []string{
+			lowercase.Name,
+			ru.StopName,
+			en.StopName,
+			ru.SnowballStemmerName,
+			en.SnowballStemmerName,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	m.DefaultAnalyzer = analyzerName
+
+	doc := bleve.NewDocumentStaticMapping()
+	doc.AddFieldMappingsAt(fieldTitle, textField(true))
+	doc.AddFieldMappingsAt(fieldAuthor, textField(false))
+	doc.AddFieldMappingsAt(fieldTags, textField(false))
+	doc.AddFieldMappingsAt(fieldText, textField(false))
+
+	// URLs and times are stored for results, but aren't searched.
+	url := bleve.NewTextFieldMapping()
+	url.Index = false
+	url.IncludeInAll = false
+	doc.AddFieldMappingsAt(fieldURL, url)
+
+	timestamp := bleve.NewDateTimeFieldMapping()
+	timestamp.IncludeInAll = false
+	doc.AddFieldMappingsAt(fieldTime, timestamp)
+
+	m.DefaultMapping = doc
+	return m, nil
+}
+
+func textField(store bool) *mapping.FieldMapping {
+	field := bleve.NewTextFieldMapping()
+	field.Analyzer = analyzerName
+	field.Store = store
+	return field
+}
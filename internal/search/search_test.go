@@ -0,0 +1,100 @@
+package search
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kapitanov/habrabot/internal/data"
+)
+
+func TestIndex(t *testing.T) {
+	index := New(filepath.Join(t.TempDir(), "search"))
+	defer func() {
+		assert.NoError(t, index.Close())
+	}()
+
+	results, err := index.Search("go", 10)
+	require.NoError(t, err)
+	assert.Zero(t, results.Total, "missing index has no results")
+
+	published := time.Date(2022, 10, 19, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, index.Index(data.Article{
+		ID:      "https://habr.com/ru/post/1/",
+		Title:   "Оптимизация запросов в PostgreSQL",
+		Tags:    []string{"postgresql"},
+		LinkURL: "https://habr.com/ru/post/1/",
+		Time:    published,
+	}, "Мы расскажем, как ускорили медленные запросы к базе данных."))
+	require.NoError(t, index.Index(data.Article{
+		ID:      "https://habr.com/ru/post/2/",
+		Title:   "Writing fast Go programs",
+		Tags:    []string{"go"},
+		LinkURL: "https://habr.com/ru/post/2/",
+	}, "Profiling and benchmarking of running services."))
+
+	tests := []struct {
+		query string
+		ids   []string
+	}{
+		{"запрос", []string{"https://habr.com/ru/post/1/"}},         // Russian stemming.
+		{"медленный базы", []string{"https://habr.com/ru/post/1/"}}, // Other word forms.
+		{"benchmark", []string{"https://habr.com/ru/post/2/"}},      // English stemming.
+		{"program runs", []string{"https://habr.com/ru/post/2/"}},
+		{"tags:go", []string{"https://habr.com/ru/post/2/"}},
+		{"\"ускорили медленные\"", []string{"https://habr.com/ru/post/1/"}},
+		{"kubernetes", nil},
+	}
+
+	for _, tt := range tests {
+		results, err := index.Search(tt.query, 10)
+		require.NoError(t, err, tt.query)
+
+		var ids []string
+		for _, hit := range results.Hits {
+			ids = append(ids, hit.ID)
+		}
+
+		assert.Equal(t, tt.ids, ids, tt.query)
+	}
+
+	results, err = index.Search("оптимизация", 10)
+	require.NoError(t, err)
+	require.Len(t, results.Hits, 1)
+	assert.Equal(t, "Оптимизация запросов в PostgreSQL", results.Hits[0].Title)
+	assert.Equal(t, "https://habr.com/ru/post/1/", results.Hits[0].URL)
+	assert.True(t, published.Equal(results.Hits[0].Time))
+
+	// Articles are replaced once they are indexed again.
+	require.NoError(t, index.Index(data.Article{ID: "https://habr.com/ru/post/2/", Title: "Rust"}, ""))
+
+	results, err = index.Search("go", 10)
+	require.NoError(t, err)
+	assert.Zero(t, results.Total)
+
+	_, err = index.Search(" ", 10)
+	assert.ErrorIs(t, err, ErrEmptyQuery)
+}
+
+func TestIndex_ReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "search")
+
+	readOnly := NewReadOnly(path)
+	results, err := readOnly.Search("go", 10)
+	require.NoError(t, err)
+	assert.Zero(t, results.Total, "missing index has no results")
+
+	index := New(path)
+	require.NoError(t, index.Index(data.Article{ID: "1", Title: "Writing fast Go programs"}, ""))
+	require.NoError(t, index.Close())
+
+	results, err = readOnly.Search("go", 10)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), results.Total)
+
+	assert.ErrorIs(t, readOnly.Index(data.Article{ID: "2", Title: "Rust"}, ""), errReadOnly)
+	require.NoError(t, readOnly.Close())
+}
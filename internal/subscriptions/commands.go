@@ -0,0 +1,191 @@
+package subscriptions
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/rs/zerolog/log"
+)
+
+const maxFilters = 50
+
+const helpText = `Subscribe to articles and get them in this chat:
+/subscribe tag:NAME - subscribe to a tag
+/subscribe author:NAME - subscribe to an author
+/unsubscribe FILTER - remove a subscription
+/unsubscribe - remove all subscriptions
+/subscriptions - list your subscriptions`
+
+// HandleUpdate executes subscription commands sent in private chats.
+func (n *Notifier) HandleUpdate(ctx context.Context, bot *tgbotapi.BotAPI, update tgbotapi.Update) {
+	n.handleUpdate(ctx, bot, update)
+}
+
+func (n *Notifier) handleUpdate(_ context.Context, bot messenger, update tgbotapi.Update) {
+	msg := update.Message
+	if msg == nil || !msg.IsCommand() || !msg.Chat.IsPrivate() {
+		return
+	}
+
+	text, ok := n.execute(msg.Chat.ID, msg.Command(), strings.TrimSpace(msg.CommandArguments()))
+	if !ok {
+		// Other commands are left for other handlers.
+		return
+	}
+
+	reply := tgbotapi.NewMessage(msg.Chat.ID, text)
+	reply.DisableWebPagePreview = true
+	reply.ReplyToMessageID = msg.MessageID
+
+	_, err := bot.Send(reply)
+	if err != nil {
+		log.Error().Err(err).Int64("chat", msg.Chat.ID).Msg("unable to reply to subscription command")
+	}
+}
+
+// execute runs a command and returns a reply text.
+// It returns false if the command isn't a subscription command.
+func (n *Notifier) execute(chatID int64, command, args string) (string, bool) {
+	switch command {
+	case "start":
+		return helpText, true
+	case "subscribe":
+		return n.subscribeCommand(chatID, args), true
+	case "unsubscribe":
+		return n.unsubscribeCommand(chatID, args), true
+	case "subscriptions":
+		return n.subscriptionsCommand(chatID), true
+	default:
+		return "", false
+	}
+}
+
+func (n *Notifier) subscribeCommand(chatID int64, args string) string {
+	if args == "" {
+		return "Usage: /subscribe tag:NAME or /subscribe author:NAME"
+	}
+
+	filter, err := ParseFilter(args)
+	if err != nil {
+		return fmt.Sprintf("Error: %v", err)
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	s, err := n.subscriber(chatID)
+	if err != nil {
+		return replyError("unable to load subscriptions", err)
+	}
+
+	if s.index(filter) >= 0 {
+		return fmt.Sprintf("You are already subscribed to %s.", filter)
+	}
+
+	if len(s.Filters) >= maxFilters {
+		return fmt.Sprintf("You can't have more than %d subscriptions.", maxFilters)
+	}
+
+	s.Filters = append(s.Filters, filter)
+
+	err = n.store.Put(subscriberKey(chatID), s)
+	if err != nil {
+		return replyError("unable to store subscriptions", err)
+	}
+
+	log.Info().Int64("chat", chatID).Str("filter", filter.String()).Msg("user has subscribed")
+	return fmt.Sprintf("You have subscribed to %s.", filter)
+}
+
+func (n *Notifier) unsubscribeCommand(chatID int64, args string) string {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if args == "" {
+		err := n.store.Delete(subscriberKey(chatID))
+		if err != nil {
+			return replyError("unable to remove subscriptions", err)
+		}
+
+		log.Info().Int64("chat", chatID).Msg("user has unsubscribed from everything")
+		return "You have unsubscribed from everything."
+	}
+
+	filter, err := ParseFilter(args)
+	if err != nil {
+		return fmt.Sprintf("Error: %v", err)
+	}
+
+	s, err := n.subscriber(chatID)
+	if err != nil {
+		return replyError("unable to load subscriptions", err)
+	}
+
+	i := s.index(filter)
+	if i < 0 {
+		return fmt.Sprintf("You aren't subscribed to %s.", filter)
+	}
+
+	s.Filters = append(s.Filters[:i], s.Filters[i+1:]...)
+
+	if len(s.Filters) == 0 {
+		err = n.store.Delete(subscriberKey(chatID))
+	} else {
+		err = n.store.Put(subscriberKey(chatID), s)
+	}
+
+	if err != nil {
+		return replyError("unable to store subscriptions", err)
+	}
+
+	log.Info().Int64("chat", chatID).Str("filter", filter.String()).Msg("user has unsubscribed")
+	return fmt.Sprintf("You have unsubscribed from %s.", filter)
+}
+
+func (n *Notifier) subscriptionsCommand(chatID int64) string {
+	s, err := n.subscriber(chatID)
+	if err != nil {
+		return replyError("unable to load subscriptions", err)
+	}
+
+	if len(s.Filters) == 0 {
+		return "You have no subscriptions.\n\n" + helpText
+	}
+
+	var sb strings.Builder
+	sb.WriteString("Your subscriptions:")
+	for _, f := range s.Filters {
+		fmt.Fprintf(&sb, "\n%s", f)
+	}
+
+	return sb.String()
+}
+
+func (n *Notifier) subscriber(chatID int64) (subscriber, error) {
+	s := subscriber{ChatID: chatID}
+
+	_, err := n.store.Get(subscriberKey(chatID), &s)
+	if err != nil {
+		return subscriber{}, err
+	}
+
+	return s, nil
+}
+
+// index returns a position of the filter in subscriber's filters, or -1 if it's not found.
+func (s subscriber) index(filter Filter) int {
+	for i, f := range s.Filters {
+		if f.Equal(filter) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func replyError(msg string, err error) string {
+	log.Error().Err(err).Msg(msg)
+	return fmt.Sprintf("Error: %s: %v", msg, err)
+}
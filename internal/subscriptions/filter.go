@@ -0,0 +1,70 @@
+package subscriptions
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kapitanov/habrabot/internal/data"
+)
+
+// filterKind defines which article's field is matched by a filter.
+type filterKind string
+
+const (
+	filterKindTag    filterKind = "tag"
+	filterKindAuthor filterKind = "author"
+)
+
+// Filter selects articles that a user is subscribed to.
+type Filter struct {
+	Kind  filterKind `json:"kind"`
+	Value string     `json:"value"`
+}
+
+// ParseFilter parses a filter like "tag:go" or "author:username".
+func ParseFilter(str string) (Filter, error) {
+	kind, value, ok := strings.Cut(strings.TrimSpace(str), ":")
+	value = strings.TrimSpace(value)
+	if !ok || value == "" {
+		return Filter{}, fmt.Errorf("malformed filter \"%s\", expected \"tag:NAME\" or \"author:NAME\"", str)
+	}
+
+	switch filterKind(strings.ToLower(kind)) {
+	case filterKindTag:
+		return Filter{Kind: filterKindTag, Value: value}, nil
+	case filterKindAuthor:
+		return Filter{Kind: filterKindAuthor, Value: strings.TrimPrefix(value, "@")}, nil
+	default:
+		return Filter{}, fmt.Errorf("unknown filter \"%s\", expected \"tag\" or \"author\"", kind)
+	}
+}
+
+// Matches returns true if the article is selected by the filter.
+func (f Filter) Matches(article data.Article) bool {
+	switch f.Kind {
+	case filterKindTag:
+		for _, tag := range article.Tags {
+			if strings.EqualFold(tag, f.Value) {
+				return true
+			}
+		}
+
+		return false
+
+	case filterKindAuthor:
+		return strings.EqualFold(article.Author, f.Value)
+
+	default:
+		return false
+	}
+}
+
+// Equal returns true if both filters select the same articles.
+func (f Filter) Equal(other Filter) bool {
+	return f.Kind == other.Kind && strings.EqualFold(f.Value, other.Value)
+}
+
+// String returns a text representation of the filter, which may be parsed with ParseFilter.
+func (f Filter) String() string {
+	return fmt.Sprintf("%s:%s", f.Kind, f.Value)
+}
@@ -0,0 +1,358 @@
+package subscriptions
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/rs/zerolog/log"
+
+	"github.com/kapitanov/habrabot/internal/data"
+	"github.com/kapitanov/habrabot/internal/httpclient"
+	"github.com/kapitanov/habrabot/internal/ratelimit"
+	"github.com/kapitanov/habrabot/internal/telegram"
+)
+
+// Store is a persistent key-value storage.
+type Store interface {
+	// Get loads a value by its key. It returns false if the key doesn't exist.
+	Get(key string, value interface{}) (bool, error)
+
+	// Put stores a value by its key.
+	Put(key string, value interface{}) error
+
+	// Delete removes a value by its key.
+	Delete(key string) error
+
+	// ForEach iterates over values with keys starting with the specified prefix.
+	ForEach(prefix string, fn func(key string, value []byte) error) error
+}
+
+// messenger is a subset of Bot API used by subscriptions.
+type messenger interface {
+	Send(c tgbotapi.Chattable) (tgbotapi.Message, error)
+}
+
+// subscriber is a user who receives notifications in a private chat.
+type subscriber struct {
+	ChatID  int64    `json:"chat_id"`
+	Filters []Filter `json:"filters"`
+}
+
+const (
+	subscriberKeyPrefix = "user/"
+	notifiedKeyPrefix   = "notified/"
+
+	// Records of notifications are kept for a while, so updates and resends of articles aren't notified about again.
+	// Older records are pruned once in a while.
+	notifiedTTL         = 30 * 24 * time.Hour
+	notifiedPrunePeriod = 24 * time.Hour
+
+	maxSendRetries = 3
+
+	// Articles wait to be notified about in a queue of this size, articles that don't fit are skipped.
+	notificationQueueSize = 100
+)
+
+// notification is a record of an article that subscribers have been notified about.
+type notification struct {
+	NotifiedAt time.Time `json:"notified_at"`
+}
+
+// Notifier is a consumer that notifies users about articles matching their subscriptions.
+// It's also an update handler that manages subscriptions via bot commands.
+type Notifier struct {
+	token   string
+	store   Store
+	limiter *ratelimit.Limiter
+	users   *ratelimit.Limiter
+	bot     messenger
+	now     func() time.Time
+	queue   chan data.Article
+
+	// mutex serializes changes of subscriptions.
+	mutex      sync.Mutex
+	lastPruned time.Time
+}
+
+// New creates new notifier.
+// Messages wait for the limiter, and notifications above the per-user rate are skipped.
+func New(token string, store Store, limiter *ratelimit.Limiter, perUser ratelimit.Rate) *Notifier {
+	log.Info().Int("per_user", perUser.Count).Dur("period", perUser.Period).Msg("will notify subscribers")
+
+	return &Notifier{
+		token:   token,
+		store:   store,
+		limiter: limiter,
+		users:   ratelimit.New(ratelimit.Rate{}, perUser),
+		now:     time.Now,
+		queue:   make(chan data.Article, notificationQueueSize),
+	}
+}
+
+// On method is invoked when an article is received from the feed.
+// The article is queued, and subscribers are notified about it in background,
+// so notifications waiting for the rate limiter don't delay posting.
+func (n *Notifier) On(_ context.Context, article data.Article) error {
+	select {
+	case n.queue <- article:
+	default:
+		log.Warn().Str("id", article.ID).Msg("notification queue is full, subscribers won't be notified about the article")
+	}
+
+	return nil
+}
+
+// Run notifies subscribers about queued articles until the context is canceled.
+func (n *Notifier) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case article := <-n.queue:
+			// Errors are logged already.
+			_ = n.notifyAll(ctx, article)
+		}
+	}
+}
+
+// notifyAll notifies subscribers about an article, unless they have been notified about it already.
+// Failed notifications are logged only, so they never cause the article to be published again.
+func (n *Notifier) notifyAll(ctx context.Context, article data.Article) error {
+	notifiedKey := notifiedKeyPrefix + strings.ToLower(article.ID)
+
+	n.pruneNotified()
+
+	// Value isn't decoded, since records stored by previous versions aren't notifications.
+	var notified json.RawMessage
+	found, err := n.store.Get(notifiedKey, &notified)
+	if err != nil {
+		log.Error().Err(err).Str("id", article.ID).Msg("unable to load notification status")
+		return err
+	}
+
+	if found {
+		// Updated and resent articles aren't notified about again.
+		return nil
+	}
+
+	subscribers, err := n.subscribers()
+	if err != nil {
+		log.Error().Err(err).Msg("unable to load subscribers")
+		return err
+	}
+
+	for _, s := range subscribers {
+		filter, ok := s.match(article)
+		if !ok {
+			continue
+		}
+
+		err = n.notify(ctx, s, article, filter)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			log.Error().Err(err).Int64("chat", s.ChatID).Str("id", article.ID).Msg("unable to notify subscriber")
+		}
+	}
+
+	err = n.store.Put(notifiedKey, notification{NotifiedAt: n.now().UTC()})
+	if err != nil {
+		log.Error().Err(err).Str("id", article.ID).Msg("unable to store notification status")
+	}
+
+	return err
+}
+
+// pruneNotified removes expired records of notifications, at most once per prune period.
+// Records stored by previous versions have no time, so they are given one to expire later.
+func (n *Notifier) pruneNotified() {
+	now := n.now()
+
+	n.mutex.Lock()
+	if now.Sub(n.lastPruned) < notifiedPrunePeriod {
+		n.mutex.Unlock()
+		return
+	}
+	n.lastPruned = now
+	n.mutex.Unlock()
+
+	var expired, legacy []string
+	err := n.store.ForEach(notifiedKeyPrefix, func(key string, value []byte) error {
+		var record notification
+		if json.Unmarshal(value, &record) != nil || record.NotifiedAt.IsZero() {
+			legacy = append(legacy, key)
+			return nil
+		}
+
+		if now.Sub(record.NotifiedAt) > notifiedTTL {
+			expired = append(expired, key)
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("unable to load notification records")
+		return
+	}
+
+	for _, key := range expired {
+		err = n.store.Delete(key)
+		if err != nil {
+			log.Error().Err(err).Str("key", key).Msg("unable to remove expired notification record")
+			return
+		}
+	}
+
+	for _, key := range legacy {
+		err = n.store.Put(key, notification{NotifiedAt: now.UTC()})
+		if err != nil {
+			log.Error().Err(err).Str("key", key).Msg("unable to update notification record")
+			return
+		}
+	}
+
+	if len(expired) > 0 {
+		log.Info().Int("count", len(expired)).Msg("expired notification records removed")
+	}
+}
+
+func (n *Notifier) notify(ctx context.Context, s subscriber, article data.Article, filter Filter) error {
+	chat := strconv.FormatInt(s.ChatID, 10)
+	if !n.users.Allow(chat) {
+		log.Warn().Int64("chat", s.ChatID).Str("id", article.ID).Msg("subscriber's rate limit exceeded, notification skipped")
+		return nil
+	}
+
+	err := n.limiter.Wait(ctx, chat)
+	if err != nil {
+		return err
+	}
+
+	err = n.connect()
+	if err != nil {
+		return err
+	}
+
+	for retries := 0; ; retries++ {
+		_, err = n.bot.Send(createNotification(s.ChatID, article, filter))
+		if err == nil {
+			return nil
+		}
+
+		apiErr, ok := telegram.AsAPIError(err)
+		switch {
+		case !ok:
+			return err
+
+		case apiErr.IsForbidden():
+			// User has blocked the bot, so subscriptions are dropped.
+			log.Info().Int64("chat", s.ChatID).Msg("subscriber has blocked the bot, subscriptions removed")
+
+			n.mutex.Lock()
+			defer n.mutex.Unlock()
+
+			return n.store.Delete(subscriberKey(s.ChatID))
+
+		case apiErr.IsRateLimited() && retries < maxSendRetries:
+			log.Warn().Dur("retry_after", apiErr.RetryAfter).Int64("chat", s.ChatID).Msg("telegram rate limit exceeded")
+
+			err = sleep(ctx, apiErr.RetryAfter)
+			if err != nil {
+				return err
+			}
+
+		default:
+			return err
+		}
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func createNotification(chatID int64, article data.Article, filter Filter) tgbotapi.Chattable {
+	text := fmt.Sprintf(
+		"<a href=\"%s\"><b>%s</b></a>\n\n%s",
+		html.EscapeString(article.LinkURL),
+		html.EscapeString(article.Title),
+		html.EscapeString(filter.String()),
+	)
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ParseMode = tgbotapi.ModeHTML
+
+	return msg
+}
+
+func (n *Notifier) connect() error {
+	if n.bot != nil {
+		return nil
+	}
+
+	httpClient, err := httpclient.New(httpclient.TelegramPolicy)
+	if err != nil {
+		return err
+	}
+
+	bot, err := tgbotapi.NewBotAPIWithClient(n.token, httpClient.StandardClient())
+	if err != nil {
+		log.Error().Err(err).Msg("unable to connect to telegram")
+		return err
+	}
+
+	n.bot = bot
+	return nil
+}
+
+func (n *Notifier) subscribers() ([]subscriber, error) {
+	var subscribers []subscriber
+
+	err := n.store.ForEach(subscriberKeyPrefix, func(_ string, value []byte) error {
+		var s subscriber
+		err := json.Unmarshal(value, &s)
+		if err != nil {
+			return err
+		}
+
+		subscribers = append(subscribers, s)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return subscribers, nil
+}
+
+// match returns the first filter of the subscriber that selects the article.
+func (s subscriber) match(article data.Article) (Filter, bool) {
+	for _, f := range s.Filters {
+		if f.Matches(article) {
+			return f, true
+		}
+	}
+
+	return Filter{}, false
+}
+
+func subscriberKey(chatID int64) string {
+	return subscriberKeyPrefix + strconv.FormatInt(chatID, 10)
+}
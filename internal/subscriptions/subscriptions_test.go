@@ -0,0 +1,283 @@
+package subscriptions
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kapitanov/habrabot/internal/data"
+	"github.com/kapitanov/habrabot/internal/ratelimit"
+)
+
+type inMemoryStore map[string][]byte
+
+func (s inMemoryStore) Get(key string, value interface{}) (bool, error) {
+	bytes, exists := s[key]
+	if !exists {
+		return false, nil
+	}
+
+	return true, json.Unmarshal(bytes, value)
+}
+
+func (s inMemoryStore) Put(key string, value interface{}) error {
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	s[key] = bytes
+	return nil
+}
+
+func (s inMemoryStore) Delete(key string) error {
+	delete(s, key)
+	return nil
+}
+
+func (s inMemoryStore) ForEach(prefix string, fn func(key string, value []byte) error) error {
+	for key, value := range s {
+		if strings.HasPrefix(key, prefix) {
+			err := fn(key, value)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+type fakeMessenger struct {
+	sent        []tgbotapi.MessageConfig
+	blocked     map[int64]bool
+	rateLimited int // Number of following requests that are rejected by flood control.
+}
+
+func (m *fakeMessenger) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	msg := c.(tgbotapi.MessageConfig)
+	if m.blocked[msg.ChatID] {
+		return tgbotapi.Message{}, errors.New("Forbidden: bot was blocked by the user")
+	}
+
+	if m.rateLimited > 0 {
+		m.rateLimited--
+		return tgbotapi.Message{}, errors.New("Too Many Requests: retry after 0")
+	}
+
+	m.sent = append(m.sent, msg)
+	return tgbotapi.Message{MessageID: len(m.sent)}, nil
+}
+
+// signalingMessenger signals once a message has been sent.
+type signalingMessenger struct {
+	messenger messenger
+	sent      chan<- struct{}
+}
+
+func (m *signalingMessenger) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	msg, err := m.messenger.Send(c)
+	m.sent <- struct{}{}
+	return msg, err
+}
+
+func newTestNotifier(perUser ratelimit.Rate) (*Notifier, *fakeMessenger, inMemoryStore) {
+	store := inMemoryStore{}
+	bot := &fakeMessenger{blocked: make(map[int64]bool)}
+
+	n := New("token", store, ratelimit.New(ratelimit.Rate{}, ratelimit.Rate{}), perUser)
+	n.bot = bot
+
+	return n, bot, store
+}
+
+func command(chatID int64, text string) tgbotapi.Update {
+	command, _, _ := strings.Cut(text, " ")
+
+	return tgbotapi.Update{
+		Message: &tgbotapi.Message{
+			Chat: &tgbotapi.Chat{ID: chatID, Type: "private"},
+			Text: text,
+			Entities: &[]tgbotapi.MessageEntity{
+				{Type: "bot_command", Offset: 0, Length: len(command)},
+			},
+		},
+	}
+}
+
+func TestNotifier_Commands(t *testing.T) {
+	n, bot, _ := newTestNotifier(ratelimit.Rate{})
+
+	n.handleUpdate(context.Background(), bot, command(1, "/subscribe tag:Go"))
+	n.handleUpdate(context.Background(), bot, command(1, "/subscribe author:@user"))
+	n.handleUpdate(context.Background(), bot, command(1, "/subscribe tag:go"))
+	n.handleUpdate(context.Background(), bot, command(1, "/subscribe lang:go"))
+	n.handleUpdate(context.Background(), bot, command(1, "/subscriptions"))
+	n.handleUpdate(context.Background(), bot, command(1, "/unsubscribe tag:GO"))
+	n.handleUpdate(context.Background(), bot, command(1, "/status"))
+
+	replies := make([]string, len(bot.sent))
+	for i, msg := range bot.sent {
+		replies[i] = msg.Text
+	}
+
+	assert.Equal(t, []string{
+		"You have subscribed to tag:Go.",
+		"You have subscribed to author:user.",
+		"You are already subscribed to tag:go.",
+		"Error: unknown filter \"lang\", expected \"tag\" or \"author\"",
+		"Your subscriptions:\ntag:Go\nauthor:user",
+		"You have unsubscribed from tag:GO.",
+	}, replies)
+}
+
+func TestNotifier_IgnoresGroupChats(t *testing.T) {
+	n, bot, _ := newTestNotifier(ratelimit.Rate{})
+
+	update := command(-1, "/subscribe tag:go")
+	update.Message.Chat.Type = "group"
+	n.handleUpdate(context.Background(), bot, update)
+
+	assert.Empty(t, bot.sent)
+}
+
+func TestNotifier_On(t *testing.T) {
+	n, bot, store := newTestNotifier(ratelimit.Rate{})
+	require.NoError(t, store.Put(subscriberKey(1), subscriber{ChatID: 1, Filters: []Filter{{Kind: filterKindTag, Value: "go"}}}))
+	require.NoError(t, store.Put(subscriberKey(2), subscriber{ChatID: 2, Filters: []Filter{{Kind: filterKindAuthor, Value: "user"}}}))
+
+	article := data.Article{ID: "1", Title: "A & B", LinkURL: "https://habr.com/post/1/", Author: "other", Tags: []string{"Go"}}
+	require.NoError(t, n.notifyAll(context.Background(), article))
+
+	if assert.Len(t, bot.sent, 1) {
+		assert.Equal(t, int64(1), bot.sent[0].ChatID)
+		assert.Equal(t, "<a href=\"https://habr.com/post/1/\"><b>A &amp; B</b></a>\n\ntag:go", bot.sent[0].Text)
+	}
+
+	// Updates of the article aren't sent again.
+	require.NoError(t, n.notifyAll(context.Background(), article))
+	assert.Len(t, bot.sent, 1)
+}
+
+func TestNotifier_On_Queued(t *testing.T) {
+	n, bot, store := newTestNotifier(ratelimit.Rate{})
+	require.NoError(t, store.Put(subscriberKey(1), subscriber{ChatID: 1, Filters: []Filter{{Kind: filterKindTag, Value: "go"}}}))
+
+	sent := make(chan struct{}, 1)
+	n.bot = &signalingMessenger{messenger: bot, sent: sent}
+
+	require.NoError(t, n.On(context.Background(), data.Article{ID: "1", Tags: []string{"go"}}))
+	assert.Empty(t, bot.sent)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		n.Run(ctx)
+	}()
+
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		require.Fail(t, "subscriber hasn't been notified")
+	}
+
+	cancel()
+	<-done
+
+	assert.Len(t, bot.sent, 1)
+}
+
+func TestNotifier_On_QueueFull(t *testing.T) {
+	n, _, _ := newTestNotifier(ratelimit.Rate{})
+
+	for i := 0; i < notificationQueueSize+1; i++ {
+		require.NoError(t, n.On(context.Background(), data.Article{ID: strconv.Itoa(i)}))
+	}
+
+	assert.Len(t, n.queue, notificationQueueSize)
+}
+
+func TestNotifier_On_RateLimit(t *testing.T) {
+	n, bot, store := newTestNotifier(ratelimit.Rate{Count: 2, Period: time.Hour})
+	require.NoError(t, store.Put(subscriberKey(1), subscriber{ChatID: 1, Filters: []Filter{{Kind: filterKindTag, Value: "go"}}}))
+
+	for _, id := range []string{"1", "2", "3"} {
+		require.NoError(t, n.notifyAll(context.Background(), data.Article{ID: id, Tags: []string{"go"}}))
+	}
+
+	assert.Len(t, bot.sent, 2)
+}
+
+func TestNotifier_On_Blocked(t *testing.T) {
+	n, bot, store := newTestNotifier(ratelimit.Rate{})
+	require.NoError(t, store.Put(subscriberKey(1), subscriber{ChatID: 1, Filters: []Filter{{Kind: filterKindTag, Value: "go"}}}))
+	bot.blocked[1] = true
+
+	require.NoError(t, n.notifyAll(context.Background(), data.Article{ID: "1", Tags: []string{"go"}}))
+
+	_, exists := store[subscriberKey(1)]
+	assert.False(t, exists)
+}
+
+func TestNotifier_On_RetryAfter(t *testing.T) {
+	n, bot, store := newTestNotifier(ratelimit.Rate{})
+	require.NoError(t, store.Put(subscriberKey(1), subscriber{ChatID: 1, Filters: []Filter{{Kind: filterKindTag, Value: "go"}}}))
+	bot.rateLimited = 2
+
+	require.NoError(t, n.notifyAll(context.Background(), data.Article{ID: "1", Tags: []string{"go"}}))
+
+	assert.Len(t, bot.sent, 1)
+	_, exists := store[subscriberKey(1)]
+	assert.True(t, exists)
+}
+
+func TestNotifier_PruneNotified(t *testing.T) {
+	n, bot, store := newTestNotifier(ratelimit.Rate{})
+	require.NoError(t, store.Put(subscriberKey(1), subscriber{ChatID: 1, Filters: []Filter{{Kind: filterKindTag, Value: "go"}}}))
+
+	now := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	n.now = func() time.Time { return now }
+
+	require.NoError(t, store.Put(notifiedKeyPrefix+"legacy", true))
+	require.NoError(t, n.notifyAll(context.Background(), data.Article{ID: "1", Tags: []string{"go"}}))
+
+	var legacy notification
+	found, err := store.Get(notifiedKeyPrefix+"legacy", &legacy)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, now, legacy.NotifiedAt)
+
+	// Records expire after the TTL, so the article is notified about again.
+	now = now.Add(notifiedTTL + time.Hour)
+	require.NoError(t, n.notifyAll(context.Background(), data.Article{ID: "1", Tags: []string{"go"}}))
+	assert.Len(t, bot.sent, 2)
+
+	_, exists := store[notifiedKeyPrefix+"legacy"]
+	assert.False(t, exists)
+}
+
+func TestParseFilter(t *testing.T) {
+	f, err := ParseFilter(" Tag: go ")
+	require.NoError(t, err)
+	assert.Equal(t, Filter{Kind: filterKindTag, Value: "go"}, f)
+
+	f, err = ParseFilter("author:@user")
+	require.NoError(t, err)
+	assert.Equal(t, Filter{Kind: filterKindAuthor, Value: "user"}, f)
+
+	_, err = ParseFilter("go")
+	assert.Error(t, err)
+
+	_, err = ParseFilter("tag:")
+	assert.Error(t, err)
+}
@@ -0,0 +1,298 @@
+package telegram
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kapitanov/habrabot/internal/data"
+	"github.com/kapitanov/habrabot/internal/telegram/telegramtest"
+)
+
+const (
+	testToken  = "123:token"
+	testChatID = int64(-1001234567890)
+)
+
+// newTestServer starts a fake Bot API server that hosts "@channel", and makes Bot API clients use it.
+func newTestServer(t *testing.T) *telegramtest.Server {
+	server := telegramtest.NewServer(testToken)
+	t.Cleanup(server.Close)
+
+	server.AddChat(tgbotapi.Chat{ID: testChatID, Type: "channel", UserName: "channel", Title: "Channel"})
+	t.Setenv("TELEGRAM_API_ENDPOINT", server.URL)
+
+	return server
+}
+
+// newSiteServer serves an article's image and its web page, which may be removed.
+func newSiteServer(t *testing.T, imageBytes []byte) (*httptest.Server, *bool) {
+	removed := false
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/image.png":
+			_, _ = w.Write(imageBytes)
+		case removed:
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			_, _ = w.Write([]byte("<html></html>"))
+		}
+	}))
+	t.Cleanup(site.Close)
+
+	return site, &removed
+}
+
+func TestTransmitter_E2E_Photo(t *testing.T) {
+	server := newTestServer(t)
+	site, _ := newSiteServer(t, encodeTestPNG(t, 64, 32))
+
+	keyboard, err := ParseKeyboard("Read={{.LinkURL}}", nil)
+	require.NoError(t, err)
+
+	transmitter := New(testToken, "@channel", WithKeyboard(keyboard))
+
+	imageURL := site.URL + "/image.png"
+	article := data.Article{
+		ID:          "1",
+		Title:       "TITLE",
+		Description: strings.Repeat("lorem ipsum ", 500),
+		LinkURL:     site.URL + "/post/1/",
+		ImageURL:    &imageURL,
+	}
+
+	require.NoError(t, transmitter.On(context.Background(), article))
+
+	messages := server.Messages(testChatID)
+	if assert.Len(t, messages, 1) {
+		assert.Equal(t, "photo", messages[0].Kind)
+		assert.Equal(t, tgbotapi.ModeHTML, messages[0].ParseMode)
+		assert.True(t, strings.HasPrefix(messages[0].Text, "TITLE\n\nlorem ipsum"))
+		assert.LessOrEqual(t, len([]rune(messages[0].Text)), telegramtest.MaxCaptionLength)
+		assert.Contains(t, messages[0].ReplyMarkup, article.LinkURL)
+	}
+}
+
+func TestTransmitter_E2E_NumericChatIDAndTopics(t *testing.T) {
+	server := newTestServer(t)
+
+	topics, err := ParseTopics("go=12", 3)
+	require.NoError(t, err)
+
+	transmitter := New(testToken, "-1001234567890", WithTopics(topics))
+
+	require.NoError(t, transmitter.On(context.Background(), data.Article{ID: "1", Title: "GO", Tags: []string{"go"}}))
+	require.NoError(t, transmitter.On(context.Background(), data.Article{ID: "2", Title: "RUST", Tags: []string{"rust"}}))
+
+	messages := server.Messages(testChatID)
+	if assert.Len(t, messages, 2) {
+		assert.Equal(t, 12, messages[0].ThreadID)
+		assert.Equal(t, 3, messages[1].ThreadID)
+	}
+}
+
+func TestTransmitter_E2E_EntityParseError(t *testing.T) {
+	server := newTestServer(t)
+	transmitter := New(testToken, "@channel")
+
+	article := data.Article{ID: "1", Title: "TITLE", Description: "<p>Some <b>text</b></p>", LinkURL: "https://habr.com/post/1/"}
+	require.NoError(t, transmitter.On(context.Background(), article))
+
+	assert.Equal(t, 2, server.Calls("sendMessage"))
+	messages := server.Messages(testChatID)
+	if assert.Len(t, messages, 1) {
+		assert.Empty(t, messages[0].ParseMode)
+		assert.Equal(t, "TITLE\n\n<p>Some text</p>\n\nhttps://habr.com/post/1/", messages[0].Text)
+	}
+}
+
+func TestTransmitter_E2E_RateLimited(t *testing.T) {
+	server := newTestServer(t)
+	server.Flood(1, 1)
+
+	transmitter := New(testToken, "@channel")
+
+	started := time.Now()
+	require.NoError(t, transmitter.On(context.Background(), data.Article{ID: "1", Title: "TITLE"}))
+
+	assert.GreaterOrEqual(t, time.Since(started), time.Second)
+	assert.Equal(t, 2, server.Calls("sendMessage"))
+	assert.Len(t, server.Messages(testChatID), 1)
+}
+
+func TestTransmitter_E2E_EditAndDelete(t *testing.T) {
+	server := newTestServer(t)
+	site, removed := newSiteServer(t, nil)
+
+	transmitter := New(
+		testToken,
+		"@channel",
+		WithMessageStore(inMemoryStore{}),
+		WithReconciliation(Reconciliation{Period: time.Hour, Window: time.Hour, Action: ReconcileActionDelete}),
+	)
+
+	article := data.Article{ID: "1", Title: "TITLE", LinkURL: site.URL + "/post/1/"}
+	require.NoError(t, transmitter.On(context.Background(), article))
+
+	// Unchanged article isn't edited.
+	require.NoError(t, transmitter.On(context.Background(), article))
+	assert.Zero(t, server.Calls("editMessageText"))
+
+	article.Title = "NEW TITLE"
+	require.NoError(t, transmitter.On(context.Background(), article))
+
+	messages := server.Messages(testChatID)
+	if assert.Len(t, messages, 1) {
+		assert.True(t, messages[0].Edited)
+		assert.True(t, strings.HasPrefix(messages[0].Text, "NEW TITLE"))
+	}
+
+	*removed = true
+	require.NoError(t, transmitter.Reconcile(context.Background()))
+
+	messages = server.Messages(testChatID)
+	if assert.Len(t, messages, 1) {
+		assert.True(t, messages[0].Deleted)
+	}
+}
+
+func TestTransmitter_E2E_MarkWithoutStoredArticle(t *testing.T) {
+	server := newTestServer(t)
+	site, removed := newSiteServer(t, nil)
+
+	store := inMemoryStore{}
+	transmitter := New(
+		testToken,
+		"@channel",
+		WithMessageStore(store),
+		WithReconciliation(Reconciliation{Period: time.Hour, Window: time.Hour, Action: ReconcileActionMark, Marker: "Removed"}),
+	)
+
+	article := data.Article{ID: "1", Title: "TITLE", Description: "TEXT", LinkURL: site.URL + "/post/1/"}
+	require.NoError(t, transmitter.On(context.Background(), article))
+
+	// The message has been stored without its article's text.
+	messages := &messageStore{store: store}
+	posted, found, err := messages.Get("@channel", "1")
+	require.NoError(t, err)
+	require.True(t, found)
+	posted.Article = data.Article{ID: "1", LinkURL: article.LinkURL}
+	require.NoError(t, messages.Put("@channel", posted))
+
+	*removed = true
+	require.NoError(t, transmitter.Reconcile(context.Background()))
+
+	assert.Zero(t, server.Calls("editMessageText"))
+	if posted := server.Messages(testChatID); assert.Len(t, posted, 1) {
+		assert.False(t, posted[0].Edited)
+		assert.True(t, strings.HasPrefix(posted[0].Text, "TITLE"))
+	}
+
+	posted, _, err = messages.Get("@channel", "1")
+	require.NoError(t, err)
+	assert.True(t, posted.Removed)
+}
+
+func TestTransmitter_E2E_UpdatedWithoutPostedMessage(t *testing.T) {
+	server := newTestServer(t)
+	site, _ := newSiteServer(t, nil)
+
+	transmitter := New(testToken, "@channel", WithMessageStore(inMemoryStore{}))
+
+	// The article has been posted before messages were tracked.
+	article := data.Article{ID: "1", Title: "NEW TITLE", LinkURL: site.URL + "/post/1/", Updated: true}
+	require.NoError(t, transmitter.On(context.Background(), article))
+
+	assert.Empty(t, server.Messages(testChatID))
+}
+
+func TestTransmitter_E2E_Listener(t *testing.T) {
+	newTestServer(t)
+	site, _ := newSiteServer(t, nil)
+
+	var posted []string
+	listener := data.ConsumerFunc(func(_ context.Context, article data.Article) error {
+		posted = append(posted, article.Title)
+		return nil
+	})
+
+	transmitter := New(testToken, "@channel", WithMessageStore(inMemoryStore{}), WithListener(listener))
+
+	article := data.Article{ID: "1", Title: "TITLE", LinkURL: site.URL + "/post/1/"}
+	require.NoError(t, transmitter.On(context.Background(), article))
+
+	// Edits aren't passed to the listener.
+	article.Title = "NEW TITLE"
+	require.NoError(t, transmitter.On(context.Background(), article))
+
+	assert.Equal(t, []string{"TITLE"}, posted)
+}
+
+func TestTransmitter_E2E_LinkPreview(t *testing.T) {
+	server := newTestServer(t)
+	site, _ := newSiteServer(t, encodeTestPNG(t, 64, 32))
+
+	transmitter := New(
+		testToken,
+		"@channel",
+		WithMessageStore(inMemoryStore{}),
+		WithPostingMode(PostingModeLinkPreview),
+		WithLinkPreview(LinkPreview{Position: PreviewPositionAbove, Size: PreviewSizeLarge}),
+	)
+
+	imageURL := site.URL + "/image.png"
+	article := data.Article{ID: "1", Title: "TITLE", LinkURL: site.URL + "/post/1/", ImageURL: &imageURL}
+	require.NoError(t, transmitter.On(context.Background(), article))
+
+	options := `{"url":"` + article.LinkURL + `","prefer_large_media":true,"show_above_text":true}`
+
+	messages := server.Messages(testChatID)
+	if assert.Len(t, messages, 1) {
+		assert.Equal(t, "text", messages[0].Kind)
+		assert.False(t, messages[0].DisableWebPagePreview)
+		assert.Equal(t, options, messages[0].LinkPreviewOptions)
+	}
+
+	// Link preview options are kept when the post is edited.
+	article.Title = "NEW TITLE"
+	require.NoError(t, transmitter.On(context.Background(), article))
+
+	messages = server.Messages(testChatID)
+	if assert.Len(t, messages, 1) {
+		assert.True(t, messages[0].Edited)
+		assert.Equal(t, options, messages[0].LinkPreviewOptions)
+	}
+}
+
+func TestTransmitter_E2E_LinkPreviewFallback(t *testing.T) {
+	server := newTestServer(t)
+	site, _ := newSiteServer(t, nil) // Empty image can be sent neither as a photo nor as a document.
+
+	transmitter := New(testToken, "@channel", WithLinkPreview(LinkPreview{Size: PreviewSizeSmall}))
+
+	imageURL := site.URL + "/image.png"
+	article := data.Article{ID: "1", Title: "TITLE", LinkURL: site.URL + "/post/1/", ImageURL: &imageURL}
+	require.NoError(t, transmitter.On(context.Background(), article))
+
+	messages := server.Messages(testChatID)
+	if assert.Len(t, messages, 1) {
+		assert.Equal(t, "text", messages[0].Kind)
+		assert.False(t, messages[0].DisableWebPagePreview)
+		assert.Equal(t, `{"url":"`+article.LinkURL+`","prefer_small_media":true}`, messages[0].LinkPreviewOptions)
+	}
+}
+
+func TestTransmitter_E2E_UnknownChat(t *testing.T) {
+	newTestServer(t)
+	transmitter := New(testToken, "@unknown")
+
+	err := transmitter.On(context.Background(), data.Article{ID: "1", Title: "TITLE"})
+	assert.ErrorContains(t, err, "chat not found")
+}
@@ -0,0 +1,210 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/rs/zerolog/log"
+
+	"github.com/kapitanov/habrabot/internal/data"
+)
+
+// update edits a previously posted message if its article has changed.
+func (t *Transmitter) update(ctx context.Context, article data.Article, posted postedMessage) error {
+	hash := data.ContentHash(article)
+	if posted.Hash == hash && !t.shouldEditMedia(article, posted) {
+		log.Debug().Str("id", article.ID).Msg("telegram message is up to date")
+		return nil
+	}
+
+	opts := messageOptions{ImageMode: t.postingMode.imageMode(), Keyboard: t.keyboard}
+	posted.ChatID = t.chat.ID
+
+	_, err := t.send(ctx, article, opts, func(opts messageOptions) (tgbotapi.Message, error) {
+		return t.edit(ctx, article, posted, opts)
+	})
+	if err != nil {
+		if isMessageNotFound(err) {
+			// Message has been deleted from the chat, so it's posted again.
+			log.Warn().Str("id", article.ID).Int("msg", posted.MessageID).Msg("edited telegram message not found")
+			return t.transmit(ctx, article, opts)
+		}
+
+		return err
+	}
+
+	log.Info().
+		Int("msg", posted.MessageID).
+		Str("channel", t.channelNameOrID).
+		Str("title", article.Title).
+		Str("id", article.ID).
+		Msg("edited a telegram message")
+
+	if t.shouldEditMedia(article, posted) {
+		posted.ImageURL = *article.ImageURL
+	}
+
+	posted.Hash = hash
+	posted.Article = article
+	t.storePostedMessage(posted)
+	return nil
+}
+
+func (t *Transmitter) edit(
+	ctx context.Context,
+	article data.Article,
+	posted postedMessage,
+	opts messageOptions,
+) (tgbotapi.Message, error) {
+	var (
+		result tgbotapi.Message
+		err    error
+	)
+
+	switch {
+	case t.shouldEditMedia(article, posted):
+		result, err = t.replaceMedia(ctx, article, posted, opts)
+	case posted.HasCaption():
+		result, err = t.bot.Send(createCaptionEdit(article, posted, opts))
+	default:
+		result, err = t.editText(article, posted, opts)
+	}
+
+	if err != nil && isMessageNotModified(err) {
+		return tgbotapi.Message{MessageID: posted.MessageID}, nil
+	}
+
+	return result, err
+}
+
+func (t *Transmitter) shouldEditMedia(article data.Article, posted postedMessage) bool {
+	return t.editMedia &&
+		posted.Kind == messageKindPhoto &&
+		article.ImageURL != nil &&
+		*article.ImageURL != "" &&
+		posted.ImageURL != "" &&
+		*article.ImageURL != posted.ImageURL
+}
+
+// editText edits text of a message, keeping options of its link preview.
+func (t *Transmitter) editText(article data.Article, posted postedMessage, opts messageOptions) (tgbotapi.Message, error) {
+	var params map[string]string
+
+	if posted.Kind == messageKindLinkPreview {
+		var err error
+		params, err = t.linkPreview.params(article.LinkURL)
+		if err != nil {
+			return tgbotapi.Message{}, err
+		}
+	}
+
+	return sendWithParams(t.bot, createTextEdit(article, posted, opts), params)
+}
+
+func createTextEdit(article data.Article, posted postedMessage, opts messageOptions) tgbotapi.Chattable {
+	text, parseMode := formatMessage(article, maxTextLength, opts)
+
+	edit := tgbotapi.NewEditMessageText(posted.ChatID, posted.MessageID, text)
+	edit.ParseMode = parseMode
+	edit.DisableWebPagePreview = posted.Kind != messageKindLinkPreview
+	edit.ReplyMarkup = opts.Keyboard.markup(article)
+
+	return edit
+}
+
+func createCaptionEdit(article data.Article, posted postedMessage, opts messageOptions) tgbotapi.Chattable {
+	caption, parseMode := formatMessage(article, maxMediaCaptionLength, opts)
+
+	edit := tgbotapi.NewEditMessageCaption(posted.ChatID, posted.MessageID, caption)
+	edit.ParseMode = parseMode
+	edit.ReplyMarkup = opts.Keyboard.markup(article)
+
+	return edit
+}
+
+// inputMediaPhoto is an InputMediaPhoto object of Bot API, which tgbotapi doesn't support for edits.
+type inputMediaPhoto struct {
+	Type      string `json:"type"`
+	Media     string `json:"media"`
+	Caption   string `json:"caption,omitempty"`
+	ParseMode string `json:"parse_mode,omitempty"`
+}
+
+func (t *Transmitter) replaceMedia(
+	ctx context.Context,
+	article data.Article,
+	posted postedMessage,
+	opts messageOptions,
+) (tgbotapi.Message, error) {
+	const attachmentName = "photo"
+
+	raw, err := downloadImage(ctx, *article.ImageURL, t.httpClient.StandardClient())
+	if err != nil {
+		return tgbotapi.Message{}, err
+	}
+
+	img, err := prepareImage(raw)
+	if err != nil {
+		return tgbotapi.Message{}, err
+	}
+
+	if img.Kind != imageKindPhoto {
+		// Image can't be sent as a photo anymore, so only the caption is updated.
+		return t.bot.Send(createCaptionEdit(article, posted, opts))
+	}
+
+	caption, parseMode := formatMessage(article, maxMediaCaptionLength, opts)
+	media, err := json.Marshal(inputMediaPhoto{
+		Type:      "photo",
+		Media:     "attach://" + attachmentName,
+		Caption:   caption,
+		ParseMode: parseMode,
+	})
+	if err != nil {
+		return tgbotapi.Message{}, err
+	}
+
+	params := map[string]string{
+		"chat_id":    strconv.FormatInt(posted.ChatID, 10),
+		"message_id": strconv.Itoa(posted.MessageID),
+		"media":      string(media),
+	}
+
+	if markup := opts.Keyboard.markup(article); markup != nil {
+		bytes, err := json.Marshal(markup)
+		if err != nil {
+			return tgbotapi.Message{}, err
+		}
+
+		params["reply_markup"] = string(bytes)
+	}
+
+	resp, err := t.bot.UploadFile("editMessageMedia", params, attachmentName, tgbotapi.FileBytes{Name: "image.jpg", Bytes: img.Bytes})
+	if err != nil {
+		return tgbotapi.Message{}, err
+	}
+
+	var result tgbotapi.Message
+	err = json.Unmarshal(resp.Result, &result)
+	if err != nil {
+		return tgbotapi.Message{}, err
+	}
+
+	return result, nil
+}
+
+func isMessageNotModified(err error) bool {
+	apiErr, ok := AsAPIError(err)
+	return ok && apiErr.IsMessageNotModified()
+}
+
+func isMessageNotFound(err error) bool {
+	apiErr, ok := AsAPIError(err)
+
+	return ok && apiErr.Code == 400 &&
+		(strings.Contains(apiErr.Description, "message to edit not found") ||
+			strings.Contains(apiErr.Description, "MESSAGE_ID_INVALID"))
+}
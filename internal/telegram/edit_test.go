@@ -0,0 +1,129 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kapitanov/habrabot/internal/data"
+)
+
+type inMemoryStore map[string][]byte
+
+func (s inMemoryStore) Get(key string, value interface{}) (bool, error) {
+	bytes, exists := s[key]
+	if !exists {
+		return false, nil
+	}
+
+	return true, json.Unmarshal(bytes, value)
+}
+
+func (s inMemoryStore) Put(key string, value interface{}) error {
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	s[key] = bytes
+	return nil
+}
+
+func (s inMemoryStore) Delete(key string) error {
+	delete(s, key)
+	return nil
+}
+
+func (s inMemoryStore) ForEach(prefix string, fn func(key string, value []byte) error) error {
+	for key, value := range s {
+		if strings.HasPrefix(key, prefix) {
+			err := fn(key, value)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func TestMessageStore(t *testing.T) {
+	store := &messageStore{store: inMemoryStore{}}
+	posted := postedMessage{ChatID: 1, MessageID: 2, Kind: messageKindPhoto, Article: data.Article{ID: "ID"}}
+
+	require.NoError(t, store.Put("@Channel", posted))
+
+	actual, found, err := store.Get("@channel", "id")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, posted, actual)
+
+	require.NoError(t, store.Delete("@channel", "ID"))
+
+	_, found, err = store.Get("@channel", "ID")
+	require.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestMessageKindOf(t *testing.T) {
+	article := data.Article{Title: "TITLE", LinkURL: "https://google.com"}
+
+	assert.Equal(t, messageKindText, messageKindOf(createTextMessage(article, 1, messageOptions{})))
+	assert.Equal(t, messageKindLinkPreview, messageKindOf(createLinkPreviewMessage(article, 1, messageOptions{})))
+	assert.Equal(t, messageKindPhoto, messageKindOf(tgbotapi.NewPhotoUpload(1, nil)))
+	assert.Equal(t, messageKindDocument, messageKindOf(tgbotapi.NewDocumentUpload(1, nil)))
+}
+
+func TestCreateTextEdit(t *testing.T) {
+	article := data.Article{Title: "TITLE", Description: "TEXT", LinkURL: "https://google.com"}
+	posted := postedMessage{ChatID: 1, MessageID: 2, Kind: messageKindLinkPreview}
+
+	chattable := createTextEdit(article, posted, messageOptions{})
+
+	if assert.IsType(t, tgbotapi.EditMessageTextConfig{}, chattable) {
+		edit := chattable.(tgbotapi.EditMessageTextConfig)
+		assert.Equal(t, int64(1), edit.ChatID)
+		assert.Equal(t, 2, edit.MessageID)
+		assert.Equal(t, "<a href=\"https://google.com\"><strong>TITLE</strong></a>\n\nTEXT", edit.Text)
+		assert.Equal(t, tgbotapi.ModeHTML, edit.ParseMode)
+		assert.False(t, edit.DisableWebPagePreview)
+	}
+}
+
+func TestCreateCaptionEdit(t *testing.T) {
+	article := data.Article{Title: "TITLE", Description: "TEXT", LinkURL: "https://google.com"}
+	posted := postedMessage{ChatID: 1, MessageID: 2, Kind: messageKindPhoto}
+
+	chattable := createCaptionEdit(article, posted, messageOptions{PlainText: true})
+
+	if assert.IsType(t, tgbotapi.EditMessageCaptionConfig{}, chattable) {
+		edit := chattable.(tgbotapi.EditMessageCaptionConfig)
+		assert.Equal(t, 2, edit.MessageID)
+		assert.Equal(t, "TITLE\n\nTEXT\n\nhttps://google.com", edit.Caption)
+		assert.Empty(t, edit.ParseMode)
+	}
+}
+
+func TestUpdate_Unchanged(t *testing.T) {
+	article := data.Article{ID: "1", Title: "TITLE"}
+	tr := &Transmitter{chat: &tgbotapi.Chat{ID: 1}}
+
+	err := tr.update(context.Background(), article, postedMessage{Hash: data.ContentHash(article)})
+
+	assert.NoError(t, err)
+}
+
+func TestUpdate_ImageLost(t *testing.T) {
+	article := data.Article{ID: "1", Title: "TITLE"}
+	tr := &Transmitter{chat: &tgbotapi.Chat{ID: 1}, editMedia: true}
+
+	posted := postedMessage{Kind: messageKindPhoto, ImageURL: "https://example.com/1.png", Hash: data.ContentHash(article)}
+	err := tr.update(context.Background(), article, posted)
+
+	assert.NoError(t, err)
+}
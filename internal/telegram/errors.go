@@ -0,0 +1,138 @@
+package telegram
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+
+	"github.com/kapitanov/habrabot/internal/data"
+)
+
+// ErrDestinationUnavailable is returned when the bot is not allowed to post into the destination chat anymore.
+// It matches data.ErrUnavailable, so articles are kept in the queue rather than failing the sync.
+var ErrDestinationUnavailable = fmt.Errorf("telegram %w", data.ErrUnavailable)
+
+// APIError is an error returned by Telegram Bot API.
+type APIError struct {
+	Code            int           // Error code, e.g. 400 or 429.
+	Description     string        // Human-readable error description.
+	RetryAfter      time.Duration // Time to wait before the request can be repeated, if rate limit is exceeded.
+	MigrateToChatID int64         // New ID of the chat, if it has been migrated to a supergroup.
+}
+
+// Error returns an error message.
+func (e *APIError) Error() string {
+	return fmt.Sprintf("telegram api error %d: %s", e.Code, e.Description)
+}
+
+// IsRateLimited returns true if the request was rejected because of flood control.
+func (e *APIError) IsRateLimited() bool {
+	return e.Code == 429
+}
+
+// IsChatMigrated returns true if the chat has been upgraded to a supergroup.
+func (e *APIError) IsChatMigrated() bool {
+	return e.MigrateToChatID != 0
+}
+
+// forbiddenDescriptions are parts of descriptions of 403 errors that mean the bot has lost access to the chat.
+// Other 403 errors, e.g. of missing admin rights, may be transient.
+var forbiddenDescriptions = []string{
+	"bot was kicked",
+	"bot was blocked",
+	"user is deactivated",
+}
+
+// IsForbidden returns true if the bot has lost access to the chat (e.g. it has been kicked or blocked).
+func (e *APIError) IsForbidden() bool {
+	if e.Code != 403 {
+		return false
+	}
+
+	for _, description := range forbiddenDescriptions {
+		if strings.Contains(e.Description, description) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsEntityParseError returns true if Telegram wasn't able to parse message markup.
+func (e *APIError) IsEntityParseError() bool {
+	return e.Code == 400 && strings.Contains(e.Description, "can't parse entities")
+}
+
+// IsMessageNotModified returns true if an edit was rejected because it doesn't change the message.
+func (e *APIError) IsMessageNotModified() bool {
+	return e.Code == 400 && strings.Contains(e.Description, "message is not modified")
+}
+
+// Telegram prefixes error descriptions with the error type, which is used to restore the error code
+// when the underlying client doesn't provide it (e.g. for file uploads).
+var errorCodePrefixes = []struct {
+	Prefix string
+	Code   int
+}{
+	{Prefix: "Bad Request", Code: 400},
+	{Prefix: "Unauthorized", Code: 401},
+	{Prefix: "Forbidden", Code: 403},
+	{Prefix: "Not Found", Code: 404},
+	{Prefix: "Conflict", Code: 409},
+	{Prefix: "Request Entity Too Large", Code: 413},
+	{Prefix: "Too Many Requests", Code: 429},
+	{Prefix: "Internal Server Error", Code: 500},
+	{Prefix: "Bad Gateway", Code: 502},
+}
+
+var retryAfterRegex = regexp.MustCompile(`retry after (\d+)`)
+
+// AsAPIError converts an error returned by tgbotapi into an APIError.
+// It returns false if the error doesn't look like a Bot API error (e.g. a network failure).
+func AsAPIError(err error) (*APIError, bool) {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr, true
+	}
+
+	description := err.Error()
+	code := errorCode(description)
+	if code == 0 {
+		return nil, false
+	}
+
+	apiErr = &APIError{
+		Code:        code,
+		Description: description,
+	}
+
+	var tgErr tgbotapi.Error
+	if errors.As(err, &tgErr) {
+		apiErr.RetryAfter = time.Duration(tgErr.RetryAfter) * time.Second
+		apiErr.MigrateToChatID = tgErr.MigrateToChatID
+	}
+
+	if apiErr.RetryAfter == 0 && code == 429 {
+		if m := retryAfterRegex.FindStringSubmatch(description); m != nil {
+			seconds, _ := strconv.Atoi(m[1])
+			apiErr.RetryAfter = time.Duration(seconds) * time.Second
+		}
+	}
+
+	return apiErr, true
+}
+
+func errorCode(description string) int {
+	for _, p := range errorCodePrefixes {
+		if strings.HasPrefix(description, p.Prefix) {
+			return p.Code
+		}
+	}
+
+	return 0
+}
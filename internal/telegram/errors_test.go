@@ -0,0 +1,176 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kapitanov/habrabot/internal/data"
+)
+
+func TestAsAPIError(t *testing.T) {
+	testCases := []struct {
+		Name       string
+		Err        error
+		Code       int
+		RetryAfter time.Duration
+		MigrateTo  int64
+	}{
+		{
+			Name: "BadRequest",
+			Err:  tgbotapi.Error{Message: "Bad Request: can't parse entities: unexpected end tag"},
+			Code: 400,
+		},
+		{
+			Name: "TooManyRequests",
+			Err: tgbotapi.Error{
+				Message:            "Too Many Requests: retry after 7",
+				ResponseParameters: tgbotapi.ResponseParameters{RetryAfter: 7},
+			},
+			Code:       429,
+			RetryAfter: 7 * time.Second,
+		},
+		{
+			Name:       "TooManyRequestsWithoutParameters",
+			Err:        errors.New("Too Many Requests: retry after 12"),
+			Code:       429,
+			RetryAfter: 12 * time.Second,
+		},
+		{
+			Name: "Migrated",
+			Err: tgbotapi.Error{
+				Message:            "Bad Request: group chat was upgraded to a supergroup chat",
+				ResponseParameters: tgbotapi.ResponseParameters{MigrateToChatID: -100123},
+			},
+			Code:      400,
+			MigrateTo: -100123,
+		},
+		{
+			Name: "Forbidden",
+			Err:  errors.New("Forbidden: bot was kicked from the channel chat"),
+			Code: 403,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.Name, func(t *testing.T) {
+			apiErr, ok := AsAPIError(tc.Err)
+
+			require.True(t, ok)
+			assert.Equal(t, tc.Code, apiErr.Code)
+			assert.Equal(t, tc.RetryAfter, apiErr.RetryAfter)
+			assert.Equal(t, tc.MigrateTo, apiErr.MigrateToChatID)
+		})
+	}
+}
+
+func TestAsAPIError_NetworkError(t *testing.T) {
+	_, ok := AsAPIError(errors.New("dial tcp: connection refused"))
+
+	assert.False(t, ok)
+}
+
+func TestHandleSendError_EntityParseError(t *testing.T) {
+	tr := &Transmitter{chat: &tgbotapi.Chat{ID: 1}}
+
+	opts, err := tr.handleSendError(
+		context.Background(),
+		data.Article{},
+		messageOptions{},
+		errors.New("Bad Request: can't parse entities: unsupported start tag"),
+	)
+
+	require.NoError(t, err)
+	assert.True(t, opts.PlainText)
+}
+
+func TestHandleSendError_ChatMigrated(t *testing.T) {
+	store := &messageStore{store: inMemoryStore{}}
+	require.NoError(t, store.Put("@channel", postedMessage{ChatID: 1, MessageID: 10, Article: data.Article{ID: "1"}}))
+
+	tr := &Transmitter{channelNameOrID: "@channel", chat: &tgbotapi.Chat{ID: 1}, messages: store}
+
+	_, err := tr.handleSendError(
+		context.Background(),
+		data.Article{},
+		messageOptions{},
+		tgbotapi.Error{
+			Message:            "Bad Request: group chat was upgraded to a supergroup chat",
+			ResponseParameters: tgbotapi.ResponseParameters{MigrateToChatID: 2},
+		},
+	)
+
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), tr.chat.ID)
+
+	// Messages posted into the chat are edited in the supergroup.
+	posted, found, err := store.Get("@channel", "1")
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, int64(2), posted.ChatID)
+}
+
+func TestHandleSendError_Forbidden(t *testing.T) {
+	tr := &Transmitter{bot: &tgbotapi.BotAPI{}, chat: &tgbotapi.Chat{ID: 1}}
+
+	_, err := tr.handleSendError(
+		context.Background(),
+		data.Article{},
+		messageOptions{},
+		errors.New("Forbidden: bot was kicked from the channel chat"),
+	)
+
+	assert.ErrorIs(t, err, ErrDestinationUnavailable)
+	assert.ErrorIs(t, tr.On(context.Background(), data.Article{}), ErrDestinationUnavailable)
+
+	// Posting is attempted again once the retry period is over.
+	tr.retryAt = time.Now().Add(-time.Second)
+	assert.NoError(t, tr.connect())
+}
+
+func TestHandleSendError_ForbiddenTransient(t *testing.T) {
+	tr := &Transmitter{chat: &tgbotapi.Chat{ID: 1}}
+
+	_, err := tr.handleSendError(
+		context.Background(),
+		data.Article{},
+		messageOptions{},
+		errors.New("Forbidden: bot is not allowed to post messages, not enough rights"),
+	)
+
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrDestinationUnavailable)
+	assert.Nil(t, tr.unavailable)
+}
+
+func TestHandleSendError_RateLimited(t *testing.T) {
+	tr := &Transmitter{chat: &tgbotapi.Chat{ID: 1}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := tr.handleSendError(ctx, data.Article{}, messageOptions{}, errors.New("Too Many Requests: retry after 30"))
+
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestHandleSendError_RejectedImage(t *testing.T) {
+	tr := &Transmitter{chat: &tgbotapi.Chat{ID: 1}}
+	imageURL := "https://example.com/image.png"
+
+	opts, err := tr.handleSendError(
+		context.Background(),
+		data.Article{ImageURL: &imageURL},
+		messageOptions{ImageMode: imageModeUpload},
+		errors.New("Bad Request: PHOTO_INVALID_DIMENSIONS"),
+	)
+
+	require.NoError(t, err)
+	assert.Equal(t, imageModeLinkPreview, opts.ImageMode)
+}
@@ -0,0 +1,197 @@
+package telegram
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	_ "image/gif" // GIF decoder registration.
+	"image/jpeg"
+	_ "image/png" // PNG decoder registration.
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // WebP decoder registration.
+)
+
+// Telegram's constraints for photos, see https://core.telegram.org/bots/api#sendphoto.
+const (
+	maxPhotoFileSize     = 10 * 1024 * 1024
+	maxPhotoDimensionSum = 10000
+	maxPhotoAspectRatio  = 20
+	maxDocumentFileSize  = 50 * 1024 * 1024
+)
+
+// photoLimits are constraints that prepared photos satisfy.
+type photoLimits struct {
+	FileSize     int // Maximum size of an encoded photo, in bytes.
+	DimensionSum int // Maximum sum of photo's width and height.
+	AspectRatio  int // Maximum ratio of the longer side of a photo to its shorter side.
+}
+
+// telegramPhotoLimits are Telegram's constraints for photos.
+var telegramPhotoLimits = photoLimits{
+	FileSize:     maxPhotoFileSize,
+	DimensionSum: maxPhotoDimensionSum,
+	AspectRatio:  maxPhotoAspectRatio,
+}
+
+var jpegQualityLevels = []int{90, 75, 60}
+
+const (
+	downscaleFactor  = 0.75
+	maxDownscaleRuns = 8
+)
+
+// maxDecodedPixels limits dimensions of images that are decoded, so a small compressed image can't exhaust memory.
+const maxDecodedPixels = 40 * 1000 * 1000
+
+var errImageUnusable = errors.New("image can't be sent to telegram")
+
+type imageKind int
+
+const (
+	imageKindPhoto    imageKind = iota // Image could be sent as a photo.
+	imageKindDocument                  // Image should be sent as a document.
+)
+
+type preparedImage struct {
+	Bytes []byte
+	Kind  imageKind
+}
+
+// prepareImage converts a downloaded image into a form that satisfies Telegram's photo constraints.
+// Images that can't be decoded are sent as documents, if their size allows it.
+// Images that are too large to be decoded are rejected.
+func prepareImage(raw []byte) (preparedImage, error) {
+	return telegramPhotoLimits.prepare(raw)
+}
+
+// prepare converts an image into a photo that satisfies the limits, see prepareImage.
+func (limits photoLimits) prepare(raw []byte) (preparedImage, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(raw))
+	if err != nil {
+		return prepareDocument(raw)
+	}
+
+	if cfg.Width <= 0 || cfg.Height <= 0 || int64(cfg.Width)*int64(cfg.Height) > maxDecodedPixels {
+		return preparedImage{}, errImageUnusable
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return prepareDocument(raw)
+	}
+
+	if limits.isAcceptable(img.Bounds(), format, len(raw)) {
+		return preparedImage{Bytes: raw, Kind: imageKindPhoto}, nil
+	}
+
+	// Padding leaves a margin so the aspect ratio stays within limits after downscaling rounds the dimensions.
+	img = padToAspectRatio(img, limits.AspectRatio-1)
+	img = fitDimensionSum(img, limits.DimensionSum)
+
+	for i := 0; i < maxDownscaleRuns; i++ {
+		for _, quality := range jpegQualityLevels {
+			encoded, err := encodeJPEG(img, quality)
+			if err != nil {
+				return preparedImage{}, err
+			}
+
+			if len(encoded) <= limits.FileSize {
+				return preparedImage{Bytes: encoded, Kind: imageKindPhoto}, nil
+			}
+		}
+
+		img = scale(img, downscaleFactor)
+	}
+
+	return prepareDocument(raw)
+}
+
+func prepareDocument(raw []byte) (preparedImage, error) {
+	if len(raw) == 0 || len(raw) > maxDocumentFileSize {
+		return preparedImage{}, errImageUnusable
+	}
+
+	return preparedImage{Bytes: raw, Kind: imageKindDocument}, nil
+}
+
+func (limits photoLimits) isAcceptable(bounds image.Rectangle, format string, size int) bool {
+	if format != "jpeg" && format != "png" {
+		return false
+	}
+
+	if size > limits.FileSize {
+		return false
+	}
+
+	w, h := bounds.Dx(), bounds.Dy()
+	if w <= 0 || h <= 0 || w+h > limits.DimensionSum {
+		return false
+	}
+
+	return w <= h*limits.AspectRatio && h <= w*limits.AspectRatio
+}
+
+// padToAspectRatio extends the shorter side of an image with a white background
+// so its aspect ratio doesn't exceed the specified value.
+func padToAspectRatio(img image.Image, maxRatio int) image.Image {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+
+	newW, newH := w, h
+	switch {
+	case w > h*maxRatio:
+		newH = (w + maxRatio - 1) / maxRatio
+	case h > w*maxRatio:
+		newW = (h + maxRatio - 1) / maxRatio
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, newW, newH))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+
+	offset := image.Pt((newW-w)/2, (newH-h)/2)
+	draw.Draw(dst, image.Rectangle{Min: offset, Max: offset.Add(img.Bounds().Size())}, img, img.Bounds().Min, draw.Over)
+
+	return dst
+}
+
+// fitDimensionSum downscales an image so the sum of its width and height doesn't exceed the specified value.
+func fitDimensionSum(img image.Image, maxSum int) image.Image {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	if w+h <= maxSum {
+		return img
+	}
+
+	return scale(img, float64(maxSum)/float64(w+h))
+}
+
+func scale(img image.Image, factor float64) image.Image {
+	w := int(float64(img.Bounds().Dx()) * factor)
+	h := int(float64(img.Bounds().Dy()) * factor)
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.ApproxBiLinear.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Src, nil)
+
+	return dst
+}
+
+func encodeJPEG(img image.Image, quality int) ([]byte, error) {
+	// JPEG has no alpha channel, so transparent pixels are blended with a white background.
+	flat := image.NewRGBA(img.Bounds())
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), img, img.Bounds().Min, draw.Over)
+
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, flat, &jpeg.Options{Quality: quality})
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
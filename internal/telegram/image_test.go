@@ -0,0 +1,147 @@
+package telegram
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrepareImage_AcceptablePhoto(t *testing.T) {
+	raw := encodeTestPNG(t, 800, 600)
+
+	img, err := prepareImage(raw)
+
+	require.NoError(t, err)
+	assert.Equal(t, imageKindPhoto, img.Kind)
+	assert.Equal(t, raw, img.Bytes)
+}
+
+func TestPrepareImage_ReencodeGIF(t *testing.T) {
+	var buf bytes.Buffer
+	err := gif.Encode(&buf, newTestImage(100, 50), nil)
+	require.NoError(t, err)
+
+	img, err := prepareImage(buf.Bytes())
+
+	require.NoError(t, err)
+	assert.Equal(t, imageKindPhoto, img.Kind)
+	w, h, format := decodeTestImage(t, img.Bytes)
+	assert.Equal(t, "jpeg", format)
+	assert.Equal(t, 100, w)
+	assert.Equal(t, 50, h)
+}
+
+func TestPrepareImage_PadAspectRatio(t *testing.T) {
+	testCases := []struct {
+		Name   string
+		Width  int
+		Height int
+	}{
+		{Name: "Wide", Width: 3000, Height: 10},
+		{Name: "Tall", Width: 10, Height: 3000},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.Name, func(t *testing.T) {
+			img, err := prepareImage(encodeTestPNG(t, tc.Width, tc.Height))
+
+			require.NoError(t, err)
+			assert.Equal(t, imageKindPhoto, img.Kind)
+
+			w, h, _ := decodeTestImage(t, img.Bytes)
+			assert.LessOrEqual(t, w, h*maxPhotoAspectRatio)
+			assert.LessOrEqual(t, h, w*maxPhotoAspectRatio)
+			assert.LessOrEqual(t, w+h, maxPhotoDimensionSum)
+		})
+	}
+}
+
+func TestPrepareImage_Downscale(t *testing.T) {
+	// Limits are lowered, so a small image goes through the same steps as a full-size one.
+	limits := photoLimits{FileSize: 2 * 1024, DimensionSum: 600, AspectRatio: maxPhotoAspectRatio}
+
+	img, err := limits.prepare(encodeTestPNG(t, 800, 400))
+
+	require.NoError(t, err)
+	assert.Equal(t, imageKindPhoto, img.Kind)
+
+	w, h, _ := decodeTestImage(t, img.Bytes)
+	assert.Less(t, w+h, limits.DimensionSum, "image is downscaled further to fit the file size")
+	assert.InDelta(t, 2.0, float64(w)/float64(h), 0.02)
+	assert.LessOrEqual(t, len(img.Bytes), limits.FileSize)
+}
+
+func TestPrepareImage_UnknownFormat(t *testing.T) {
+	raw := []byte("definitely not an image")
+
+	img, err := prepareImage(raw)
+
+	require.NoError(t, err)
+	assert.Equal(t, imageKindDocument, img.Kind)
+	assert.Equal(t, raw, img.Bytes)
+}
+
+func TestPrepareImage_DecompressionBomb(t *testing.T) {
+	// Only the header of the PNG image is written, it's enough to learn its dimensions.
+	header := make([]byte, 13)
+	binary.BigEndian.PutUint32(header[0:], 100000)
+	binary.BigEndian.PutUint32(header[4:], 100000)
+	header[8] = 8 // Bit depth.
+	header[9] = 2 // RGB color type.
+
+	chunk := append([]byte("IHDR"), header...)
+	raw := append([]byte("\x89PNG\r\n\x1a\n"), 0, 0, 0, byte(len(header)))
+	raw = append(raw, chunk...)
+	raw = binary.BigEndian.AppendUint32(raw, crc32.ChecksumIEEE(chunk))
+
+	_, err := prepareImage(raw)
+
+	assert.ErrorIs(t, err, errImageUnusable)
+}
+
+func TestPrepareImage_Empty(t *testing.T) {
+	_, err := prepareImage(nil)
+
+	assert.ErrorIs(t, err, errImageUnusable)
+}
+
+func TestImageFileName(t *testing.T) {
+	assert.Equal(t, "cover.webp", imageFileName("https://habrastorage.org/webt/cover.webp?x=1"))
+	assert.Equal(t, "image", imageFileName("https://habrastorage.org/"))
+}
+
+func newTestImage(width, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+
+	return img
+}
+
+func encodeTestPNG(t *testing.T, width, height int) []byte {
+	var buf bytes.Buffer
+	err := png.Encode(&buf, newTestImage(width, height))
+	require.NoError(t, err)
+
+	return buf.Bytes()
+}
+
+func decodeTestImage(t *testing.T, raw []byte) (width, height int, format string) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(raw))
+	require.NoError(t, err)
+
+	return cfg.Width, cfg.Height, format
+}
@@ -0,0 +1,173 @@
+package telegram
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"text/template"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/rs/zerolog/log"
+
+	"github.com/kapitanov/habrabot/internal/data"
+)
+
+// Button is an inline keyboard button that opens a URL.
+// Both text and URL are templates that are executed against the article.
+type Button struct {
+	Text *template.Template
+	URL  *template.Template
+}
+
+// Keyboard is an inline keyboard attached to posts, it's a list of button rows.
+type Keyboard [][]Button
+
+// ParseKeyboard parses a keyboard layout.
+// Each button is defined as "Text=URL", buttons of a row are separated by ";" and rows are separated by "|", e.g.:
+//
+//	Read on Habr={{.LinkURL}};Comments={{.LinkURL}}comments/|Author={{if .Author}}https://habr.com/users/{{.Author}}/{{end}}
+//
+// Separators inside template actions are ignored, so pipelines may be used in templates.
+// Extra template functions may be supplied by funcs, "pathescape" and "queryescape" are always available.
+func ParseKeyboard(str string, funcs template.FuncMap) (Keyboard, error) {
+	var keyboard Keyboard
+
+	for _, rowStr := range splitOutsideActions(str, '|') {
+		var row []Button
+
+		for _, buttonStr := range splitOutsideActions(rowStr, ';') {
+			buttonStr = strings.TrimSpace(buttonStr)
+			if buttonStr == "" {
+				continue
+			}
+
+			button, err := parseButton(buttonStr, funcs)
+			if err != nil {
+				return nil, err
+			}
+
+			row = append(row, button)
+		}
+
+		if len(row) > 0 {
+			keyboard = append(keyboard, row)
+		}
+	}
+
+	return keyboard, nil
+}
+
+// splitOutsideActions splits a string by a separator that isn't enclosed in template action delimiters.
+func splitOutsideActions(str string, sep byte) []string {
+	var (
+		parts []string
+		depth int
+		start int
+	)
+
+	for i := 0; i < len(str); i++ {
+		switch {
+		case strings.HasPrefix(str[i:], "{{"):
+			depth++
+			i++
+		case strings.HasPrefix(str[i:], "}}") && depth > 0:
+			depth--
+			i++
+		case str[i] == sep && depth == 0:
+			parts = append(parts, str[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(parts, str[start:])
+}
+
+func parseButton(str string, funcs template.FuncMap) (Button, error) {
+	text, link, ok := strings.Cut(str, "=")
+	if !ok {
+		return Button{}, fmt.Errorf("malformed button \"%s\", expected \"Text=URL\"", str)
+	}
+
+	textTemplate, err := parseButtonTemplate(strings.TrimSpace(text), funcs)
+	if err != nil {
+		return Button{}, fmt.Errorf("malformed button text \"%s\": %w", text, err)
+	}
+
+	urlTemplate, err := parseButtonTemplate(strings.TrimSpace(link), funcs)
+	if err != nil {
+		return Button{}, fmt.Errorf("malformed button url \"%s\": %w", link, err)
+	}
+
+	return Button{Text: textTemplate, URL: urlTemplate}, nil
+}
+
+func parseButtonTemplate(str string, funcs template.FuncMap) (*template.Template, error) {
+	t, err := template.New("").
+		Funcs(template.FuncMap{
+			"pathescape":  url.PathEscape,
+			"queryescape": url.QueryEscape,
+		}).
+		Funcs(funcs).
+		Parse(str)
+	if err != nil {
+		return nil, err
+	}
+
+	// Templates are executed once against an empty article to catch references to unknown fields early.
+	err = t.Execute(&strings.Builder{}, data.Article{})
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// markup renders the keyboard for an article.
+// Buttons with empty text or URL are omitted, so templates may skip buttons that aren't applicable.
+func (k Keyboard) markup(article data.Article) *tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+
+	for _, row := range k {
+		var buttons []tgbotapi.InlineKeyboardButton
+
+		for _, button := range row {
+			text, err := executeButtonTemplate(button.Text, article)
+			if err != nil {
+				log.Warn().Err(err).Str("id", article.ID).Msg("unable to render inline keyboard button")
+				continue
+			}
+
+			link, err := executeButtonTemplate(button.URL, article)
+			if err != nil {
+				log.Warn().Err(err).Str("id", article.ID).Msg("unable to render inline keyboard button")
+				continue
+			}
+
+			if text != "" && link != "" {
+				buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonURL(text, link))
+			}
+		}
+
+		if len(buttons) > 0 {
+			rows = append(rows, buttons)
+		}
+	}
+
+	if len(rows) == 0 {
+		return nil
+	}
+
+	markup := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	return &markup
+}
+
+func executeButtonTemplate(t *template.Template, article data.Article) (string, error) {
+	var sb strings.Builder
+
+	err := t.Execute(&sb, article)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(sb.String()), nil
+}
@@ -0,0 +1,81 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+	"text/template"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kapitanov/habrabot/internal/data"
+)
+
+func TestParseKeyboard(t *testing.T) {
+	keyboard, err := ParseKeyboard(
+		"Read on Habr={{.LinkURL}}; Comments={{.LinkURL}}comments/ |"+
+			"Author={{if .Author}}https://habr.com/users/{{pathescape .Author}}/{{end}};"+
+			"Copy=https://cc.example.com/{{ccpath . | pathescape}}",
+		template.FuncMap{"ccpath": func(article data.Article) string { return article.ID + ".html" }},
+	)
+	require.NoError(t, err)
+
+	markup := keyboard.markup(data.Article{ID: "1", LinkURL: "https://habr.com/post/1/", Author: "user name"})
+
+	expected := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonURL("Read on Habr", "https://habr.com/post/1/"),
+			tgbotapi.NewInlineKeyboardButtonURL("Comments", "https://habr.com/post/1/comments/"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonURL("Author", "https://habr.com/users/user%20name/"),
+			tgbotapi.NewInlineKeyboardButtonURL("Copy", "https://cc.example.com/1.html"),
+		),
+	)
+	assert.Equal(t, &expected, markup)
+}
+
+func TestParseKeyboard_Malformed(t *testing.T) {
+	tests := []string{
+		"Read on Habr",
+		"Read on Habr={{.LinkURL",
+		"Read on Habr={{.UnknownField}}",
+		"Read on Habr={{unknownfunc .}}",
+	}
+
+	for _, str := range tests {
+		t.Run(str, func(t *testing.T) {
+			_, err := ParseKeyboard(str, nil)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestKeyboard_OmitsEmptyButtons(t *testing.T) {
+	keyboard, err := ParseKeyboard("Author={{if .Author}}https://habr.com/users/{{.Author}}/{{end}}", nil)
+	require.NoError(t, err)
+
+	assert.Nil(t, keyboard.markup(data.Article{}))
+	assert.Nil(t, Keyboard(nil).markup(data.Article{}))
+}
+
+func TestCreateTextMessage_Keyboard(t *testing.T) {
+	keyboard, err := ParseKeyboard("Read={{.LinkURL}}", nil)
+	require.NoError(t, err)
+
+	article := data.Article{Title: "TITLE", LinkURL: "https://google.com"}
+
+	msg := createTextMessage(article, 1, messageOptions{Keyboard: keyboard}).(tgbotapi.MessageConfig)
+	if assert.IsType(t, &tgbotapi.InlineKeyboardMarkup{}, msg.ReplyMarkup) {
+		markup := msg.ReplyMarkup.(*tgbotapi.InlineKeyboardMarkup)
+		assert.Equal(t, "https://google.com", *markup.InlineKeyboard[0][0].URL)
+	}
+
+	edit := createTextEdit(article, postedMessage{}, messageOptions{Keyboard: keyboard}).(tgbotapi.EditMessageTextConfig)
+	assert.NotNil(t, edit.ReplyMarkup)
+
+	msg = createTextMessage(article, 1, messageOptions{}).(tgbotapi.MessageConfig)
+	assert.Nil(t, msg.ReplyMarkup)
+	assert.False(t, strings.Contains(msg.Text, "Read"))
+}
@@ -2,14 +2,19 @@ package telegram
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"html"
 	"io"
 	"net/http"
+	"net/url"
+	"path"
+	"regexp"
 	"strings"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
 	"github.com/kapitanov/habrabot/internal/data"
+	"github.com/rs/zerolog/log"
 	"golang.org/x/exp/utf8string"
 )
 
@@ -22,26 +27,58 @@ const (
 
 var ellipsisUTF8 = utf8string.NewString(ellipsis)
 
+// imageMode defines how an article's image is delivered to Telegram.
+type imageMode int
+
+const (
+	imageModeUpload      imageMode = iota // Image is downloaded and uploaded to Telegram.
+	imageModeLinkPreview                  // Image is shown by Telegram's link preview.
+	imageModeNone                         // Image is omitted.
+)
+
+// messageOptions control how a message is rendered.
+type messageOptions struct {
+	ImageMode imageMode // How article's image is delivered.
+	PlainText bool      // If true, message is sent without HTML markup.
+	Silent    bool      // If true, message is sent without notification.
+	Keyboard  Keyboard  // Inline keyboard attached to message.
+}
+
 func prepareMessage(
 	ctx context.Context,
 	article data.Article,
 	chatID int64,
 	httpClient *http.Client,
+	opts messageOptions,
 ) (tgbotapi.Chattable, error) {
-	if article.ImageURL == nil {
-		return createTextMessage(article, chatID), nil
+	if opts.ImageMode == imageModeLinkPreview {
+		// Telegram shows a preview of article's link even if the article has no image.
+		return createLinkPreviewMessage(article, chatID, opts), nil
 	}
 
-	return createTextAndImageMessage(ctx, article, chatID, httpClient)
+	if article.ImageURL == nil || opts.ImageMode == imageModeNone {
+		return createTextMessage(article, chatID, opts), nil
+	}
+
+	return createTextAndImageMessage(ctx, article, chatID, httpClient, opts)
 }
 
-func createTextMessage(article data.Article, chatID int64) tgbotapi.Chattable {
-	text := formatMessageText(article.Title, article.Description, article.LinkURL, maxTextLength)
+func createTextMessage(article data.Article, chatID int64, opts messageOptions) tgbotapi.Chattable {
+	text, parseMode := formatMessage(article, maxTextLength, opts)
 
 	msg := tgbotapi.NewMessageToChannel("", text)
 	msg.ChatID = chatID
-	msg.ParseMode = tgbotapi.ModeHTML
+	msg.ParseMode = parseMode
 	msg.DisableWebPagePreview = true
+	msg.DisableNotification = opts.Silent
+	msg.ReplyMarkup = replyMarkup(article, opts)
+
+	return msg
+}
+
+func createLinkPreviewMessage(article data.Article, chatID int64, opts messageOptions) tgbotapi.Chattable {
+	msg := createTextMessage(article, chatID, opts).(tgbotapi.MessageConfig)
+	msg.DisableWebPagePreview = false
 
 	return msg
 }
@@ -51,21 +88,75 @@ func createTextAndImageMessage(
 	article data.Article,
 	chatID int64,
 	httpClient *http.Client,
+	opts messageOptions,
 ) (tgbotapi.Chattable, error) {
 	bytes, err := downloadImage(ctx, *article.ImageURL, httpClient)
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		log.Warn().Err(err).Str("url", *article.ImageURL).Msg("unable to download image, will use link preview")
+		return createLinkPreviewMessage(article, chatID, opts), nil
+	}
+
+	img, err := prepareImage(bytes)
+	if err != nil {
+		if errors.Is(err, errImageUnusable) {
+			return createLinkPreviewMessage(article, chatID, opts), nil
+		}
+
 		return nil, err
 	}
 
-	text := formatMessageText(article.Title, article.Description, article.LinkURL, maxMediaCaptionLength)
+	text, parseMode := formatMessage(article, maxMediaCaptionLength, opts)
+
+	if img.Kind == imageKindDocument {
+		document := tgbotapi.NewDocumentUpload(chatID, tgbotapi.FileBytes{Name: imageFileName(*article.ImageURL), Bytes: img.Bytes})
+		document.Caption = text
+		document.ParseMode = parseMode
+		document.DisableNotification = opts.Silent
+		document.ReplyMarkup = replyMarkup(article, opts)
 
-	photo := tgbotapi.NewPhotoUpload(chatID, tgbotapi.FileBytes{Bytes: bytes})
+		return document, nil
+	}
+
+	photo := tgbotapi.NewPhotoUpload(chatID, tgbotapi.FileBytes{Bytes: img.Bytes})
 	photo.Caption = text
-	photo.ParseMode = tgbotapi.ModeHTML
+	photo.ParseMode = parseMode
+	photo.DisableNotification = opts.Silent
+	photo.ReplyMarkup = replyMarkup(article, opts)
 
 	return photo, nil
 }
 
+// replyMarkup renders inline keyboard of a message.
+// It returns an untyped nil if there is no keyboard, so it's omitted from the request.
+func replyMarkup(article data.Article, opts messageOptions) interface{} {
+	markup := opts.Keyboard.markup(article)
+	if markup == nil {
+		return nil
+	}
+
+	return markup
+}
+
+func imageFileName(imageURL string) string {
+	const defaultName = "image"
+
+	u, err := url.Parse(imageURL)
+	if err != nil {
+		return defaultName
+	}
+
+	name := path.Base(u.Path)
+	if name == "." || name == "/" {
+		return defaultName
+	}
+
+	return name
+}
+
 func downloadImage(
 	ctx context.Context,
 	url string,
@@ -85,7 +176,11 @@ func downloadImage(
 		_ = resp.Body.Close()
 	}()
 
-	bytes, err := io.ReadAll(resp.Body)
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return nil, fmt.Errorf("unable to download \"%s\": %v", url, resp.Status)
+	}
+
+	bytes, err := io.ReadAll(io.LimitReader(resp.Body, maxDocumentFileSize+1))
 	if err != nil {
 		return nil, err
 	}
@@ -93,6 +188,14 @@ func downloadImage(
 	return bytes, nil
 }
 
+func formatMessage(article data.Article, maxLength int, opts messageOptions) (text, parseMode string) {
+	if opts.PlainText {
+		return formatPlainMessageText(article.Title, article.Description, article.LinkURL, maxLength), ""
+	}
+
+	return formatMessageText(article.Title, article.Description, article.LinkURL, maxLength), tgbotapi.ModeHTML
+}
+
 func formatMessageText(title, text, href string, maxLength int) string {
 	const titleTextSeparator = "\n\n"
 
@@ -127,6 +230,33 @@ func formatMessageText(title, text, href string, maxLength int) string {
 	return formattedText
 }
 
+// formatPlainMessageText renders a message without any markup.
+// It's used when Telegram is unable to parse entities of an HTML message.
+func formatPlainMessageText(title, text, href string, maxLength int) string {
+	const separator = "\n\n"
+
+	title = sanitizeText(title)
+	text = sanitizeText(stripMarkup(text))
+
+	suffix := separator + href
+	if unicodeLength(title)+unicodeLength(suffix) > maxLength {
+		return trimLongText(href, maxLength)
+	}
+
+	remMaxTextLength := maxLength - unicodeLength(title) - unicodeLength(suffix) - unicodeLength(separator)
+	if text == "" || remMaxTextLength <= 0 {
+		return title + suffix
+	}
+
+	return title + separator + trimLongText(text, remMaxTextLength) + suffix
+}
+
+var markupTagRegex = regexp.MustCompile(`</?(b|strong|i|em|code|s|strike|del|u|pre)(\s[^>]*)?>`)
+
+func stripMarkup(text string) string {
+	return markupTagRegex.ReplaceAllString(text, "")
+}
+
 func unicodeLength(text string) int {
 	return utf8string.NewString(text).RuneCount()
 }
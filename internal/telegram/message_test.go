@@ -129,7 +129,7 @@ func TestCreateTextMessage_NoTrim(t *testing.T) {
 
 	article.Description = unicodeSlice(article.Description, 0, maxTextLength-10)
 	chatID := int64(1024)
-	chattable := createTextMessage(article, chatID)
+	chattable := createTextMessage(article, chatID, messageOptions{})
 
 	if assert.NotNil(t, chattable) {
 		if assert.IsType(t, tgbotapi.MessageConfig{}, chattable) {
@@ -177,7 +177,7 @@ func TestCreateTextMessage_Trim(t *testing.T) {
 
 			article.Description = unicodeSlice(article.Description, 0, strLength)
 			chatID := int64(1024)
-			chattable := createTextMessage(article, chatID)
+			chattable := createTextMessage(article, chatID, messageOptions{})
 
 			if assert.NotNil(t, chattable) {
 				if assert.IsType(t, tgbotapi.MessageConfig{}, chattable) {
@@ -205,8 +205,9 @@ func TestCreateTextMessage_Trim(t *testing.T) {
 }
 
 func TestCreateTextAndImageMessage_NoTrim(t *testing.T) {
+	imageBytes := encodeTestPNG(t, 64, 32)
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
-		_, _ = w.Write([]byte("image"))
+		_, _ = w.Write(imageBytes)
 	}))
 	defer server.Close()
 
@@ -222,7 +223,7 @@ func TestCreateTextAndImageMessage_NoTrim(t *testing.T) {
 
 	article.Description = unicodeSlice(article.Description, 0, maxMediaCaptionLength-10)
 	chatID := int64(1024)
-	chattable, err := createTextAndImageMessage(context.Background(), article, chatID, http.DefaultClient)
+	chattable, err := createTextAndImageMessage(context.Background(), article, chatID, http.DefaultClient, messageOptions{})
 	assert.NoError(t, err)
 
 	if assert.NotNil(t, chattable) {
@@ -248,8 +249,9 @@ func TestCreateTextAndImageMessage_NoTrim(t *testing.T) {
 }
 
 func TestCreateTextAndImageMessage_Trim(t *testing.T) {
+	imageBytes := encodeTestPNG(t, 64, 32)
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
-		_, _ = w.Write([]byte("image"))
+		_, _ = w.Write(imageBytes)
 	}))
 	defer server.Close()
 
@@ -275,7 +277,7 @@ func TestCreateTextAndImageMessage_Trim(t *testing.T) {
 
 			article.Description = unicodeSlice(article.Description, 0, strLength)
 			chatID := int64(1024)
-			chattable, err := createTextAndImageMessage(context.Background(), article, chatID, http.DefaultClient)
+			chattable, err := createTextAndImageMessage(context.Background(), article, chatID, http.DefaultClient, messageOptions{})
 			assert.NoError(t, err)
 
 			if assert.NotNil(t, chattable) {
@@ -318,3 +320,33 @@ func unicodeSlice(s string, i, j int) string {
 func unicodeLen(s string) int {
 	return utf8string.NewString(s).RuneCount()
 }
+
+func TestFormatPlainMessageText(t *testing.T) {
+	const href = "https://google.com"
+
+	actual := formatPlainMessageText("TITLE", "<b>bold</b> and <pre language=\"go\">a < b</pre>", href, 1000)
+
+	assert.Equal(t, "TITLE\n\nbold and a < b\n\nhttps://google.com", actual)
+}
+
+func TestFormatPlainMessageText_Trim(t *testing.T) {
+	const href = "https://google.com"
+
+	actual := formatPlainMessageText("TITLE", "TEXT OF THE MESSAGE", href, 35)
+
+	assert.Equal(t, "TITLE\n\nTEXT OF…\n\nhttps://google.com", actual)
+	assert.LessOrEqual(t, unicodeLength(actual), 35)
+}
+
+func TestCreateTextMessage_Silent(t *testing.T) {
+	article := data.Article{
+		Title:   "TITLE",
+		LinkURL: "https://google.com",
+	}
+
+	chattable := createTextMessage(article, 1024, messageOptions{Silent: true})
+
+	if assert.IsType(t, tgbotapi.MessageConfig{}, chattable) {
+		assert.True(t, chattable.(tgbotapi.MessageConfig).DisableNotification)
+	}
+}
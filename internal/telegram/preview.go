@@ -0,0 +1,112 @@
+package telegram
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// PostingMode defines how posts show article's image.
+type PostingMode string
+
+const (
+	PostingModeUpload      PostingMode = "upload"       // Image is downloaded and uploaded to Telegram as a photo.
+	PostingModeLinkPreview PostingMode = "link_preview" // Nothing is downloaded, Telegram shows a preview of article's link.
+)
+
+// ParsePostingMode parses a posting mode.
+func ParsePostingMode(str string) (PostingMode, error) {
+	switch m := PostingMode(strings.ToLower(strings.TrimSpace(str))); m {
+	case "", PostingModeUpload:
+		return PostingModeUpload, nil
+	case PostingModeLinkPreview:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown posting mode \"%s\", expected \"upload\" or \"link_preview\"", str)
+	}
+}
+
+func (m PostingMode) imageMode() imageMode {
+	if m == PostingModeLinkPreview {
+		return imageModeLinkPreview
+	}
+
+	return imageModeUpload
+}
+
+// PreviewPosition defines where Telegram shows a link preview relative to message's text.
+type PreviewPosition string
+
+const (
+	PreviewPositionBelow PreviewPosition = "below" // Preview is shown below the text, it's Telegram's default.
+	PreviewPositionAbove PreviewPosition = "above" // Preview is shown above the text.
+)
+
+// PreviewSize defines size of media in a link preview.
+type PreviewSize string
+
+const (
+	PreviewSizeAuto  PreviewSize = "auto"  // Telegram chooses the size.
+	PreviewSizeSmall PreviewSize = "small" // Media is shrunk.
+	PreviewSizeLarge PreviewSize = "large" // Media is enlarged.
+)
+
+// LinkPreview configures link previews of posts.
+type LinkPreview struct {
+	Position PreviewPosition
+	Size     PreviewSize
+}
+
+// ParsePreviewPosition parses a link preview position.
+func ParsePreviewPosition(str string) (PreviewPosition, error) {
+	switch p := PreviewPosition(strings.ToLower(strings.TrimSpace(str))); p {
+	case "", PreviewPositionBelow:
+		return PreviewPositionBelow, nil
+	case PreviewPositionAbove:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown link preview position \"%s\", expected \"above\" or \"below\"", str)
+	}
+}
+
+// ParsePreviewSize parses a link preview size.
+func ParsePreviewSize(str string) (PreviewSize, error) {
+	switch s := PreviewSize(strings.ToLower(strings.TrimSpace(str))); s {
+	case "", PreviewSizeAuto:
+		return PreviewSizeAuto, nil
+	case PreviewSizeSmall, PreviewSizeLarge:
+		return s, nil
+	default:
+		return "", fmt.Errorf("unknown link preview size \"%s\", expected \"auto\", \"small\" or \"large\"", str)
+	}
+}
+
+// linkPreviewOptions is a LinkPreviewOptions object of Bot API, which tgbotapi doesn't support.
+type linkPreviewOptions struct {
+	URL              string `json:"url,omitempty"`
+	PreferSmallMedia bool   `json:"prefer_small_media,omitempty"`
+	PreferLargeMedia bool   `json:"prefer_large_media,omitempty"`
+	ShowAboveText    bool   `json:"show_above_text,omitempty"`
+}
+
+// params returns request parameters that make Telegram show a preview of the URL.
+// It returns nil if Telegram's defaults are used, so messages may be sent by tgbotapi as is.
+func (p LinkPreview) params(linkURL string) (map[string]string, error) {
+	options := linkPreviewOptions{
+		URL:              linkURL,
+		PreferSmallMedia: p.Size == PreviewSizeSmall,
+		PreferLargeMedia: p.Size == PreviewSizeLarge,
+		ShowAboveText:    p.Position == PreviewPositionAbove,
+	}
+
+	if !options.PreferSmallMedia && !options.PreferLargeMedia && !options.ShowAboveText {
+		return nil, nil
+	}
+
+	bytes, err := json.Marshal(options)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{"link_preview_options": string(bytes)}, nil
+}
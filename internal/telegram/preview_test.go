@@ -0,0 +1,49 @@
+package telegram
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePostingMode(t *testing.T) {
+	mode, err := ParsePostingMode("")
+	require.NoError(t, err)
+	assert.Equal(t, PostingModeUpload, mode)
+
+	mode, err = ParsePostingMode(" Link_Preview ")
+	require.NoError(t, err)
+	assert.Equal(t, PostingModeLinkPreview, mode)
+
+	_, err = ParsePostingMode("inline")
+	assert.Error(t, err)
+}
+
+func TestParsePreview(t *testing.T) {
+	position, err := ParsePreviewPosition("above")
+	require.NoError(t, err)
+	assert.Equal(t, PreviewPositionAbove, position)
+
+	_, err = ParsePreviewPosition("left")
+	assert.Error(t, err)
+
+	size, err := ParsePreviewSize("")
+	require.NoError(t, err)
+	assert.Equal(t, PreviewSizeAuto, size)
+
+	_, err = ParsePreviewSize("huge")
+	assert.Error(t, err)
+}
+
+func TestLinkPreview_Params(t *testing.T) {
+	params, err := LinkPreview{Position: PreviewPositionBelow, Size: PreviewSizeAuto}.params("https://habr.com/post/1/")
+	require.NoError(t, err)
+	assert.Nil(t, params)
+
+	params, err = LinkPreview{Position: PreviewPositionAbove, Size: PreviewSizeSmall}.params("https://habr.com/post/1/")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"link_preview_options": `{"url":"https://habr.com/post/1/","prefer_small_media":true,"show_above_text":true}`,
+	}, params)
+}
@@ -0,0 +1,269 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/rs/zerolog/log"
+
+	"github.com/kapitanov/habrabot/internal/httpclient"
+)
+
+// ReconcileAction defines what happens to messages of articles that have been removed.
+type ReconcileAction string
+
+const (
+	ReconcileActionDelete ReconcileAction = "delete" // Messages are deleted.
+	ReconcileActionMark   ReconcileAction = "mark"   // Messages are marked as removed.
+)
+
+// ParseReconcileAction parses reconcile action name.
+func ParseReconcileAction(str string) (ReconcileAction, error) {
+	switch ReconcileAction(str) {
+	case ReconcileActionDelete, ReconcileActionMark:
+		return ReconcileAction(str), nil
+	default:
+		return "", fmt.Errorf("unknown reconcile action \"%s\", expected \"delete\" or \"mark\"", str)
+	}
+}
+
+// Reconciliation configures re-checking of recently posted articles.
+type Reconciliation struct {
+	Period time.Duration   // How often articles are re-checked.
+	Window time.Duration   // How long articles are re-checked after they have been posted.
+	Action ReconcileAction // What happens to messages of removed articles.
+	Marker string          // Text that is prepended to messages of removed articles.
+}
+
+// WithReconciliation enables re-checking of recently posted articles.
+// It requires posted messages to be tracked, see WithMessageStore.
+func WithReconciliation(reconciliation Reconciliation) Option {
+	return func(t *Transmitter) {
+		t.reconciliation = reconciliation
+	}
+}
+
+// Run re-checks recently posted articles periodically until the context is canceled.
+func (t *Transmitter) Run(ctx context.Context) {
+	if t.reconciliation.Period <= 0 || t.messages == nil {
+		return
+	}
+
+	log.Info().
+		Str("channel", t.channelNameOrID).
+		Dur("period", t.reconciliation.Period).
+		Str("action", string(t.reconciliation.Action)).
+		Msg("will re-check posted articles")
+
+	ticker := time.NewTicker(t.reconciliation.Period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := t.Reconcile(ctx)
+			if err != nil && ctx.Err() == nil {
+				log.Error().Err(err).Str("channel", t.channelNameOrID).Msg("unable to re-check posted articles")
+			}
+		}
+	}
+}
+
+// Reconcile re-checks recently posted articles once,
+// and deletes or marks messages of articles that have been removed.
+func (t *Transmitter) Reconcile(ctx context.Context) error {
+	messages, err := t.messages.List(t.channelNameOrID)
+	if err != nil {
+		return err
+	}
+
+	httpClient, err := t.getReconcileClient()
+	if err != nil {
+		return err
+	}
+
+	since := time.Now().Add(-t.reconciliation.Window)
+	for _, posted := range messages {
+		if posted.Removed || posted.PostedAt.Before(since) {
+			continue
+		}
+
+		removed, err := isArticleRemoved(ctx, posted.Article.LinkURL, httpClient)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			log.Warn().Err(err).Str("url", posted.Article.LinkURL).Msg("unable to re-check posted article")
+			continue
+		}
+
+		if removed {
+			err = t.handleRemovedArticle(ctx, posted)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// getReconcileClient returns an HTTP client that re-checks articles, it's created once and reused by later runs.
+func (t *Transmitter) getReconcileClient() (*retryablehttp.Client, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.reconcileClient == nil {
+		httpClient, err := httpclient.New(httpclient.ReconcilePolicy)
+		if err != nil {
+			return nil, err
+		}
+
+		t.reconcileClient = httpClient
+	}
+
+	return t.reconcileClient, nil
+}
+
+func isArticleRemoved(ctx context.Context, url string, httpClient *retryablehttp.Client) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := httpClient.StandardClient().Do(req)
+	if err != nil {
+		return false, err
+	}
+
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	return resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone, nil
+}
+
+func (t *Transmitter) handleRemovedArticle(ctx context.Context, posted postedMessage) error {
+	// Edits and deletions share the rate limit of the chat with new posts.
+	// The limiter is waited for before the mutex is taken, so posting isn't blocked meanwhile.
+	if t.limiter != nil {
+		err := t.limiter.Wait(ctx, t.channelNameOrID)
+		if err != nil {
+			return err
+		}
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	err := t.connect()
+	if err != nil {
+		return err
+	}
+
+	log.Info().
+		Str("id", posted.Article.ID).
+		Str("url", posted.Article.LinkURL).
+		Int("msg", posted.MessageID).
+		Msg("posted article has been removed")
+
+	if t.reconciliation.Action == ReconcileActionDelete {
+		deleted, err := t.deleteMessage(posted)
+		if err != nil {
+			return err
+		}
+
+		if deleted {
+			return t.messages.Delete(t.channelNameOrID, posted.Article.ID)
+		}
+
+		// Old messages can't be deleted by bots, so they are marked instead.
+	}
+
+	err = t.markMessage(ctx, posted)
+	if err != nil {
+		return err
+	}
+
+	posted.Removed = true
+	return t.messages.Put(t.channelNameOrID, posted)
+}
+
+// markMessage prepends the marker to the message of a removed article.
+// Messages are re-rendered from their stored articles, so a message without its article's text is left as is.
+func (t *Transmitter) markMessage(ctx context.Context, posted postedMessage) error {
+	if posted.Article.Title == "" {
+		log.Warn().Int("msg", posted.MessageID).Msg("unable to mark telegram message, its article isn't stored")
+		return nil
+	}
+
+	_, err := t.send(ctx, posted.Article, messageOptions{Keyboard: t.keyboard}, func(opts messageOptions) (tgbotapi.Message, error) {
+		result, err := t.bot.Send(createRemovedMarkEdit(posted, t.reconciliation.Marker, opts))
+		if err != nil && isMessageNotModified(err) {
+			return result, nil
+		}
+
+		return result, err
+	})
+	if err != nil && !isMessageNotFound(err) {
+		return err
+	}
+
+	return nil
+}
+
+func (t *Transmitter) deleteMessage(posted postedMessage) (bool, error) {
+	_, err := t.bot.DeleteMessage(tgbotapi.NewDeleteMessage(posted.ChatID, posted.MessageID))
+	if err == nil {
+		return true, nil
+	}
+
+	str := err.Error()
+	switch {
+	case strings.Contains(str, "message to delete not found"):
+		return true, nil
+	case strings.Contains(str, "message can't be deleted"):
+		log.Warn().Err(err).Int("msg", posted.MessageID).Msg("unable to delete telegram message, will mark it")
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+func createRemovedMarkEdit(posted postedMessage, marker string, opts messageOptions) tgbotapi.Chattable {
+	const separator = "\n\n"
+
+	maxLength := maxTextLength
+	if posted.HasCaption() {
+		maxLength = maxMediaCaptionLength
+	}
+
+	if !opts.PlainText {
+		marker = fmt.Sprintf("<b>%s</b>", html.EscapeString(marker))
+	}
+
+	text, parseMode := formatMessage(posted.Article, maxLength-unicodeLength(marker)-unicodeLength(separator), opts)
+	text = marker + separator + text
+
+	if posted.HasCaption() {
+		edit := tgbotapi.NewEditMessageCaption(posted.ChatID, posted.MessageID, text)
+		edit.ParseMode = parseMode
+		edit.ReplyMarkup = opts.Keyboard.markup(posted.Article)
+		return edit
+	}
+
+	edit := tgbotapi.NewEditMessageText(posted.ChatID, posted.MessageID, text)
+	edit.ParseMode = parseMode
+	edit.DisableWebPagePreview = posted.Kind != messageKindLinkPreview
+	edit.ReplyMarkup = opts.Keyboard.markup(posted.Article)
+	return edit
+}
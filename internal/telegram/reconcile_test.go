@@ -0,0 +1,139 @@
+package telegram
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kapitanov/habrabot/internal/data"
+	"github.com/kapitanov/habrabot/internal/httpclient"
+)
+
+func TestParseReconcileAction(t *testing.T) {
+	action, err := ParseReconcileAction("delete")
+	require.NoError(t, err)
+	assert.Equal(t, ReconcileActionDelete, action)
+
+	action, err = ParseReconcileAction("mark")
+	require.NoError(t, err)
+	assert.Equal(t, ReconcileActionMark, action)
+
+	_, err = ParseReconcileAction("ignore")
+	assert.Error(t, err)
+}
+
+func TestMessageStore_List(t *testing.T) {
+	store := &messageStore{store: inMemoryStore{}}
+	require.NoError(t, store.Put("@channel", postedMessage{MessageID: 1, Article: data.Article{ID: "1"}}))
+	require.NoError(t, store.Put("@channel", postedMessage{MessageID: 2, Article: data.Article{ID: "2"}}))
+	require.NoError(t, store.Put("@other", postedMessage{MessageID: 3, Article: data.Article{ID: "3"}}))
+
+	messages, err := store.List("@Channel")
+	require.NoError(t, err)
+
+	ids := make([]int, 0, len(messages))
+	for _, m := range messages {
+		ids = append(ids, m.MessageID)
+	}
+
+	assert.ElementsMatch(t, []int{1, 2}, ids)
+}
+
+func TestIsArticleRemoved(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/not-found":
+			w.WriteHeader(http.StatusNotFound)
+		case "/gone":
+			w.WriteHeader(http.StatusGone)
+		case "/forbidden":
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer server.Close()
+
+	httpClient, err := httpclient.New(httpclient.ReconcilePolicy)
+	require.NoError(t, err)
+
+	tests := map[string]bool{
+		"/article":   false,
+		"/not-found": true,
+		"/gone":      true,
+		"/forbidden": false,
+	}
+
+	for path, expected := range tests {
+		t.Run(path, func(t *testing.T) {
+			removed, err := isArticleRemoved(context.Background(), server.URL+path, httpClient)
+			require.NoError(t, err)
+			assert.Equal(t, expected, removed)
+		})
+	}
+}
+
+func TestCreateRemovedMarkEdit_Text(t *testing.T) {
+	posted := postedMessage{
+		ChatID:    1,
+		MessageID: 2,
+		Kind:      messageKindText,
+		Article:   data.Article{Title: "TITLE", Description: "TEXT", LinkURL: "https://google.com"},
+	}
+
+	chattable := createRemovedMarkEdit(posted, "Removed", messageOptions{})
+
+	if assert.IsType(t, tgbotapi.EditMessageTextConfig{}, chattable) {
+		edit := chattable.(tgbotapi.EditMessageTextConfig)
+		assert.Equal(t, 2, edit.MessageID)
+		assert.Equal(t, "<b>Removed</b>\n\n<a href=\"https://google.com\"><strong>TITLE</strong></a>\n\nTEXT", edit.Text)
+		assert.Equal(t, tgbotapi.ModeHTML, edit.ParseMode)
+		assert.True(t, edit.DisableWebPagePreview)
+	}
+}
+
+func TestCreateRemovedMarkEdit_Caption(t *testing.T) {
+	posted := postedMessage{
+		ChatID:    1,
+		MessageID: 2,
+		Kind:      messageKindPhoto,
+		Article:   data.Article{Title: "TITLE", Description: "TEXT", LinkURL: "https://google.com"},
+	}
+
+	chattable := createRemovedMarkEdit(posted, "<Removed>", messageOptions{PlainText: true})
+
+	if assert.IsType(t, tgbotapi.EditMessageCaptionConfig{}, chattable) {
+		edit := chattable.(tgbotapi.EditMessageCaptionConfig)
+		assert.Equal(t, "<Removed>\n\nTITLE\n\nTEXT\n\nhttps://google.com", edit.Caption)
+		assert.Empty(t, edit.ParseMode)
+	}
+}
+
+func TestReconcile_SkipsOldAndRemovedMessages(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	store := &messageStore{store: inMemoryStore{}}
+	now := time.Now()
+	require.NoError(t, store.Put("@channel", postedMessage{PostedAt: now, Article: data.Article{ID: "1", LinkURL: server.URL}}))
+	require.NoError(t, store.Put("@channel", postedMessage{PostedAt: now.Add(-time.Hour), Article: data.Article{ID: "2", LinkURL: server.URL}}))
+	require.NoError(t, store.Put("@channel", postedMessage{PostedAt: now, Removed: true, Article: data.Article{ID: "3", LinkURL: server.URL}}))
+
+	tr := New("token", "@channel", WithReconciliation(Reconciliation{Window: time.Minute, Action: ReconcileActionMark}))
+	tr.messages = store
+
+	err := tr.Reconcile(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, requests)
+}
@@ -0,0 +1,165 @@
+package telegram
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// sendWithParams sends a message with request parameters that Bot API library doesn't support,
+// e.g. forum topics or link preview options. Such requests are made directly, like for editMessageMedia.
+func sendWithParams(bot *tgbotapi.BotAPI, msg tgbotapi.Chattable, extra map[string]string) (tgbotapi.Message, error) {
+	if len(extra) == 0 {
+		return bot.Send(msg)
+	}
+
+	req, err := newRawRequest(msg, extra)
+	if err != nil {
+		return tgbotapi.Message{}, err
+	}
+
+	var resp tgbotapi.APIResponse
+	if req.File != nil {
+		resp, err = bot.UploadFile(req.Method, req.Params, req.FileField, req.File)
+	} else {
+		values := url.Values{}
+		for key, value := range req.Params {
+			values.Set(key, value)
+		}
+
+		resp, err = bot.MakeRequest(req.Method, values)
+	}
+
+	if err != nil {
+		return tgbotapi.Message{}, err
+	}
+
+	var result tgbotapi.Message
+	err = json.Unmarshal(resp.Result, &result)
+	if err != nil {
+		return tgbotapi.Message{}, err
+	}
+
+	return result, nil
+}
+
+// rawRequest is a Bot API request built from a message of Bot API library.
+type rawRequest struct {
+	Method    string
+	Params    map[string]string
+	FileField string      // Name of the uploaded file's field, if any.
+	File      interface{} // Uploaded file, if any.
+}
+
+func newRawRequest(msg tgbotapi.Chattable, extra map[string]string) (rawRequest, error) {
+	var req rawRequest
+
+	switch m := msg.(type) {
+	case tgbotapi.MessageConfig:
+		params, err := chatParams(m.BaseChat)
+		if err != nil {
+			return rawRequest{}, err
+		}
+
+		setTextParams(params, m.Text, m.ParseMode, m.DisableWebPagePreview)
+		req = rawRequest{Method: "sendMessage", Params: params}
+
+	case tgbotapi.EditMessageTextConfig:
+		params, err := editParams(m.BaseEdit)
+		if err != nil {
+			return rawRequest{}, err
+		}
+
+		setTextParams(params, m.Text, m.ParseMode, m.DisableWebPagePreview)
+		req = rawRequest{Method: "editMessageText", Params: params}
+
+	case tgbotapi.PhotoConfig:
+		params, err := chatParams(m.BaseChat)
+		if err != nil {
+			return rawRequest{}, err
+		}
+
+		setCaptionParams(params, m.Caption, m.ParseMode)
+		req = rawRequest{Method: "sendPhoto", Params: params, FileField: "photo", File: m.File}
+
+	case tgbotapi.DocumentConfig:
+		params, err := chatParams(m.BaseChat)
+		if err != nil {
+			return rawRequest{}, err
+		}
+
+		setCaptionParams(params, m.Caption, m.ParseMode)
+		req = rawRequest{Method: "sendDocument", Params: params, FileField: "document", File: m.File}
+
+	default:
+		return rawRequest{}, fmt.Errorf("unable to send %T with extra parameters", msg)
+	}
+
+	for key, value := range extra {
+		req.Params[key] = value
+	}
+
+	if _, ok := req.Params["link_preview_options"]; ok {
+		// Link preview options replace the deprecated parameter.
+		delete(req.Params, "disable_web_page_preview")
+	}
+
+	return req, nil
+}
+
+func chatParams(chat tgbotapi.BaseChat) (map[string]string, error) {
+	params := map[string]string{
+		"chat_id":              strconv.FormatInt(chat.ChatID, 10),
+		"disable_notification": strconv.FormatBool(chat.DisableNotification),
+	}
+
+	if chat.ReplyMarkup != nil {
+		bytes, err := json.Marshal(chat.ReplyMarkup)
+		if err != nil {
+			return nil, err
+		}
+
+		params["reply_markup"] = string(bytes)
+	}
+
+	return params, nil
+}
+
+func editParams(edit tgbotapi.BaseEdit) (map[string]string, error) {
+	params := map[string]string{
+		"chat_id":    strconv.FormatInt(edit.ChatID, 10),
+		"message_id": strconv.Itoa(edit.MessageID),
+	}
+
+	if edit.ReplyMarkup != nil {
+		bytes, err := json.Marshal(edit.ReplyMarkup)
+		if err != nil {
+			return nil, err
+		}
+
+		params["reply_markup"] = string(bytes)
+	}
+
+	return params, nil
+}
+
+func setTextParams(params map[string]string, text, parseMode string, disableWebPagePreview bool) {
+	params["text"] = text
+	params["disable_web_page_preview"] = strconv.FormatBool(disableWebPagePreview)
+	if parseMode != "" {
+		params["parse_mode"] = parseMode
+	}
+}
+
+func setCaptionParams(params map[string]string, caption, parseMode string) {
+	if caption != "" {
+		params["caption"] = caption
+	}
+
+	if parseMode != "" {
+		params["parse_mode"] = parseMode
+	}
+}
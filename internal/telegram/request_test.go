@@ -0,0 +1,67 @@
+package telegram
+
+import (
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kapitanov/habrabot/internal/data"
+)
+
+func TestNewRawRequest_Message(t *testing.T) {
+	article := data.Article{Title: "TITLE", LinkURL: "https://habr.com/post/1/"}
+	keyboard, err := ParseKeyboard("Read={{.LinkURL}}", nil)
+	require.NoError(t, err)
+
+	msg := createTextMessage(article, -100, messageOptions{Silent: true, Keyboard: keyboard}).(tgbotapi.MessageConfig)
+
+	req, err := newRawRequest(msg, threadParams(12))
+	require.NoError(t, err)
+
+	assert.Equal(t, "sendMessage", req.Method)
+	assert.Nil(t, req.File)
+	assert.Equal(t, map[string]string{
+		"chat_id":                  "-100",
+		"message_thread_id":        "12",
+		"text":                     msg.Text,
+		"parse_mode":               tgbotapi.ModeHTML,
+		"disable_web_page_preview": "true",
+		"disable_notification":     "true",
+		"reply_markup":             `{"inline_keyboard":[[{"text":"Read","url":"https://habr.com/post/1/"}]]}`,
+	}, req.Params)
+}
+
+func TestNewRawRequest_LinkPreviewEdit(t *testing.T) {
+	article := data.Article{Title: "TITLE", LinkURL: "https://habr.com/post/1/"}
+	posted := postedMessage{ChatID: -100, MessageID: 42, Kind: messageKindLinkPreview}
+
+	preview, err := LinkPreview{Position: PreviewPositionAbove}.params(article.LinkURL)
+	require.NoError(t, err)
+
+	req, err := newRawRequest(createTextEdit(article, posted, messageOptions{}), preview)
+	require.NoError(t, err)
+
+	assert.Equal(t, "editMessageText", req.Method)
+	assert.Equal(t, "42", req.Params["message_id"])
+	assert.Equal(t, `{"url":"https://habr.com/post/1/","show_above_text":true}`, req.Params["link_preview_options"])
+	assert.NotContains(t, req.Params, "disable_web_page_preview")
+}
+
+func TestNewRawRequest_Photo(t *testing.T) {
+	photo := tgbotapi.NewPhotoUpload(-100, tgbotapi.FileBytes{Bytes: []byte("image")})
+	photo.Caption = "CAPTION"
+
+	req, err := newRawRequest(photo, threadParams(12))
+	require.NoError(t, err)
+
+	assert.Equal(t, "sendPhoto", req.Method)
+	assert.Equal(t, "photo", req.FileField)
+	assert.Equal(t, photo.File, req.File)
+	assert.Equal(t, "CAPTION", req.Params["caption"])
+	assert.Equal(t, "12", req.Params["message_thread_id"])
+
+	_, err = newRawRequest(tgbotapi.NewDeleteMessage(-100, 42), threadParams(12))
+	assert.Error(t, err)
+}
@@ -0,0 +1,138 @@
+package telegram
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+
+	"github.com/kapitanov/habrabot/internal/data"
+)
+
+// Store is a persistent key-value storage.
+type Store interface {
+	// Get loads a value by its key. It returns false if the key doesn't exist.
+	Get(key string, value interface{}) (bool, error)
+
+	// Put stores a value by its key.
+	Put(key string, value interface{}) error
+
+	// Delete removes a value by its key.
+	Delete(key string) error
+
+	// ForEach iterates over values with keys starting with the specified prefix.
+	ForEach(prefix string, fn func(key string, value []byte) error) error
+}
+
+// messageKind defines how an article has been posted.
+type messageKind string
+
+const (
+	messageKindText        messageKind = "text"
+	messageKindLinkPreview messageKind = "link_preview"
+	messageKindPhoto       messageKind = "photo"
+	messageKindDocument    messageKind = "document"
+)
+
+func messageKindOf(msg tgbotapi.Chattable) messageKind {
+	switch m := msg.(type) {
+	case tgbotapi.PhotoConfig:
+		return messageKindPhoto
+	case tgbotapi.DocumentConfig:
+		return messageKindDocument
+	case tgbotapi.MessageConfig:
+		if !m.DisableWebPagePreview {
+			return messageKindLinkPreview
+		}
+	}
+
+	return messageKindText
+}
+
+// postedMessage describes a message that has been posted for an article.
+type postedMessage struct {
+	ChatID    int64        `json:"chat_id"`
+	MessageID int          `json:"message_id"`
+	Kind      messageKind  `json:"kind"`
+	Hash      string       `json:"hash"`                // Content hash of the article.
+	ImageURL  string       `json:"image_url,omitempty"` // URL of the image shown in the message.
+	PostedAt  time.Time    `json:"posted_at"`
+	Removed   bool         `json:"removed,omitempty"` // Article has been removed from the web site.
+	Article   data.Article `json:"article"`
+}
+
+// HasCaption returns true if the message is a media message with a caption.
+func (m postedMessage) HasCaption() bool {
+	return m.Kind == messageKindPhoto || m.Kind == messageKindDocument
+}
+
+type messageStore struct {
+	store Store
+}
+
+func (s *messageStore) Get(chat, articleID string) (postedMessage, bool, error) {
+	var posted postedMessage
+	found, err := s.store.Get(messageKey(chat, articleID), &posted)
+	if err != nil {
+		return postedMessage{}, false, err
+	}
+
+	return posted, found, nil
+}
+
+func (s *messageStore) Put(chat string, posted postedMessage) error {
+	return s.store.Put(messageKey(chat, posted.Article.ID), posted)
+}
+
+func (s *messageStore) Delete(chat, articleID string) error {
+	return s.store.Delete(messageKey(chat, articleID))
+}
+
+// List returns all messages posted into the chat.
+func (s *messageStore) List(chat string) ([]postedMessage, error) {
+	var messages []postedMessage
+
+	err := s.store.ForEach(messageKey(chat, ""), func(_ string, value []byte) error {
+		var posted postedMessage
+		err := json.Unmarshal(value, &posted)
+		if err != nil {
+			return err
+		}
+
+		messages = append(messages, posted)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// MigrateChat updates messages posted into the chat once it's been upgraded to a supergroup.
+func (s *messageStore) MigrateChat(chat string, from, to int64) error {
+	messages, err := s.List(chat)
+	if err != nil {
+		return err
+	}
+
+	for _, posted := range messages {
+		if posted.ChatID != from {
+			continue
+		}
+
+		posted.ChatID = to
+		err = s.Put(chat, posted)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func messageKey(chat, articleID string) string {
+	return fmt.Sprintf("%s/%s", strings.ToLower(chat), strings.ToLower(articleID))
+}
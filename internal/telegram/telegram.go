@@ -3,7 +3,10 @@ package telegram
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
 	"github.com/hashicorp/go-retryablehttp"
@@ -11,30 +14,200 @@ import (
 
 	"github.com/kapitanov/habrabot/internal/data"
 	"github.com/kapitanov/habrabot/internal/httpclient"
+	"github.com/kapitanov/habrabot/internal/ratelimit"
 )
 
 // New creates new consumed that publishes messages into Telegram channel.
-func New(token, channelNameOrID string) data.Consumer {
-	t := &transmitter{
+func New(token, channelNameOrID string, options ...Option) *Transmitter {
+	t := &Transmitter{
 		token:           token,
 		channelNameOrID: channelNameOrID,
 		bot:             nil,
 		chat:            nil,
 	}
 
+	for _, option := range options {
+		option(t)
+	}
+
 	return t
 }
 
-type transmitter struct {
+// Option configures Telegram consumer.
+type Option func(t *Transmitter)
+
+// WithMessageStore enables tracking of posted messages,
+// so messages are edited when their articles are updated instead of being posted again.
+func WithMessageStore(store Store) Option {
+	return func(t *Transmitter) {
+		t.messages = &messageStore{store: store}
+	}
+}
+
+// WithMediaEdits enables replacing message's image when article's image changes.
+func WithMediaEdits() Option {
+	return func(t *Transmitter) {
+		t.editMedia = true
+	}
+}
+
+// WithKeyboard attaches an inline keyboard to posts.
+func WithKeyboard(keyboard Keyboard) Option {
+	return func(t *Transmitter) {
+		t.keyboard = keyboard
+	}
+}
+
+// WithTopics sends posts into forum topics of a supergroup selected by article tags.
+func WithTopics(topics Topics) Option {
+	return func(t *Transmitter) {
+		t.topics = topics
+	}
+}
+
+// WithPostingMode selects how posts show article's image.
+func WithPostingMode(mode PostingMode) Option {
+	return func(t *Transmitter) {
+		t.postingMode = mode
+	}
+}
+
+// WithLinkPreview configures link previews of posts.
+// They are shown in the link preview posting mode, and when article's image can't be uploaded.
+func WithLinkPreview(preview LinkPreview) Option {
+	return func(t *Transmitter) {
+		t.linkPreview = preview
+	}
+}
+
+// WithRateLimiter makes edits and deletions of posted messages wait for the rate limiter of the chat.
+// New posts are expected to be limited by the caller, see ratelimit.Use.
+func WithRateLimiter(limiter *ratelimit.Limiter) Option {
+	return func(t *Transmitter) {
+		t.limiter = limiter
+	}
+}
+
+// WithListener makes the transmitter pass articles to the listener once they have been posted.
+// Edits of posted messages aren't passed, and listener's errors don't fail posting.
+func WithListener(listener data.Consumer) Option {
+	return func(t *Transmitter) {
+		t.listener = listener
+	}
+}
+
+// Transmitter is a consumer that publishes messages into Telegram channel.
+type Transmitter struct {
 	token           string
 	channelNameOrID string
 	httpClient      *retryablehttp.Client
+	reconcileClient *retryablehttp.Client
+	limiter         *ratelimit.Limiter
+	listener        data.Consumer
 	bot             *tgbotapi.BotAPI
 	chat            *tgbotapi.Chat
+	unavailable     error
+	retryAt         time.Time // Posting into an unavailable chat is attempted again after this time.
+	messages        *messageStore
+	editMedia       bool
+	reconciliation  Reconciliation
+	keyboard        Keyboard
+	topics          Topics
+	postingMode     PostingMode
+	linkPreview     LinkPreview
+
+	// mutex serializes access to Bot API between posting and reconciliation.
+	mutex sync.Mutex
 }
 
+const maxSendRetries = 5
+
+// unavailableRetryPeriod is a time after which posting into a chat that the bot has lost access to is attempted again,
+// so the bot recovers once access is restored.
+const unavailableRetryPeriod = time.Hour
+
 // On method is invoked when an article is received from the feed.
-func (t *transmitter) On(ctx context.Context, article data.Article) error {
+func (t *Transmitter) On(ctx context.Context, article data.Article) error {
+	posted, err := t.post(ctx, article)
+	if err != nil || !posted || t.listener == nil {
+		return err
+	}
+
+	// The article has been published already, so listener's failures are logged only.
+	// Listener is invoked without holding the mutex, so it doesn't delay other posts.
+	err = t.listener.On(ctx, article)
+	if err != nil {
+		log.Error().Err(err).Str("id", article.ID).Msg("unable to notify about posted telegram message")
+	}
+
+	return nil
+}
+
+// post publishes a new article, or edits the message of an article that has been posted before.
+// It returns true if a new message has been posted.
+func (t *Transmitter) post(ctx context.Context, article data.Article) (bool, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	err := t.connect()
+	if err != nil {
+		return false, err
+	}
+
+	if t.messages != nil {
+		posted, found, err := t.messages.Get(t.channelNameOrID, article.ID)
+		if err != nil {
+			log.Error().Err(err).Str("id", article.ID).Msg("unable to load posted telegram message")
+			return false, err
+		}
+
+		if found {
+			return false, t.update(ctx, article, posted)
+		}
+
+		if article.Updated {
+			// The article has been posted before its messages were tracked, so it can't be edited.
+			// Posting it again would only duplicate the post.
+			log.Info().Str("id", article.ID).Msg("posted telegram message not found, updated feed item is skipped")
+			return false, nil
+		}
+	}
+
+	opts := messageOptions{
+		ImageMode: t.postingMode.imageMode(),
+		Silent:    data.IsSilentDelivery(ctx),
+		Keyboard:  t.keyboard,
+	}
+
+	err = t.transmit(ctx, article, opts)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Forget removes the record of a message posted for the article,
+// so the article is posted again instead of being edited.
+func (t *Transmitter) Forget(articleID string) error {
+	if t.messages == nil {
+		return nil
+	}
+
+	return t.messages.Delete(t.channelNameOrID, articleID)
+}
+
+func (t *Transmitter) connect() error {
+	if t.unavailable != nil {
+		if time.Now().Before(t.retryAt) {
+			return t.unavailable
+		}
+
+		// The next post checks whether access has been restored, it's made unavailable again otherwise.
+		log.Info().Str("chat", t.channelNameOrID).Msg("retrying to post into unavailable telegram chat")
+		t.unavailable = nil
+	}
+
 	err := t.connectToTelegram()
 	if err != nil {
 		log.Error().Err(err).Msg("unable to connect to telegram")
@@ -47,58 +220,217 @@ func (t *transmitter) On(ctx context.Context, article data.Article) error {
 		return err
 	}
 
-	return t.transmit(ctx, article)
+	return nil
 }
 
-func (t *transmitter) transmit(ctx context.Context, article data.Article) error {
-	msg, err := prepareMessage(ctx, article, t.chat.ID, t.httpClient.StandardClient())
+func (t *Transmitter) transmit(ctx context.Context, article data.Article, opts messageOptions) error {
+	var kind messageKind
+
+	result, err := t.send(ctx, article, opts, func(opts messageOptions) (tgbotapi.Message, error) {
+		msg, err := prepareMessage(ctx, article, t.chat.ID, t.httpClient.StandardClient(), opts)
+		if err != nil {
+			log.Error().Err(err).Msg("unable to prepare telegram message")
+			return tgbotapi.Message{}, err
+		}
+
+		params, err := t.messageParams(article, msg)
+		if err != nil {
+			return tgbotapi.Message{}, err
+		}
+
+		kind = messageKindOf(msg)
+		return sendWithParams(t.bot, msg, params)
+	})
 	if err != nil {
-		log.Error().Err(err).Msg("unable to prepare telegram message")
 		return err
 	}
 
-	result, err := t.bot.Send(msg)
+	log.Info().
+		Int("msg", result.MessageID).
+		Str("channel", chatName(t.chat)).
+		Str("title", article.Title).
+		Str("id", article.ID).
+		Msg("posted a telegram message")
+
+	if t.messages != nil {
+		posted := postedMessage{
+			ChatID:    t.chat.ID,
+			MessageID: result.MessageID,
+			Kind:      kind,
+			Hash:      data.ContentHash(article),
+			PostedAt:  time.Now().UTC(),
+			Article:   article,
+		}
+
+		if article.ImageURL != nil {
+			posted.ImageURL = *article.ImageURL
+		}
+
+		t.storePostedMessage(posted)
+	}
+
+	return nil
+}
+
+// messageParams returns request parameters of a post that Bot API library doesn't support.
+func (t *Transmitter) messageParams(article data.Article, msg tgbotapi.Chattable) (map[string]string, error) {
+	params := threadParams(t.topics.thread(article))
+	if messageKindOf(msg) != messageKindLinkPreview {
+		return params, nil
+	}
+
+	preview, err := t.linkPreview.params(article.LinkURL)
 	if err != nil {
-		if shouldRetry(article, err) {
+		return nil, err
+	}
+
+	if params == nil {
+		return preview, nil
+	}
+
+	for key, value := range preview {
+		params[key] = value
+	}
+
+	return params, nil
+}
+
+// send invokes a Bot API request and repeats it while Telegram errors are recoverable.
+func (t *Transmitter) send(
+	ctx context.Context,
+	article data.Article,
+	opts messageOptions,
+	request func(opts messageOptions) (tgbotapi.Message, error),
+) (tgbotapi.Message, error) {
+	for retries := 0; ; retries++ {
+		result, err := request(opts)
+		if err == nil {
+			return result, nil
+		}
+
+		if retries < maxSendRetries {
 			log.Warn().
 				Err(err).
 				Str("title", article.Title).
 				Str("id", article.ID).
 				Msg("unable to send to telegram")
 
-			article.ImageURL = nil
-			return t.transmit(ctx, article)
+			opts, err = t.handleSendError(ctx, article, opts, err)
 		}
 
-		log.Error().
-			Err(err).
-			Str("title", article.Title).
-			Str("id", article.ID).
-			Msg("unable to send to telegram")
-		return err
+		if err != nil {
+			log.Error().
+				Err(err).
+				Str("title", article.Title).
+				Str("id", article.ID).
+				Msg("unable to send to telegram")
+			return tgbotapi.Message{}, err
+		}
 	}
+}
 
-	log.Info().
-		Int("msg", result.MessageID).
-		Str("channel", fmt.Sprintf("@%v", t.chat.UserName)).
-		Str("title", article.Title).
-		Str("id", article.ID).
-		Msg("posted a telegram message")
-	return nil
+func (t *Transmitter) storePostedMessage(posted postedMessage) {
+	// The message has been posted already, so failing here would only make it posted twice.
+	err := t.messages.Put(t.channelNameOrID, posted)
+	if err != nil {
+		log.Error().Err(err).Str("id", posted.Article.ID).Msg("unable to store posted telegram message")
+	}
+}
+
+// handleSendError decides how a message rejected by Telegram should be sent again.
+// It returns updated message options, or an error if the message shouldn't be retried.
+func (t *Transmitter) handleSendError(
+	ctx context.Context,
+	article data.Article,
+	opts messageOptions,
+	err error,
+) (messageOptions, error) {
+	apiErr, ok := AsAPIError(err)
+	if !ok {
+		return opts, err
+	}
+
+	switch {
+	case apiErr.IsRateLimited():
+		log.Warn().Dur("retry_after", apiErr.RetryAfter).Msg("telegram rate limit exceeded")
+		return opts, sleep(ctx, apiErr.RetryAfter)
+
+	case apiErr.IsChatMigrated():
+		t.migrateChat(apiErr.MigrateToChatID)
+		return opts, nil
+
+	case apiErr.IsForbidden():
+		t.unavailable = fmt.Errorf("%w: %s", ErrDestinationUnavailable, apiErr.Description)
+		t.retryAt = time.Now().Add(unavailableRetryPeriod)
+		return opts, t.unavailable
+
+	case apiErr.IsEntityParseError() && !opts.PlainText:
+		opts.PlainText = true
+		return opts, nil
+	}
+
+	if fallback, ok := fallbackImageMode(article, opts.ImageMode, apiErr); ok {
+		opts.ImageMode = fallback
+		return opts, nil
+	}
+
+	return opts, err
+}
+
+// migrateChat switches to the supergroup that the chat has been upgraded to, along with messages posted into the chat.
+// Configuration isn't updated, so the operator is asked to do it.
+func (t *Transmitter) migrateChat(chatID int64) {
+	log.Warn().
+		Int64("from", t.chat.ID).
+		Int64("to", chatID).
+		Str("chat", t.channelNameOrID).
+		Msg("telegram chat has been migrated, its new ID should be configured")
+
+	from := t.chat.ID
+	t.chat.ID = chatID
+
+	if t.messages != nil {
+		err := t.messages.MigrateChat(t.channelNameOrID, from, chatID)
+		if err != nil {
+			log.Error().Err(err).Str("chat", t.channelNameOrID).Msg("unable to migrate posted telegram messages")
+		}
+	}
 }
 
-func shouldRetry(article data.Article, err error) bool {
-	if article.ImageURL == nil {
-		return false
+// fallbackImageMode selects a less demanding way to deliver an article's image after Telegram has rejected a message.
+func fallbackImageMode(article data.Article, mode imageMode, err *APIError) (imageMode, bool) {
+	if article.ImageURL == nil || mode != imageModeUpload {
+		return mode, false
+	}
+
+	if isRejectedImageError(err.Description) {
+		return imageModeLinkPreview, true
 	}
 
-	str := err.Error()
+	return mode, false
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
 
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func isRejectedImageError(str string) bool {
 	return strings.Contains(str, "PHOTO_INVALID_DIMENSIONS") ||
-		strings.Contains(str, "can't parse entities")
+		strings.Contains(str, "IMAGE_PROCESS_FAILED") ||
+		strings.Contains(str, "PHOTO_SAVE_FILE_INVALID") ||
+		strings.Contains(str, "failed to get HTTP URL content") ||
+		strings.Contains(str, "wrong file identifier")
 }
 
-func (t *transmitter) createHTTPClient() error {
+func (t *Transmitter) createHTTPClient() error {
 	if t.httpClient != nil {
 		return nil
 	}
@@ -121,7 +453,7 @@ func createHTTPClient() (*retryablehttp.Client, error) {
 	return httpClient, nil
 }
 
-func (t *transmitter) connectToTelegram() error {
+func (t *Transmitter) connectToTelegram() error {
 	if t.bot != nil {
 		return nil
 	}
@@ -157,7 +489,7 @@ func connectToTelegram(httpClient *retryablehttp.Client, token string) (*tgbotap
 	return bot, nil
 }
 
-func (t *transmitter) selectChat() error {
+func (t *Transmitter) selectChat() error {
 	if t.chat == nil {
 		chat, err := selectChat(t.bot, t.channelNameOrID)
 		if err != nil {
@@ -171,14 +503,33 @@ func (t *transmitter) selectChat() error {
 }
 
 func selectChat(bot *tgbotapi.BotAPI, channelNameOrID string) (*tgbotapi.Chat, error) {
-	chat, err := bot.GetChat(tgbotapi.ChatConfig{SuperGroupUsername: channelNameOrID})
+	chat, err := bot.GetChat(chatConfig(channelNameOrID))
 	if err != nil {
 		return nil, err
 	}
 
 	log.Info().
-		Str("channel", fmt.Sprintf("@%v", chat.UserName)).
+		Str("channel", chatName(&chat)).
 		Int64("id", chat.ID).
 		Msg("will post messages to telegram channel")
 	return &chat, nil
 }
+
+// chatConfig selects a chat by its numeric ID, e.g. "-1001234567890", or by its username, e.g. "@channel".
+func chatConfig(channelNameOrID string) tgbotapi.ChatConfig {
+	id, err := strconv.ParseInt(channelNameOrID, 10, 64)
+	if err == nil {
+		return tgbotapi.ChatConfig{ChatID: id}
+	}
+
+	return tgbotapi.ChatConfig{SuperGroupUsername: channelNameOrID}
+}
+
+// chatName returns a human-readable name of a chat, since private supergroups have no username.
+func chatName(chat *tgbotapi.Chat) string {
+	if chat.UserName != "" {
+		return fmt.Sprintf("@%v", chat.UserName)
+	}
+
+	return chat.Title
+}
@@ -0,0 +1,94 @@
+package telegramtest
+
+import (
+	"html"
+	"strings"
+	"unicode/utf16"
+)
+
+// htmlTags are tags that Telegram supports in HTML parse mode.
+var htmlTags = map[string]bool{
+	"b": true, "strong": true,
+	"i": true, "em": true,
+	"u": true, "ins": true,
+	"s": true, "strike": true, "del": true,
+	"a": true, "code": true, "pre": true,
+	"span": true, "tg-spoiler": true, "tg-emoji": true,
+	"blockquote": true,
+}
+
+// parseText parses markup of a text and returns the text without markup.
+// Only HTML markup is validated, other parse modes are accepted as is.
+func parseText(text, parseMode string) (string, error) {
+	if !strings.EqualFold(parseMode, "HTML") {
+		return text, nil
+	}
+
+	return parseHTML(text)
+}
+
+// parseHTML validates HTML markup the way Telegram does and strips tags from the text.
+func parseHTML(text string) (string, error) {
+	var (
+		sb    strings.Builder
+		stack []string
+	)
+
+	for i := 0; i < len(text); {
+		if text[i] != '<' {
+			end := strings.IndexByte(text[i:], '<')
+			if end < 0 {
+				end = len(text) - i
+			}
+
+			sb.WriteString(html.UnescapeString(text[i : i+end]))
+			i += end
+			continue
+		}
+
+		end := strings.IndexByte(text[i:], '>')
+		if end < 0 {
+			return "", badRequest("can't parse entities: Can't find end of the tag at byte offset %d", i)
+		}
+
+		tag := text[i+1 : i+end]
+		if strings.HasPrefix(tag, "/") {
+			name := strings.ToLower(strings.TrimSpace(tag[1:]))
+			if len(stack) == 0 {
+				return "", badRequest("can't parse entities: Unexpected end tag at byte offset %d", i)
+			}
+
+			if name != stack[len(stack)-1] {
+				return "", badRequest(
+					"can't parse entities: Can't find end tag corresponding to start tag \"%s\"", stack[len(stack)-1],
+				)
+			}
+
+			stack = stack[:len(stack)-1]
+		} else {
+			var name string
+			if fields := strings.Fields(tag); len(fields) > 0 {
+				name = strings.ToLower(fields[0])
+			}
+
+			if !htmlTags[name] {
+				return "", badRequest("can't parse entities: Unsupported start tag \"%s\" at byte offset %d", name, i)
+			}
+
+			stack = append(stack, name)
+		}
+
+		i += end + 1
+	}
+
+	if len(stack) > 0 {
+		return "", badRequest("can't parse entities: Can't find end tag corresponding to start tag \"%s\"", stack[len(stack)-1])
+	}
+
+	return sb.String(), nil
+}
+
+// textLength returns a length of a text in UTF-16 code units, which is how Telegram measures texts.
+func textLength(text string) int {
+	return len(utf16.Encode([]rune(text)))
+}
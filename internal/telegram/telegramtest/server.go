@@ -0,0 +1,654 @@
+// Package telegramtest provides a fake Telegram Bot API server for end-to-end tests.
+package telegramtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	// Image formats that Telegram accepts as photos.
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+
+	"github.com/kapitanov/habrabot/internal/httpclient"
+)
+
+// Limits of Bot API, in UTF-16 code units of a text without markup.
+const (
+	MaxTextLength    = 4096
+	MaxCaptionLength = 1024
+)
+
+const maxUploadSize = 64 * 1024 * 1024
+
+// Message is a message that has been sent to the fake server.
+type Message struct {
+	ID                    int
+	ChatID                int64
+	ThreadID              int
+	Kind                  string // "text", "photo" or "document".
+	Text                  string // Text of a text message or caption of a media message, without markup.
+	RawText               string // Text or caption as it has been sent, with markup.
+	ParseMode             string
+	ReplyMarkup           string // Inline keyboard as JSON.
+	File                  []byte // Uploaded photo or document.
+	MediaGroupID          string
+	DisableWebPagePreview bool
+	LinkPreviewOptions    string // Link preview options as JSON.
+	DisableNotification   bool
+	Edited                bool
+	Deleted               bool
+}
+
+// Server is a fake Bot API server.
+// It keeps sent messages in memory and validates requests like Telegram does,
+// so it rejects malformed markup, messages and captions that are too long and unknown chats.
+type Server struct {
+	// URL is a base URL of the server, which may be used as a Bot API endpoint.
+	URL string
+
+	token    string
+	server   *httptest.Server
+	mutex    sync.Mutex
+	chats    []tgbotapi.Chat
+	messages []*Message
+	calls    map[string]int
+	lastID   int
+
+	floodRequests   int
+	floodRetryAfter int
+	rejected        map[string]string // Descriptions of errors that requests are rejected with, by methods.
+}
+
+// NewServer starts a fake Bot API server for a bot with the specified token.
+// Caller should call Close when finished, to shut it down.
+func NewServer(token string) *Server {
+	s := &Server{
+		token: token,
+		calls: make(map[string]int),
+	}
+
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.server.URL
+
+	return s
+}
+
+// Close shuts down the server.
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// Client returns an HTTP client that sends Bot API requests to the server.
+func (s *Server) Client() *http.Client {
+	transport, err := httpclient.NewEndpointTransport(s.URL, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	return &http.Client{Transport: transport}
+}
+
+// AddChat makes a chat available to the bot.
+// Chats are found by their IDs and by "@username".
+func (s *Server) AddChat(chat tgbotapi.Chat) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.chats = append(s.chats, chat)
+}
+
+// Flood makes the server reject the specified number of next send, edit and delete requests
+// with "429 Too Many Requests" error, asking to retry after the specified number of seconds.
+func (s *Server) Flood(requests, retryAfter int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.floodRequests = requests
+	s.floodRetryAfter = retryAfter
+}
+
+// Reject makes the server reject all next requests to the method with "400 Bad Request" error with the description.
+func (s *Server) Reject(method, description string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.rejected == nil {
+		s.rejected = make(map[string]string)
+	}
+
+	s.rejected[method] = description
+}
+
+// Messages returns messages sent into the chat, including deleted ones.
+func (s *Server) Messages(chatID int64) []Message {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var messages []Message
+	for _, m := range s.messages {
+		if m.ChatID == chatID {
+			messages = append(messages, *m)
+		}
+	}
+
+	return messages
+}
+
+// Calls returns a number of requests to the method, including rejected ones.
+func (s *Server) Calls(method string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.calls[method]
+}
+
+// apiError is an error response of Bot API.
+type apiError struct {
+	Code        int
+	Description string
+	RetryAfter  int
+}
+
+func (e *apiError) Error() string {
+	return e.Description
+}
+
+func badRequest(format string, args ...interface{}) *apiError {
+	return &apiError{Code: http.StatusBadRequest, Description: "Bad Request: " + fmt.Sprintf(format, args...)}
+}
+
+// request is a parsed Bot API request.
+type request struct {
+	values map[string][]string
+	files  map[string][]byte
+}
+
+func (r request) get(key string) string {
+	if v := r.values[key]; len(v) > 0 {
+		return v[0]
+	}
+
+	return ""
+}
+
+func (r request) bool(key string) bool {
+	b, _ := strconv.ParseBool(r.get(key))
+	return b
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	token, method, ok := strings.Cut(strings.TrimPrefix(req.URL.Path, "/bot"), "/")
+	if !ok || !strings.HasPrefix(req.URL.Path, "/bot") || token != s.token {
+		writeError(w, &apiError{Code: http.StatusUnauthorized, Description: "Unauthorized"})
+		return
+	}
+
+	r, err := parseRequest(req)
+	if err != nil {
+		writeError(w, badRequest("%v", err))
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.calls[method]++
+
+	result, err := s.invoke(method, r)
+	if err != nil {
+		apiErr, ok := err.(*apiError)
+		if !ok {
+			apiErr = &apiError{Code: http.StatusInternalServerError, Description: "Internal Server Error: " + err.Error()}
+		}
+
+		writeError(w, apiErr)
+		return
+	}
+
+	writeResult(w, result)
+}
+
+func parseRequest(req *http.Request) (request, error) {
+	if !strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/form-data") {
+		err := req.ParseForm()
+		if err != nil {
+			return request{}, err
+		}
+
+		return request{values: req.PostForm}, nil
+	}
+
+	reader, err := req.MultipartReader()
+	if err != nil {
+		return request{}, err
+	}
+
+	r := request{values: make(map[string][]string), files: make(map[string][]byte)}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return r, nil
+		}
+
+		if err != nil {
+			return request{}, err
+		}
+
+		bytes, err := io.ReadAll(io.LimitReader(part, maxUploadSize))
+		if err != nil {
+			return request{}, err
+		}
+
+		// Like Telegram, any part with a "filename" parameter is a file, even if the name is empty.
+		_, params, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+		if err != nil {
+			return request{}, err
+		}
+
+		if _, isFile := params["filename"]; isFile {
+			r.files[part.FormName()] = bytes
+		} else {
+			r.values[part.FormName()] = append(r.values[part.FormName()], string(bytes))
+		}
+	}
+}
+
+func (s *Server) invoke(method string, r request) (interface{}, error) {
+	if s.floodRequests > 0 && isFloodControlled(method) {
+		s.floodRequests--
+		return nil, &apiError{
+			Code:        http.StatusTooManyRequests,
+			Description: fmt.Sprintf("Too Many Requests: retry after %d", s.floodRetryAfter),
+			RetryAfter:  s.floodRetryAfter,
+		}
+	}
+
+	if description, ok := s.rejected[method]; ok {
+		return nil, badRequest("%s", description)
+	}
+
+	switch method {
+	case "getMe":
+		return tgbotapi.User{ID: 1, IsBot: true, FirstName: "Habrabot", UserName: "habrabot_test_bot"}, nil
+	case "getChat":
+		return s.findChat(r.get("chat_id"))
+	case "sendMessage":
+		return s.sendMessage(r)
+	case "sendPhoto":
+		return s.sendFile(r, "photo")
+	case "sendDocument":
+		return s.sendFile(r, "document")
+	case "sendMediaGroup":
+		return s.sendMediaGroup(r)
+	case "editMessageText":
+		return s.editMessage(r, "text")
+	case "editMessageCaption":
+		return s.editMessage(r, "caption")
+	case "deleteMessage":
+		return s.deleteMessage(r)
+	default:
+		return nil, &apiError{Code: http.StatusNotFound, Description: "Not Found: method not found"}
+	}
+}
+
+func isFloodControlled(method string) bool {
+	return strings.HasPrefix(method, "send") || strings.HasPrefix(method, "edit") || strings.HasPrefix(method, "delete")
+}
+
+func (s *Server) findChat(chatID string) (tgbotapi.Chat, error) {
+	for _, chat := range s.chats {
+		if strings.HasPrefix(chatID, "@") {
+			if chat.UserName != "" && strings.EqualFold(chatID[1:], chat.UserName) {
+				return chat, nil
+			}
+		} else if strconv.FormatInt(chat.ID, 10) == chatID {
+			return chat, nil
+		}
+	}
+
+	return tgbotapi.Chat{}, badRequest("chat not found")
+}
+
+func (s *Server) sendMessage(r request) (interface{}, error) {
+	chat, err := s.findChat(r.get("chat_id"))
+	if err != nil {
+		return nil, err
+	}
+
+	rawText := r.get("text")
+	if strings.TrimSpace(rawText) == "" {
+		return nil, badRequest("message text is empty")
+	}
+
+	text, err := parseText(rawText, r.get("parse_mode"))
+	if err != nil {
+		return nil, err
+	}
+
+	if textLength(text) > MaxTextLength {
+		return nil, badRequest("message is too long")
+	}
+
+	err = validateLinkPreviewOptions(r.get("link_preview_options"))
+	if err != nil {
+		return nil, err
+	}
+
+	m := s.newMessage(chat, r)
+	m.Kind = "text"
+	m.Text = text
+	m.RawText = rawText
+	m.DisableWebPagePreview = r.bool("disable_web_page_preview")
+	m.LinkPreviewOptions = r.get("link_preview_options")
+
+	return s.result(chat, m), nil
+}
+
+func (s *Server) sendFile(r request, kind string) (interface{}, error) {
+	chat, err := s.findChat(r.get("chat_id"))
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := readUpload(r, kind, r.get(kind))
+	if err != nil {
+		return nil, err
+	}
+
+	caption, err := parseCaption(r.get("caption"), r.get("parse_mode"))
+	if err != nil {
+		return nil, err
+	}
+
+	m := s.newMessage(chat, r)
+	m.Kind = kind
+	m.Text = caption
+	m.RawText = r.get("caption")
+	m.File = file
+
+	return s.result(chat, m), nil
+}
+
+// inputMedia is an item of a media group.
+type inputMedia struct {
+	Type      string `json:"type"`
+	Media     string `json:"media"`
+	Caption   string `json:"caption"`
+	ParseMode string `json:"parse_mode"`
+}
+
+func (s *Server) sendMediaGroup(r request) (interface{}, error) {
+	chat, err := s.findChat(r.get("chat_id"))
+	if err != nil {
+		return nil, err
+	}
+
+	var media []inputMedia
+	err = json.Unmarshal([]byte(r.get("media")), &media)
+	if err != nil {
+		return nil, badRequest("can't parse media JSON object")
+	}
+
+	if len(media) < 2 || len(media) > 10 {
+		return nil, badRequest("media group must include 2-10 items")
+	}
+
+	// Whole group is validated before any message is sent.
+	files := make([][]byte, len(media))
+	captions := make([]string, len(media))
+	for i, item := range media {
+		if item.Type != "photo" && item.Type != "document" {
+			return nil, badRequest("unsupported media type \"%s\" in media group", item.Type)
+		}
+
+		files[i], err = readUpload(r, item.Type, item.Media)
+		if err != nil {
+			return nil, err
+		}
+
+		captions[i], err = parseCaption(item.Caption, item.ParseMode)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	groupID := strconv.Itoa(s.lastID + 1)
+
+	var results []tgbotapi.Message
+	for i, item := range media {
+		m := s.newMessage(chat, r)
+		m.Kind = item.Type
+		m.Text = captions[i]
+		m.RawText = item.Caption
+		m.ParseMode = item.ParseMode
+		m.File = files[i]
+		m.MediaGroupID = groupID
+
+		results = append(results, s.result(chat, m))
+	}
+
+	return results, nil
+}
+
+// readUpload reads an uploaded file, which is referred either by its field name or by "attach://<name>".
+// Files referred by HTTP URLs are accepted as is, and file IDs are never known to the server.
+func readUpload(r request, kind, ref string) ([]byte, error) {
+	name := kind
+	if ref != "" {
+		if strings.HasPrefix(ref, "http://") || strings.HasPrefix(ref, "https://") {
+			return nil, nil
+		}
+
+		if !strings.HasPrefix(ref, "attach://") {
+			return nil, badRequest("wrong file identifier/HTTP URL specified")
+		}
+
+		name = strings.TrimPrefix(ref, "attach://")
+	}
+
+	file, found := r.files[name]
+	if !found {
+		return nil, badRequest("there is no %s in the request", kind)
+	}
+
+	if len(file) == 0 {
+		return nil, badRequest("file must be non-empty")
+	}
+
+	if kind == "photo" {
+		_, _, err := image.DecodeConfig(bytes.NewReader(file))
+		if err != nil {
+			return nil, badRequest("IMAGE_PROCESS_FAILED")
+		}
+	}
+
+	return file, nil
+}
+
+func (s *Server) editMessage(r request, field string) (interface{}, error) {
+	chat, err := s.findChat(r.get("chat_id"))
+	if err != nil {
+		return nil, err
+	}
+
+	m := s.findMessage(chat.ID, r.get("message_id"))
+	if m == nil {
+		return nil, badRequest("message to edit not found")
+	}
+
+	var text string
+	switch {
+	case field == "text" && m.Kind != "text":
+		return nil, badRequest("there is no text in the message to edit")
+	case field == "caption" && m.Kind == "text":
+		return nil, badRequest("there is no caption in the message to edit")
+	case field == "text":
+		text, err = parseText(r.get("text"), r.get("parse_mode"))
+		if err == nil && textLength(text) > MaxTextLength {
+			err = badRequest("message is too long")
+		}
+
+		if err == nil {
+			err = validateLinkPreviewOptions(r.get("link_preview_options"))
+		}
+	default:
+		text, err = parseCaption(r.get("caption"), r.get("parse_mode"))
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if m.RawText == r.get(field) && m.ParseMode == r.get("parse_mode") && m.ReplyMarkup == r.get("reply_markup") {
+		return nil, badRequest(
+			"message is not modified: specified new message content and reply markup are exactly the same " +
+				"as a current content and reply markup of the message",
+		)
+	}
+
+	m.Text = text
+	m.RawText = r.get(field)
+	m.ParseMode = r.get("parse_mode")
+	m.ReplyMarkup = r.get("reply_markup")
+	m.Edited = true
+
+	if field == "text" {
+		m.DisableWebPagePreview = r.bool("disable_web_page_preview")
+		m.LinkPreviewOptions = r.get("link_preview_options")
+	}
+
+	return s.result(chat, m), nil
+}
+
+func validateLinkPreviewOptions(options string) error {
+	if options == "" {
+		return nil
+	}
+
+	var v struct {
+		URL              string `json:"url"`
+		PreferSmallMedia bool   `json:"prefer_small_media"`
+		PreferLargeMedia bool   `json:"prefer_large_media"`
+	}
+
+	if json.Unmarshal([]byte(options), &v) != nil {
+		return badRequest("can't parse link preview options JSON object")
+	}
+
+	if v.PreferSmallMedia && v.PreferLargeMedia {
+		return badRequest("can't prefer both small and large media")
+	}
+
+	return nil
+}
+
+func (s *Server) deleteMessage(r request) (interface{}, error) {
+	chat, err := s.findChat(r.get("chat_id"))
+	if err != nil {
+		return nil, err
+	}
+
+	m := s.findMessage(chat.ID, r.get("message_id"))
+	if m == nil {
+		return nil, badRequest("message to delete not found")
+	}
+
+	m.Deleted = true
+	return true, nil
+}
+
+func (s *Server) findMessage(chatID int64, messageID string) *Message {
+	for _, m := range s.messages {
+		if m.ChatID == chatID && strconv.Itoa(m.ID) == messageID && !m.Deleted {
+			return m
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) newMessage(chat tgbotapi.Chat, r request) *Message {
+	s.lastID++
+
+	threadID, _ := strconv.Atoi(r.get("message_thread_id"))
+	m := &Message{
+		ID:                  s.lastID,
+		ChatID:              chat.ID,
+		ThreadID:            threadID,
+		ParseMode:           r.get("parse_mode"),
+		ReplyMarkup:         r.get("reply_markup"),
+		DisableNotification: r.bool("disable_notification"),
+	}
+
+	s.messages = append(s.messages, m)
+	return m
+}
+
+func (s *Server) result(chat tgbotapi.Chat, m *Message) tgbotapi.Message {
+	result := tgbotapi.Message{
+		MessageID: m.ID,
+		Chat:      &chat,
+		Date:      int(time.Now().Unix()),
+	}
+
+	if m.Kind == "text" {
+		result.Text = m.Text
+	} else {
+		result.Caption = m.Text
+	}
+
+	return result
+}
+
+func parseCaption(caption, parseMode string) (string, error) {
+	text, err := parseText(caption, parseMode)
+	if err != nil {
+		return "", err
+	}
+
+	if textLength(text) > MaxCaptionLength {
+		return "", badRequest("message caption is too long")
+	}
+
+	return text, nil
+}
+
+func writeResult(w http.ResponseWriter, result interface{}) {
+	bytes, err := json.Marshal(result)
+	if err != nil {
+		writeError(w, &apiError{Code: http.StatusInternalServerError, Description: "Internal Server Error: " + err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(tgbotapi.APIResponse{Ok: true, Result: bytes})
+}
+
+func writeError(w http.ResponseWriter, err *apiError) {
+	resp := tgbotapi.APIResponse{
+		Ok:          false,
+		ErrorCode:   err.Code,
+		Description: err.Description,
+	}
+
+	if err.RetryAfter > 0 {
+		resp.Parameters = &tgbotapi.ResponseParameters{RetryAfter: err.RetryAfter}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(err.Code)
+	_ = json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,182 @@
+package telegramtest
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestBot(t *testing.T) (*Server, *tgbotapi.BotAPI) {
+	server := NewServer("token")
+	t.Cleanup(server.Close)
+
+	server.AddChat(tgbotapi.Chat{ID: -100, Type: "channel", UserName: "channel"})
+
+	bot, err := tgbotapi.NewBotAPIWithClient("token", server.Client())
+	require.NoError(t, err)
+
+	return server, bot
+}
+
+func TestServer_SendMessage(t *testing.T) {
+	server, bot := newTestBot(t)
+
+	msg := tgbotapi.NewMessageToChannel("@Channel", "<b>bold</b> &amp; <a href=\"https://habr.com\">link</a>")
+	msg.ParseMode = tgbotapi.ModeHTML
+
+	result, err := bot.Send(msg)
+	require.NoError(t, err)
+
+	assert.Equal(t, "bold & link", result.Text)
+	assert.Equal(t, []Message{{
+		ID:        1,
+		ChatID:    -100,
+		Kind:      "text",
+		Text:      "bold & link",
+		RawText:   msg.Text,
+		ParseMode: tgbotapi.ModeHTML,
+	}}, server.Messages(-100))
+}
+
+func TestServer_Validation(t *testing.T) {
+	_, bot := newTestBot(t)
+
+	tests := map[string]struct {
+		msg   tgbotapi.Chattable
+		error string
+	}{
+		"unknown chat": {
+			msg:   tgbotapi.NewMessage(-1, "text"),
+			error: "Bad Request: chat not found",
+		},
+		"empty text": {
+			msg:   tgbotapi.NewMessage(-100, " "),
+			error: "Bad Request: message text is empty",
+		},
+		"unsupported tag": {
+			msg:   tgbotapi.MessageConfig{BaseChat: tgbotapi.BaseChat{ChatID: -100}, Text: "a <p>b</p>", ParseMode: tgbotapi.ModeHTML},
+			error: "Bad Request: can't parse entities: Unsupported start tag \"p\" at byte offset 2",
+		},
+		"unclosed tag": {
+			msg:   tgbotapi.MessageConfig{BaseChat: tgbotapi.BaseChat{ChatID: -100}, Text: "<b><i>b</b>", ParseMode: tgbotapi.ModeHTML},
+			error: "Bad Request: can't parse entities: Can't find end tag corresponding to start tag \"i\"",
+		},
+		"long text": {
+			msg:   tgbotapi.NewMessage(-100, string(bytes.Repeat([]byte("a"), MaxTextLength+1))),
+			error: "Bad Request: message is too long",
+		},
+		"long caption": {
+			msg: tgbotapi.PhotoConfig{
+				BaseFile: tgbotapi.BaseFile{
+					BaseChat: tgbotapi.BaseChat{ChatID: -100},
+					File:     tgbotapi.FileBytes{Name: "image.png", Bytes: encodePNG(t)},
+				},
+				Caption: string(bytes.Repeat([]byte("a"), MaxCaptionLength+1)),
+			},
+			error: "Bad Request: message caption is too long",
+		},
+		"broken image": {
+			msg:   tgbotapi.NewPhotoUpload(-100, tgbotapi.FileBytes{Name: "image.png", Bytes: []byte("not an image")}),
+			error: "Bad Request: IMAGE_PROCESS_FAILED",
+		},
+		"unknown file": {
+			msg:   tgbotapi.NewPhotoShare(-100, "FILE_ID"),
+			error: "Bad Request: wrong file identifier/HTTP URL specified",
+		},
+		"missing message": {
+			msg:   tgbotapi.NewEditMessageText(-100, 42, "text"),
+			error: "Bad Request: message to edit not found",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := bot.Send(tt.msg)
+			assert.EqualError(t, err, tt.error)
+		})
+	}
+}
+
+func TestServer_Flood(t *testing.T) {
+	server, bot := newTestBot(t)
+	server.Flood(1, 5)
+
+	_, err := bot.Send(tgbotapi.NewMessage(-100, "text"))
+	if assert.Error(t, err) {
+		assert.Equal(t, 5, err.(tgbotapi.Error).RetryAfter)
+	}
+
+	_, err = bot.Send(tgbotapi.NewMessage(-100, "text"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, server.Calls("sendMessage"))
+}
+
+func TestServer_EditAndDelete(t *testing.T) {
+	server, bot := newTestBot(t)
+
+	msg, err := bot.Send(tgbotapi.NewMessage(-100, "text"))
+	require.NoError(t, err)
+
+	_, err = bot.Send(tgbotapi.NewEditMessageText(-100, msg.MessageID, "text"))
+	assert.ErrorContains(t, err, "message is not modified")
+
+	_, err = bot.Send(tgbotapi.NewEditMessageText(-100, msg.MessageID, "new text"))
+	require.NoError(t, err)
+
+	_, err = bot.DeleteMessage(tgbotapi.NewDeleteMessage(-100, msg.MessageID))
+	require.NoError(t, err)
+
+	_, err = bot.DeleteMessage(tgbotapi.NewDeleteMessage(-100, msg.MessageID))
+	assert.EqualError(t, err, "Bad Request: message to delete not found")
+
+	messages := server.Messages(-100)
+	if assert.Len(t, messages, 1) {
+		assert.Equal(t, "new text", messages[0].Text)
+		assert.True(t, messages[0].Edited)
+		assert.True(t, messages[0].Deleted)
+	}
+}
+
+func TestServer_SendMediaGroup(t *testing.T) {
+	server, bot := newTestBot(t)
+
+	params := map[string]string{
+		"chat_id": "-100",
+		"media":   `[{"type":"photo","media":"attach://a","caption":"<b>A</b>","parse_mode":"HTML"},{"type":"photo","media":"attach://b"}]`,
+	}
+
+	// Bot API library can't upload several files at once, so the group has a single upload and a URL.
+	_, err := bot.UploadFile("sendMediaGroup", params, "a", tgbotapi.FileBytes{Name: "a.png", Bytes: encodePNG(t)})
+	assert.EqualError(t, err, "Bad Request: there is no photo in the request")
+
+	params["media"] = `[{"type":"photo","media":"attach://a","caption":"<b>A</b>","parse_mode":"HTML"},{"type":"photo","media":"https://habr.com/image.png"}]`
+	_, err = bot.UploadFile("sendMediaGroup", params, "a", tgbotapi.FileBytes{Name: "a.png", Bytes: encodePNG(t)})
+	require.NoError(t, err)
+
+	messages := server.Messages(-100)
+	if assert.Len(t, messages, 2) {
+		assert.Equal(t, "A", messages[0].Text)
+		assert.NotEmpty(t, messages[0].File)
+		assert.Equal(t, messages[0].MediaGroupID, messages[1].MediaGroupID)
+	}
+}
+
+func TestServer_Unauthorized(t *testing.T) {
+	server := NewServer("token")
+	defer server.Close()
+
+	_, err := tgbotapi.NewBotAPIWithClient("wrong", server.Client())
+	assert.EqualError(t, err, "Unauthorized")
+}
+
+func encodePNG(t *testing.T) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 8, 8))))
+
+	return buf.Bytes()
+}
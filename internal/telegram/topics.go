@@ -0,0 +1,96 @@
+package telegram
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/kapitanov/habrabot/internal/data"
+)
+
+// TopicRoute routes articles with any of the tags into a forum topic.
+type TopicRoute struct {
+	Tags     []string
+	ThreadID int
+}
+
+// Topics is a routing table from article tags to forum topics of a supergroup.
+type Topics struct {
+	Routes        []TopicRoute
+	DefaultThread int // Topic of articles that match no route, zero means the general topic.
+}
+
+// ParseTopics parses a routing table.
+// Each route is defined as "tag1,tag2=THREAD_ID", and routes are separated by ";", e.g.:
+//
+//	go,golang=12;python=15
+//
+// Routes are matched in order, and articles that match no route are sent into the default topic.
+func ParseTopics(str string, defaultThread int) (Topics, error) {
+	topics := Topics{DefaultThread: defaultThread}
+
+	for _, routeStr := range strings.Split(str, ";") {
+		routeStr = strings.TrimSpace(routeStr)
+		if routeStr == "" {
+			continue
+		}
+
+		tagsStr, threadStr, ok := strings.Cut(routeStr, "=")
+		if !ok {
+			return Topics{}, fmt.Errorf("malformed topic route \"%s\", expected \"TAGS=THREAD_ID\"", routeStr)
+		}
+
+		threadID, err := strconv.Atoi(strings.TrimSpace(threadStr))
+		if err != nil || threadID <= 0 {
+			return Topics{}, fmt.Errorf("malformed thread ID in topic route \"%s\"", routeStr)
+		}
+
+		route := TopicRoute{ThreadID: threadID}
+		for _, tag := range strings.Split(tagsStr, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag != "" {
+				route.Tags = append(route.Tags, tag)
+			}
+		}
+
+		if len(route.Tags) == 0 {
+			return Topics{}, fmt.Errorf("topic route \"%s\" has no tags", routeStr)
+		}
+
+		topics.Routes = append(topics.Routes, route)
+	}
+
+	return topics, nil
+}
+
+// thread returns an ID of the forum topic that the article is sent into.
+func (t Topics) thread(article data.Article) int {
+	for _, route := range t.Routes {
+		for _, tag := range route.Tags {
+			if hasTag(article, tag) {
+				return route.ThreadID
+			}
+		}
+	}
+
+	return t.DefaultThread
+}
+
+func hasTag(article data.Article, tag string) bool {
+	for _, t := range article.Tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// threadParams returns request parameters that send a message into the forum topic.
+func threadParams(threadID int) map[string]string {
+	if threadID == 0 {
+		return nil
+	}
+
+	return map[string]string{"message_thread_id": strconv.Itoa(threadID)}
+}
@@ -0,0 +1,46 @@
+package telegram
+
+import (
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kapitanov/habrabot/internal/data"
+)
+
+func TestParseTopics(t *testing.T) {
+	topics, err := ParseTopics(" go, golang =12;;python=15 ", 3)
+	require.NoError(t, err)
+
+	assert.Equal(t, Topics{
+		Routes: []TopicRoute{
+			{Tags: []string{"go", "golang"}, ThreadID: 12},
+			{Tags: []string{"python"}, ThreadID: 15},
+		},
+		DefaultThread: 3,
+	}, topics)
+}
+
+func TestParseTopics_Malformed(t *testing.T) {
+	for _, str := range []string{"go", "go=", "go=abc", "go=-1", "=12"} {
+		_, err := ParseTopics(str, 0)
+		assert.Error(t, err, str)
+	}
+}
+
+func TestTopics_Thread(t *testing.T) {
+	topics, err := ParseTopics("go=12;python,go=15", 3)
+	require.NoError(t, err)
+
+	assert.Equal(t, 12, topics.thread(data.Article{Tags: []string{"python", "Go"}}))
+	assert.Equal(t, 15, topics.thread(data.Article{Tags: []string{"python"}}))
+	assert.Equal(t, 3, topics.thread(data.Article{Tags: []string{"rust"}}))
+	assert.Equal(t, 0, Topics{}.thread(data.Article{Tags: []string{"go"}}))
+}
+
+func TestChatConfig(t *testing.T) {
+	assert.Equal(t, tgbotapi.ChatConfig{ChatID: -1001234567890}, chatConfig("-1001234567890"))
+	assert.Equal(t, tgbotapi.ChatConfig{SuperGroupUsername: "@channel"}, chatConfig("@channel"))
+}
@@ -0,0 +1,58 @@
+package updates
+
+import (
+	"context"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/rs/zerolog/log"
+)
+
+const pollTimeout = 30 * time.Second
+
+func (r *Receiver) runLongPolling(ctx context.Context) {
+	log.Info().Msg("will receive telegram updates via long polling")
+
+	offset := 0
+	for {
+		updates, err := r.getUpdates(ctx, offset)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			log.Error().Err(err).Msg("unable to receive telegram updates")
+			if sleep(ctx, retryDelay) != nil {
+				return
+			}
+
+			continue
+		}
+
+		for _, update := range updates {
+			offset = update.UpdateID + 1
+			r.handle(ctx, update)
+		}
+	}
+}
+
+// getUpdates waits for updates, it returns immediately once the context is canceled.
+func (r *Receiver) getUpdates(ctx context.Context, offset int) ([]tgbotapi.Update, error) {
+	type result struct {
+		Updates []tgbotapi.Update
+		Err     error
+	}
+
+	ch := make(chan result, 1)
+	go func() {
+		updates, err := r.bot.GetUpdates(tgbotapi.UpdateConfig{Offset: offset, Timeout: int(pollTimeout.Seconds())})
+		ch <- result{Updates: updates, Err: err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-ch:
+		return res.Updates, res.Err
+	}
+}
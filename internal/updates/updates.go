@@ -0,0 +1,113 @@
+package updates
+
+import (
+	"context"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/rs/zerolog/log"
+
+	"github.com/kapitanov/habrabot/internal/httpclient"
+)
+
+// Handler handles updates received from Telegram.
+type Handler interface {
+	// HandleUpdate is invoked for each received update.
+	// Bot may be used to reply to the update.
+	HandleUpdate(ctx context.Context, bot *tgbotapi.BotAPI, update tgbotapi.Update)
+}
+
+// HandlerFunc is a function-based implementation of Handler.
+type HandlerFunc func(ctx context.Context, bot *tgbotapi.BotAPI, update tgbotapi.Update)
+
+// HandleUpdate is invoked for each received update.
+func (f HandlerFunc) HandleUpdate(ctx context.Context, bot *tgbotapi.BotAPI, update tgbotapi.Update) {
+	f(ctx, bot, update)
+}
+
+const retryDelay = 10 * time.Second
+
+// Receiver receives updates from Telegram and passes them to handlers one by one.
+// Updates are received via long polling, unless a webhook is configured.
+type Receiver struct {
+	token    string
+	handlers []Handler
+	webhook  *Webhook
+	bot      *tgbotapi.BotAPI
+}
+
+// Option configures update receiver.
+type Option func(r *Receiver)
+
+// WithWebhook makes receiver get updates via webhook instead of long polling.
+func WithWebhook(webhook Webhook) Option {
+	return func(r *Receiver) {
+		r.webhook = &webhook
+	}
+}
+
+// New creates new update receiver.
+func New(token string, handlers []Handler, options ...Option) *Receiver {
+	r := &Receiver{
+		token:    token,
+		handlers: handlers,
+	}
+
+	for _, option := range options {
+		option(r)
+	}
+
+	return r
+}
+
+// Run receives updates until the context is canceled.
+func (r *Receiver) Run(ctx context.Context) {
+	for r.bot == nil {
+		err := r.connect()
+		if err != nil {
+			log.Error().Err(err).Msg("unable to connect to telegram")
+			if sleep(ctx, retryDelay) != nil {
+				return
+			}
+		}
+	}
+
+	if r.webhook != nil {
+		r.runWebhook(ctx)
+	} else {
+		r.runLongPolling(ctx)
+	}
+}
+
+func (r *Receiver) connect() error {
+	httpClient, err := httpclient.New(httpclient.TelegramPolicy)
+	if err != nil {
+		return err
+	}
+
+	bot, err := tgbotapi.NewBotAPIWithClient(r.token, httpClient.StandardClient())
+	if err != nil {
+		return err
+	}
+
+	r.bot = bot
+	return nil
+}
+
+func (r *Receiver) handle(ctx context.Context, update tgbotapi.Update) {
+	for _, handler := range r.handlers {
+		handler.HandleUpdate(ctx, r.bot, update)
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
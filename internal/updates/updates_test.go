@@ -0,0 +1,298 @@
+package updates
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeBotAPI is a local Bot API server that records invoked methods.
+type fakeBotAPI struct {
+	mutex   sync.Mutex
+	calls   map[string][]url.Values
+	updates []tgbotapi.Update
+}
+
+func newFakeBotAPI(t *testing.T, updates ...tgbotapi.Update) (*fakeBotAPI, *tgbotapi.BotAPI) {
+	api := &fakeBotAPI{calls: make(map[string][]url.Values), updates: updates}
+
+	server := httptest.NewServer(http.HandlerFunc(api.serveHTTP))
+	t.Cleanup(server.Close)
+
+	target, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	bot := &tgbotapi.BotAPI{
+		Token:  "token",
+		Client: &http.Client{Transport: rewriteTransport{target: target}},
+	}
+
+	return api, bot
+}
+
+func (api *fakeBotAPI) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	_ = req.ParseForm()
+	method := req.URL.Path[strings.LastIndex(req.URL.Path, "/")+1:]
+
+	api.mutex.Lock()
+	api.calls[method] = append(api.calls[method], req.PostForm)
+
+	var result interface{} = true
+	if method == "getUpdates" {
+		result = api.updates
+		api.updates = nil
+	}
+	api.mutex.Unlock()
+
+	bytes, _ := json.Marshal(result)
+	_ = json.NewEncoder(w).Encode(tgbotapi.APIResponse{Ok: true, Result: bytes})
+}
+
+func (api *fakeBotAPI) Calls(method string) []url.Values {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	return api.calls[method]
+}
+
+// rewriteTransport redirects Bot API requests to a local server.
+type rewriteTransport struct {
+	target *url.URL
+}
+
+func (t rewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = t.target.Scheme
+	req.URL.Host = t.target.Host
+
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// runReceiver runs the receiver until the first update is handled.
+func runReceiver(t *testing.T, r *Receiver, received chan tgbotapi.Update, send func()) tgbotapi.Update {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		r.Run(ctx)
+	}()
+
+	send()
+
+	var update tgbotapi.Update
+	select {
+	case update = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("update hasn't been received")
+	}
+
+	cancel()
+	<-done
+
+	return update
+}
+
+func newRecordingHandler() (Handler, chan tgbotapi.Update) {
+	received := make(chan tgbotapi.Update, 1)
+
+	return HandlerFunc(func(_ context.Context, _ *tgbotapi.BotAPI, update tgbotapi.Update) {
+		received <- update
+	}), received
+}
+
+func TestReceiver_LongPolling(t *testing.T) {
+	api, bot := newFakeBotAPI(t, tgbotapi.Update{UpdateID: 42})
+	handler, received := newRecordingHandler()
+
+	r := New("token", []Handler{handler})
+	r.bot = bot
+
+	update := runReceiver(t, r, received, func() {})
+
+	assert.Equal(t, 42, update.UpdateID)
+	assert.NotEmpty(t, api.Calls("getUpdates"))
+}
+
+func TestReceiver_Webhook(t *testing.T) {
+	api, bot := newFakeBotAPI(t)
+	handler, received := newRecordingHandler()
+	addr := freeAddr(t)
+
+	r := New("token", []Handler{handler}, WithWebhook(Webhook{
+		URL:         "https://bot.example.com/telegram/hook",
+		ListenAddr:  addr,
+		Path:        "/hook",
+		SecretToken: "secret",
+	}))
+	r.bot = bot
+
+	update := runReceiver(t, r, received, func() {
+		require.Eventually(t, func() bool { return len(api.Calls("setWebhook")) > 0 }, 5*time.Second, 10*time.Millisecond)
+
+		assert.Equal(t, http.StatusOK, postUpdate(t, "http://"+addr+"/hook", `{"update_id":7}`))
+	})
+
+	assert.Equal(t, 7, update.UpdateID)
+
+	if calls := api.Calls("setWebhook"); assert.Len(t, calls, 1) {
+		assert.Equal(t, "https://bot.example.com/telegram/hook", calls[0].Get("url"))
+		assert.Equal(t, "secret", calls[0].Get("secret_token"))
+	}
+
+	assert.Len(t, api.Calls("deleteWebhook"), 1)
+}
+
+func TestReceiver_WebhookShutdown(t *testing.T) {
+	api, bot := newFakeBotAPI(t)
+	addr := freeAddr(t)
+
+	// The first update is being handled on shutdown, while the second one is acknowledged and queued.
+	release := make(chan struct{})
+	handled := make(chan int, 2)
+	deletedBeforeHandled := false
+	handler := HandlerFunc(func(_ context.Context, _ *tgbotapi.BotAPI, update tgbotapi.Update) {
+		if update.UpdateID == 1 {
+			<-release
+		} else {
+			deletedBeforeHandled = len(api.Calls("deleteWebhook")) > 0
+		}
+
+		handled <- update.UpdateID
+	})
+
+	r := New("token", []Handler{handler}, WithWebhook(Webhook{
+		URL:         "https://bot.example.com/hook",
+		ListenAddr:  addr,
+		SecretToken: "secret",
+	}))
+	r.bot = bot
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		r.Run(ctx)
+	}()
+
+	require.Eventually(t, func() bool { return len(api.Calls("setWebhook")) > 0 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, http.StatusOK, postUpdate(t, "http://"+addr+"/hook", `{"update_id":1}`))
+	assert.Equal(t, http.StatusOK, postUpdate(t, "http://"+addr+"/hook", `{"update_id":2}`))
+
+	cancel()
+	close(release)
+	<-done
+
+	require.Len(t, handled, 2)
+	assert.Equal(t, 1, <-handled)
+	assert.Equal(t, 2, <-handled)
+	assert.False(t, deletedBeforeHandled)
+	assert.Len(t, api.Calls("deleteWebhook"), 1)
+}
+
+func postUpdate(t *testing.T, url, body string) int {
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set(secretTokenHeader, "secret")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+
+	return resp.StatusCode
+}
+
+func TestWebhookHandler(t *testing.T) {
+	updates := make(chan tgbotapi.Update, 1)
+	handler := NewWebhookHandler("secret", updates)
+
+	tests := []struct {
+		Name     string
+		Method   string
+		Token    string
+		Body     string
+		Expected int
+	}{
+		{Name: "valid", Method: http.MethodPost, Token: "secret", Body: `{"update_id":1}`, Expected: http.StatusOK},
+		{Name: "wrong token", Method: http.MethodPost, Token: "wrong", Body: `{"update_id":1}`, Expected: http.StatusUnauthorized},
+		{Name: "missing token", Method: http.MethodPost, Body: `{"update_id":1}`, Expected: http.StatusUnauthorized},
+		{Name: "malformed body", Method: http.MethodPost, Token: "secret", Body: `{`, Expected: http.StatusBadRequest},
+		{Name: "wrong method", Method: http.MethodGet, Token: "secret", Expected: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.Method, "/", strings.NewReader(tt.Body))
+			if tt.Token != "" {
+				req.Header.Set(secretTokenHeader, tt.Token)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.Expected, w.Code)
+		})
+	}
+
+	assert.Len(t, updates, 1)
+}
+
+func TestWebhookHandler_QueueFull(t *testing.T) {
+	updates := make(chan tgbotapi.Update)
+	handler := newWebhookHandler("secret", updates, time.Millisecond)
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"update_id":1}`))
+	req.Header.Set(secretTokenHeader, "secret")
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "10", w.Header().Get("Retry-After"))
+}
+
+func TestWebhookHandler_Canceled(t *testing.T) {
+	updates := make(chan tgbotapi.Update)
+	handler := newWebhookHandler("secret", updates, time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"update_id":1}`)).WithContext(ctx)
+	req.Header.Set(secretTokenHeader, "secret")
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestWebhook_WithDefaults(t *testing.T) {
+	webhook, err := Webhook{URL: "https://bot.example.com/hook"}.withDefaults()
+	require.NoError(t, err)
+
+	assert.Equal(t, "/hook", webhook.Path)
+	assert.Len(t, webhook.SecretToken, 64)
+}
+
+func freeAddr(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	addr := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	return addr
+}
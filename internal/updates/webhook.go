@@ -0,0 +1,225 @@
+package updates
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/rs/zerolog/log"
+)
+
+// Webhook configures receiving updates via webhook.
+type Webhook struct {
+	URL         string // Public URL that Telegram sends updates to.
+	ListenAddr  string // Address that HTTP server listens on, e.g. ":8080".
+	Path        string // Path that updates are accepted at, defaults to the path of URL (it may differ behind a reverse proxy).
+	SecretToken string // Secret token that Telegram sends with each update, a random one is generated if empty.
+}
+
+const (
+	secretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+	webhookQueueSize  = 100
+	shutdownTimeout   = 10 * time.Second
+	readHeaderTimeout = 10 * time.Second
+	maxUpdateSize     = 1 << 20
+
+	// enqueueTimeout limits how long an update waits for a full queue,
+	// after that Telegram is asked to send it again later.
+	enqueueTimeout = 5 * time.Second
+	retryAfter     = 10 * time.Second
+)
+
+func (r *Receiver) runWebhook(ctx context.Context) {
+	webhook, err := r.webhook.withDefaults()
+	if err != nil {
+		log.Error().Err(err).Msg("unable to configure telegram webhook")
+		return
+	}
+
+	updates := make(chan tgbotapi.Update, webhookQueueSize)
+
+	mux := http.NewServeMux()
+	mux.Handle(webhook.Path, NewWebhookHandler(webhook.SecretToken, updates))
+
+	listener, err := net.Listen("tcp", webhook.ListenAddr)
+	if err != nil {
+		log.Error().Err(err).Str("addr", webhook.ListenAddr).Msg("unable to listen for telegram webhook")
+		return
+	}
+
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+
+	go func() {
+		err := server.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error().Err(err).Str("addr", webhook.ListenAddr).Msg("unable to serve telegram webhook")
+		}
+	}()
+
+	registered := r.registerWebhook(ctx, webhook)
+	if registered {
+		log.Info().Str("url", webhook.URL).Str("addr", webhook.ListenAddr).Msg("will receive telegram updates via webhook")
+		r.receive(ctx, updates)
+	}
+
+	// The server is shut down first, so updates that have been acknowledged are all queued by then.
+	// They are handled before the webhook is removed, otherwise they would be lost.
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	err = server.Shutdown(shutdownCtx)
+	if err != nil {
+		log.Error().Err(err).Msg("unable to shut down telegram webhook server")
+	}
+
+	r.drain(shutdownCtx, updates)
+
+	if registered {
+		r.deleteWebhook()
+	}
+}
+
+// registerWebhook registers the webhook, retrying until it succeeds or the context is canceled.
+func (r *Receiver) registerWebhook(ctx context.Context, webhook Webhook) bool {
+	for {
+		err := r.setWebhook(webhook)
+		if err == nil {
+			return true
+		}
+
+		log.Error().Err(err).Msg("unable to register telegram webhook")
+		if sleep(ctx, retryDelay) != nil {
+			return false
+		}
+	}
+}
+
+// receive handles queued updates until the context is canceled.
+func (r *Receiver) receive(ctx context.Context, updates <-chan tgbotapi.Update) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case update := <-updates:
+			r.handle(ctx, update)
+		}
+	}
+}
+
+// drain handles updates that are left in the queue.
+func (r *Receiver) drain(ctx context.Context, updates <-chan tgbotapi.Update) {
+	for {
+		select {
+		case update := <-updates:
+			r.handle(ctx, update)
+		default:
+			return
+		}
+	}
+}
+
+func (w Webhook) withDefaults() (Webhook, error) {
+	u, err := url.Parse(w.URL)
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	if w.Path == "" {
+		w.Path = u.Path
+	}
+
+	if w.Path == "" {
+		w.Path = "/"
+	}
+
+	if w.SecretToken == "" {
+		bytes := make([]byte, 32)
+		_, err = rand.Read(bytes)
+		if err != nil {
+			return Webhook{}, err
+		}
+
+		w.SecretToken = hex.EncodeToString(bytes)
+	}
+
+	return w, nil
+}
+
+func (r *Receiver) setWebhook(webhook Webhook) error {
+	params := url.Values{}
+	params.Set("url", webhook.URL)
+	params.Set("secret_token", webhook.SecretToken)
+
+	_, err := r.bot.MakeRequest("setWebhook", params)
+	return err
+}
+
+func (r *Receiver) deleteWebhook() {
+	_, err := r.bot.MakeRequest("deleteWebhook", url.Values{})
+	if err != nil {
+		log.Error().Err(err).Msg("unable to remove telegram webhook")
+		return
+	}
+
+	log.Info().Msg("telegram webhook has been removed")
+}
+
+// NewWebhookHandler creates an HTTP handler that accepts updates sent by Telegram
+// and puts them into the channel.
+// Requests without a valid secret token are rejected.
+// Updates that can't be queued are answered with an error status, so Telegram sends them again.
+func NewWebhookHandler(secretToken string, updates chan<- tgbotapi.Update) http.Handler {
+	return newWebhookHandler(secretToken, updates, enqueueTimeout)
+}
+
+func newWebhookHandler(secretToken string, updates chan<- tgbotapi.Update, timeout time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		token := req.Header.Get(secretTokenHeader)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(secretToken)) != 1 {
+			log.Warn().Str("remote", req.RemoteAddr).Msg("rejected telegram webhook request with invalid secret token")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var update tgbotapi.Update
+		err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxUpdateSize)).Decode(&update)
+		if err != nil {
+			log.Warn().Err(err).Msg("unable to decode telegram update")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		select {
+		case updates <- update:
+			w.WriteHeader(http.StatusOK)
+		case <-timer.C:
+			log.Warn().Int("update_id", update.UpdateID).Msg("telegram update queue is full, update is rejected")
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+			w.WriteHeader(http.StatusTooManyRequests)
+		case <-req.Context().Done():
+			// Request has been canceled, e.g. the server is shutting down,
+			// so an explicit error status makes Telegram send the update again instead of an implicit 200.
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+}